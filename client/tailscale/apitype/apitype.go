@@ -4,7 +4,12 @@
 // Package apitype contains types for the Tailscale LocalAPI and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
 
 // LocalAPIHost is the Host header value used by the LocalAPI.
 const LocalAPIHost = "local-tailscaled.sock"
@@ -57,3 +62,685 @@ type ExitNodeSuggestionResponse struct {
 	Name     string
 	Location tailcfg.LocationView `json:",omitempty"`
 }
+
+// KeyExpiryResponse is the response to a LocalAPI key-expiry GET request.
+type KeyExpiryResponse struct {
+	// Expiry is when the node key expires. It is the zero value if
+	// ExpiryDisabled is true.
+	Expiry time.Time
+
+	// Remaining is the time remaining until Expiry. It is negative if the
+	// key has already expired and zero if ExpiryDisabled is true.
+	Remaining time.Duration
+
+	// Warning is whether the key expires within the requested warning
+	// threshold (or has already expired).
+	Warning bool
+
+	// Expired is whether the key has already expired.
+	Expired bool
+
+	// ExpiryDisabled is whether key expiry is disabled for this node by the
+	// tailnet's policy, in which case the key never expires.
+	ExpiryDisabled bool
+}
+
+// NodeInventory is the response to a LocalAPI inventory GET request. It
+// summarizes the local node in one document for asset-management systems.
+//
+// Fields may be added over time but existing fields are not renamed or
+// removed.
+type NodeInventory struct {
+	// StableID is the node's stable ID. It's empty if the node is not
+	// logged in.
+	StableID tailcfg.StableNodeID `json:",omitempty"`
+
+	// DNSName is the node's MagicDNS name, without a trailing dot.
+	DNSName string `json:",omitempty"`
+
+	// Hostname is the node's OS hostname.
+	Hostname string
+
+	// OS is the node's operating system, in the same form as
+	// tailcfg.Hostinfo.OS ("linux", "windows", "macOS", etc).
+	OS string
+
+	// OSVersion is the operating system version, if known.
+	OSVersion string `json:",omitempty"`
+
+	// Version is the long version string of the running tailscaled.
+	Version string
+
+	// TailscaleIPs are the node's Tailscale IP addresses.
+	TailscaleIPs []netip.Addr
+
+	// Tags are the ACL tags assigned to the node by control.
+	Tags []string `json:",omitempty"`
+
+	// AdvertisedRoutes are the subnet routes (including exit node
+	// routes) the node advertises.
+	AdvertisedRoutes []netip.Prefix `json:",omitempty"`
+
+	// KeyExpiry is when the node key expires. It's nil if the node is not
+	// logged in or key expiry is disabled.
+	KeyExpiry *time.Time `json:",omitempty"`
+
+	// Hostinfo is the full Hostinfo the node reports to control.
+	Hostinfo *tailcfg.Hostinfo `json:",omitempty"`
+}
+
+// DERPDisabledResponse is the response to the LocalAPI debug "disable-derp"
+// and "enable-derp" actions.
+type DERPDisabledResponse struct {
+	// DERPDisabled is whether DERP relaying is now disabled.
+	DERPDisabled bool
+
+	// ClosedRegions are the IDs of the DERP regions whose connections
+	// were closed as a result of disabling DERP.
+	ClosedRegions []int `json:",omitempty"`
+
+	// DroppedPeers are the peers that had no direct path when DERP was
+	// disabled and thus lost connectivity. Each is the peer's MagicDNS
+	// name if known, else its short node key.
+	DroppedPeers []string `json:",omitempty"`
+}
+
+// DERPRegionFailResponse is the response to the LocalAPI debug
+// "fail-derp-region" and "restore-derp-region" actions.
+type DERPRegionFailResponse struct {
+	// Region is the ID of the DERP region acted on.
+	Region int
+
+	// Failed is whether the region is now treated as unreachable.
+	Failed bool
+
+	// HomeRegion is the ID of this node's home DERP region after the
+	// change, or zero if it has none.
+	HomeRegion int
+
+	// HomeRegionCode is the region code of HomeRegion, such as "nyc".
+	HomeRegionCode string `json:",omitempty"`
+}
+
+// VersionResponse is the response to a LocalAPI version GET request,
+// describing the tailscaled build.
+type VersionResponse struct {
+	// MajorMinorPatch is the "major.minor.patch" version string, without
+	// any hyphenated suffix.
+	MajorMinorPatch string `json:"majorMinorPatch"`
+
+	// Short is the short version string, such as "1.74.0" or
+	// "1.75.0-dev20240801".
+	Short string `json:"short"`
+
+	// Long is the full version string, including git commit hash(es) as
+	// the suffix.
+	Long string `json:"long"`
+
+	// IsDev is whether the binary is a development build.
+	IsDev bool `json:"isDev,omitempty"`
+
+	// UnstableBranch is whether the build is from an unstable
+	// (odd minor version) branch.
+	UnstableBranch bool `json:"unstableBranch,omitempty"`
+
+	// GitCommit is the git commit of the tailscale repository the binary
+	// was built from, if known.
+	GitCommit string `json:"gitCommit,omitempty"`
+
+	// GitDirty is whether the binary was built from a working directory
+	// with uncommitted changes.
+	GitDirty bool `json:"gitDirty,omitempty"`
+
+	// ExtraGitCommit is the git commit of the supplemental repository the
+	// binary was built from, if any.
+	ExtraGitCommit string `json:"extraGitCommit,omitempty"`
+
+	// GitCommitTime is the commit time of GitCommit, if known.
+	GitCommitTime string `json:"gitCommitTime,omitempty"`
+
+	// Cap is the binary's Tailscale capability version.
+	Cap tailcfg.CapabilityVersion `json:"cap"`
+
+	// GoVersion is the version of Go the binary was built with.
+	GoVersion string `json:"goVersion"`
+
+	// OS and Arch are the GOOS and GOARCH the binary was built for.
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+
+	// BuildTags are the Go build tags the binary was built with.
+	BuildTags []string `json:"buildTags,omitempty"`
+
+	// AWS is whether AWS support, such as the AWS SSM state store, was
+	// compiled in; it's false for builds with the ts_omit_aws tag.
+	AWS bool `json:"aws"`
+}
+
+// Connection is an entry in the response to a LocalAPI connections GET
+// request, describing a flow that tailscaled recently carried traffic for.
+type Connection struct {
+	// Proto is the IP protocol, such as "TCP" or "UDP".
+	Proto string
+
+	// Src is the address on this node's side of the tunnel.
+	Src netip.AddrPort
+
+	// Dst is the address on the peer's side of the tunnel.
+	Dst netip.AddrPort
+
+	TxPackets uint64 // packets sent from Src to Dst
+	TxBytes   uint64
+	RxPackets uint64 // packets received by Src from Dst
+	RxBytes   uint64
+
+	// LastActive is when the flow last carried a packet.
+	LastActive time.Time
+
+	// PeerID is the stable ID of the peer the flow is with, if known.
+	PeerID tailcfg.StableNodeID `json:",omitempty"`
+
+	// PeerName is the peer's MagicDNS name, if known.
+	PeerName string `json:",omitempty"`
+
+	// Direct is whether the peer is currently reached directly rather
+	// than relayed via DERP.
+	Direct bool
+
+	// CurAddr is the peer's direct UDP endpoint, if Direct.
+	CurAddr string `json:",omitempty"`
+
+	// Relay is the DERP region code relaying traffic to the peer, if not
+	// Direct.
+	Relay string `json:",omitempty"`
+}
+
+// SubnetSweepResponse is the response to the LocalAPI debug "sweep-subnet"
+// action.
+type SubnetSweepResponse struct {
+	// CIDR is the swept prefix.
+	CIDR string
+
+	// Probed is the number of addresses pinged.
+	Probed int
+
+	// Alive are the hosts that replied, sorted by address.
+	Alive []SubnetSweepHost
+}
+
+// SubnetSweepHost is a host that replied to a subnet sweep ping.
+type SubnetSweepHost struct {
+	Addr    netip.Addr
+	Latency time.Duration
+}
+
+// DebugTrace is an active period of elevated logging for one subsystem, as
+// reported by the LocalAPI debug "trace" action.
+type DebugTrace struct {
+	Subsystem string    // one of ipn.DebuggableComponents
+	Until     time.Time // when the logging reverts to normal
+}
+
+// SetupChecksResponse is the response to the LocalAPI setup-checks
+// endpoint, which checks the host prerequisites for this node's role.
+type SetupChecksResponse struct {
+	// Router is whether the node advertises subnet routes or offers to
+	// be an exit node, and thus needs to forward packets.
+	Router bool
+
+	// Checks are the results of the checks run, in order.
+	Checks []SetupCheck
+}
+
+// SetupCheck is the result of a single setup check.
+type SetupCheck struct {
+	// Name identifies the check, such as "ip-forwarding".
+	Name string
+
+	// Pass is whether the host passed the check.
+	Pass bool
+
+	// Message describes the result.
+	Message string
+
+	// Fix suggests how to fix a failed check, if known.
+	Fix string `json:",omitempty"`
+}
+
+// InboundAccessRule is a rule of this node's packet filter permitting
+// connections to it, as returned by the LocalAPI inbound-access endpoint.
+type InboundAccessRule struct {
+	// Protos are the IP protocols permitted, such as "tcp" or "udp".
+	Protos []string
+
+	// Ports are the destination port ranges permitted, such as "22",
+	// "8000-8999" or "*".
+	Ports []string
+
+	// Srcs are the source IP prefixes permitted.
+	Srcs []string
+
+	// SrcCaps are node capabilities that also permit a peer having them.
+	SrcCaps []tailcfg.NodeCapability `json:",omitempty"`
+
+	// Peers are the peers in the netmap matched by Srcs or SrcCaps.
+	Peers []InboundAccessPeer `json:",omitempty"`
+}
+
+// InboundAccessPeer is a peer permitted to connect to this node by an
+// InboundAccessRule.
+type InboundAccessPeer struct {
+	ID   tailcfg.StableNodeID
+	Name string   // MagicDNS name
+	Tags []string `json:",omitempty"`
+}
+
+// LogSegment is a file of tailscaled's on-disk log buffer, as listed by
+// the LocalAPI logs endpoint.
+type LogSegment struct {
+	Name    string    // file base name; pass to the logs endpoint to download
+	Size    int64     // size in bytes
+	ModTime time.Time // last modification time
+}
+
+// PeerLatencyResponse is the response to a LocalAPI peer-latency request.
+type PeerLatencyResponse struct {
+	// StableID is the peer's stable node ID.
+	StableID tailcfg.StableNodeID
+
+	// Samples are the round-trip times recently observed to the peer,
+	// oldest first.
+	Samples []PeerLatencySample
+}
+
+// PeerLatencySample is a single round-trip time observed to a peer.
+type PeerLatencySample struct {
+	// Time is when the sample was taken.
+	Time time.Time
+
+	// LatencyMs is the round-trip time in milliseconds.
+	LatencyMs float64
+
+	// DERPRegion is the ID of the DERP region the sample was measured
+	// through, or zero for a direct path.
+	DERPRegion int `json:",omitempty"`
+
+	// Endpoint is the peer's ip:port for a direct path, or empty for DERP.
+	Endpoint string `json:",omitempty"`
+}
+
+// PeerEndpoints are the paths tailscaled is considering to reach a peer,
+// as returned by the LocalAPI peer-endpoints endpoint. It's for debugging
+// and has no stability guarantees.
+type PeerEndpoints struct {
+	// StableID is the peer's stable node ID.
+	StableID tailcfg.StableNodeID
+
+	// Name is the peer's MagicDNS name.
+	Name string
+
+	// Candidates are the UDP endpoints the peer might be reachable at
+	// directly, sorted by address.
+	Candidates []EndpointCandidate
+
+	// BestAddr is the direct endpoint currently selected, if any.
+	BestAddr string `json:",omitempty"`
+
+	// BestAddrTrusted is whether BestAddr has been confirmed recently
+	// enough that packets go only to it and not also via DERP.
+	BestAddrTrusted bool `json:",omitempty"`
+
+	// DERPRegion is the ID of the peer's home DERP region, or zero if
+	// unknown.
+	DERPRegion int
+
+	// DERPRegionCode is the short name of DERPRegion, if known.
+	DERPRegionCode string `json:",omitempty"`
+}
+
+// EndpointCandidate is a UDP endpoint a peer might be reachable at.
+type EndpointCandidate struct {
+	// Addr is the endpoint's ip:port.
+	Addr netip.AddrPort
+
+	// Source is how the endpoint was learned: "netmap" from the control
+	// plane, "call-me-maybe" from the peer via DERP, or "ping" from a disco
+	// ping the peer sent from it.
+	Source string
+
+	// LastPing is when tailscaled last pinged the endpoint, if ever.
+	LastPing *time.Time `json:",omitempty"`
+
+	// LatencyMs is the round-trip time of the endpoint's most recent pong,
+	// or zero if it has never replied.
+	LatencyMs float64 `json:",omitempty"`
+}
+
+// LoginInfoResponse is the response to the LocalAPI login-info endpoint.
+type LoginInfoResponse struct {
+	// ControlURL is the URL of the control server in effect.
+	ControlURL string
+
+	// IsDefaultControlURL is whether ControlURL is Tailscale's default
+	// control server, rather than a custom or self-hosted one.
+	IsDefaultControlURL bool
+
+	// ControlURLFromPolicy is whether ControlURL is set by a system
+	// policy, overriding prefs.
+	ControlURLFromPolicy bool `json:",omitempty"`
+
+	// AdminURL is the URL of the admin console for ControlURL.
+	AdminURL string `json:",omitempty"`
+}
+
+// UpdateCheckResponse is the response to the LocalAPI update/check
+// endpoint.
+type UpdateCheckResponse struct {
+	// ClientVersion describes the update tailscaled can install itself,
+	// if any. On platforms where tailscaled can't install updates, it
+	// always says the latest version is running; see Latest and
+	// UpdateAvailable for the version available regardless.
+	tailcfg.ClientVersion
+
+	// Current is the version of tailscaled running, in version.Short form
+	// ("1.34.2").
+	Current string `json:"current"`
+
+	// Latest is the latest version available for this platform, as
+	// reported by the control server, whether or not tailscaled can
+	// install it. It's empty if unknown.
+	Latest string `json:"latest,omitempty"`
+
+	// UpdateAvailable is whether Latest is newer than Current.
+	UpdateAvailable bool `json:"updateAvailable"`
+}
+
+// FileSendRequest is the request body of the LocalAPI file-send endpoint,
+// which sends a file tailscaled reads from its local filesystem.
+type FileSendRequest struct {
+	StableID tailcfg.StableNodeID `json:"stableID"` // the peer to send to
+	Path     string               `json:"path"`     // absolute path of the file
+}
+
+// FilePutStatus is the data of the final "done" event of a LocalAPI
+// file-put request made with progress=1, which responds with a
+// text/event-stream of "progress" events, each an ipn.OutgoingFile.
+type FilePutStatus struct {
+	// StatusCode is the HTTP status with which the receiving peer responded
+	// to the file.
+	StatusCode int
+
+	// Error is the peer's error message, if StatusCode isn't a success.
+	Error string `json:",omitempty"`
+}
+
+// HostnameResponse is the response to the LocalAPI hostname endpoint.
+type HostnameResponse struct {
+	// Hostname is the hostname the node reports to control, from which
+	// its MagicDNS name is derived.
+	Hostname string
+
+	// FromPrefs is whether Hostname was set explicitly, overriding the OS
+	// hostname.
+	FromPrefs bool `json:",omitempty"`
+}
+
+// Notice is a message from the control plane for the node's user, as
+// returned by the LocalAPI notices endpoint. Notices are the problems
+// control reports through the node's health state; see
+// tailcfg.MapResponse.Health.
+type Notice struct {
+	// ID identifies the notice for acknowledging it. It's derived from
+	// Text, so the same message always has the same ID.
+	ID string
+
+	// Text is the human-readable message.
+	Text string
+}
+
+// DNSConfigResponse is the response to a LocalAPI dns-config GET request,
+// describing the DNS configuration tailscaled currently has applied.
+type DNSConfigResponse struct {
+	// Applied is whether a DNS configuration is currently applied. If
+	// false, the remaining fields are empty.
+	Applied bool
+
+	// SplitDNS is whether the OS configuration uses split DNS, sending
+	// only MatchDomains to Nameservers, rather than making Nameservers the
+	// primary resolvers.
+	SplitDNS bool `json:",omitempty"`
+
+	// OS is the configuration pushed to the OS.
+	OS DNSOSConfig
+
+	// Resolver is the configuration of tailscaled's internal resolver,
+	// which answers queries sent to 100.100.100.100.
+	Resolver DNSResolverConfig
+}
+
+// DNSOSConfig is the DNS configuration pushed to the OS.
+type DNSOSConfig struct {
+	// Nameservers are the nameservers the OS is told to use.
+	Nameservers []netip.Addr `json:",omitempty"`
+
+	// SearchDomains are the search domains added to the OS's.
+	SearchDomains []string `json:",omitempty"`
+
+	// MatchDomains, if non-empty, are the only domains for which the OS
+	// uses Nameservers.
+	MatchDomains []string `json:",omitempty"`
+
+	// NumHosts is the number of entries added to the OS hosts file.
+	NumHosts int `json:",omitempty"`
+}
+
+// DNSResolverConfig is the configuration of tailscaled's internal DNS
+// resolver.
+type DNSResolverConfig struct {
+	// Routes maps DNS suffixes to the addresses of the upstream resolvers
+	// queries under them are forwarded to. The suffix "." is the default
+	// route.
+	Routes map[string][]string `json:",omitempty"`
+
+	// LocalDomains are the suffixes answered by the resolver itself and
+	// never forwarded.
+	LocalDomains []string `json:",omitempty"`
+
+	// NumHosts is the number of MagicDNS and extra records the resolver
+	// answers.
+	NumHosts int `json:",omitempty"`
+}
+
+// DNSQueryEvent is a DNS query answered by tailscaled's MagicDNS resolver,
+// as streamed by the LocalAPI watch-dns endpoint.
+type DNSQueryEvent struct {
+	Time time.Time     // when the query arrived
+	Name string        // the name queried, with a trailing dot
+	Type string        // the query type, such as "A" or "AAAA"
+	Took time.Duration // how long the query took to answer
+
+	// Forwarded is whether the query was forwarded upstream rather than
+	// answered by the resolver itself.
+	Forwarded bool `json:",omitempty"`
+
+	// Upstream is the upstream resolver that answered the query, if it
+	// was forwarded and one did.
+	Upstream string `json:",omitempty"`
+
+	// Cached is whether the answer came from a cache of earlier answers.
+	Cached bool `json:",omitempty"`
+
+	RCode string `json:",omitempty"` // response code, such as "Success" or "NameError"
+	Error string `json:",omitempty"`
+}
+
+// DNSConfigChange is a single entry in the response to a LocalAPI
+// dns/history GET request, describing one DNS configuration change.
+type DNSConfigChange struct {
+	// Time is when the change was made.
+	Time time.Time
+
+	// Error is the error from compiling or applying the configuration,
+	// if any.
+	Error string `json:",omitempty"`
+
+	// Resolvers are the addresses of the default DNS resolvers.
+	Resolvers []string `json:",omitempty"`
+
+	// Routes maps DNS suffixes to the addresses of the resolvers used for
+	// them. An empty list means names under the suffix are answered
+	// locally by MagicDNS.
+	Routes map[string][]string `json:",omitempty"`
+
+	// SearchDomains are the DNS search domains.
+	SearchDomains []string `json:",omitempty"`
+
+	// OSNameservers are the nameservers set in the OS configuration.
+	OSNameservers []netip.Addr `json:",omitempty"`
+
+	// Diff describes what changed relative to the previous configuration.
+	// It's nil for the oldest change retained.
+	Diff *DNSConfigDiff `json:",omitempty"`
+}
+
+// DNSConfigDiff describes the difference between two consecutive DNS
+// configurations.
+type DNSConfigDiff struct {
+	ResolversAdded       []string `json:",omitempty"`
+	ResolversRemoved     []string `json:",omitempty"`
+	RoutesAdded          []string `json:",omitempty"` // suffixes
+	RoutesRemoved        []string `json:",omitempty"` // suffixes
+	RoutesChanged        []string `json:",omitempty"` // suffixes whose resolvers changed
+	SearchDomainsAdded   []string `json:",omitempty"`
+	SearchDomainsRemoved []string `json:",omitempty"`
+	OSNameserversAdded   []string `json:",omitempty"`
+	OSNameserversRemoved []string `json:",omitempty"`
+}
+
+// TailnetDNSConfig is the response to a LocalAPI dns/tailnet-config GET
+// request. It describes the DNS configuration pushed by the control plane
+// in the netmap, before any local preferences or OS constraints are applied.
+type TailnetDNSConfig struct {
+	// MagicDNS is whether MagicDNS is enabled for the tailnet.
+	MagicDNS bool
+
+	// AcceptDNS is whether this node's preferences accept the tailnet DNS
+	// configuration. If false, the configuration is not applied locally.
+	AcceptDNS bool
+
+	// Nameservers are the addresses of the tailnet's global nameservers.
+	Nameservers []string `json:",omitempty"`
+
+	// FallbackResolvers are the addresses of the resolvers used when the
+	// tailnet has no global nameservers and the OS's can't be used.
+	FallbackResolvers []string `json:",omitempty"`
+
+	// Routes maps split DNS suffixes to the addresses of the nameservers
+	// used for them. An empty list means names under the suffix are
+	// answered locally by MagicDNS.
+	Routes map[string][]string `json:",omitempty"`
+
+	// SearchDomains are the tailnet's DNS search domains.
+	SearchDomains []string `json:",omitempty"`
+
+	// CertDomains are the domains this node can get TLS certificates for.
+	CertDomains []string `json:",omitempty"`
+
+	// ExtraRecords is the number of extra DNS records pushed by control.
+	ExtraRecords int `json:",omitempty"`
+}
+
+// DERPMeasureResponse is the response to a LocalAPI derp/measure POST
+// request.
+type DERPMeasureResponse struct {
+	// PreferredDERP is the DERP region ID chosen as the home region by
+	// the measurement, or 0 if none was.
+	PreferredDERP int
+
+	// Regions are the measured regions, ordered from lowest to highest
+	// latency.
+	Regions []DERPRegionLatency
+}
+
+// DERPRegionLatency is the measured latency to a single DERP region.
+type DERPRegionLatency struct {
+	RegionID   int
+	RegionCode string
+	Latency    time.Duration // fastest of LatencyV4 and LatencyV6
+	LatencyV4  time.Duration `json:",omitempty"`
+	LatencyV6  time.Duration `json:",omitempty"`
+}
+
+// AcceptedRoutesResponse is the response to a LocalAPI accepted-routes
+// request.
+type AcceptedRoutesResponse struct {
+	// AcceptAll is whether all advertised subnet routes are accepted
+	// because the RouteAll pref (--accept-routes) is set. If so, Accepted
+	// is ignored.
+	AcceptAll bool
+
+	// Available are the subnet routes advertised by peers.
+	Available []netip.Prefix
+
+	// Accepted are the subnet routes the user has chosen to accept. They
+	// need not currently be advertised by any peer.
+	Accepted []netip.Prefix
+}
+
+// AcceptedRoutesRequest is the body of a LocalAPI accepted-routes POST
+// request.
+type AcceptedRoutesRequest struct {
+	// Routes are the subnet routes to accept, replacing any previous
+	// selection. An empty list accepts none.
+	Routes []netip.Prefix
+}
+
+// AddressesResponse is the response to a LocalAPI addresses request.
+type AddressesResponse struct {
+	// Current are the node's tailnet addresses, IPv4 first. It's empty
+	// if the node has no network map yet.
+	Current []netip.Prefix
+
+	// Previous are the tailnet addresses the node had before, most
+	// recently unassigned first. Only the last few are kept.
+	Previous []PastAddress
+}
+
+// PastAddress is a tailnet address previously assigned to the node.
+type PastAddress struct {
+	Addr netip.Prefix
+
+	// Assigned is when the node was first seen with Addr.
+	Assigned time.Time
+
+	// Unassigned is when the node was first seen without Addr.
+	Unassigned time.Time
+}
+
+// RolesResponse is the response to a LocalAPI roles request. It describes
+// the node's responsibilities to the rest of the tailnet.
+type RolesResponse struct {
+	// ExitNode is whether the node offers itself as an exit node and
+	// the control plane has approved it.
+	ExitNode bool
+	// ExitNodePending is whether the node offers itself as an exit node
+	// but the control plane hasn't approved it.
+	ExitNodePending bool `json:",omitempty"`
+	// ExitNodeUsers is how many peers sent traffic to the internet through
+	// the node in the last two minutes.
+	ExitNodeUsers int
+
+	// SubnetRoutes are the subnet routes the node advertises that the
+	// control plane has approved.
+	SubnetRoutes []netip.Prefix
+	// PendingSubnetRoutes are the subnet routes the node advertises that
+	// the control plane hasn't approved.
+	PendingSubnetRoutes []netip.Prefix `json:",omitempty"`
+	// SubnetRouterUsers is how many peers sent traffic to the node's
+	// approved subnet routes in the last two minutes.
+	SubnetRouterUsers int
+
+	// AppConnector is whether the node advertises itself as an app
+	// connector.
+	AppConnector bool
+}
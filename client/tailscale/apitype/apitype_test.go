@@ -0,0 +1,20 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package apitype
+
+import (
+	"testing"
+
+	"tailscale.com/tstest/deptest"
+)
+
+func TestDeps(t *testing.T) {
+	deptest.DepChecker{
+		BadDeps: map[string]string{
+			// apitype holds wire types shared by clients and tailscaled;
+			// build metadata is filled in by the server, not linked here.
+			"tailscale.com/version": "keep apitype free of build metadata",
+		},
+	}.Check(t)
+}
@@ -6,6 +6,7 @@
 package tailscale
 
 import (
+	"bufio"
 	"bytes"
 	"cmp"
 	"context"
@@ -39,6 +40,7 @@
 	"tailscale.com/tka"
 	"tailscale.com/types/key"
 	"tailscale.com/types/tkatype"
+	"tailscale.com/util/clientmetric"
 )
 
 // defaultLocalClient is the default LocalClient when using the legacy
@@ -310,7 +312,8 @@ func (lc *LocalClient) WhoIs(ctx context.Context, remoteAddr string) (*apitype.W
 	return decodeJSON[*apitype.WhoIsResponse](body)
 }
 
-// ErrPeerNotFound is returned by WhoIs and WhoIsNodeKey when a peer is not found.
+// ErrPeerNotFound is returned by WhoIs, WhoIsNodeKey and WhoIsStableID when a
+// peer is not found.
 var ErrPeerNotFound = errors.New("peer not found")
 
 // WhoIsNodeKey returns the owner of the given wireguard public key.
@@ -327,6 +330,20 @@ func (lc *LocalClient) WhoIsNodeKey(ctx context.Context, key key.NodePublic) (*a
 	return decodeJSON[*apitype.WhoIsResponse](body)
 }
 
+// WhoIsStableID returns the owner of the node with the given stable ID.
+//
+// If not found, the error is ErrPeerNotFound.
+func (lc *LocalClient) WhoIsStableID(ctx context.Context, id tailcfg.StableNodeID) (*apitype.WhoIsResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/whois?stableid="+url.QueryEscape(string(id)))
+	if err != nil {
+		if hs, ok := err.(httpStatusError); ok && hs.HTTPStatus == http.StatusNotFound {
+			return nil, ErrPeerNotFound
+		}
+		return nil, err
+	}
+	return decodeJSON[*apitype.WhoIsResponse](body)
+}
+
 // WhoIsProto returns the owner of the remoteAddr, which must be an IP or
 // IP:port, for the given protocol (tcp or udp).
 //
@@ -347,12 +364,44 @@ func (lc *LocalClient) Goroutines(ctx context.Context) ([]byte, error) {
 	return lc.get200(ctx, "/localapi/v0/goroutines")
 }
 
+// GoroutinesMatching returns the stacks of the Tailscale daemon's goroutines
+// whose stack contains substr.
+func (lc *LocalClient) GoroutinesMatching(ctx context.Context, substr string) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/goroutines?match="+url.QueryEscape(substr))
+}
+
+// GoroutineCount returns the number of the Tailscale daemon's goroutines.
+// If substr is non-empty, only goroutines whose stack contains it are
+// counted.
+func (lc *LocalClient) GoroutineCount(ctx context.Context, substr string) (int, error) {
+	v := url.Values{"count": {"1"}}
+	if substr != "" {
+		v.Set("match", substr)
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/goroutines?"+v.Encode())
+	if err != nil {
+		return 0, err
+	}
+	res, err := decodeJSON[struct{ Count int }](body)
+	return res.Count, err
+}
+
 // DaemonMetrics returns the Tailscale daemon's metrics in
 // the Prometheus text exposition format.
 func (lc *LocalClient) DaemonMetrics(ctx context.Context) ([]byte, error) {
 	return lc.get200(ctx, "/localapi/v0/metrics")
 }
 
+// DaemonMetricsJSON returns the current values of the Tailscale daemon's
+// metrics, sorted by name and then by label values.
+func (lc *LocalClient) DaemonMetricsJSON(ctx context.Context) ([]clientmetric.MetricSnapshot, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/metrics.json")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]clientmetric.MetricSnapshot](body)
+}
+
 // IncrementCounter increments the value of a Tailscale daemon's counter
 // metric by the given delta. If the metric has yet to exist, a new counter
 // metric is created and initialized to delta.
@@ -467,6 +516,39 @@ func (lc *LocalClient) BugReportWithOpts(ctx context.Context, opts BugReportOpts
 	return strings.TrimSpace(string(body)), nil
 }
 
+// BugReportBundle runs in-depth diagnostics and returns a zip stream of
+// diagnostic data for support: the bugreport marker, a netmap summary, DERP
+// latencies, prefs and, if the caller has write access, recent logs. If
+// logLines is positive, at most that many log lines are included. The
+// caller must close the returned reader.
+func (lc *LocalClient) BugReportBundle(ctx context.Context, note string, logLines int) (io.ReadCloser, error) {
+	qparams := url.Values{"diagnose": {"true"}}
+	if note != "" {
+		qparams.Set("note", note)
+	}
+	if logLines > 0 {
+		qparams.Set("lines", strconv.Itoa(logLines))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/bugreport?"+qparams.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/zip")
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, errors.New(res.Status)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/zip" {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected bugreport Content-Type %q; tailscaled too old?", ct)
+	}
+	return res.Body, nil
+}
+
 // BugReport logs and returns a log marker that can be shared by the user with support.
 //
 // This is the same as calling BugReportWithOpts and only specifying the Note
@@ -485,6 +567,21 @@ func (lc *LocalClient) DebugAction(ctx context.Context, action string) error {
 	return nil
 }
 
+// DebugSetDERPRegionFailed sets whether tailscaled treats the DERP region
+// with the given ID as unreachable, to test home region failover. It reports
+// the home region after the change.
+func (lc *LocalClient) DebugSetDERPRegionFailed(ctx context.Context, regionID int, failed bool) (*apitype.DERPRegionFailResponse, error) {
+	action := "restore-derp-region"
+	if failed {
+		action = "fail-derp-region"
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug?action="+action+"&region="+strconv.Itoa(regionID), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.DERPRegionFailResponse](body)
+}
+
 // DebugResultJSON invokes a debug action and returns its result as something JSON-able.
 // These are development tools and subject to change or removal over time.
 func (lc *LocalClient) DebugResultJSON(ctx context.Context, action string) (any, error) {
@@ -499,6 +596,34 @@ func (lc *LocalClient) DebugResultJSON(ctx context.Context, action string) (any,
 	return x, nil
 }
 
+// DebugSweepSubnet pings every host in cidr, which must be within one of
+// the node's advertised subnet routes, and reports which replied.
+func (lc *LocalClient) DebugSweepSubnet(ctx context.Context, cidr netip.Prefix) (*apitype.SubnetSweepResponse, error) {
+	v := url.Values{"action": {"sweep-subnet"}, "cidr": {cidr.String()}}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.SubnetSweepResponse](body)
+}
+
+// DebugTrace elevates the logging of subsystem, one of
+// ipn.DebuggableComponents, for duration d, after which it reverts
+// automatically. A zero d reverts it immediately. It returns the subsystems
+// whose logging is elevated afterwards.
+func (lc *LocalClient) DebugTrace(ctx context.Context, subsystem string, d time.Duration) ([]apitype.DebugTrace, error) {
+	v := url.Values{
+		"action":    {"trace"},
+		"subsystem": {subsystem},
+		"seconds":   {strconv.Itoa(int(d.Seconds()))},
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[[]apitype.DebugTrace](body)
+}
+
 // DebugPortmapOpts contains options for the DebugPortmap command.
 type DebugPortmapOpts struct {
 	// Duration is how long the mapping should be created for. It defaults
@@ -626,6 +751,45 @@ func (lc *LocalClient) status(ctx context.Context, queryString string) (*ipnstat
 	return decodeJSON[*ipnstate.Status](body)
 }
 
+// StatusWatch blocks until tailscaled's status differs from the one with
+// ETag lastETag, or until timeout elapses, and returns the current status
+// and its ETag. An empty lastETag waits for any change from the status at
+// the time of the call. If the status is unchanged at the timeout, st is
+// nil and etag is lastETag. A zero timeout uses the server's default.
+func (lc *LocalClient) StatusWatch(ctx context.Context, lastETag string, timeout time.Duration) (st *ipnstate.Status, etag string, err error) {
+	path := "/localapi/v0/status-watch"
+	if timeout > 0 {
+		path += "?timeout=" + url.QueryEscape(timeout.String())
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if lastETag != "" {
+		req.Header.Set("If-None-Match", lastETag)
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return nil, lastETag, nil
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%v: %s", res.Status, bytes.TrimSpace(body))
+	}
+	st, err = decodeJSON[*ipnstate.Status](body)
+	if err != nil {
+		return nil, "", err
+	}
+	return st, res.Header.Get("ETag"), nil
+}
+
 // IDToken is a request to get an OIDC ID token for an audience.
 // The token can be presented to any resource provider which offers OIDC
 // Federation.
@@ -715,6 +879,84 @@ func (lc *LocalClient) PushFile(ctx context.Context, target tailcfg.StableNodeID
 	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
 }
 
+// PushFileWithProgress is like PushFile, but calls progress periodically
+// with the state of the transfer as it proceeds.
+func (lc *LocalClient) PushFileWithProgress(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader, progress func(ipn.OutgoingFile)) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put/"+string(target)+"/"+url.PathEscape(name)+"?progress=1", r)
+	if err != nil {
+		return err
+	}
+	if size != -1 {
+		req.ContentLength = size
+	}
+	return lc.doFilePutEvents(req, progress)
+}
+
+// doFilePutEvents does req, whose response is a stream of file-put
+// progress events, calling progress (if non-nil) with each, and returns the
+// outcome reported by the final "done" event.
+func (lc *LocalClient) doFilePutEvents(req *http.Request, progress func(ipn.OutgoingFile)) error {
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		all, _ := io.ReadAll(res.Body)
+		return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+	}
+
+	var event string
+	bs := bufio.NewScanner(res.Body)
+	for bs.Scan() {
+		line := bs.Text()
+		if v, ok := strings.CutPrefix(line, "event: "); ok {
+			event = v
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		switch event {
+		case "progress":
+			var f ipn.OutgoingFile
+			if err := json.Unmarshal([]byte(data), &f); err == nil && progress != nil {
+				progress(f)
+			}
+		case "done":
+			var st apitype.FilePutStatus
+			if err := json.Unmarshal([]byte(data), &st); err != nil {
+				return fmt.Errorf("invalid file-put result: %w", err)
+			}
+			if st.StatusCode >= 400 {
+				return fmt.Errorf("%d %s: %s", st.StatusCode, http.StatusText(st.StatusCode), st.Error)
+			}
+			return nil
+		}
+	}
+	if err := bs.Err(); err != nil {
+		return err
+	}
+	return errors.New("file transfer ended without a result")
+}
+
+// SendLocalFile asks tailscaled to send the file at path, which must be
+// absolute, to target with Taildrop, reading the file itself. It calls
+// progress (if non-nil) periodically with the state of the transfer, the
+// first time as soon as it starts, and returns once it's done. Canceling
+// ctx cancels the transfer. Only local admins may use it.
+func (lc *LocalClient) SendLocalFile(ctx context.Context, target tailcfg.StableNodeID, path string, progress func(ipn.OutgoingFile)) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-send", jsonBody(apitype.FileSendRequest{
+		StableID: target,
+		Path:     path,
+	}))
+	if err != nil {
+		return err
+	}
+	return lc.doFilePutEvents(req, progress)
+}
+
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
 // machine is properly configured to forward IP packets as a subnet router
 // or exit node.
@@ -755,6 +997,17 @@ func (lc *LocalClient) CheckUDPGROForwarding(ctx context.Context) error {
 	return nil
 }
 
+// SetupChecks runs the host prerequisite checks for this node's role, such
+// as IP forwarding on subnet routers and exit nodes, and returns their
+// results.
+func (lc *LocalClient) SetupChecks(ctx context.Context) (*apitype.SetupChecksResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/setup-checks")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.SetupChecksResponse](body)
+}
+
 // SetUDPGROForwarding enables UDP GRO forwarding for the main interface of this
 // node. This can be done to improve performance of tailnet nodes acting as exit
 // nodes or subnet routers.
@@ -807,6 +1060,16 @@ func (lc *LocalClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn
 	return decodeJSON[*ipn.Prefs](body)
 }
 
+// ResetPrefs resets the current profile's preferences to their defaults
+// without logging out, and returns the resulting preferences.
+func (lc *LocalClient) ResetPrefs(ctx context.Context) (*ipn.Prefs, error) {
+	body, err := lc.send(ctx, "DELETE", "/localapi/v0/prefs", http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.Prefs](body)
+}
+
 // StartLoginInteractive starts an interactive login.
 func (lc *LocalClient) StartLoginInteractive(ctx context.Context) error {
 	_, err := lc.send(ctx, "POST", "/localapi/v0/login-interactive", http.StatusNoContent, nil)
@@ -1066,6 +1329,23 @@ func (lc *LocalClient) PingWithOpts(ctx context.Context, ip netip.Addr, pingtype
 	return decodeJSON[*ipnstate.PingResult](body)
 }
 
+// PingCount sends count pings (at most 10), a second apart, of the provided
+// type to the provided IP and returns their results in order. A ping that
+// fails or gets no reply within 5 seconds has its Err set, and doesn't stop
+// the ones after it.
+func (lc *LocalClient) PingCount(ctx context.Context, ip netip.Addr, pingtype tailcfg.PingType, count int, opts PingOpts) ([]*ipnstate.PingResult, error) {
+	v := url.Values{}
+	v.Set("ip", ip.String())
+	v.Set("size", strconv.Itoa(opts.Size))
+	v.Set("type", string(pingtype))
+	v.Set("count", strconv.Itoa(count))
+	body, err := lc.send(ctx, "POST", "/localapi/v0/ping?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[[]*ipnstate.PingResult](body)
+}
+
 // Ping sends a ping of the provided type to the provided IP and waits
 // for its response.
 func (lc *LocalClient) Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg.PingType) (*ipnstate.PingResult, error) {
@@ -1366,6 +1646,20 @@ func (lc *LocalClient) ProfileStatus(ctx context.Context) (current ipn.LoginProf
 	return current, all, err
 }
 
+// ProfilePrefs returns the prefs of the profile with the given ID, which
+// needn't be the current profile.
+func (lc *LocalClient) ProfilePrefs(ctx context.Context, profile ipn.ProfileID) (*ipn.Prefs, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/profiles/"+url.PathEscape(string(profile))+"/prefs")
+	if err != nil {
+		return nil, err
+	}
+	var p ipn.Prefs
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid prefs JSON: %w", err)
+	}
+	return &p, nil
+}
+
 // ReloadConfig reloads the config file, if possible.
 func (lc *LocalClient) ReloadConfig(ctx context.Context) (ok bool, err error) {
 	body, err := lc.send(ctx, "POST", "/localapi/v0/reload-config", 200, nil)
@@ -1452,6 +1746,347 @@ func (lc *LocalClient) DebugSetExpireIn(ctx context.Context, d time.Duration) er
 	return err
 }
 
+// Inventory returns a summary of the local node suitable for asset
+// management: its identity, addresses, OS, version, tags and routes.
+func (lc *LocalClient) Inventory(ctx context.Context) (*apitype.NodeInventory, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/inventory")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.NodeInventory](body)
+}
+
+// KeyExpiry reports when the current node key expires. If the key expires
+// within threshold, the response's Warning field is set. A zero threshold
+// uses the server's default.
+func (lc *LocalClient) KeyExpiry(ctx context.Context, threshold time.Duration) (*apitype.KeyExpiryResponse, error) {
+	v := url.Values{}
+	if threshold != 0 {
+		v.Set("threshold", threshold.String())
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/key-expiry?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.KeyExpiryResponse](body)
+}
+
+// DNSConfig returns the DNS configuration the node currently has applied to
+// the OS and to its internal resolver.
+func (lc *LocalClient) DNSConfig(ctx context.Context) (*apitype.DNSConfigResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/dns-config")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.DNSConfigResponse](body)
+}
+
+// CheckUpdateAvailable reports whether the control server has told
+// tailscaled that a newer client version is available for this platform,
+// whether or not tailscaled can install it. See CheckUpdate for whether it
+// can.
+func (lc *LocalClient) CheckUpdateAvailable(ctx context.Context) (*apitype.UpdateCheckResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/update/check")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.UpdateCheckResponse](body)
+}
+
+// LoginInfo returns the control server in effect and whether it's
+// Tailscale's default one.
+func (lc *LocalClient) LoginInfo(ctx context.Context) (*apitype.LoginInfoResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/login-info")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.LoginInfoResponse](body)
+}
+
+// Hostname returns the hostname the node reports to control.
+func (lc *LocalClient) Hostname(ctx context.Context) (*apitype.HostnameResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/hostname")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.HostnameResponse](body)
+}
+
+// SetHostname changes the hostname the node reports to control, from which
+// its MagicDNS name is derived. An empty name reverts to the OS hostname.
+func (lc *LocalClient) SetHostname(ctx context.Context, name string) (*apitype.HostnameResponse, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/hostname?hostname="+url.QueryEscape(name), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.HostnameResponse](body)
+}
+
+// AcceptedRoutes returns the subnet routes advertised by peers and which of
+// them are individually accepted.
+func (lc *LocalClient) AcceptedRoutes(ctx context.Context) (*apitype.AcceptedRoutesResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/accepted-routes")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.AcceptedRoutesResponse](body)
+}
+
+// SetAcceptedRoutes sets the subnet routes to accept from peers when
+// --accept-routes is off, replacing any previous selection.
+func (lc *LocalClient) SetAcceptedRoutes(ctx context.Context, routes []netip.Prefix) (*apitype.AcceptedRoutesResponse, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/accepted-routes", 200, jsonBody(apitype.AcceptedRoutesRequest{Routes: routes}))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.AcceptedRoutesResponse](body)
+}
+
+// Addresses returns the node's current tailnet addresses and the last few
+// it had previously.
+func (lc *LocalClient) Addresses(ctx context.Context) (*apitype.AddressesResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/addresses")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.AddressesResponse](body)
+}
+
+// Roles reports whether the node is an exit node, subnet router or app
+// connector, and how many peers are using it as one. Users are counted from
+// the flows tailscaled tracks, as for Connections, so the first call reports
+// none.
+func (lc *LocalClient) Roles(ctx context.Context) (*apitype.RolesResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/roles")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.RolesResponse](body)
+}
+
+// Notices returns the messages the control plane has for the node's user
+// that the user hasn't acknowledged yet.
+func (lc *LocalClient) Notices(ctx context.Context) ([]apitype.Notice, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/notices")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.Notice](body)
+}
+
+// AckNotice acknowledges the notice with the given ID so that it's no longer
+// returned by Notices. It returns the remaining unacknowledged notices.
+func (lc *LocalClient) AckNotice(ctx context.Context, id string) ([]apitype.Notice, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/notices?id="+url.QueryEscape(id), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.Notice](body)
+}
+
+// WatchDNSQueries calls fn with each DNS query answered by tailscaled's
+// MagicDNS resolver until ctx is done or the connection fails. tailscaled
+// must be run with TS_DEBUG_DNS_QUERY_LOG=1.
+func (lc *LocalClient) WatchDNSQueries(ctx context.Context, fn func(apitype.DNSQueryEvent)) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/watch-dns", nil)
+	if err != nil {
+		return err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		all, _ := io.ReadAll(res.Body)
+		return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+	}
+	bs := bufio.NewScanner(res.Body)
+	for bs.Scan() {
+		data, ok := strings.CutPrefix(bs.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev apitype.DNSQueryEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("invalid DNS query event: %w", err)
+		}
+		fn(ev)
+	}
+	if err := bs.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// DNSHistory returns the node's recent DNS configuration changes, newest
+// first. If limit is positive, at most limit changes are returned.
+func (lc *LocalClient) DNSHistory(ctx context.Context, limit int) ([]apitype.DNSConfigChange, error) {
+	v := url.Values{}
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/dns/history?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.DNSConfigChange](body)
+}
+
+// Version returns metadata about the tailscaled build, such as its git
+// commit, Go version and build tags.
+func (lc *LocalClient) Version(ctx context.Context) (*apitype.VersionResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/version")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.VersionResponse](body)
+}
+
+// Connections returns the flows tailscaled recently carried traffic for,
+// most recently active first.
+//
+// tailscaled only tracks flows while they're being asked for: from the
+// first call until 10 minutes after the last one. The first call, and the
+// first after 10 minutes without any, thus return no flows.
+func (lc *LocalClient) Connections(ctx context.Context) ([]apitype.Connection, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/connections")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.Connection](body)
+}
+
+// InboundAccess returns the rules of the packet filter tailscaled enforces
+// that permit other nodes to connect to this one. There are none while
+// shields are up.
+func (lc *LocalClient) InboundAccess(ctx context.Context) ([]apitype.InboundAccessRule, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/inbound-access")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.InboundAccessRule](body)
+}
+
+// LogSegments lists the files of tailscaled's on-disk buffer of log lines
+// not yet uploaded. If since is non-zero, files not modified since then are
+// omitted.
+func (lc *LocalClient) LogSegments(ctx context.Context, since time.Time) ([]apitype.LogSegment, error) {
+	path := "/localapi/v0/logs"
+	if !since.IsZero() {
+		path += "?since=" + url.QueryEscape(since.Format(time.RFC3339))
+	}
+	body, err := lc.get200(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.LogSegment](body)
+}
+
+// LogSegment returns the contents of the named file of tailscaled's on-disk
+// log buffer, as listed by LogSegments.
+func (lc *LocalClient) LogSegment(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/logs?name="+url.QueryEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("HTTP %s: %s", res.Status, body)
+	}
+	return res.Body, nil
+}
+
+// RecentDaemonLogs returns the log lines tailscaled has retained in
+// memory, oldest first, as one JSON object per line in the same form
+// TailDaemonLogs streams.
+func (lc *LocalClient) RecentDaemonLogs(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/logs/recent")
+}
+
+// PeerLatency returns the round-trip times tailscaled recently observed to
+// the peer with the given stable node ID, without sending any pings.
+func (lc *LocalClient) PeerLatency(ctx context.Context, id tailcfg.StableNodeID) (*apitype.PeerLatencyResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/peer-latency?stableid="+url.QueryEscape(string(id)))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.PeerLatencyResponse](body)
+}
+
+// PeerEndpoints returns the candidate endpoints tailscaled is considering
+// for the peer with the given stable node ID, or for all peers if id is
+// empty, and which one it currently uses for direct traffic.
+func (lc *LocalClient) PeerEndpoints(ctx context.Context, id tailcfg.StableNodeID) ([]apitype.PeerEndpoints, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/peer-endpoints?stableid="+url.QueryEscape(string(id)))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.PeerEndpoints](body)
+}
+
+// TailnetDNSConfig returns the DNS configuration the control plane pushed
+// to this node, as opposed to the configuration applied to the OS.
+func (lc *LocalClient) TailnetDNSConfig(ctx context.Context) (*apitype.TailnetDNSConfig, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/dns/tailnet-config")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.TailnetDNSConfig](body)
+}
+
+// MeasureDERPLatency forces an immediate re-measurement of DERP region
+// latencies and returns the results. If regionID is non-zero, only that
+// region is reported. A zero timeout uses the server's default.
+func (lc *LocalClient) MeasureDERPLatency(ctx context.Context, regionID int, timeout time.Duration) (*apitype.DERPMeasureResponse, error) {
+	v := url.Values{}
+	if regionID != 0 {
+		v.Set("region", strconv.Itoa(regionID))
+	}
+	if timeout != 0 {
+		v.Set("timeout", timeout.String())
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/derp/measure?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.DERPMeasureResponse](body)
+}
+
+// Netcheck has tailscaled run a netcheck now and returns its report, a
+// JSON-encoded netcheck.Report. If full, all DERP regions are probed rather
+// than only the quickest few.
+func (lc *LocalClient) Netcheck(ctx context.Context, full bool) ([]byte, error) {
+	return lc.send(ctx, "POST", "/localapi/v0/netcheck?full="+strconv.FormatBool(full), 200, nil)
+}
+
+// Tuning returns the engine's current tuning parameters.
+func (lc *LocalClient) Tuning(ctx context.Context) (*ipn.Tuning, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/tuning")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.Tuning](body)
+}
+
+// SetTuning replaces the engine's tuning parameters with t, returning the
+// parameters now in effect. Zero fields restore their defaults.
+func (lc *LocalClient) SetTuning(ctx context.Context, t ipn.Tuning) (*ipn.Tuning, error) {
+	v := url.Values{}
+	v.Set("keepalive", t.KeepaliveInterval.String())
+	v.Set("derp-fallback", t.DERPFallbackAfter.String())
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tuning?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.Tuning](body)
+}
+
 // StreamDebugCapture streams a pcap-formatted packet capture.
 //
 // The provided context does not determine the lifetime of the
@@ -1529,6 +2164,17 @@ func (lc *LocalClient) SetUseExitNode(ctx context.Context, on bool) error {
 	return err
 }
 
+// SetExitNode sets the exit node to the peer with the given Tailscale IP or
+// MagicDNS name, which must offer to be an exit node, and returns the new
+// prefs. An empty nameOrIP clears the exit node.
+func (lc *LocalClient) SetExitNode(ctx context.Context, nameOrIP string) (*ipn.Prefs, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/set-exit-node?name="+url.QueryEscape(nameOrIP), http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.Prefs](body)
+}
+
 // DriveSetServerAddr instructs Taildrive to use the server at addr to access
 // the filesystem. This is used on platforms like Windows and MacOS to let
 // Taildrive know to use the file server running in the GUI app.
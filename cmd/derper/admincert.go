@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// loadAdminClientCAs reads the PEM-encoded CA certificates in path, which
+// sign the TLS client certificates that operators must present to reach
+// admin (debug) endpoints.
+func loadAdminClientCAs(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// configureAdminClientAuth makes tc ask clients for a certificate and verify
+// any presented against pool. Clients without a certificate (such as regular
+// DERP clients) are still permitted at the TLS layer; it's up to
+// requireAdminClientCert to reject them from admin paths.
+func configureAdminClientAuth(tc *tls.Config, pool *x509.CertPool) error {
+	if tc == nil {
+		return errors.New("admin client certificates require TLS")
+	}
+	tc.ClientCAs = pool
+	tc.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// isAdminPath reports whether path is one of derper's admin endpoints.
+func isAdminPath(path string) bool {
+	return path == "/debug" || strings.HasPrefix(path, "/debug/")
+}
+
+// requireAdminClientCert returns a handler that rejects requests to admin
+// paths with 403 Forbidden unless they arrived over TLS with a verified
+// client certificate. All other requests are passed through to h.
+func requireAdminClientCert(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "admin client certificate required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -7,18 +7,24 @@
 	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"expvar"
+	"fmt"
+	"hash/maphash"
 	"log"
 	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"tailscale.com/logtail/backoff"
 	"tailscale.com/syncs"
+	"tailscale.com/util/limiter"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/slicesx"
 )
@@ -26,19 +32,81 @@
 const refreshTimeout = time.Minute
 
 type dnsEntryMap struct {
-	IPs     map[string][]net.IP
-	Percent map[string]float64 // "foo.com" => 0.5 for 50%
+	IPs       map[string][]net.IP
+	Percent   map[string]float64 // "foo.com" => 0.5 for 50%
+	Refreshed time.Time          // when the names were resolved
+}
+
+// bootstrapDNSV2 is the bootstrap DNS response served for ?v=2. The
+// default (v1) response is just the IPs of each name, as a
+// map[string][]net.IP.
+type bootstrapDNSV2 struct {
+	// Refreshed is when the names were last resolved, so clients can
+	// detect stale answers.
+	Refreshed time.Time
+
+	// Names are the addresses of each name, by name.
+	Names map[string]bootstrapDNSV2Addrs
+}
+
+// bootstrapDNSV2Addrs are the addresses of a name in a bootstrapDNSV2,
+// split by family so clients can prefer one.
+type bootstrapDNSV2Addrs struct {
+	A    []netip.Addr
+	AAAA []netip.Addr
+}
+
+// marshalBootstrapDNS returns the bootstrap DNS response for ips, resolved
+// at refreshed, in the format of version v: 1 for the default format, or 2
+// for bootstrapDNSV2.
+func marshalBootstrapDNS(ips map[string][]net.IP, refreshed time.Time, v int) ([]byte, error) {
+	if v != 2 {
+		return json.MarshalIndent(ips, "", "\t")
+	}
+	res := bootstrapDNSV2{
+		Refreshed: refreshed.UTC(),
+		Names:     make(map[string]bootstrapDNSV2Addrs, len(ips)),
+	}
+	for name, nips := range ips {
+		addrs := bootstrapDNSV2Addrs{A: []netip.Addr{}, AAAA: []netip.Addr{}}
+		for _, ip := range nips {
+			a, ok := netip.AddrFromSlice(ip)
+			if !ok {
+				continue
+			}
+			if a.Is4In6() || a.Is4() {
+				addrs.A = append(addrs.A, a.Unmap())
+			} else {
+				addrs.AAAA = append(addrs.AAAA, a)
+			}
+		}
+		res.Names[name] = addrs
+	}
+	return json.MarshalIndent(res, "", "\t")
 }
 
 var (
 	dnsCache            atomic.Pointer[dnsEntryMap]
 	dnsCacheBytes       syncs.AtomicValue[[]byte] // of JSON
+	dnsCacheBytesV2     syncs.AtomicValue[[]byte] // of JSON, in the ?v=2 format
 	unpublishedDNSCache atomic.Pointer[dnsEntryMap]
 	bootstrapLookupMap  syncs.Map[string, bool]
+
+	// dnsSetCache is the cache of each named group of
+	// --bootstrap-dns-names, by group name. dnsCache, dnsCacheBytes and
+	// dnsCacheBytesV2 hold the first group, which is also served to
+	// clients that don't ask for a group.
+	dnsSetCache syncs.AtomicValue[map[string]dnsSet]
+
+	// bootstrapDNSLimiter, if non-nil, rate limits bootstrap DNS
+	// requests per client IP. It's set at startup from
+	// --bootstrap-dns-qps.
+	bootstrapDNSLimiter *shardedLimiter
 )
 
 var (
 	bootstrapDNSRequests        = expvar.NewInt("counter_bootstrap_dns_requests")
+	bootstrapDNSThrottled       = expvar.NewInt("counter_bootstrap_dns_throttled")
 	publishedDNSHits            = expvar.NewInt("counter_bootstrap_dns_published_hits")
 	publishedDNSMisses          = expvar.NewInt("counter_bootstrap_dns_published_misses")
 	unpublishedDNSHits          = expvar.NewInt("counter_bootstrap_dns_unpublished_hits")
@@ -52,49 +120,148 @@ func init() {
 	}))
 }
 
+// dnsSet is the cached bootstrap DNS of a group of --bootstrap-dns-names.
+type dnsSet struct {
+	entries *dnsEntryMap
+	json    []byte // of entries.IPs
+	jsonV2  []byte // of entries.IPs, in the ?v=2 format
+}
+
+// dnsGroup is a named group of hostnames to resolve for bootstrap DNS.
+type dnsGroup struct {
+	name string // empty for a flag value without groups
+	list string // comma-separated, as passed to resolveList
+}
+
+// parseBootstrapDNSGroups parses the value of --bootstrap-dns-names, which
+// is either a plain comma-separated list of hostnames, or semicolon-separated
+// named groups of them, as in "eu=host1,host2;us=host3".
+func parseBootstrapDNSGroups(v string) ([]dnsGroup, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if !strings.ContainsAny(v, "=;") {
+		return []dnsGroup{{list: v}}, nil
+	}
+	var groups []dnsGroup
+	for _, g := range strings.Split(v, ";") {
+		name, list, ok := strings.Cut(g, "=")
+		if !ok || name == "" || list == "" {
+			return nil, fmt.Errorf("invalid group %q; want <name>=<host>[,<host>...]", g)
+		}
+		if slices.ContainsFunc(groups, func(g dnsGroup) bool { return g.name == name }) {
+			return nil, fmt.Errorf("duplicate group %q", name)
+		}
+		groups = append(groups, dnsGroup{name, list})
+	}
+	return groups, nil
+}
+
+const (
+	// refreshInterval is how often the bootstrap DNS names are
+	// re-resolved once they all resolve.
+	refreshInterval = 10 * time.Minute
+
+	// refreshRetryBase is how soon the bootstrap DNS names are
+	// re-resolved after a failure to resolve any of them. The retry
+	// interval grows with consecutive failures, up to refreshInterval.
+	refreshRetryBase = 30 * time.Second
+)
+
 func refreshBootstrapDNSLoop() {
 	if *bootstrapDNS == "" && *unpublishedDNS == "" {
 		return
 	}
+	bo := backoff.NewBackoff("bootstrap-dns", log.Printf, refreshInterval)
+	bo.Base = refreshRetryBase
 	for {
-		refreshBootstrapDNS()
-		refreshUnpublishedDNS()
-		time.Sleep(10 * time.Minute)
+		err := errors.Join(refreshBootstrapDNS(), refreshUnpublishedDNS())
+		if err == nil {
+			time.Sleep(refreshInterval)
+		}
+		bo.BackOff(context.Background(), err)
 	}
 }
 
-func refreshBootstrapDNS() {
-	if *bootstrapDNS == "" {
-		return
+// refreshBootstrapDNS re-resolves the names of --bootstrap-dns-names. It
+// returns an error if any of them failed to resolve, in which case the
+// others are still updated.
+func refreshBootstrapDNS() error {
+	groups, err := parseBootstrapDNSGroups(*bootstrapDNS)
+	if err != nil {
+		log.Printf("bootstrap DNS: %v", err)
+		return nil // retrying won't help
+	}
+	if len(groups) == 0 {
+		return nil
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *bootstrapDNS)
+	sets := make(map[string]dnsSet, len(groups))
+	var errs []error
+	for _, g := range groups {
+		s, err := resolveSet(ctx, g.list)
+		if s.entries == nil {
+			// leave the old values in place
+			return err
+		}
+		errs = append(errs, err)
+		sets[g.name] = s
+	}
+
+	first := sets[groups[0].name]
+	dnsCache.Store(first.entries)
+	dnsCacheBytes.Store(first.json)
+	dnsCacheBytesV2.Store(first.jsonV2)
+	dnsSetCache.Store(sets)
+	return errors.Join(errs...)
+}
+
+// resolveSet resolves the comma-separated list of hostnames into a dnsSet.
+// If some of the names fail to resolve, it returns the others along with the
+// error from resolveList. If the set can't be encoded, it returns a zero
+// dnsSet.
+func resolveSet(ctx context.Context, list string) (dnsSet, error) {
+	dnsEntries, resolveErr := resolveList(ctx, list)
 	// Randomize the order of the IPs for each name to avoid the client biasing
 	// to IPv6
 	for _, vv := range dnsEntries.IPs {
 		slicesx.Shuffle(vv)
 	}
-	j, err := json.MarshalIndent(dnsEntries.IPs, "", "\t")
+	j, err := marshalBootstrapDNS(dnsEntries.IPs, dnsEntries.Refreshed, 1)
 	if err != nil {
-		// leave the old values in place
-		return
+		return dnsSet{}, err
 	}
-
-	dnsCache.Store(dnsEntries)
-	dnsCacheBytes.Store(j)
+	j2, err := marshalBootstrapDNS(dnsEntries.IPs, dnsEntries.Refreshed, 2)
+	if err != nil {
+		return dnsSet{}, err
+	}
+	return dnsSet{entries: dnsEntries, json: j, jsonV2: j2}, resolveErr
 }
 
-func refreshUnpublishedDNS() {
+// refreshUnpublishedDNS re-resolves the names of
+// --unpublished-bootstrap-dns-names. It returns an error if any of them
+// failed to resolve, in which case the others are still updated.
+func refreshUnpublishedDNS() error {
 	if *unpublishedDNS == "" {
-		return
+		return nil
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *unpublishedDNS)
+	dnsEntries, err := resolveList(ctx, *unpublishedDNS)
 	unpublishedDNSCache.Store(dnsEntries)
+	return err
+}
+
+// resolver is the subset of *net.Resolver used by resolveList.
+type resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
 }
 
+// dnsResolver resolves the bootstrap DNS names. It's replaced in tests.
+var dnsResolver resolver = new(net.Resolver)
+
 // resolveList takes a comma-separated list of DNS names to resolve.
 //
 // If an entry contains a slash, it's two DNS names: the first is the one to
@@ -102,17 +269,22 @@ func refreshUnpublishedDNS() {
 // percentage in range "0".."100". If the TXT record doesn't exist or is
 // malformed, the percentage is 0. If the TXT record is not provided (there's no
 // slash), then the percentage is 100.
-func resolveList(ctx context.Context, list string) *dnsEntryMap {
+//
+// Names that fail to resolve are left out of the result, and the returned
+// error reports them. A missing TXT record isn't an error.
+func resolveList(ctx context.Context, list string) (*dnsEntryMap, error) {
 	ents := strings.Split(list, ",")
 
 	ret := &dnsEntryMap{}
+	var errs []error
 
-	var r net.Resolver
+	r := dnsResolver
 	for _, ent := range ents {
 		name, txtName, _ := strings.Cut(ent, "/")
 		addrs, err := r.LookupIP(ctx, "ip", name)
 		if err != nil {
 			log.Printf("bootstrap DNS lookup %q: %v", name, err)
+			errs = append(errs, fmt.Errorf("lookup %q: %w", name, err))
 			continue
 		}
 		mak.Set(&ret.IPs, name, addrs)
@@ -132,17 +304,96 @@ func resolveList(ctx context.Context, list string) *dnsEntryMap {
 			}
 		}
 	}
-	return ret
+	ret.Refreshed = time.Now()
+	return ret, errors.Join(errs...)
+}
+
+// limiterShards is the number of independent limiters a shardedLimiter
+// spreads client IPs over.
+const limiterShards = 64
+
+// shardedLimiter is a per-IP rate limiter made of independent
+// limiter.Limiters, each covering a fixed subset of IPs, so that
+// concurrent requests from different clients rarely contend for the same
+// limiter's mutex.
+type shardedLimiter struct {
+	seed   maphash.Seed
+	shards [limiterShards]*limiter.Limiter[netip.Addr]
+}
+
+// Allow reports whether ip may make a request now, charging it for one if
+// so.
+func (l *shardedLimiter) Allow(ip netip.Addr) bool {
+	a := ip.As16()
+	return l.shards[maphash.Bytes(l.seed, a[:])%limiterShards].Allow(ip)
+}
+
+// newBootstrapDNSLimiter returns a limiter allowing each client IP qps
+// bootstrap DNS requests per second, with bursts of up to one second's
+// worth, or nil for no limit if qps isn't positive. It tracks up to 10,000
+// recently seen IPs in all.
+func newBootstrapDNSLimiter(qps float64) *shardedLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	l := &shardedLimiter{seed: maphash.MakeSeed()}
+	for i := range l.shards {
+		l.shards[i] = &limiter.Limiter[netip.Addr]{
+			Size:           10_000 / limiterShards,
+			Max:            max(1, int64(qps)),
+			RefillInterval: limiter.QPSInterval(qps),
+		}
+	}
+	return l
+}
+
+// allowBootstrapDNS reports whether the client at remoteAddr may make a
+// bootstrap DNS request under bootstrapDNSLimiter. Requests from unparsable
+// addresses are allowed, as there's nothing to key them by.
+func allowBootstrapDNS(remoteAddr string) bool {
+	lim := bootstrapDNSLimiter
+	if lim == nil {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return true
+	}
+	return lim.Allow(ap.Addr().Unmap())
 }
 
 func handleBootstrapDNS(w http.ResponseWriter, r *http.Request) {
 	bootstrapDNSRequests.Add(1)
+	if !allowBootstrapDNS(r.RemoteAddr) {
+		bootstrapDNSThrottled.Add(1)
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	// Bootstrap DNS requests occur cross-regions, and are randomized per
 	// request, so keeping a connection open is pointlessly expensive.
 	w.Header().Set("Connection", "close")
 
+	// Clients ask for the ?v=2 format explicitly; anything else gets the
+	// original format.
+	v := 1
+	if r.URL.Query().Get("v") == "2" {
+		v = 2
+	}
+
+	// Select the published group the client asked for, if any. Unknown
+	// groups get the default one, as any answer beats none for bootstrapping.
+	pub, pubJSON, pubJSONV2 := dnsCache.Load(), dnsCacheBytes.Load(), dnsCacheBytesV2.Load()
+	if set := r.URL.Query().Get("set"); set != "" {
+		if s, ok := dnsSetCache.Load()[set]; ok {
+			pub, pubJSON, pubJSONV2 = s.entries, s.json, s.jsonV2
+		}
+	}
+	if v == 2 {
+		pubJSON = pubJSONV2
+	}
+
 	// Try answering a query from our hidden map first
 	if q := r.URL.Query().Get("q"); q != "" {
 		bootstrapLookupMap.Store(q, true)
@@ -155,8 +406,8 @@ func handleBootstrapDNS(w http.ResponseWriter, r *http.Request) {
 			percent := m.Percent[q]
 			if remoteAddrMatchesPercent(r.RemoteAddr, percent) {
 				// Only return the specific query, not everything.
-				m := map[string][]net.IP{q: m.IPs[q]}
-				j, err := json.MarshalIndent(m, "", "\t")
+				ips := map[string][]net.IP{q: m.IPs[q]}
+				j, err := marshalBootstrapDNS(ips, m.Refreshed, v)
 				if err == nil {
 					w.Write(j)
 					return
@@ -168,7 +419,7 @@ func handleBootstrapDNS(w http.ResponseWriter, r *http.Request) {
 
 		// If we have a "q" query for a name in the published cache
 		// list, then track whether that's a hit/miss.
-		m := dnsCache.Load()
+		m := pub
 		var inPub bool
 		var ips []net.IP
 		if m != nil {
@@ -188,8 +439,7 @@ func handleBootstrapDNS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fall back to returning the public set of cached DNS names
-	j := dnsCacheBytes.Load()
-	w.Write(j)
+	w.Write(pubJSON)
 }
 
 // percent is [0.0, 1.0].
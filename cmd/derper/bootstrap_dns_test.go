@@ -5,7 +5,9 @@
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"net/http"
@@ -14,6 +16,7 @@
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"tailscale.com/tstest"
 	"tailscale.com/tstest/nettest"
@@ -227,3 +230,212 @@ func TestRemoteAddrMatchesPercent(t *testing.T) {
 		t.Errorf("got %v; want %v ± %v", gotPercent, wantPercent, tolerance)
 	}
 }
+
+func TestBootstrapDNSRateLimit(t *testing.T) {
+	tstest.Replace(t, &bootstrapDNSLimiter, newBootstrapDNSLimiter(2))
+	dnsCacheBytes.Store([]byte(`{}`))
+
+	get := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "https://localhost/bootstrap-dns", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handleBootstrapDNS(w, req)
+		return w.Code
+	}
+	requests, throttled := bootstrapDNSRequests.Value(), bootstrapDNSThrottled.Value()
+	for i := range 2 {
+		if code := get("1.2.3.4:123"); code != 200 {
+			t.Fatalf("request %d: got status %d; want 200", i, code)
+		}
+	}
+	if code := get("1.2.3.4:456"); code != http.StatusTooManyRequests {
+		t.Errorf("third request: got status %d; want 429", code)
+	}
+	if code := get("5.6.7.8:123"); code != 200 {
+		t.Errorf("other client: got status %d; want 200", code)
+	}
+	if got := bootstrapDNSRequests.Value() - requests; got != 4 {
+		t.Errorf("counted %d requests; want 4, including the throttled one", got)
+	}
+	if got := bootstrapDNSThrottled.Value() - throttled; got != 1 {
+		t.Errorf("counted %d throttled requests; want 1", got)
+	}
+
+	if newBootstrapDNSLimiter(0) != nil {
+		t.Errorf("newBootstrapDNSLimiter(0) != nil; want no limit")
+	}
+}
+
+func TestParseBootstrapDNSGroups(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []dnsGroup
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "a.com,b.com", want: []dnsGroup{{list: "a.com,b.com"}}},
+		{in: "eu=a.com,b.com;us=c.com", want: []dnsGroup{{"eu", "a.com,b.com"}, {"us", "c.com"}}},
+		{in: "eu=a.com", want: []dnsGroup{{"eu", "a.com"}}},
+		{in: "a.com;b.com", wantErr: true},
+		{in: "eu=a.com;", wantErr: true},
+		{in: "=a.com", wantErr: true},
+		{in: "eu=", wantErr: true},
+		{in: "eu=a.com;eu=b.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBootstrapDNSGroups(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBootstrapDNSGroups(%q) error = %v; want error = %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBootstrapDNSGroups(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBootstrapDNSSets(t *testing.T) {
+	eu := dnsSet{
+		entries: &dnsEntryMap{IPs: map[string][]net.IP{"eu.example.com": {net.IPv4(1, 1, 1, 1)}}},
+		json:    []byte(`{"eu.example.com":["1.1.1.1"]}`),
+	}
+	us := dnsSet{
+		entries: &dnsEntryMap{IPs: map[string][]net.IP{"us.example.com": {net.IPv4(2, 2, 2, 2)}}},
+		json:    []byte(`{"us.example.com":["2.2.2.2"]}`),
+	}
+	dnsCache.Store(eu.entries)
+	dnsCacheBytes.Store(eu.json)
+	dnsSetCache.Store(map[string]dnsSet{"eu": eu, "us": us})
+	t.Cleanup(func() { dnsSetCache.Store(nil) })
+
+	for _, tt := range []struct {
+		query string
+		want  map[string][]net.IP
+	}{
+		{"", eu.entries.IPs},
+		{"?set=us", us.entries.IPs},
+		{"?set=eu", eu.entries.IPs},
+		{"?set=unknown", eu.entries.IPs},
+	} {
+		req := httptest.NewRequest("GET", "https://localhost/bootstrap-dns"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleBootstrapDNS(w, req)
+		var got map[string][]net.IP
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v; want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalBootstrapDNS(t *testing.T) {
+	ips := map[string][]net.IP{
+		"derp.example.com": {net.IPv4(1, 2, 3, 4), net.ParseIP("2001:db8::1"), net.IPv4(5, 6, 7, 8)},
+		"v6.example.com":   {net.ParseIP("2001:db8::2")},
+	}
+	refreshed := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	got, err := marshalBootstrapDNS(ips, refreshed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantV1 = `{
+	"derp.example.com": [
+		"1.2.3.4",
+		"2001:db8::1",
+		"5.6.7.8"
+	],
+	"v6.example.com": [
+		"2001:db8::2"
+	]
+}`
+	if string(got) != wantV1 {
+		t.Errorf("v1:\ngot:\n%s\nwant:\n%s", got, wantV1)
+	}
+
+	got, err = marshalBootstrapDNS(ips, refreshed, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantV2 = `{
+	"Refreshed": "2024-05-06T07:08:09Z",
+	"Names": {
+		"derp.example.com": {
+			"A": [
+				"1.2.3.4",
+				"5.6.7.8"
+			],
+			"AAAA": [
+				"2001:db8::1"
+			]
+		},
+		"v6.example.com": {
+			"A": [],
+			"AAAA": [
+				"2001:db8::2"
+			]
+		}
+	}
+}`
+	if string(got) != wantV2 {
+		t.Errorf("v2:\ngot:\n%s\nwant:\n%s", got, wantV2)
+	}
+}
+
+func TestBootstrapDNSVersion(t *testing.T) {
+	dnsCache.Store(&dnsEntryMap{})
+	dnsCacheBytes.Store([]byte(`{"v":1}`))
+	dnsCacheBytesV2.Store([]byte(`{"v":2}`))
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"", `{"v":1}`},
+		{"?v=1", `{"v":1}`},
+		{"?v=2", `{"v":2}`},
+		{"?v=3", `{"v":1}`},
+	} {
+		req := httptest.NewRequest("GET", "https://localhost/bootstrap-dns"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleBootstrapDNS(w, req)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%q: got %s; want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+// failingResolver is a resolver for which every lookup fails.
+type failingResolver struct{}
+
+func (failingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (failingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestRefreshBootstrapDNSFailure(t *testing.T) {
+	tstest.Replace[resolver](t, &dnsResolver, failingResolver{})
+	tstest.Replace(t, bootstrapDNS, "eu=foo.invalid;us=bar.invalid")
+	tstest.Replace(t, unpublishedDNS, "baz.invalid")
+	t.Cleanup(func() { dnsSetCache.Store(nil) })
+
+	if err := refreshBootstrapDNS(); err == nil {
+		t.Errorf("refreshBootstrapDNS succeeded; want error so it's retried sooner")
+	}
+	if sets := dnsSetCache.Load(); len(sets) != 2 || len(sets["eu"].entries.IPs) != 0 {
+		t.Errorf("sets = %+v; want 2 empty sets", sets)
+	}
+	if err := refreshUnpublishedDNS(); err == nil {
+		t.Errorf("refreshUnpublishedDNS succeeded; want error so it's retried sooner")
+	}
+
+	tstest.Replace(t, bootstrapDNS, "")
+	tstest.Replace(t, unpublishedDNS, "")
+	if err := errors.Join(refreshBootstrapDNS(), refreshUnpublishedDNS()); err != nil {
+		t.Errorf("refreshing no names: %v", err)
+	}
+}
@@ -14,6 +14,7 @@
 	"cmp"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"expvar"
@@ -60,14 +61,17 @@
 	hostname    = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443")
 	runSTUN     = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
 	runDERP     = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
+	stunOnly    = flag.Bool("stun-only", false, "run only the STUN server, without DERP relaying or bootstrap DNS. The HTTP(S) listener still serves /healthz and the debug handlers.")
 
 	meshPSKFile     = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
 	meshWith        = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list")
-	bootstrapDNS    = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns")
+	bootstrapDNS    = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns. To serve different hostnames to different clients, it may instead be a semicolon-separated list of named groups, as in `eu=host1,host2;us=host3`; clients pick a group with ?set=<name>, defaulting to the first.")
+	bootstrapDNSQPS = flag.Float64("bootstrap-dns-qps", 0, "if positive, the rate limit of /bootstrap-dns requests per second per client IP; requests in excess of it get a 429 response")
 	unpublishedDNS  = flag.String("unpublished-bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns and not publish in the list. If an entry contains a slash, the second part names a DNS record to poll for its TXT record with a `0` to `100` value for rollout percentage.")
 	verifyClients   = flag.Bool("verify-clients", false, "verify clients to this DERP server through a local tailscaled instance.")
 	verifyClientURL = flag.String("verify-client-url", "", "if non-empty, an admission controller URL for permitting client connections; see tailcfg.DERPAdmitClientRequest")
 	verifyFailOpen  = flag.Bool("verify-client-url-fail-open", true, "whether we fail open if --verify-client-url is unreachable")
+	adminClientCA   = flag.String("admin-client-ca", "", "if non-empty, path to a PEM file of CA certificates; requests to /debug/ must then present a TLS client certificate signed by one of them. Requires TLS.")
 
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
@@ -93,7 +97,7 @@ type config struct {
 }
 
 func loadConfig() config {
-	if *dev {
+	if *dev || *stunOnly {
 		return config{PrivateKey: key.NewNode()}
 	}
 	if *configPath == "" {
@@ -159,9 +163,32 @@ func main() {
 		log.Fatalf("invalid server address: %v", err)
 	}
 
+	if _, err := parseBootstrapDNSGroups(*bootstrapDNS); err != nil {
+		log.Fatalf("invalid --bootstrap-dns-names: %v", err)
+	}
+
+	if *stunOnly {
+		if !*runSTUN {
+			log.Fatalf("--stun-only requires --stun")
+		}
+		if *meshWith != "" {
+			log.Fatalf("--stun-only can't be used with --mesh-with")
+		}
+		*runDERP = false
+	}
+
 	if *runSTUN {
 		ss := stunserver.New(ctx)
-		go ss.ListenAndServe(net.JoinHostPort(listenHost, fmt.Sprint(*stunPort)))
+		stunAddr := net.JoinHostPort(listenHost, fmt.Sprint(*stunPort))
+		if *stunOnly {
+			// With nothing else to serve, failing to listen is fatal.
+			if err := ss.Listen(stunAddr); err != nil {
+				log.Fatalf("STUN: %v", err)
+			}
+			go ss.Serve()
+		} else {
+			go ss.ListenAndServe(stunAddr)
+		}
 	}
 
 	cfg := loadConfig()
@@ -185,10 +212,12 @@ func main() {
 		s.SetMeshKey(key)
 		log.Printf("DERP mesh key configured")
 	}
-	if err := startMesh(s); err != nil {
-		log.Fatalf("startMesh: %v", err)
+	if !*stunOnly {
+		if err := startMesh(s); err != nil {
+			log.Fatalf("startMesh: %v", err)
+		}
+		expvar.Publish("derp", s.ExpVar())
 	}
-	expvar.Publish("derp", s.ExpVar())
 
 	mux := http.NewServeMux()
 	if *runDERP {
@@ -201,13 +230,23 @@ func main() {
 		}))
 	}
 
-	// These two endpoints are the same. Different versions of the clients
-	// have assumes different paths over time so we support both.
-	mux.HandleFunc("/derp/probe", derphttp.ProbeHandler)
-	mux.HandleFunc("/derp/latency-check", derphttp.ProbeHandler)
-
-	go refreshBootstrapDNSLoop()
-	mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
+	if *stunOnly {
+		for _, path := range []string{"/derp/", "/bootstrap-dns"} {
+			mux.Handle(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "STUN-only server", http.StatusNotFound)
+			}))
+		}
+	} else {
+		// These two endpoints are the same. Different versions of the clients
+		// have assumes different paths over time so we support both.
+		mux.HandleFunc("/derp/probe", derphttp.ProbeHandler)
+		mux.HandleFunc("/derp/latency-check", derphttp.ProbeHandler)
+
+		bootstrapDNSLimiter = newBootstrapDNSLimiter(*bootstrapDNSQPS)
+		go refreshBootstrapDNSLoop()
+		mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
+	}
+	mux.HandleFunc("/healthz", serveHealthz)
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tsweb.AddBrowserHeaders(w)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -226,7 +265,9 @@ func main() {
   <li><a href="https://github.com/tailscale/tailscale/tree/main/cmd/derper#derp">How to run a DERP server</a></li>
 </ul>
 `)
-		if !*runDERP {
+		if *stunOnly {
+			io.WriteString(w, `<p>Status: <b>STUN only</b></p>`)
+		} else if !*runDERP {
 			io.WriteString(w, `<p>Status: <b>disabled</b></p>`)
 		}
 		if tsweb.AllowDebugAccess(r) {
@@ -241,6 +282,7 @@ func main() {
 	debug := tsweb.Debugger(mux)
 	debug.KV("TLS hostname", *hostname)
 	debug.KV("Mesh key", s.HasMeshKey())
+	debug.KV("STUN only", *stunOnly)
 	debug.Handle("check", "Consistency check", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		err := s.ConsistencyCheck()
 		if err != nil {
@@ -265,6 +307,22 @@ func main() {
 		fmt.Fprintf(w, "mutex changed from %v to %v\n", old, v)
 	}))
 
+	// handler is mux, optionally wrapped to require admin client
+	// certificates for the debug endpoints.
+	var handler http.Handler = mux
+	var adminCAs *x509.CertPool
+	if *adminClientCA != "" {
+		if !serveTLS {
+			log.Fatalf("derper: --admin-client-ca requires TLS")
+		}
+		adminCAs, err = loadAdminClientCAs(*adminClientCA)
+		if err != nil {
+			log.Fatalf("derper: loading admin client CAs: %v", err)
+		}
+		handler = requireAdminClientCert(mux)
+		log.Printf("derper: admin endpoints require a client certificate")
+	}
+
 	// Longer lived DERP connections send an application layer keepalive. Note
 	// if the keepalive is hit, the user timeout will take precedence over the
 	// keepalive counter, so the probe if unanswered will take effect promptly,
@@ -277,7 +335,7 @@ func main() {
 	quietLogger := log.New(logger.HTTPServerLogFilter{Inner: log.Printf}, "", 0)
 	httpsrv := &http.Server{
 		Addr:     *addr,
-		Handler:  mux,
+		Handler:  handler,
 		ErrorLog: quietLogger,
 
 		// Set read/write timeout. For derper, this basically
@@ -314,6 +372,11 @@ func main() {
 		}
 		// Disable TLS 1.0 and 1.1, which are obsolete and have security issues.
 		httpsrv.TLSConfig.MinVersion = tls.VersionTLS12
+		if adminCAs != nil {
+			if err := configureAdminClientAuth(httpsrv.TLSConfig, adminCAs); err != nil {
+				log.Fatalf("derper: %v", err)
+			}
+		}
 		httpsrv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.TLS != nil {
 				label := "unknown"
@@ -332,13 +395,13 @@ func main() {
 				defer tlsActiveVersion.Add(label, -1)
 			}
 
-			mux.ServeHTTP(w, r)
+			handler.ServeHTTP(w, r)
 		})
 		if *httpPort > -1 {
 			go func() {
 				port80mux := http.NewServeMux()
 				port80mux.HandleFunc("/generate_204", derphttp.ServeNoContent)
-				port80mux.Handle("/", certManager.HTTPHandler(tsweb.Port80Handler{Main: mux}))
+				port80mux.Handle("/", certManager.HTTPHandler(tsweb.Port80Handler{Main: handler}))
 				port80srv := &http.Server{
 					Addr:        net.JoinHostPort(listenHost, fmt.Sprintf("%d", *httpPort)),
 					Handler:     port80mux,
@@ -468,3 +531,25 @@ func init() {
 		return 0
 	}))
 }
+
+// serveHealthz reports which of the DERP and STUN services this server runs
+// and, if STUN is running, how many binding requests it has answered.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	mode := "derp"
+	switch {
+	case *stunOnly:
+		mode = "stun-only"
+	case !*runDERP && !*runSTUN:
+		mode = "disabled"
+	case !*runDERP:
+		mode = "stun"
+	case *runSTUN:
+		mode = "derp+stun"
+	}
+	fmt.Fprintf(w, "mode: %s\n", mode)
+	if *runSTUN {
+		reqs, succ := stunserver.Stats()
+		fmt.Fprintf(w, "stun_binding_requests: %d\nstun_binding_successes: %d\n", reqs, succ)
+	}
+}
@@ -5,6 +5,8 @@
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -110,3 +112,62 @@ func TestDeps(t *testing.T) {
 		},
 	}.Check(t)
 }
+
+func TestRequireAdminClientCert(t *testing.T) {
+	h := requireAdminClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{new(x509.Certificate)}}}
+	tests := []struct {
+		name string
+		path string
+		tls  *tls.ConnectionState
+		want int
+	}{
+		{"public-plain", "/derp/probe", nil, http.StatusNoContent},
+		{"bootstrap-dns", "/bootstrap-dns", &tls.ConnectionState{}, http.StatusNoContent},
+		{"debug-plain", "/debug/", nil, http.StatusForbidden},
+		{"debug-no-cert", "/debug/metrics", &tls.ConnectionState{}, http.StatusForbidden},
+		{"debug-root-no-cert", "/debug", &tls.ConnectionState{}, http.StatusForbidden},
+		{"debug-verified", "/debug/metrics", verified, http.StatusNoContent},
+		{"debugger-lookalike", "/debugger", nil, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.TLS = tt.tls
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d; want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	defer func(derp, stun, only bool) {
+		*runDERP, *runSTUN, *stunOnly = derp, stun, only
+	}(*runDERP, *runSTUN, *stunOnly)
+
+	tests := []struct {
+		derp, stun, only bool
+		want             string
+	}{
+		{derp: true, stun: true, want: "mode: derp+stun\n"},
+		{derp: true, want: "mode: derp\n"},
+		{stun: true, only: true, want: "mode: stun-only\n"},
+	}
+	for _, tt := range tests {
+		*runDERP, *runSTUN, *stunOnly = tt.derp, tt.stun, tt.only
+		rec := httptest.NewRecorder()
+		serveHealthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+		got := rec.Body.String()
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("derp=%v stun=%v only=%v: got %q, want prefix %q", tt.derp, tt.stun, tt.only, got, tt.want)
+		}
+		if hasStats := strings.Contains(got, "stun_binding_requests:"); hasStats != tt.stun {
+			t.Errorf("derp=%v stun=%v only=%v: got %q; STUN stats present = %v", tt.derp, tt.stun, tt.only, got, hasStats)
+		}
+	}
+}
@@ -8,6 +8,8 @@
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
@@ -22,6 +24,7 @@
 		fs := newFlagSet("bugreport")
 		fs.BoolVar(&bugReportArgs.diagnose, "diagnose", false, "run additional in-depth checks")
 		fs.BoolVar(&bugReportArgs.record, "record", false, "if true, pause and then write another bugreport")
+		fs.StringVar(&bugReportArgs.bundle, "bundle", "", "if non-empty, run in-depth checks and write a zip of diagnostics (netmap summary, DERP latency, prefs, recent logs) to this file")
 		return fs
 	})(),
 }
@@ -29,6 +32,7 @@
 var bugReportArgs struct {
 	diagnose bool
 	record   bool
+	bundle   string
 }
 
 func runBugReport(ctx context.Context, args []string) error {
@@ -40,6 +44,12 @@ func runBugReport(ctx context.Context, args []string) error {
 	default:
 		return errors.New("unknown arguments")
 	}
+	if bugReportArgs.bundle != "" {
+		if bugReportArgs.record {
+			return errors.New("--bundle and --record are mutually exclusive")
+		}
+		return writeBugReportBundle(ctx, note, bugReportArgs.bundle)
+	}
 	opts := tailscale.BugReportOpts{
 		Note:     note,
 		Diagnose: bugReportArgs.diagnose,
@@ -81,3 +91,25 @@ type bugReportResp struct {
 	outln("Please provide both bugreport markers above to the support team or GitHub issue.")
 	return nil
 }
+
+func writeBugReportBundle(ctx context.Context, note, path string) error {
+	rc, err := localClient.BugReportBundle(ctx, note, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	outln("Wrote diagnostic bundle to " + path)
+	outln("Please attach it to your support ticket or GitHub issue.")
+	return nil
+}
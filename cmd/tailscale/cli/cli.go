@@ -206,6 +206,7 @@ func newRootCmd() *ffcli.Command {
 			debugCmd,
 			driveCmd,
 			idTokenCmd,
+			dnsCmd,
 		},
 		FlagSet: rootfs,
 		Exec: func(ctx context.Context, args []string) error {
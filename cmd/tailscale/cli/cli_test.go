@@ -6,16 +6,22 @@
 import (
 	"bytes"
 	stdcmp "cmp"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/netip"
 	"reflect"
+	"regexp"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/health/healthmsg"
 	"tailscale.com/ipn"
@@ -27,6 +33,7 @@
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
+	"tailscale.com/types/ptr"
 	"tailscale.com/version/distro"
 )
 
@@ -1476,3 +1483,335 @@ func TestParseNLArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestReplayWatchIPN(t *testing.T) {
+	var rec bytes.Buffer
+	enc := json.NewEncoder(&rec)
+	t0 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, n := range []ipn.Notify{
+		{Version: "1.2.3"},
+		{ErrMessage: ptr.To("boom")},
+	} {
+		raw, err := json.Marshal(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(watchIPNRecord{Time: t0.Add(time.Duration(i) * time.Second), Notify: raw}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := replayWatchIPN(context.Background(), &rec, &out, false); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"# 2024-01-02T03:04:05Z\n",
+		`"Version": "1.2.3"`,
+		"# 2024-01-02T03:04:06Z\n",
+		`"ErrMessage": "boom"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q; got:\n%s", want, got)
+		}
+	}
+
+	if err := replayWatchIPN(context.Background(), strings.NewReader("not json"), io.Discard, false); err == nil {
+		t.Error("replaying garbage succeeded; want error")
+	}
+}
+
+func TestPrintDNSStatus(t *testing.T) {
+	var out bytes.Buffer
+	printDNSStatus(&out, &apitype.DNSConfigResponse{
+		Applied: true,
+		OS: apitype.DNSOSConfig{
+			Nameservers:   []netip.Addr{netip.MustParseAddr("100.100.100.100")},
+			SearchDomains: []string{"tail1234.ts.net."},
+		},
+		Resolver: apitype.DNSResolverConfig{
+			Routes: map[string][]string{
+				".":                 {"8.8.8.8"},
+				"tail1234.ts.net.":  nil,
+				"corp.example.com.": {"10.0.0.53"},
+			},
+		},
+	})
+	got := out.String()
+	for _, want := range []string{
+		"DNS mode: primary\n",
+		"100.100.100.100  MagicDNS\n",
+		"Search domains: tail1234.ts.net.\n",
+		"Match domains:  (none)\n",
+		"corp.example.com.  10.0.0.53 (upstream)\n",
+		"tail1234.ts.net.   (answered by MagicDNS)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q; got:\n%s", want, got)
+		}
+	}
+
+	out.Reset()
+	printDNSStatus(&out, &apitype.DNSConfigResponse{})
+	if got, want := out.String(), "No DNS configuration is applied.\n"; got != want {
+		t.Errorf("unapplied output = %q; want %q", got, want)
+	}
+}
+
+func TestDebugTraceSubsystem(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantSub     string
+		wantSeconds int
+		wantFollow  bool
+		wantErr     bool
+	}{
+		{args: []string{"magicsock"}, wantSub: "magicsock", wantSeconds: 60},
+		{args: []string{"--seconds=30", "dns"}, wantSub: "dns", wantSeconds: 30},
+		{args: []string{"magicsock", "--seconds=30", "--follow"}, wantSub: "magicsock", wantSeconds: 30, wantFollow: true},
+		{args: []string{"--list"}, wantSeconds: 60},
+		{args: []string{"--list", "dns"}, wantErr: true},
+		{args: []string{}, wantErr: true},
+		{args: []string{"bogus"}, wantErr: true},
+		{args: []string{"dns", "magicsock"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			debugTraceArgs.list = false
+			debugTraceArgs.seconds = 60
+			debugTraceArgs.follow = false
+			if err := debugTraceFlags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			sub, err := debugTraceSubsystem(debugTraceFlags.Args())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sub != tt.wantSub {
+				t.Errorf("subsystem = %q; want %q", sub, tt.wantSub)
+			}
+			if debugTraceArgs.seconds != tt.wantSeconds {
+				t.Errorf("seconds = %d; want %d", debugTraceArgs.seconds, tt.wantSeconds)
+			}
+			if debugTraceArgs.follow != tt.wantFollow {
+				t.Errorf("follow = %v; want %v", debugTraceArgs.follow, tt.wantFollow)
+			}
+		})
+	}
+}
+
+func TestRunViaBatch(t *testing.T) {
+	in := strings.Join([]string{
+		"# site routes",
+		"7 10.1.0.0/16",
+		"",
+		"fd7a:115c:a1e0:b1a:0:7:a01:0/112",
+		"bogus",
+		"70000 10.0.0.0/8",
+		"0x10 192.168.0.0/24",
+	}, "\n")
+	var out bytes.Buffer
+	err := runViaBatch(strings.NewReader(in), &out)
+	if err == nil || !strings.Contains(err.Error(), "2 line(s) failed") {
+		t.Errorf("err = %v; want 2 failed lines", err)
+	}
+	want := strings.Join([]string{
+		"fd7a:115c:a1e0:b1a:0:7:a01:0/112",
+		"site 7 (0x7), 10.1.0.0/16",
+		`error: bogus: netip.ParsePrefix("bogus"): no '/'`,
+		"error: 70000 10.0.0.0/8: site-id values over 65535 are currently reserved",
+		"fd7a:115c:a1e0:b1a:0:10:c0a8:0/120",
+	}, "\n") + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSelectMetrics(t *testing.T) {
+	const in = `# TYPE b_count counter
+b_count 5
+# TYPE a_gauge gauge
+a_gauge 10
+# TYPE c_count counter
+c_count 7
+`
+	tests := []struct {
+		name   string
+		filter string
+		sortBy string
+		want   []string // metric names, in order
+	}{
+		{name: "all", want: []string{"b_count", "a_gauge", "c_count"}},
+		{name: "filter", filter: "_count$", want: []string{"b_count", "c_count"}},
+		{name: "sort-name", sortBy: "name", want: []string{"a_gauge", "b_count", "c_count"}},
+		{name: "sort-value", sortBy: "value", want: []string{"a_gauge", "c_count", "b_count"}},
+		{name: "filter-sort-value", filter: "count", sortBy: "value", want: []string{"c_count", "b_count"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter *regexp.Regexp
+			if tt.filter != "" {
+				filter = regexp.MustCompile(tt.filter)
+			}
+			out := string(selectMetrics([]byte(in), filter, tt.sortBy))
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+				if name, ok := strings.CutPrefix(line, "# TYPE "); ok {
+					name, _, _ = strings.Cut(name, " ")
+					got = append(got, name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got metrics %q; want %q; output:\n%s", got, tt.want, out)
+			}
+		})
+	}
+}
+
+func TestSelectMetricsFamilies(t *testing.T) {
+	const in = `# TYPE z_latency histogram
+z_latency_bucket{le="10"} 1
+z_latency_bucket{le="+Inf"} 3
+z_latency_sum 42.5
+z_latency_count 3
+# TYPE derp_packets counter
+derp_packets{region="nyc"} 1
+derp_packets{region="sfo"} 1
+# TYPE a_gauge gauge
+a_gauge 2
+`
+	want := `# TYPE z_latency histogram
+z_latency_bucket{le="10"} 1
+z_latency_bucket{le="+Inf"} 3
+z_latency_sum 42.5
+z_latency_count 3
+# TYPE a_gauge gauge
+a_gauge 2
+# TYPE derp_packets counter
+derp_packets{region="nyc"} 1
+derp_packets{region="sfo"} 1
+`
+	if got := string(selectMetrics([]byte(in), nil, "value")); got != want {
+		t.Errorf("sorted by value:\n%s\nwant:\n%s", got, want)
+	}
+
+	want = `# TYPE derp_packets counter
+derp_packets{region="sfo"} 1
+`
+	if got := string(selectMetrics([]byte(in), regexp.MustCompile("sfo"), "name")); got != want {
+		t.Errorf("filtered by label:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDiffPrefs(t *testing.T) {
+	a := ipn.NewPrefs()
+	b := ipn.NewPrefs()
+	b.ShieldsUp = true
+	b.AutoUpdate.Check = !a.AutoUpdate.Check
+	b.AdvertiseTags = []string{"tag:server"}
+
+	diffs, err := diffPrefs(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range diffs {
+		got = append(got, fmt.Sprintf("%s: %s => %s", d.field, d.a, d.b))
+	}
+	want := []string{
+		`AdvertiseTags: null => ["tag:server"]`,
+		fmt.Sprintf("AutoUpdate.Check: %v => %v", a.AutoUpdate.Check, b.AutoUpdate.Check),
+		"ShieldsUp: false => true",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("diffs:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if diffs, err := diffPrefs(a, a.Clone()); err != nil || len(diffs) != 0 {
+		t.Errorf("diffPrefs of equal prefs = %v, %v; want none", diffs, err)
+	}
+}
+
+func TestFindProfile(t *testing.T) {
+	all := []ipn.LoginProfile{
+		{ID: "1a2b", Name: "alice@example.com"},
+		{ID: "3c4d", Name: "bob@example.com"},
+		{ID: "5e6f", Name: "bob@example.com"},
+	}
+	for _, tt := range []struct {
+		arg     string
+		wantID  ipn.ProfileID
+		wantErr bool
+	}{
+		{arg: "3c4d", wantID: "3c4d"},
+		{arg: "alice@example.com", wantID: "1a2b"},
+		{arg: "bob@example.com", wantErr: true},
+		{arg: "carol@example.com", wantErr: true},
+	} {
+		p, err := findProfile(all, tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("findProfile(%q) error = %v; wantErr %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if err == nil && p.ID != tt.wantID {
+			t.Errorf("findProfile(%q) = %q; want %q", tt.arg, p.ID, tt.wantID)
+		}
+	}
+}
+
+func TestIsWhoIsAddr(t *testing.T) {
+	for arg, want := range map[string]bool{
+		"100.101.102.103":         true,
+		"100.101.102.103:22":      true,
+		"fd7a:115c:a1e0::1":       true,
+		"[fd7a:115c:a1e0::1]:443": true,
+		"nodekey:0123abcd":        true,
+		"nTRN6pXGEB11CNTRL":       false,
+		"n1234":                   false,
+	} {
+		if got := isWhoIsAddr(arg); got != want {
+			t.Errorf("isWhoIsAddr(%q) = %v; want %v", arg, got, want)
+		}
+	}
+}
+
+func TestPrintPeerEndpoints(t *testing.T) {
+	ap := netip.MustParseAddrPort
+	peers := []apitype.PeerEndpoints{
+		{
+			StableID:        "nA",
+			Name:            "a.example.ts.net",
+			BestAddr:        "192.168.1.2:41641",
+			BestAddrTrusted: true,
+			DERPRegion:      1,
+			DERPRegionCode:  "nyc",
+			Candidates: []apitype.EndpointCandidate{
+				{Addr: ap("192.168.1.2:41641"), Source: "netmap", LatencyMs: 1.25},
+				{Addr: ap("203.0.113.5:41641"), Source: "call-me-maybe"},
+			},
+		},
+		{
+			StableID:   "nB",
+			Name:       "b.example.ts.net",
+			DERPRegion: 2,
+		},
+	}
+	var buf bytes.Buffer
+	if err := printPeerEndpoints(&buf, peers); err != nil {
+		t.Fatal(err)
+	}
+	want := `a.example.ts.net (nA), home DERP nyc, path direct 192.168.1.2:41641
+  * 192.168.1.2:41641  netmap         1.2ms
+    203.0.113.5:41641  call-me-maybe  -
+
+b.example.ts.net (nB), home DERP 2, path derp
+  no candidate endpoints
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -6,7 +6,9 @@
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -14,16 +16,22 @@
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/netip"
 	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -42,6 +50,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/must"
 	"tailscale.com/wgengine/capture"
 )
@@ -61,6 +70,8 @@
 		return fs
 	})(),
 	Subcommands: []*ffcli.Command{
+		debugDoctorCmd,
+		debugTraceCmd,
 		{
 			Name:       "derp-map",
 			ShortUsage: "tailscale debug derp-map",
@@ -80,9 +91,15 @@
 		},
 		{
 			Name:       "daemon-goroutines",
-			ShortUsage: "tailscale debug daemon-goroutines",
+			ShortUsage: "tailscale debug daemon-goroutines [--match=SUBSTR] [--count]",
 			Exec:       runDaemonGoroutines,
 			ShortHelp:  "Print tailscaled's goroutines",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("daemon-goroutines")
+				fs.StringVar(&daemonGoroutinesArgs.match, "match", "", "only include goroutines whose stack contains this substring")
+				fs.BoolVar(&daemonGoroutinesArgs.count, "count", false, "print only the number of goroutines")
+				return fs
+			})(),
 		},
 		{
 			Name:       "daemon-logs",
@@ -96,14 +113,35 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "logs",
+			ShortUsage: "tailscale debug logs [--follow] [--grep=<regexp>] [--since=<time>]",
+			Exec:       runDebugLogs,
+			ShortHelp:  "Print tailscaled's recent logs",
+			LongHelp: `Print the recent log lines tailscaled has retained in memory.
+
+With --follow, then keep printing new log lines as tailscaled writes them,
+reconnecting if tailscaled restarts.
+
+The --since flag takes an RFC 3339 time or a duration before now, such as "10m".`,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("logs")
+				fs.BoolVar(&debugLogsArgs.follow, "follow", false, "keep printing new log lines as they're written")
+				fs.StringVar(&debugLogsArgs.grep, "grep", "", "if non-empty, only print lines matching this regular expression")
+				fs.StringVar(&debugLogsArgs.since, "since", "", "if non-empty, only print lines logged since this RFC 3339 time or duration ago")
+				return fs
+			})(),
+		},
 		{
 			Name:       "metrics",
-			ShortUsage: "tailscale debug metrics",
+			ShortUsage: "tailscale debug metrics [--watch] [--filter=<regexp>] [--sort=name|value]",
 			Exec:       runDaemonMetrics,
 			ShortHelp:  "Print tailscaled's metrics",
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("metrics")
 				fs.BoolVar(&metricsArgs.watch, "watch", false, "print JSON dump of delta values")
+				fs.StringVar(&metricsArgs.filter, "filter", "", "if non-empty, only print metrics whose names match this regexp")
+				fs.StringVar(&metricsArgs.sort, "sort", "", `without --watch, sort metrics by "name" or by "value" (largest first)`)
 				return fs
 			})(),
 		},
@@ -173,6 +211,30 @@
 			Exec:       localAPIAction("pick-new-derp"),
 			ShortHelp:  "Switch to some other random DERP home region for a short time",
 		},
+		{
+			Name:       "disable-derp",
+			ShortUsage: "tailscale debug disable-derp",
+			Exec:       localAPIResultAction("disable-derp"),
+			ShortHelp:  "Disable DERP relaying so only direct connections are used (breaks reachability)",
+		},
+		{
+			Name:       "enable-derp",
+			ShortUsage: "tailscale debug enable-derp",
+			Exec:       localAPIResultAction("enable-derp"),
+			ShortHelp:  "Re-enable DERP relaying after disable-derp",
+		},
+		{
+			Name:       "fail-derp-region",
+			ShortUsage: "tailscale debug fail-derp-region <region-id>",
+			Exec:       runFailDERPRegion(true),
+			ShortHelp:  "Treat a DERP region as down until restored, forcing a home region failover",
+		},
+		{
+			Name:       "restore-derp-region",
+			ShortUsage: "tailscale debug restore-derp-region <region-id>",
+			Exec:       runFailDERPRegion(false),
+			ShortHelp:  "Stop treating a DERP region as down after fail-derp-region",
+		},
 		{
 			Name:       "force-netmap-update",
 			ShortUsage: "tailscale debug force-netmap-update",
@@ -203,17 +265,51 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "prefs-diff",
+			ShortUsage: "tailscale debug prefs-diff <profile> <profile>",
+			Exec:       runPrefsDiff,
+			ShortHelp:  "Print the prefs that differ between two profiles",
+			LongHelp: `Print the prefs that differ between two profiles, one per line.
+
+Each profile is given by its ID or name, as listed by "tailscale switch --list".
+Nested prefs are named by their path, such as "AutoUpdate.Check".`,
+		},
 		{
 			Name:       "watch-ipn",
-			ShortUsage: "tailscale debug watch-ipn",
+			ShortUsage: "tailscale debug watch-ipn [--record=FILE | --replay=FILE]",
 			Exec:       runWatchIPN,
 			ShortHelp:  "Subscribe to IPN message bus",
+			LongHelp: `Subscribe to the IPN message bus and print each message as JSON.
+
+With --record, each message is also appended with its arrival time to FILE,
+which can later be printed with --replay, without talking to tailscaled.`,
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("watch-ipn")
 				fs.BoolVar(&watchIPNArgs.netmap, "netmap", true, "include netmap in messages")
 				fs.BoolVar(&watchIPNArgs.initial, "initial", false, "include initial status")
 				fs.BoolVar(&watchIPNArgs.showPrivateKey, "show-private-key", false, "include node private key in printed netmap")
 				fs.IntVar(&watchIPNArgs.count, "count", 0, "exit after printing this many statuses, or 0 to keep going forever")
+				fs.StringVar(&watchIPNArgs.record, "record", "", "if non-empty, also write the messages to this file for later --replay")
+				fs.StringVar(&watchIPNArgs.replay, "replay", "", "if non-empty, print the messages recorded in this file instead of watching tailscaled")
+				fs.BoolVar(&watchIPNArgs.realtime, "realtime", false, "with --replay, wait between messages as long as when they were recorded")
+				return fs
+			})(),
+		},
+		{
+			Name:       "watch-dns",
+			ShortUsage: "tailscale debug watch-dns [--count=N]",
+			Exec:       runWatchDNS,
+			ShortHelp:  "Stream the DNS queries answered by MagicDNS",
+			LongHelp: `Print each DNS query answered by tailscaled's MagicDNS resolver as JSON:
+the name and type queried, whether and where it was forwarded upstream, the
+response code and how long it took.
+
+As the names looked up are private, tailscaled only reports queries when run
+with TS_DEBUG_DNS_QUERY_LOG=1 in its environment.`,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("watch-dns")
+				fs.IntVar(&watchDNSArgs.count, "count", 0, "exit after printing this many queries, or 0 to keep going forever")
 				return fs
 			})(),
 		},
@@ -231,9 +327,15 @@
 		{
 			Name: "via",
 			ShortUsage: "tailscale debug via <site-id> <v4-cidr>\n" +
-				"tailscale debug via <v6-route>",
+				"tailscale debug via <v6-route>\n" +
+				"tailscale debug via < routes.txt",
 			Exec:      runVia,
 			ShortHelp: "Convert between site-specific IPv4 CIDRs and IPv6 'via' routes",
+			LongHelp: `Convert between site-specific IPv4 CIDRs and IPv6 'via' routes.
+
+With no arguments, read one "<site-id> <v4-cidr>" or "<v6-route>" per line
+from stdin and print each conversion on its own line. Lines that fail to
+convert are printed as "error: <line>: <reason>" without stopping the rest.`,
 		},
 		{
 			Name:       "ts2021",
@@ -244,7 +346,7 @@
 				fs := newFlagSet("ts2021")
 				fs.StringVar(&ts2021Args.host, "host", "controlplane.tailscale.com", "hostname of control plane")
 				fs.IntVar(&ts2021Args.version, "version", int(tailcfg.CurrentCapabilityVersion), "protocol version")
-				fs.BoolVar(&ts2021Args.verbose, "verbose", false, "be extra verbose")
+				fs.BoolVar(&ts2021Args.verbose, "verbose", false, "be extra verbose, including how long each phase of the connection took")
 				return fs
 			})(),
 		},
@@ -308,6 +410,22 @@
 			Exec:       runPeerEndpointChanges,
 			ShortHelp:  "Prints debug information about a peer's endpoint changes",
 		},
+		{
+			Name:       "peer-endpoints",
+			ShortUsage: "tailscale debug peer-endpoints [--json] [<stable-node-id>]",
+			Exec:       runPeerEndpoints,
+			ShortHelp:  "Print the endpoints tailscaled is considering for each peer",
+			LongHelp: `Print the UDP endpoints tailscaled might reach each peer at directly, where
+it learned them, and which one, if any, it has selected. Peers without a
+selected endpoint are reached via their home DERP region.
+
+With a stable node ID, only that peer is shown.`,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("peer-endpoints")
+				fs.BoolVar(&peerEndpointsArgs.json, "json", false, "output JSON")
+				return fs
+			})(),
+		},
 		{
 			Name:       "dial-types",
 			ShortUsage: "tailscale debug dial-types <hostname-or-IP> <port>",
@@ -330,6 +448,25 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "latency-matrix",
+			ShortUsage: "tailscale debug latency-matrix [--top N] [--json]",
+			Exec:       runDebugLatencyMatrix,
+			ShortHelp:  "Ping all online peers and print their latency and path type",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("latency-matrix")
+				fs.IntVar(&latencyMatrixArgs.top, "top", 0, "if positive, only show this many peers")
+				fs.BoolVar(&latencyMatrixArgs.json, "json", false, "output JSON")
+				fs.DurationVar(&latencyMatrixArgs.timeout, "timeout", 5*time.Second, "timeout for each peer's ping")
+				return fs
+			})(),
+		},
+		{
+			Name:       "sweep-subnet",
+			ShortUsage: "tailscale debug sweep-subnet <cidr>",
+			Exec:       runDebugSweepSubnet,
+			ShortHelp:  "Ping every host in an advertised subnet route and print which reply",
+		},
 	},
 }
 
@@ -479,14 +616,185 @@ func runPrefs(ctx context.Context, args []string) error {
 	return nil
 }
 
+func runPrefsDiff(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: tailscale debug prefs-diff <profile> <profile>")
+	}
+	_, all, err := localClient.ProfileStatus(ctx)
+	if err != nil {
+		return err
+	}
+	var profiles [2]ipn.LoginProfile
+	var prefs [2]*ipn.Prefs
+	for i, arg := range args {
+		if profiles[i], err = findProfile(all, arg); err != nil {
+			return err
+		}
+		if prefs[i], err = localClient.ProfilePrefs(ctx, profiles[i].ID); err != nil {
+			return fmt.Errorf("prefs of profile %q: %w", arg, err)
+		}
+	}
+	diffs, err := diffPrefs(prefs[0], prefs[1])
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		outln("No differences.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "FIELD\t%s\t%s\n", profiles[0].Name, profiles[1].Name)
+	for _, d := range diffs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.field, d.a, d.b)
+	}
+	return tw.Flush()
+}
+
+// findProfile returns the profile in all whose ID or, failing that,
+// name is s.
+func findProfile(all []ipn.LoginProfile, s string) (ipn.LoginProfile, error) {
+	if i := slices.IndexFunc(all, func(p ipn.LoginProfile) bool { return string(p.ID) == s }); i >= 0 {
+		return all[i], nil
+	}
+	var found []ipn.LoginProfile
+	for _, p := range all {
+		if p.Name == s {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return ipn.LoginProfile{}, fmt.Errorf("no profile with ID or name %q", s)
+	case 1:
+		return found[0], nil
+	}
+	return ipn.LoginProfile{}, fmt.Errorf("more than one profile is named %q; use its ID instead", s)
+}
+
+// prefsDiff is a pref whose JSON value differs between two Prefs.
+type prefsDiff struct {
+	field string // dotted path, such as "AutoUpdate.Check"
+	a, b  string // JSON values, or "-" if absent
+}
+
+// diffPrefs returns the prefs whose values differ between a and b, sorted
+// by field. Nested structs are compared field by field; other values,
+// including lists, are compared as a whole.
+func diffPrefs(a, b *ipn.Prefs) ([]prefsDiff, error) {
+	var fields [2]map[string]string
+	for i, p := range []*ipn.Prefs{a, b} {
+		j, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		var v any
+		if err := json.Unmarshal(j, &v); err != nil {
+			return nil, err
+		}
+		fields[i] = map[string]string{}
+		flattenJSON("", v, fields[i])
+	}
+	var diffs []prefsDiff
+	for f, av := range fields[0] {
+		if bv, ok := fields[1][f]; !ok || av != bv {
+			diffs = append(diffs, prefsDiff{f, av, cmp.Or(bv, "-")})
+		}
+	}
+	for f, bv := range fields[1] {
+		if _, ok := fields[0][f]; !ok {
+			diffs = append(diffs, prefsDiff{f, "-", bv})
+		}
+	}
+	slices.SortFunc(diffs, func(x, y prefsDiff) int { return strings.Compare(x.field, y.field) })
+	return diffs, nil
+}
+
+// flattenJSON adds the leaf values of v, as decoded by encoding/json, to
+// out, keyed by their dotted path under prefix.
+func flattenJSON(prefix string, v any, out map[string]string) {
+	if m, ok := v.(map[string]any); ok && len(m) > 0 {
+		for k, mv := range m {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flattenJSON(k, mv, out)
+		}
+		return
+	}
+	j, _ := json.Marshal(v)
+	out[prefix] = string(j)
+}
+
 var watchIPNArgs struct {
 	netmap         bool
 	initial        bool
 	showPrivateKey bool
 	count          int
+	record         string
+	replay         string
+	realtime       bool
+}
+
+// watchIPNRecord is a line of a file written by "watch-ipn --record".
+type watchIPNRecord struct {
+	Time   time.Time
+	Notify json.RawMessage
+}
+
+var watchDNSArgs struct {
+	count int
+}
+
+func runWatchDNS(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	seen := 0
+	err := localClient.WatchDNSQueries(ctx, func(ev apitype.DNSQueryEvent) {
+		j, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		outln(string(j))
+		seen++
+		if watchDNSArgs.count > 0 && seen >= watchDNSArgs.count {
+			cancel()
+		}
+	})
+	if watchDNSArgs.count > 0 && seen >= watchDNSArgs.count {
+		return nil
+	}
+	return err
 }
 
 func runWatchIPN(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	if watchIPNArgs.replay != "" {
+		if watchIPNArgs.record != "" {
+			return errors.New("--record and --replay are mutually exclusive")
+		}
+		f, err := os.Open(watchIPNArgs.replay)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return replayWatchIPN(ctx, f, Stdout, watchIPNArgs.realtime)
+	}
+
+	var rec *json.Encoder
+	if watchIPNArgs.record != "" {
+		f, err := os.OpenFile(watchIPNArgs.record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rec = json.NewEncoder(f)
+	}
+
 	var mask ipn.NotifyWatchOpt
 	if watchIPNArgs.initial {
 		mask = ipn.NotifyInitialState | ipn.NotifyInitialPrefs | ipn.NotifyInitialNetMap
@@ -505,6 +813,15 @@ func runWatchIPN(ctx context.Context, args []string) error {
 		if err != nil {
 			return err
 		}
+		if rec != nil {
+			raw, err := json.Marshal(n)
+			if err != nil {
+				return err
+			}
+			if err := rec.Encode(watchIPNRecord{Time: time.Now(), Notify: raw}); err != nil {
+				return fmt.Errorf("recording: %w", err)
+			}
+		}
 		if !watchIPNArgs.netmap {
 			n.NetMap = nil
 		}
@@ -514,6 +831,42 @@ func runWatchIPN(ctx context.Context, args []string) error {
 	return nil
 }
 
+// replayWatchIPN pretty-prints to w the messages recorded in r by
+// "watch-ipn --record". If realtime, it waits between messages as long as
+// elapsed between them when they were recorded.
+func replayWatchIPN(ctx context.Context, r io.Reader, w io.Writer, realtime bool) error {
+	dec := json.NewDecoder(r)
+	var last time.Time
+	for {
+		var rec watchIPNRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("reading recording: %w", err)
+		}
+		if realtime && !last.IsZero() {
+			if d := rec.Time.Sub(last); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		last = rec.Time
+		var n ipn.Notify
+		if err := json.Unmarshal(rec.Notify, &n); err != nil {
+			return fmt.Errorf("decoding message recorded at %v: %w", rec.Time.Format(time.RFC3339Nano), err)
+		}
+		if !watchIPNArgs.netmap {
+			n.NetMap = nil
+		}
+		j, _ := json.MarshalIndent(n, "", "\t")
+		fmt.Fprintf(w, "# %s\n%s\n", rec.Time.Format(time.RFC3339Nano), j)
+	}
+}
+
 var netmapArgs struct {
 	showPrivateKey bool
 }
@@ -563,6 +916,45 @@ func localAPIAction(action string) func(context.Context, []string) error {
 	}
 }
 
+// localAPIResultAction is like localAPIAction but prints the action's JSON
+// result.
+func localAPIResultAction(action string) func(context.Context, []string) error {
+	return func(ctx context.Context, args []string) error {
+		if len(args) > 0 {
+			return errors.New("unexpected arguments")
+		}
+		v, err := localClient.DebugResultJSON(ctx, action)
+		if err != nil {
+			return err
+		}
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(v)
+	}
+}
+
+func runFailDERPRegion(failed bool) func(context.Context, []string) error {
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: <region-id>")
+		}
+		region, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid region ID %q", args[0])
+		}
+		res, err := localClient.DebugSetDERPRegionFailed(ctx, region, failed)
+		if err != nil {
+			return err
+		}
+		if res.HomeRegion == 0 {
+			printf("no home DERP region\n")
+			return nil
+		}
+		printf("home DERP region: %d (%s)\n", res.HomeRegion, res.HomeRegionCode)
+		return nil
+	}
+}
+
 func reloadConfig(ctx context.Context, args []string) error {
 	ok, err := localClient.ReloadConfig(ctx)
 	if err != nil {
@@ -613,8 +1005,27 @@ func runHostinfo(ctx context.Context, args []string) error {
 	return nil
 }
 
+var daemonGoroutinesArgs struct {
+	match string
+	count bool
+}
+
 func runDaemonGoroutines(ctx context.Context, args []string) error {
-	goroutines, err := localClient.Goroutines(ctx)
+	if daemonGoroutinesArgs.count {
+		n, err := localClient.GoroutineCount(ctx, daemonGoroutinesArgs.match)
+		if err != nil {
+			return err
+		}
+		outln(n)
+		return nil
+	}
+	var goroutines []byte
+	var err error
+	if daemonGoroutinesArgs.match != "" {
+		goroutines, err = localClient.GoroutinesMatching(ctx, daemonGoroutinesArgs.match)
+	} else {
+		goroutines, err = localClient.Goroutines(ctx)
+	}
 	if err != nil {
 		return err
 	}
@@ -655,48 +1066,181 @@ func runDaemonLogs(ctx context.Context, args []string) error {
 	}
 }
 
+var debugLogsArgs struct {
+	follow bool
+	grep   string
+	since  string
+}
+
+// debugLogLine is the subset of a tailscaled JSON log line printed by
+// "tailscale debug logs".
+type debugLogLine struct {
+	Text    string `json:"text"`
+	Logtail struct {
+		ClientTime time.Time `json:"client_time"`
+	} `json:"logtail"`
+}
+
+func runDebugLogs(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	var since time.Time
+	if v := debugLogsArgs.since; v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			since = time.Now().Add(-d)
+		} else if since, err = time.Parse(time.RFC3339, v); err != nil {
+			return fmt.Errorf("invalid --since %q: want an RFC 3339 time or a duration", v)
+		}
+	}
+	var re *regexp.Regexp
+	if debugLogsArgs.grep != "" {
+		var err error
+		re, err = regexp.Compile(debugLogsArgs.grep)
+		if err != nil {
+			return fmt.Errorf("invalid --grep: %w", err)
+		}
+	}
+
+	var last time.Time // client time of the last line printed
+	// printLine prints the log line raw unless it's filtered out or was
+	// logged at or before after, if non-zero.
+	printLine := func(raw []byte, after time.Time) {
+		var line debugLogLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			return
+		}
+		t := line.Logtail.ClientTime
+		text := strings.TrimSpace(line.Text)
+		if text == "" || t.Before(since) || (!after.IsZero() && !t.After(after)) {
+			return
+		}
+		if re != nil && !re.MatchString(text) {
+			return
+		}
+		last = line.Logtail.ClientTime
+		outln(text)
+	}
+	// printRecent prints the lines tailscaled has retained in memory that
+	// were logged after the last line printed, if any.
+	printRecent := func() error {
+		recent, err := localClient.RecentDaemonLogs(ctx)
+		if err != nil {
+			return err
+		}
+		printed := last
+		bs := bufio.NewScanner(bytes.NewReader(recent))
+		bs.Buffer(nil, 1<<20)
+		for bs.Scan() {
+			printLine(bs.Bytes(), printed)
+		}
+		return bs.Err()
+	}
+
+	if err := printRecent(); err != nil {
+		return err
+	}
+	if !debugLogsArgs.follow {
+		return nil
+	}
+
+	for {
+		logs, err := localClient.TailDaemonLogs(ctx)
+		if err == nil {
+			d := json.NewDecoder(logs)
+			for {
+				var raw json.RawMessage
+				if err = d.Decode(&raw); err != nil {
+					break
+				}
+				printLine(raw, time.Time{})
+			}
+			if c, ok := logs.(io.Closer); ok {
+				c.Close()
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Fprintf(Stderr, "--- lost connection to tailscaled (%v); reconnecting ---\n", err)
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			if _, err := localClient.StatusWithoutPeers(ctx); err == nil {
+				break
+			}
+		}
+		if last.IsZero() {
+			fmt.Fprintf(Stderr, "--- reconnected; if tailscaled restarted, lines logged meanwhile may be missing ---\n")
+		} else {
+			fmt.Fprintf(Stderr, "--- reconnected; if tailscaled restarted, lines logged since %v may be missing ---\n", last.Local().Format(time.RFC3339))
+		}
+		if err := printRecent(); err != nil {
+			fmt.Fprintf(Stderr, "--- fetching recent logs: %v ---\n", err)
+		}
+	}
+}
+
 var metricsArgs struct {
-	watch bool
+	watch  bool
+	filter string // regexp of metric names to print
+	sort   string // "name", "value", or empty for tailscaled's order
 }
 
 func runDaemonMetrics(ctx context.Context, args []string) error {
-	last := map[string]int64{}
-	for {
+	var filter *regexp.Regexp
+	if metricsArgs.filter != "" {
+		var err error
+		filter, err = regexp.Compile(metricsArgs.filter)
+		if err != nil {
+			return fmt.Errorf("invalid --filter: %w", err)
+		}
+	}
+	switch metricsArgs.sort {
+	case "", "name", "value":
+	default:
+		return fmt.Errorf("invalid --sort %q; want name or value", metricsArgs.sort)
+	}
+	if !metricsArgs.watch {
 		out, err := localClient.DaemonMetrics(ctx)
 		if err != nil {
 			return err
 		}
-		if !metricsArgs.watch {
-			Stdout.Write(out)
-			return nil
+		if filter != nil || metricsArgs.sort != "" {
+			out = selectMetrics(out, filter, metricsArgs.sort)
+		}
+		Stdout.Write(out)
+		return nil
+	}
+	last := map[string]int64{}
+	for {
+		ms, err := localClient.DaemonMetricsJSON(ctx)
+		if err != nil {
+			return err
 		}
-		bs := bufio.NewScanner(bytes.NewReader(out))
 		type change struct {
 			name     string
 			from, to int64
 		}
 		var changes []change
 		var maxNameLen int
-		for bs.Scan() {
-			line := bytes.TrimSpace(bs.Bytes())
-			if len(line) == 0 || line[0] == '#' {
-				continue
-			}
-			f := strings.Fields(string(line))
-			if len(f) != 2 {
+		for _, m := range ms {
+			name := metricDisplayName(m)
+			if filter != nil && !filter.MatchString(name) {
 				continue
 			}
-			name := f[0]
-			n, _ := strconv.ParseInt(f[1], 10, 64)
 			prev, ok := last[name]
-			if ok && prev == n {
+			if ok && prev == m.Value {
 				continue
 			}
-			last[name] = n
+			last[name] = m.Value
 			if !ok {
 				continue
 			}
-			changes = append(changes, change{name, prev, n})
+			changes = append(changes, change{name, prev, m.Value})
 			if len(name) > maxNameLen {
 				maxNameLen = len(name)
 			}
@@ -712,47 +1256,225 @@ type change struct {
 	}
 }
 
+// metricDisplayName returns the name of m as in the Prometheus text format,
+// with its labels (if any) in braces, as in derp_packets{region="nyc"}.
+// Histograms, whose Value is their number of observations, are named by
+// their "_count" sample.
+func metricDisplayName(m clientmetric.MetricSnapshot) string {
+	if m.Type == "histogram" {
+		return m.Name + "_count"
+	}
+	if len(m.Labels) == 0 {
+		return m.Name
+	}
+	var sb strings.Builder
+	sb.WriteString(m.Name)
+	sb.WriteString("{")
+	for i, k := range slices.Sorted(maps.Keys(m.Labels)) {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "%s=%q", k, m.Labels[k])
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// selectMetrics returns the metric families of out, in the Prometheus text
+// format served by tailscaled, that have samples whose names (or the name of
+// their family) match filter (if non-nil), sorted by sortBy: "name",
+// "value" (largest first), or "" to keep their order. A family's comment
+// lines, such as its "# TYPE", and its matching samples, such as the
+// buckets of a histogram or each set of labels, stay together. A family's
+// value is its "_count" sample's, if any, or else the sum of its samples.
+func selectMetrics(out []byte, filter *regexp.Regexp, sortBy string) []byte {
+	type family struct {
+		name     string
+		value    float64
+		hasCount bool   // whether value is from a "_count" sample
+		lines    []byte // comment lines and samples, newline-terminated
+		seen     int    // number of samples parsed
+		samples  int    // number of samples in lines, which match filter
+		typed    bool   // whether the family has a "# TYPE" or "# HELP" line
+	}
+	var fams []*family
+	var cur *family
+	bs := bufio.NewScanner(bytes.NewReader(out))
+	for bs.Scan() {
+		line := bytes.TrimSpace(bs.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '#' {
+			if cur == nil || cur.seen > 0 {
+				cur = &family{}
+				fams = append(fams, cur)
+			}
+			if f := strings.Fields(string(line)); len(f) >= 3 && (f[1] == "TYPE" || f[1] == "HELP") {
+				cur.name = f[2]
+				cur.typed = true
+			}
+			cur.lines = append(append(cur.lines, line...), '\n')
+			continue
+		}
+		name, value := parseMetricSample(string(line))
+		base, _, _ := strings.Cut(name, "{")
+		if cur == nil || (!cur.typed && cur.seen > 0 && cur.name != base) {
+			cur = &family{}
+			fams = append(fams, cur)
+		}
+		if cur.name == "" {
+			cur.name = base
+		}
+		cur.seen++
+		if filter != nil && !filter.MatchString(cur.name) && !filter.MatchString(name) {
+			continue
+		}
+		cur.lines = append(append(cur.lines, line...), '\n')
+		cur.samples++
+		switch {
+		case base == cur.name+"_count":
+			cur.value, cur.hasCount = value, true
+		case !cur.hasCount:
+			cur.value += value
+		}
+	}
+	fams = slices.DeleteFunc(fams, func(f *family) bool { return f.samples == 0 })
+	switch sortBy {
+	case "name":
+		slices.SortStableFunc(fams, func(a, b *family) int { return strings.Compare(a.name, b.name) })
+	case "value":
+		slices.SortStableFunc(fams, func(a, b *family) int {
+			return cmp.Or(cmp.Compare(b.value, a.value), strings.Compare(a.name, b.name))
+		})
+	}
+	var res []byte
+	for _, f := range fams {
+		res = append(res, f.lines...)
+	}
+	return res
+}
+
+// parseMetricSample returns the name, with any labels, and the value of a
+// Prometheus text format sample line, such as derp_packets{region="nyc"} 5.
+func parseMetricSample(line string) (name string, value float64) {
+	rest := ""
+	if i := strings.LastIndexByte(line, '}'); i >= 0 {
+		name, rest = line[:i+1], line[i+1:]
+	} else {
+		name, rest, _ = strings.Cut(line, " ")
+	}
+	if f := strings.Fields(rest); len(f) > 0 {
+		value, _ = strconv.ParseFloat(f[0], 64)
+	}
+	return name, value
+}
+
 func runVia(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return runViaBatch(os.Stdin, Stdout)
+	}
+	out, err := convertVia(args)
+	if err != nil {
+		return err
+	}
+	outln(out)
+	return nil
+}
+
+// runViaBatch converts each line of r, which holds the arguments of a
+// "tailscale debug via" invocation, and writes the results to w, one per
+// line. Blank lines and lines starting with '#' are skipped. A line that
+// fails to convert is reported in its place, prefixed with "error:", and
+// doesn't stop the rest from being converted.
+func runViaBatch(r io.Reader, w io.Writer) error {
+	var failed int
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out, err := convertVia(strings.Fields(line))
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "error: %s: %v\n", line, err)
+			continue
+		}
+		fmt.Fprintln(w, out)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d line(s) failed to convert", failed)
+	}
+	return nil
+}
+
+// convertVia converts args, either a site ID and IPv4 CIDR or an IPv6 via
+// route, to the other form.
+func convertVia(args []string) (string, error) {
 	switch len(args) {
 	default:
-		return errors.New("expect either <site-id> <v4-cidr> or <v6-route>")
+		return "", errors.New("expect either <site-id> <v4-cidr> or <v6-route>")
 	case 1:
 		ipp, err := netip.ParsePrefix(args[0])
 		if err != nil {
-			return err
+			return "", err
 		}
 		if !ipp.Addr().Is6() {
-			return errors.New("with one argument, expect an IPv6 CIDR")
+			return "", errors.New("with one argument, expect an IPv6 CIDR")
 		}
 		if !tsaddr.TailscaleViaRange().Contains(ipp.Addr()) {
-			return errors.New("not a via route")
+			return "", errors.New("not a via route")
 		}
 		if ipp.Bits() < 96 {
-			return errors.New("short length, want /96 or more")
+			return "", errors.New("short length, want /96 or more")
 		}
 		v4 := tsaddr.UnmapVia(ipp.Addr())
 		a := ipp.Addr().As16()
 		siteID := binary.BigEndian.Uint32(a[8:12])
-		printf("site %v (0x%x), %v\n", siteID, siteID, netip.PrefixFrom(v4, ipp.Bits()-96))
+		return fmt.Sprintf("site %v (0x%x), %v", siteID, siteID, netip.PrefixFrom(v4, ipp.Bits()-96)), nil
 	case 2:
 		siteID, err := strconv.ParseUint(args[0], 0, 32)
 		if err != nil {
-			return fmt.Errorf("invalid site-id %q; must be decimal or hex with 0x prefix", args[0])
+			return "", fmt.Errorf("invalid site-id %q; must be decimal or hex with 0x prefix", args[0])
 		}
 		if siteID > 0xffff {
-			return fmt.Errorf("site-id values over 65535 are currently reserved")
+			return "", fmt.Errorf("site-id values over 65535 are currently reserved")
 		}
 		ipp, err := netip.ParsePrefix(args[1])
 		if err != nil {
-			return err
+			return "", err
 		}
 		via, err := tsaddr.MapVia(uint32(siteID), ipp)
 		if err != nil {
-			return err
+			return "", err
 		}
-		outln(via)
+		return via.String(), nil
+	}
+}
+
+// ts2021KeysTrace returns a trace that logs the duration of each phase of
+// the /key request made by "tailscale debug ts2021 --verbose".
+func ts2021KeysTrace() *httptrace.ClientTrace {
+	var dnsStart, connStart, tlsStart time.Time
+	since := func(t time.Time) time.Duration { return time.Since(t).Round(time.Millisecond) }
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			log.Printf("/key: DNS lookup took %v (err=%v)", since(dnsStart), info.Err)
+		},
+		ConnectStart: func(_, _ string) { connStart = time.Now() },
+		ConnectDone: func(network, addr string, err error) {
+			log.Printf("/key: TCP connect to %v took %v (err=%v)", addr, since(connStart), err)
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			log.Printf("/key: TLS handshake took %v (err=%v)", since(tlsStart), err)
+		},
 	}
-	return nil
 }
 
 var ts2021Args struct {
@@ -788,7 +1510,12 @@ func runTS2021(ctx context.Context, args []string) error {
 		PublicKey key.MachinePublic
 	}
 	log.Printf("Fetching keys from %s ...", keysURL)
-	req, err := http.NewRequestWithContext(ctx, "GET", keysURL, nil)
+	keysCtx := ctx
+	if ts2021Args.verbose {
+		keysCtx = httptrace.WithClientTrace(ctx, ts2021KeysTrace())
+	}
+	keysStart := time.Now()
+	req, err := http.NewRequestWithContext(keysCtx, "GET", keysURL, nil)
 	if err != nil {
 		return err
 	}
@@ -807,19 +1534,24 @@ func runTS2021(ctx context.Context, args []string) error {
 	}
 	res.Body.Close()
 	if ts2021Args.verbose {
-		log.Printf("got public key: %v", keys.PublicKey)
+		log.Printf("got public key: %v (/key fetch took %v)", keys.PublicKey, time.Since(keysStart).Round(time.Millisecond))
 	}
 
 	dialFunc := func(ctx context.Context, network, address string) (net.Conn, error) {
 		log.Printf("Dial(%q, %q) ...", network, address)
+		start := time.Now()
 		c, err := dialer.DialContext(ctx, network, address)
+		var took string
+		if ts2021Args.verbose {
+			took = fmt.Sprintf(" in %v", time.Since(start).Round(time.Millisecond))
+		}
 		if err != nil {
 			// skip logging context cancellation errors
 			if !errors.Is(err, context.Canceled) {
-				log.Printf("Dial(%q, %q) = %v", network, address, err)
+				log.Printf("Dial(%q, %q) = %v%s", network, address, err, took)
 			}
 		} else {
-			log.Printf("Dial(%q, %q) = %v / %v", network, address, c.LocalAddr(), c.RemoteAddr())
+			log.Printf("Dial(%q, %q) = %v / %v%s", network, address, c.LocalAddr(), c.RemoteAddr(), took)
 		}
 		return c, err
 	}
@@ -827,6 +1559,7 @@ func runTS2021(ctx context.Context, args []string) error {
 	if ts2021Args.verbose {
 		logf = log.Printf
 	}
+	dialStart := time.Now()
 	conn, err := (&controlhttp.Dialer{
 		Hostname:        ts2021Args.host,
 		HTTPPort:        "80",
@@ -842,6 +1575,14 @@ func runTS2021(ctx context.Context, args []string) error {
 		return err
 	}
 	log.Printf("did noise handshake")
+	if ts2021Args.verbose {
+		log.Printf("control dial and handshake took %v; negotiated protocol version %d", time.Since(dialStart).Round(time.Millisecond), conn.ProtocolVersion())
+		if _, port, _ := net.SplitHostPort(conn.RemoteAddr().String()); port == "443" {
+			log.Printf("connected over HTTPS (port 443), the fallback used when port 80 fails")
+		} else {
+			log.Printf("connected over HTTP (port %s)", port)
+		}
+	}
 
 	gotPeer := conn.Peer()
 	if gotPeer != keys.PublicKey {
@@ -1107,6 +1848,72 @@ func runPeerEndpointChanges(ctx context.Context, args []string) error {
 	return nil
 }
 
+var peerEndpointsArgs struct {
+	json bool
+}
+
+func runPeerEndpoints(ctx context.Context, args []string) error {
+	var id tailcfg.StableNodeID
+	switch len(args) {
+	case 0:
+	case 1:
+		id = tailcfg.StableNodeID(args[0])
+	default:
+		return errors.New("usage: tailscale debug peer-endpoints [--json] [<stable-node-id>]")
+	}
+	peers, err := localClient.PeerEndpoints(ctx, id)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if peerEndpointsArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(peers)
+	}
+	slices.SortFunc(peers, func(a, b apitype.PeerEndpoints) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return printPeerEndpoints(Stdout, peers)
+}
+
+// printPeerEndpoints writes a human-readable summary of peers to w. The
+// selected endpoint of each peer is marked with an asterisk.
+func printPeerEndpoints(w io.Writer, peers []apitype.PeerEndpoints) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	for i, p := range peers {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		derp := "none"
+		if p.DERPRegion != 0 {
+			derp = cmp.Or(p.DERPRegionCode, strconv.Itoa(p.DERPRegion))
+		}
+		path := "derp"
+		if p.BestAddr != "" {
+			path = "direct " + p.BestAddr
+			if !p.BestAddrTrusted {
+				path += " (unconfirmed)"
+			}
+		}
+		fmt.Fprintf(tw, "%s (%s), home DERP %s, path %s\n", p.Name, p.StableID, derp, path)
+		if len(p.Candidates) == 0 {
+			fmt.Fprintf(tw, "  no candidate endpoints\n")
+		}
+		for _, c := range p.Candidates {
+			mark := " "
+			if c.Addr.String() == p.BestAddr {
+				mark = "*"
+			}
+			latency := "-"
+			if c.LatencyMs > 0 {
+				latency = fmt.Sprintf("%.1fms", c.LatencyMs)
+			}
+			fmt.Fprintf(tw, "  %s %s\t%s\t%s\n", mark, c.Addr, c.Source, latency)
+		}
+	}
+	return tw.Flush()
+}
+
 func debugControlKnobs(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected arguments")
@@ -1201,3 +2008,134 @@ func runDebugResolve(ctx context.Context, args []string) error {
 	}
 	return nil
 }
+
+var latencyMatrixArgs struct {
+	top     int
+	json    bool
+	timeout time.Duration
+}
+
+// peerLatency is a row of "tailscale debug latency-matrix" output.
+type peerLatency struct {
+	Name    string
+	IP      netip.Addr
+	Latency time.Duration `json:",omitempty"`
+	Path    string        `json:",omitempty"` // "direct" or "derp"
+	Via     string        `json:",omitempty"` // endpoint for direct paths, region code for DERP
+	Err     string        `json:",omitempty"` // non-empty if the peer was unreachable
+}
+
+// maxConcurrentLatencyPings is the number of peers that
+// "tailscale debug latency-matrix" pings at once.
+const maxConcurrentLatencyPings = 16
+
+func runDebugSweepSubnet(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale debug sweep-subnet <cidr>")
+	}
+	cidr, err := netip.ParsePrefix(args[0])
+	if err != nil {
+		return err
+	}
+	res, err := localClient.DebugSweepSubnet(ctx, cidr)
+	if err != nil {
+		return err
+	}
+	for _, h := range res.Alive {
+		printf("%-40s %v\n", h.Addr, h.Latency.Round(time.Microsecond))
+	}
+	printf("%d of %d hosts in %s replied\n", len(res.Alive), res.Probed, res.CIDR)
+	return nil
+}
+
+func runDebugLatencyMatrix(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+
+	var rows []*peerLatency
+	for _, ps := range st.Peer {
+		if !ps.Online || len(ps.TailscaleIPs) == 0 {
+			continue
+		}
+		rows = append(rows, &peerLatency{
+			Name: strings.TrimSuffix(cmp.Or(ps.DNSName, ps.HostName), "."),
+			IP:   ps.TailscaleIPs[0],
+		})
+	}
+	if len(rows) == 0 {
+		return errors.New("no online peers")
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentLatencyPings)
+	for _, row := range rows {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			pingPeerLatency(ctx, row)
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(rows, func(a, b *peerLatency) int {
+		// Reachable peers first, fastest first; then unreachable ones.
+		if (a.Err == "") != (b.Err == "") {
+			if a.Err == "" {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(a.Latency, b.Latency), cmp.Compare(a.Name, b.Name))
+	})
+	if n := latencyMatrixArgs.top; n > 0 && len(rows) > n {
+		rows = rows[:n]
+	}
+
+	if latencyMatrixArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(rows)
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(w, "PEER\tIP\tLATENCY\tPATH\n")
+	for _, r := range rows {
+		if r.Err != "" {
+			fmt.Fprintf(w, "%s\t%s\t-\tunreachable: %s\n", r.Name, r.IP, r.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\t%s %s\n", r.Name, r.IP, r.Latency.Round(100*time.Microsecond), r.Path, r.Via)
+	}
+	return w.Flush()
+}
+
+// pingPeerLatency disco pings row's peer and fills in the result.
+func pingPeerLatency(ctx context.Context, row *peerLatency) {
+	ctx, cancel := context.WithTimeout(ctx, latencyMatrixArgs.timeout)
+	defer cancel()
+	pr, err := localClient.Ping(ctx, row.IP, tailcfg.PingDisco)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			row.Err = "timeout"
+		} else {
+			row.Err = err.Error()
+		}
+		return
+	}
+	if pr.Err != "" {
+		row.Err = pr.Err
+		return
+	}
+	row.Latency = time.Duration(pr.LatencySeconds * float64(time.Second))
+	if pr.Endpoint != "" {
+		row.Path, row.Via = "direct", pr.Endpoint
+	} else if pr.DERPRegionID != 0 {
+		row.Path, row.Via = "derp", pr.DERPRegionCode
+	}
+}
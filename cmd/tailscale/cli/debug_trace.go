@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+)
+
+var debugTraceCmd = &ffcli.Command{
+	Name:       "trace",
+	ShortUsage: "tailscale debug trace [--seconds=N] [--follow] <" + strings.Join(ipn.DebuggableComponents, "|") + ">\ntailscale debug trace --list",
+	Exec:       runDebugTrace,
+	ShortHelp:  "Enable verbose logging for one subsystem for a while",
+	LongHelp: `Enable verbose logging for one subsystem of tailscaled for --seconds seconds.
+
+tailscaled reverts the subsystem to its normal logging when the time is up,
+whether or not this command is still running. With --follow, tailscaled's logs
+are printed until then, and interrupting the command stops the trace early.
+A --seconds of 0 stops a trace already running.`,
+	FlagSet: debugTraceFlags,
+}
+
+var debugTraceArgs struct {
+	list    bool
+	seconds int
+	follow  bool
+}
+
+var debugTraceFlags = (func() *flag.FlagSet {
+	fs := newFlagSet("trace")
+	fs.BoolVar(&debugTraceArgs.list, "list", false, "list the subsystems that can be traced")
+	fs.IntVar(&debugTraceArgs.seconds, "seconds", 60, "how long to trace for, up to an hour; 0 stops tracing")
+	fs.BoolVar(&debugTraceArgs.follow, "follow", false, "print tailscaled's logs until the trace ends")
+	return fs
+})()
+
+func runDebugTrace(ctx context.Context, args []string) error {
+	sub, err := debugTraceSubsystem(args)
+	if err != nil {
+		return err
+	}
+	if debugTraceArgs.list {
+		for _, c := range ipn.DebuggableComponents {
+			outln(c)
+		}
+		return nil
+	}
+	if debugTraceArgs.seconds <= 0 {
+		if _, err := localClient.DebugTrace(ctx, sub, 0); err != nil {
+			return fixTailscaledConnectError(err)
+		}
+		printf("Stopped tracing %s.\n", sub)
+		return nil
+	}
+
+	d := time.Duration(debugTraceArgs.seconds) * time.Second
+	traces, err := localClient.DebugTrace(ctx, sub, d)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	until := time.Now().Add(d)
+	if i := slices.IndexFunc(traces, func(t apitype.DebugTrace) bool { return t.Subsystem == sub }); i >= 0 {
+		until = traces[i].Until
+	}
+	printf("Tracing %s until %v.\n", sub, until.Local().Format(time.TimeOnly))
+	if !debugTraceArgs.follow {
+		return nil
+	}
+
+	// Stop the trace when we stop following, including when interrupted.
+	// If we're killed before we get to, tailscaled still reverts at until.
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := localClient.DebugTrace(ctx, sub, 0); err != nil {
+			errf("failed to stop tracing %s: %v\n", sub, err)
+			return
+		}
+		printf("Stopped tracing %s.\n", sub)
+	}()
+	ctx, cancelTail := context.WithDeadline(ctx, until)
+	defer cancelTail()
+
+	logs, err := localClient.TailDaemonLogs(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	dec := json.NewDecoder(logs)
+	for {
+		var line struct {
+			Text string `json:"text"`
+		}
+		if err := dec.Decode(&line); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if text := strings.TrimSpace(line.Text); text != "" {
+			outln(text)
+		}
+	}
+}
+
+// debugTraceSubsystem returns the subsystem named by the non-flag args of
+// "tailscale debug trace". Flags may also follow the subsystem, as in
+// "tailscale debug trace magicsock --seconds=30", and are parsed here.
+func debugTraceSubsystem(args []string) (string, error) {
+	if len(args) > 1 {
+		if err := debugTraceFlags.Parse(args[1:]); err != nil {
+			return "", err
+		}
+		args = append(args[:1:1], debugTraceFlags.Args()...)
+	}
+	if debugTraceArgs.list {
+		if len(args) > 0 {
+			return "", errors.New("--list takes no arguments")
+		}
+		return "", nil
+	}
+	if len(args) != 1 {
+		return "", errors.New("usage: tailscale debug trace [--seconds=N] [--follow] <subsystem>")
+	}
+	if !slices.Contains(ipn.DebuggableComponents, args[0]) {
+		return "", fmt.Errorf("unknown subsystem %q; want one of: %s", args[0], strings.Join(ipn.DebuggableComponents, ", "))
+	}
+	return args[0], nil
+}
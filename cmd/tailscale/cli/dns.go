@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/tsaddr"
+)
+
+var dnsCmd = &ffcli.Command{
+	Name:       "dns",
+	ShortUsage: "tailscale dns <subcommand> [flags]",
+	ShortHelp:  "Diagnose the DNS configuration",
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "status",
+			ShortUsage: "tailscale dns status [--json]",
+			ShortHelp:  "Print the DNS configuration currently applied",
+			LongHelp: strings.TrimSpace(`
+'tailscale dns status' prints the DNS configuration tailscaled has applied:
+the nameservers, search domains and match domains given to the OS, and the
+split DNS routes of the MagicDNS resolver at 100.100.100.100.
+`),
+			Exec: runDNSStatus,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("status")
+				fs.BoolVar(&dnsStatusArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+	},
+	Exec: func(context.Context, []string) error {
+		return flag.ErrHelp
+	},
+}
+
+var dnsStatusArgs struct {
+	json bool
+}
+
+func runDNSStatus(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale dns status'")
+	}
+	res, err := localClient.DNSConfig(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if dnsStatusArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(res)
+	}
+	printDNSStatus(Stdout, res)
+	return nil
+}
+
+// printDNSStatus writes res to w in human-readable form.
+func printDNSStatus(w io.Writer, res *apitype.DNSConfigResponse) {
+	if !res.Applied {
+		fmt.Fprintln(w, "No DNS configuration is applied.")
+		return
+	}
+	mode := "primary"
+	if res.SplitDNS {
+		mode = "split"
+	}
+	fmt.Fprintf(w, "DNS mode: %s\n\n", mode)
+
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESERVER\tTYPE")
+	for _, ns := range res.OS.Nameservers {
+		fmt.Fprintf(tw, "%s\t%s\n", ns, dnsResolverType(ns.String()))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nSearch domains: %s\n", dnsList(res.OS.SearchDomains))
+	fmt.Fprintf(w, "Match domains:  %s\n", dnsList(res.OS.MatchDomains))
+
+	if len(res.Resolver.Routes) == 0 {
+		return
+	}
+	suffixes := make([]string, 0, len(res.Resolver.Routes))
+	for s := range res.Resolver.Routes {
+		suffixes = append(suffixes, s)
+	}
+	slices.Sort(suffixes)
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUTE\tRESOLVERS")
+	for _, s := range suffixes {
+		rs := res.Resolver.Routes[s]
+		if len(rs) == 0 {
+			fmt.Fprintf(tw, "%s\t(answered by MagicDNS)\n", s)
+			continue
+		}
+		var descs []string
+		for _, r := range rs {
+			descs = append(descs, fmt.Sprintf("%s (%s)", r, dnsResolverType(r)))
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", s, strings.Join(descs, ", "))
+	}
+	tw.Flush()
+}
+
+// dnsResolverType describes the resolver at addr, which is an IP address
+// or DoH URL, as either the MagicDNS resolver or an upstream one.
+func dnsResolverType(addr string) string {
+	if ip, err := netip.ParseAddr(addr); err == nil &&
+		(ip == tsaddr.TailscaleServiceIP() || ip == tsaddr.TailscaleServiceIPv6()) {
+		return "MagicDNS"
+	}
+	return "upstream"
+}
+
+func dnsList(s []string) string {
+	if len(s) == 0 {
+		return "(none)"
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,352 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+)
+
+var debugDoctorCmd = &ffcli.Command{
+	Name:       "doctor",
+	ShortUsage: "tailscale debug doctor [--json]",
+	Exec:       runDebugDoctor,
+	ShortHelp:  "Run all diagnostics and print a health report",
+	LongHelp: `Run all diagnostics and print a health report.
+
+The doctor checks the daemon's state, its health warnings, node key expiry,
+UDP connectivity and DERP latency (by running a netcheck), DNS configuration
+and the host setup checks (such as IP forwarding on subnet routers and exit
+nodes), and suggests a fix for each problem found. Run it before filing a
+bug report.
+
+The doctor doesn't change tailscaled's configuration and doesn't need to be
+run as root.`,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("doctor")
+		fs.BoolVar(&doctorArgs.json, "json", false, "output JSON")
+		fs.DurationVar(&doctorArgs.timeout, "timeout", 10*time.Second, "timeout for each check")
+		return fs
+	})(),
+}
+
+var doctorArgs struct {
+	json    bool
+	timeout time.Duration
+}
+
+// doctorStatus is the outcome of a doctor check.
+type doctorStatus string
+
+const (
+	doctorOK      doctorStatus = "ok"
+	doctorWarn    doctorStatus = "warn"
+	doctorFail    doctorStatus = "fail"
+	doctorSkipped doctorStatus = "skipped" // the check couldn't be run
+)
+
+// doctorResult is the result of a single doctor check.
+type doctorResult struct {
+	Check   string
+	Status  doctorStatus
+	Message string
+	Hint    string `json:",omitempty"` // how to fix a warning or failure
+}
+
+// doctorCheck is a single check run by "tailscale debug doctor". It returns
+// one or more results. An error means the check couldn't be run, such as
+// when talking to an older tailscaled lacking the endpoint it uses.
+type doctorCheck struct {
+	name string
+	run  func(context.Context) ([]doctorResult, error)
+}
+
+var doctorChecks = []doctorCheck{
+	{"backend", doctorCheckBackend},
+	{"health", doctorCheckHealth},
+	{"key-expiry", doctorCheckKeyExpiry},
+	{"derp", doctorCheckDERP},
+	{"dns", doctorCheckDNS},
+	{"setup", doctorCheckSetup},
+}
+
+func runDebugDoctor(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %q", args)
+	}
+	var results []doctorResult
+	for _, c := range doctorChecks {
+		cctx, cancel := context.WithTimeout(ctx, doctorArgs.timeout)
+		rs, err := c.run(cctx)
+		cancel()
+		if err != nil {
+			rs = []doctorResult{{
+				Status:  doctorSkipped,
+				Message: fmt.Sprintf("check unavailable: %v", err),
+			}}
+		}
+		for _, r := range rs {
+			if r.Check == "" {
+				r.Check = c.name
+			}
+			results = append(results, r)
+		}
+	}
+
+	if doctorArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(results)
+	}
+
+	w, color := colorableOutput()
+	var problems int
+	for _, r := range results {
+		label := fmt.Sprintf("%-7s", r.Status)
+		if color {
+			label = doctorColor(r.Status) + label + "\x1b[0m"
+		}
+		fmt.Fprintf(w, "%s %-14s %s\n", label, r.Check, r.Message)
+		if r.Hint != "" {
+			fmt.Fprintf(w, "%23s %s\n", "→", r.Hint)
+		}
+		if r.Status == doctorWarn || r.Status == doctorFail {
+			problems++
+		}
+	}
+	if problems == 0 {
+		fmt.Fprintln(w, "\nNo problems found.")
+	} else {
+		fmt.Fprintf(w, "\n%d problem(s) found.\n", problems)
+	}
+	return nil
+}
+
+func doctorColor(s doctorStatus) string {
+	switch s {
+	case doctorOK:
+		return "\x1b[32m" // green
+	case doctorWarn:
+		return "\x1b[33m" // yellow
+	case doctorFail:
+		return "\x1b[31m" // red
+	}
+	return "\x1b[90m" // grey
+}
+
+func doctorCheckBackend(ctx context.Context) ([]doctorResult, error) {
+	st, err := localClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		return []doctorResult{{
+			Status:  doctorFail,
+			Message: fmt.Sprintf("can't talk to tailscaled: %v", err),
+			Hint:    "check that the tailscaled service is running",
+		}}, nil
+	}
+	return doctorBackendResults(st), nil
+}
+
+// doctorBackendResults returns the results of the backend check for st.
+func doctorBackendResults(st *ipnstate.Status) []doctorResult {
+	switch st.BackendState {
+	case ipn.Running.String():
+		return []doctorResult{{Status: doctorOK, Message: "tailscaled is running and connected"}}
+	case ipn.NeedsLogin.String():
+		return []doctorResult{{
+			Status:  doctorFail,
+			Message: "not logged in",
+			Hint:    `run "tailscale up" to log in`,
+		}}
+	case ipn.NeedsMachineAuth.String():
+		return []doctorResult{{
+			Status:  doctorFail,
+			Message: "machine awaiting approval",
+			Hint:    "ask a tailnet admin to approve this device in the admin console",
+		}}
+	case ipn.Stopped.String():
+		return []doctorResult{{
+			Status:  doctorWarn,
+			Message: "Tailscale is stopped",
+			Hint:    `run "tailscale up" to connect`,
+		}}
+	}
+	return []doctorResult{{Status: doctorWarn, Message: fmt.Sprintf("backend state is %s", st.BackendState)}}
+}
+
+func doctorCheckHealth(ctx context.Context) ([]doctorResult, error) {
+	st, err := localClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(st.Health) == 0 {
+		return []doctorResult{{Status: doctorOK, Message: "no health warnings"}}, nil
+	}
+	var rs []doctorResult
+	for _, h := range st.Health {
+		rs = append(rs, doctorResult{Status: doctorWarn, Message: h})
+	}
+	return rs, nil
+}
+
+func doctorCheckKeyExpiry(ctx context.Context) ([]doctorResult, error) {
+	const warnWithin = 7 * 24 * time.Hour
+	res, err := localClient.KeyExpiry(ctx, warnWithin)
+	if err != nil {
+		return nil, err
+	}
+	return doctorKeyExpiryResults(res), nil
+}
+
+// doctorKeyExpiryResults returns the results of the key-expiry check for res.
+func doctorKeyExpiryResults(res *apitype.KeyExpiryResponse) []doctorResult {
+	switch {
+	case res.ExpiryDisabled:
+		return []doctorResult{{Status: doctorOK, Message: "key expiry is disabled"}}
+	case res.Expired:
+		return []doctorResult{{
+			Status:  doctorFail,
+			Message: fmt.Sprintf("node key expired at %v", res.Expiry.Format(time.RFC3339)),
+			Hint:    `run "tailscale up --force-reauth" to log in again`,
+		}}
+	case res.Warning:
+		return []doctorResult{{
+			Status:  doctorWarn,
+			Message: fmt.Sprintf("node key expires in %v", res.Remaining.Round(time.Minute)),
+			Hint:    `re-authenticate soon with "tailscale up --force-reauth", or disable key expiry in the admin console`,
+		}}
+	}
+	return []doctorResult{{Status: doctorOK, Message: fmt.Sprintf("node key expires in %v", res.Remaining.Round(time.Hour))}}
+}
+
+// doctorCheckDERP has tailscaled run a netcheck and reports whether UDP
+// works and which DERP region is nearest.
+func doctorCheckDERP(ctx context.Context) ([]doctorResult, error) {
+	st, err := localClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if st.BackendState != ipn.Running.String() {
+		return []doctorResult{{Status: doctorSkipped, Message: "not connected"}}, nil
+	}
+	j, err := localClient.Netcheck(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	var report netcheck.Report
+	if err := json.Unmarshal(j, &report); err != nil {
+		return nil, fmt.Errorf("decoding netcheck report: %w", err)
+	}
+	dm, err := localClient.CurrentDERPMap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return doctorDERPResults(&report, dm), nil
+}
+
+// doctorDERPResults returns the results of the derp check for the netcheck
+// report and DERP map dm.
+func doctorDERPResults(report *netcheck.Report, dm *tailcfg.DERPMap) []doctorResult {
+	udp := doctorResult{Check: "udp", Status: doctorOK, Message: "UDP works"}
+	if !report.UDP {
+		udp = doctorResult{
+			Check:   "udp",
+			Status:  doctorWarn,
+			Message: "no replies to UDP STUN probes; connections will be relayed over DERP",
+			Hint:    "check that your firewall allows outbound UDP, including port 3478 to DERP servers",
+		}
+	}
+	if report.PreferredDERP == 0 {
+		hint := "DERP unreachable — check that your firewall allows outbound TCP 443 to DERP servers"
+		if !report.UDP {
+			hint = "DERP unreachable — check that your firewall allows outbound UDP 3478 and TCP 443"
+		}
+		return []doctorResult{udp, {
+			Status:  doctorFail,
+			Message: "no DERP region reachable",
+			Hint:    hint,
+		}}
+	}
+	name := fmt.Sprint(report.PreferredDERP)
+	if r := dm.Regions[report.PreferredDERP]; r != nil {
+		name = r.RegionCode
+	}
+	return []doctorResult{udp, {
+		Status:  doctorOK,
+		Message: fmt.Sprintf("nearest DERP region is %s (%v)", name, report.RegionLatency[report.PreferredDERP].Round(time.Millisecond)),
+	}}
+}
+
+func doctorCheckDNS(ctx context.Context) ([]doctorResult, error) {
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := localClient.DNSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return doctorDNSResults(prefs.CorpDNS, cfg), nil
+}
+
+// doctorDNSResults returns the results of the dns check, given whether the
+// node accepts the tailnet's DNS settings and the currently applied DNS
+// configuration. Failures to apply a configuration show up as health
+// warnings.
+func doctorDNSResults(acceptDNS bool, cfg *apitype.DNSConfigResponse) []doctorResult {
+	switch {
+	case !acceptDNS:
+		return []doctorResult{{Status: doctorOK, Message: "tailnet DNS settings not accepted (--accept-dns=false)"}}
+	case !cfg.Applied:
+		return []doctorResult{{
+			Status:  doctorWarn,
+			Message: "no DNS configuration applied",
+			Hint:    `check the health warnings and the OS DNS manager (e.g. systemd-resolved, NetworkManager)`,
+		}}
+	}
+	mode := "primary"
+	if cfg.SplitDNS {
+		mode = "split"
+	}
+	return []doctorResult{{
+		Status:  doctorOK,
+		Message: fmt.Sprintf("%s DNS configuration applied with %d nameserver(s)", mode, len(cfg.OS.Nameservers)),
+	}}
+}
+
+func doctorCheckSetup(ctx context.Context) ([]doctorResult, error) {
+	res, err := localClient.SetupChecks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return doctorSetupResults(res), nil
+}
+
+// doctorSetupResults returns a result for each of tailscaled's host setup
+// checks in res. A failed IP forwarding check is a failure, as it breaks
+// subnet routing; other failed checks are warnings.
+func doctorSetupResults(res *apitype.SetupChecksResponse) []doctorResult {
+	var rs []doctorResult
+	for _, c := range res.Checks {
+		r := doctorResult{Check: c.Name, Status: doctorOK, Message: c.Message}
+		if !c.Pass {
+			r.Status = doctorWarn
+			if c.Name == "ip-forwarding" {
+				r.Status = doctorFail
+			}
+			r.Hint = c.Fix
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
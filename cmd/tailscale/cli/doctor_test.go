@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+)
+
+func TestDoctorBackendResults(t *testing.T) {
+	tests := []struct {
+		state string
+		want  doctorStatus
+	}{
+		{ipn.Running.String(), doctorOK},
+		{ipn.NeedsLogin.String(), doctorFail},
+		{ipn.NeedsMachineAuth.String(), doctorFail},
+		{ipn.Stopped.String(), doctorWarn},
+		{ipn.Starting.String(), doctorWarn},
+	}
+	for _, tt := range tests {
+		rs := doctorBackendResults(&ipnstate.Status{BackendState: tt.state})
+		if len(rs) != 1 || rs[0].Status != tt.want {
+			t.Errorf("%s: got %+v; want status %q", tt.state, rs, tt.want)
+		}
+	}
+}
+
+func TestDoctorDERPResults(t *testing.T) {
+	dm := &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+		1: {RegionID: 1, RegionCode: "nyc"},
+	}}
+	nyc := map[int]time.Duration{1: 20 * time.Millisecond}
+	tests := []struct {
+		name     string
+		report   *netcheck.Report
+		wantUDP  doctorStatus
+		wantDERP doctorStatus
+		wantMsg  string
+	}{
+		{"ok", &netcheck.Report{UDP: true, PreferredDERP: 1, RegionLatency: nyc}, doctorOK, doctorOK, "nearest DERP region is nyc (20ms)"},
+		{"udp-blocked", &netcheck.Report{PreferredDERP: 1, RegionLatency: nyc}, doctorWarn, doctorOK, "nearest DERP region is nyc (20ms)"},
+		{"no-derp", &netcheck.Report{UDP: true}, doctorOK, doctorFail, "no DERP region reachable"},
+		{"nothing", &netcheck.Report{}, doctorWarn, doctorFail, "no DERP region reachable"},
+	}
+	for _, tt := range tests {
+		rs := doctorDERPResults(tt.report, dm)
+		if len(rs) != 2 || rs[0].Check != "udp" {
+			t.Fatalf("%s: got %+v; want udp and derp results", tt.name, rs)
+		}
+		if rs[0].Status != tt.wantUDP {
+			t.Errorf("%s: udp status = %q; want %q", tt.name, rs[0].Status, tt.wantUDP)
+		}
+		if rs[1].Status != tt.wantDERP || rs[1].Message != tt.wantMsg {
+			t.Errorf("%s: derp = %+v; want status %q, message %q", tt.name, rs[1], tt.wantDERP, tt.wantMsg)
+		}
+	}
+	// The hint for unreachable DERP names UDP 3478 only if UDP failed.
+	if rs := doctorDERPResults(&netcheck.Report{UDP: true}, dm); strings.Contains(rs[1].Hint, "3478") {
+		t.Errorf("hint with working UDP = %q; want no mention of UDP 3478", rs[1].Hint)
+	}
+}
+
+func TestDoctorDNSResults(t *testing.T) {
+	applied := &apitype.DNSConfigResponse{
+		Applied: true,
+		OS:      apitype.DNSOSConfig{Nameservers: []netip.Addr{netip.MustParseAddr("100.100.100.100")}},
+	}
+	tests := []struct {
+		name      string
+		acceptDNS bool
+		cfg       *apitype.DNSConfigResponse
+		want      doctorStatus
+	}{
+		{"not-accepted", false, &apitype.DNSConfigResponse{}, doctorOK},
+		{"not-applied", true, &apitype.DNSConfigResponse{}, doctorWarn},
+		{"applied", true, applied, doctorOK},
+	}
+	for _, tt := range tests {
+		rs := doctorDNSResults(tt.acceptDNS, tt.cfg)
+		if len(rs) != 1 || rs[0].Status != tt.want {
+			t.Errorf("%s: got %+v; want status %q", tt.name, rs, tt.want)
+		}
+	}
+}
+
+func TestDoctorSetupResults(t *testing.T) {
+	res := &apitype.SetupChecksResponse{
+		Router: true,
+		Checks: []apitype.SetupCheck{
+			{Name: "ip-forwarding", Message: "IPv4 forwarding is disabled", Fix: "See https://tailscale.com/s/ip-forwarding"},
+			{Name: "udp-gro-forwarding", Pass: true, Message: "UDP GRO forwarding is optimally configured"},
+			{Name: "udp", Message: "UDP from port 41641 appears blocked", Fix: "allow outbound UDP"},
+		},
+	}
+	got := doctorSetupResults(res)
+	want := []doctorResult{
+		{Check: "ip-forwarding", Status: doctorFail, Message: "IPv4 forwarding is disabled", Hint: "See https://tailscale.com/s/ip-forwarding"},
+		{Check: "udp-gro-forwarding", Status: doctorOK, Message: "UDP GRO forwarding is optimally configured"},
+		{Check: "udp", Status: doctorWarn, Message: "UDP from port 41641 appears blocked", Hint: "allow outbound UDP"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results; want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d = %+v; want %+v", i, got[i], want[i])
+		}
+	}
+}
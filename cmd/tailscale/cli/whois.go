@@ -9,18 +9,24 @@
 	"errors"
 	"flag"
 	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
 	"strings"
 	"text/tabwriter"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
 )
 
 var whoisCmd = &ffcli.Command{
 	Name:       "whois",
-	ShortUsage: "tailscale whois [--json] ip[:port]",
+	ShortUsage: "tailscale whois [--json] <ip[:port] | stable-id>",
 	ShortHelp:  "Show the machine and user associated with a Tailscale IP (v4 or v6)",
 	LongHelp: strings.TrimSpace(`
-	'tailscale whois' shows the machine and user associated with a Tailscale IP (v4 or v6).
+	'tailscale whois' shows the machine and user associated with a Tailscale IP (v4 or v6),
+	or with a node's stable ID, as printed by 'tailscale status --json'.
 	`),
 	Exec: runWhoIs,
 	FlagSet: func() *flag.FlagSet {
@@ -42,7 +48,16 @@ func runWhoIs(ctx context.Context, args []string) error {
 	} else if len(args) == 0 {
 		return errors.New("missing argument, expected one peer")
 	}
-	who, err := localClient.WhoIsProto(ctx, whoIsArgs.proto, args[0])
+	var who *apitype.WhoIsResponse
+	var err error
+	if isWhoIsAddr(args[0]) {
+		who, err = localClient.WhoIsProto(ctx, whoIsArgs.proto, args[0])
+	} else {
+		if whoIsArgs.proto != "" {
+			return errors.New("--proto only applies when looking up an IP:port")
+		}
+		who, err = localClient.WhoIsStableID(ctx, tailcfg.StableNodeID(args[0]))
+	}
 	if err != nil {
 		return err
 	}
@@ -73,7 +88,8 @@ func runWhoIs(ctx context.Context, args []string) error {
 
 	if cm := who.CapMap; len(cm) > 0 {
 		printf("Capabilities:\n")
-		for cap, vals := range cm {
+		for _, cap := range slices.Sorted(maps.Keys(cm)) {
+			vals := cm[cap]
 			// To make the output more readable, we have to reindent the JSON
 			// values so they line up with the cap name.
 			if len(vals) > 0 {
@@ -88,3 +104,16 @@ func runWhoIs(ctx context.Context, args []string) error {
 	}
 	return nil
 }
+
+// isWhoIsAddr reports whether arg is looked up by address (an IP, IP:port
+// or node key) rather than as a stable node ID.
+func isWhoIsAddr(arg string) bool {
+	if strings.HasPrefix(arg, "nodekey:") {
+		return true
+	}
+	if _, err := netip.ParseAddr(arg); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddrPort(arg)
+	return err == nil
+}
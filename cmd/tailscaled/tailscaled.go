@@ -130,6 +130,10 @@ func defaultPort() uint16 {
 	socksAddr      string // listen address for SOCKS5 server
 	httpProxyAddr  string // listen address for HTTP proxy server
 	disableLogs    bool
+
+	// egressInterfaces, if non-empty, is a comma-separated list of the
+	// only network interfaces tailscaled's own sockets may use.
+	egressInterfaces string
 }
 
 var (
@@ -166,13 +170,14 @@ func main() {
 	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
-	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets, 'arn:aws:ssm:...' to store in AWS SSM or 'gcp-secret:<secret-name>' to store in Google Cloud Secret Manager; use 'json:<path>' for a human-readable JSON file or 'encrypted:<keyfile>:<path>' for a file encrypted with the key in keyfile; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	flag.StringVar(&args.statedir, "statedir", "", "path to directory for storage of config state, TLS certs, temporary incoming Taildrop files, etc. If empty, it's derived from --state when possible.")
 	flag.StringVar(&args.socketpath, "socket", paths.DefaultTailscaledSocket(), "path of the service unix socket")
 	flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
 	flag.StringVar(&args.confFile, "config", "", "path to config file, or 'vm:user-data' to use the VM's user-data (EC2)")
+	flag.StringVar(&args.egressInterfaces, "egress-interfaces", "", "if non-empty, comma-separated list of the only network interfaces tailscaled may send traffic through (Linux, macOS and Windows)")
 
 	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
 		beCLI()
@@ -340,6 +345,10 @@ func run() (err error) {
 
 	sys := new(tsd.System)
 
+	if args.egressInterfaces != "" {
+		netns.SetInterfaceAllowlist(strings.Split(args.egressInterfaces, ","))
+	}
+
 	// Parse config, if specified, to fail early if it's invalid.
 	var conf *conffile.Config
 	if args.confFile != "" {
@@ -362,6 +371,14 @@ func run() (err error) {
 		sys.Set(netMon)
 	}
 
+	// Keep the most recent logs in memory for bugreport bundles and
+	// "tailscale debug logs": the last MiB, unless TS_RECENT_LOGS_MAX_BYTES
+	// says otherwise (0 to keep none).
+	recentLogsBytes := 1 << 20
+	if n, ok := envknob.LookupInt("TS_RECENT_LOGS_MAX_BYTES"); ok {
+		recentLogsBytes = n
+	}
+	logtail.RetainRecentLogs(recentLogsBytes)
 	pol := logpolicy.New(logtail.CollectionNode, netMon, sys.HealthTracker(), nil /* use log.Printf */)
 	pol.SetVerbosityLevel(args.verbose)
 	logPol = pol
@@ -612,6 +629,7 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	lb.SetVarRoot(opts.VarRoot)
 	if logPol != nil {
 		lb.SetLogFlusher(logPol.Logtail.StartFlush)
+		lb.SetLogBuffer(logPol.Buffer)
 	}
 	if root := lb.TailscaleVarRoot(); root != "" {
 		dnsfallback.SetCachePath(filepath.Join(root, "derpmap.cached.json"), logf)
@@ -5,18 +5,27 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"testing"
 
+	"tailscale.com/tailcfg"
 	"tailscale.com/tstest/integration"
 	"tailscale.com/tstest/integration/testcontrol"
+	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 )
 
 var (
-	flagNFake = flag.Int("nfake", 0, "number of fake nodes to add to network")
+	flagNFake        = flag.Int("nfake", 0, "number of fake nodes to add to network")
+	flagCapVer       = flag.Int("capver", 0, "capability version the server claims to speak; 0 means the current version")
+	flagMinClientVer = flag.Int("min-client-capver", 0, "if non-zero, reject clients with a capability version older than this")
 )
 
 func main() {
@@ -26,20 +35,118 @@ func main() {
 	derpMap := integration.RunDERPAndSTUN(t, logger.Discard, "127.0.0.1")
 
 	control := &testcontrol.Server{
-		DERPMap:         derpMap,
-		ExplicitBaseURL: "http://127.0.0.1:9911",
+		DERPMap:                    derpMap,
+		ExplicitBaseURL:            "http://127.0.0.1:9911",
+		CapabilityVersion:          tailcfg.CapabilityVersion(*flagCapVer),
+		MinClientCapabilityVersion: tailcfg.CapabilityVersion(*flagMinClientVer),
 	}
 	for range *flagNFake {
 		control.AddFakeNode()
 	}
 	mux := http.NewServeMux()
 	mux.Handle("/", control)
+	mux.HandleFunc("/admin/delete-node", func(w http.ResponseWriter, r *http.Request) {
+		serveDeleteNode(control, w, r)
+	})
+	mux.HandleFunc("/admin/nodes", func(w http.ResponseWriter, r *http.Request) {
+		serveAddNode(control, w, r)
+	})
+	mux.HandleFunc("/admin/nodes/", func(w http.ResponseWriter, r *http.Request) {
+		serveRemoveNode(control, w, r)
+	})
+	mux.HandleFunc("/admin/force-derp", func(w http.ResponseWriter, r *http.Request) {
+		serveForceDERP(control, w, r)
+	})
 	addr := "127.0.0.1:9911"
 	log.Printf("listening on %s", addr)
 	err := http.ListenAndServe(addr, mux)
 	log.Fatal(err)
 }
 
+// serveDeleteNode handles POST /admin/delete-node?key=nodekey:..., removing
+// the node from the tailnet as if an admin had deleted it.
+func serveDeleteNode(control *testcontrol.Server, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var nk key.NodePublic
+	if err := nk.UnmarshalText([]byte(r.FormValue("key"))); err != nil {
+		http.Error(w, fmt.Sprintf("invalid node key: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !control.DeleteNode(nk) {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("deleted node %v", nk.ShortString())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fakeNode is the JSON response to POST /admin/nodes.
+type fakeNode struct {
+	ID       tailcfg.NodeID
+	StableID tailcfg.StableNodeID
+	Key      key.NodePublic
+	IP       netip.Addr
+}
+
+// serveAddNode handles POST /admin/nodes, adding a fake node to the tailnet
+// and responding with its details.
+func serveAddNode(control *testcontrol.Server, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	n := control.AddFakeNode()
+	log.Printf("added fake node %v (%v)", n.ID, n.Addresses[0].Addr())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fakeNode{
+		ID:       n.ID,
+		StableID: n.StableID,
+		Key:      n.Key,
+		IP:       n.Addresses[0].Addr(),
+	})
+}
+
+// serveRemoveNode handles DELETE /admin/nodes/<id>, removing the fake node
+// with the given numeric node ID, as returned by POST /admin/nodes.
+func serveRemoveNode(control *testcontrol.Server, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/admin/nodes/"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid node ID: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !control.RemoveFakeNode(tailcfg.NodeID(id)) {
+		http.Error(w, "fake node not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("removed fake node %v", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveForceDERP handles POST /admin/force-derp?v=true (or false), setting
+// whether peers' endpoints are withheld from netmaps. See
+// testcontrol.Server.SetForceDERP.
+func serveForceDERP(control *testcontrol.Server, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	v, err := strconv.ParseBool(r.FormValue("v"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid v: %v", err), http.StatusBadRequest)
+		return
+	}
+	control.SetForceDERP(v)
+	log.Printf("force DERP: %v", v)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 type fakeTB struct {
 	*testing.T
 }
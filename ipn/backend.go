@@ -236,8 +236,10 @@ type OutgoingFile struct {
 // DebuggableComponents is a list of components whose debugging can be turned on
 // and off individually using the tailscale debug command.
 var DebuggableComponents = []string{
+	"dns",
 	"magicsock",
 	"sockstats",
+	"wgengine",
 }
 
 type Options struct {
@@ -0,0 +1,141 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/views"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+// AcceptedRoutes reports the subnet routes advertised by peers and which of
+// them the user has individually chosen to accept.
+func (b *LocalBackend) AcceptedRoutes() *apitype.AcceptedRoutesResponse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := &apitype.AcceptedRoutesResponse{
+		AcceptAll: b.pm.CurrentPrefs().RouteAll(),
+		Available: []netip.Prefix{},
+		Accepted:  slices.Clone(b.acceptedRoutes),
+	}
+	if res.Accepted == nil {
+		res.Accepted = []netip.Prefix{}
+	}
+	if nm := b.netMap; nm != nil {
+		for _, p := range nm.Peers {
+			for _, r := range p.AllowedIPs().All() {
+				if isSubnetRoute(p, r) && !slices.Contains(res.Available, r) {
+					res.Available = append(res.Available, r)
+				}
+			}
+		}
+	}
+	tsaddr.SortPrefixes(res.Available)
+	return res
+}
+
+// SetAcceptedRoutes sets the subnet routes to accept from peers when the
+// RouteAll pref is off, replacing any previous selection, and reconfigures
+// the engine. An empty routes accepts none. The selection is persisted in
+// the state store for the current profile.
+func (b *LocalBackend) SetAcceptedRoutes(routes []netip.Prefix) error {
+	var accepted []netip.Prefix
+	for _, r := range routes {
+		if !r.IsValid() {
+			return fmt.Errorf("invalid route %v", r)
+		}
+		if r.Bits() == 0 {
+			return fmt.Errorf("%v is a default route; use an exit node instead", r)
+		}
+		if r = r.Masked(); !slices.Contains(accepted, r) {
+			accepted = append(accepted, r)
+		}
+	}
+	tsaddr.SortPrefixes(accepted)
+	j, err := json.Marshal(accepted)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	profileID := b.pm.CurrentProfile().ID
+	b.mu.Unlock()
+	if profileID == "" {
+		return errors.New("no current profile; log in first")
+	}
+	if err := ipn.WriteState(b.store, ipn.AcceptedRoutesKey(profileID), j); err != nil {
+		return fmt.Errorf("saving accepted routes: %w", err)
+	}
+	b.mu.Lock()
+	if b.pm.CurrentProfile().ID != profileID {
+		// The profile changed while we were writing; its own
+		// selection has already been loaded.
+		b.mu.Unlock()
+		return nil
+	}
+	b.acceptedRoutes = accepted
+	b.mu.Unlock()
+	b.logf("accepted routes: %v", accepted)
+	b.authReconfig()
+	return nil
+}
+
+// loadAcceptedRoutesLocked loads the accepted subnet routes saved in the
+// state store for the current profile, if any.
+//
+// b.mu must be held.
+func (b *LocalBackend) loadAcceptedRoutesLocked() {
+	b.acceptedRoutes = nil
+	profileID := b.pm.CurrentProfile().ID
+	if profileID == "" {
+		return
+	}
+	j, err := b.store.ReadState(ipn.AcceptedRoutesKey(profileID))
+	if err != nil {
+		return
+	}
+	var routes []netip.Prefix
+	if err := json.Unmarshal(j, &routes); err != nil {
+		b.logf("invalid accepted routes %q in StateStore: %v", j, err)
+		return
+	}
+	b.acceptedRoutes = routes
+}
+
+// filterAcceptedRoutes removes from the peers in cfg, which was generated
+// from nm with subnet routes allowed, the subnet routes not in accepted.
+// Peers' own addresses and default routes are left alone.
+func filterAcceptedRoutes(cfg *wgcfg.Config, nm *netmap.NetworkMap, accepted []netip.Prefix) {
+	nodes := make(map[key.NodePublic]tailcfg.NodeView, len(nm.Peers))
+	for _, p := range nm.Peers {
+		nodes[p.Key()] = p
+	}
+	for i := range cfg.Peers {
+		cp := &cfg.Peers[i]
+		node, ok := nodes[cp.PublicKey]
+		if !ok {
+			continue
+		}
+		cp.AllowedIPs = slices.DeleteFunc(cp.AllowedIPs, func(r netip.Prefix) bool {
+			return isSubnetRoute(node, r) && !slices.Contains(accepted, r)
+		})
+	}
+}
+
+// isSubnetRoute reports whether r, one of node's AllowedIPs, is a subnet
+// route: neither a default route nor one of node's own addresses.
+func isSubnetRoute(node tailcfg.NodeView, r netip.Prefix) bool {
+	return r.Bits() != 0 && !views.SliceContains(node.Addresses(), r)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/types/views"
+	"tailscale.com/util/mak"
+)
+
+// maxPreviousAddresses is the most previously-assigned tailnet addresses
+// kept in the address history.
+const maxPreviousAddresses = 16
+
+// addressHistory is the node's current and previous tailnet addresses, as
+// persisted under ipn.AddressHistoryKey for each profile.
+type addressHistory struct {
+	Current  []currentAddress
+	Previous []apitype.PastAddress // most recently unassigned first
+}
+
+// currentAddress is a tailnet address currently assigned to the node.
+type currentAddress struct {
+	Addr     netip.Prefix
+	Assigned time.Time // when the node was first seen with Addr
+}
+
+// Addresses returns the node's current tailnet addresses and those it had
+// previously, for correlating references to an old address.
+func (b *LocalBackend) Addresses() *apitype.AddressesResponse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := &apitype.AddressesResponse{
+		Current:  []netip.Prefix{},
+		Previous: slices.Clone(b.addrHistory.Previous),
+	}
+	if b.netMap != nil {
+		res.Current = append(res.Current, b.netMap.GetAddresses().AsSlice()...)
+	}
+	slices.SortFunc(res.Current, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+	if res.Previous == nil {
+		res.Previous = []apitype.PastAddress{}
+	}
+	return res
+}
+
+// updateAddressHistoryLocked records addrs, the node's tailnet addresses
+// from its latest network map, in the address history, moving addresses
+// no longer assigned to the previous ones. When the history changes, it's
+// persisted in the state store for the current profile, asynchronously so
+// as not to write to the store with b.mu held.
+//
+// b.mu must be held.
+func (b *LocalBackend) updateAddressHistoryLocked(addrs views.Slice[netip.Prefix]) {
+	if addrs.Len() == 0 {
+		return
+	}
+	now := b.clock.Now()
+	h := &b.addrHistory
+	changed := false
+	h.Current = slices.DeleteFunc(h.Current, func(c currentAddress) bool {
+		if views.SliceContains(addrs, c.Addr) {
+			return false
+		}
+		b.logf("tailnet address %v unassigned", c.Addr)
+		h.Previous = slices.Insert(h.Previous, 0, apitype.PastAddress{
+			Addr:       c.Addr,
+			Assigned:   c.Assigned,
+			Unassigned: now,
+		})
+		changed = true
+		return true
+	})
+	for _, a := range addrs.All() {
+		if !slices.ContainsFunc(h.Current, func(c currentAddress) bool { return c.Addr == a }) {
+			h.Current = append(h.Current, currentAddress{Addr: a, Assigned: now})
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if len(h.Previous) > maxPreviousAddresses {
+		h.Previous = h.Previous[:maxPreviousAddresses]
+	}
+	profileID := b.pm.CurrentProfile().ID
+	if profileID == "" {
+		return
+	}
+	j, err := json.Marshal(h)
+	if err != nil {
+		b.logf("encoding address history: %v", err)
+		return
+	}
+	mak.Set(&b.addrHistoryToSave, ipn.AddressHistoryKey(profileID), j)
+	go b.saveAddressHistory()
+}
+
+// saveAddressHistory writes the address histories queued by
+// updateAddressHistoryLocked to the state store.
+func (b *LocalBackend) saveAddressHistory() {
+	b.addrHistorySaveMu.Lock()
+	defer b.addrHistorySaveMu.Unlock()
+	b.mu.Lock()
+	toSave := b.addrHistoryToSave
+	b.addrHistoryToSave = nil
+	b.mu.Unlock()
+	for key, j := range toSave {
+		if err := ipn.WriteState(b.store, key, j); err != nil {
+			b.logf("saving address history: %v", err)
+		}
+	}
+}
+
+// loadAddressHistoryLocked loads the address history saved in the state
+// store for the current profile, if any.
+//
+// b.mu must be held.
+func (b *LocalBackend) loadAddressHistoryLocked() {
+	b.addrHistory = addressHistory{}
+	profileID := b.pm.CurrentProfile().ID
+	if profileID == "" {
+		return
+	}
+	key := ipn.AddressHistoryKey(profileID)
+	j, ok := b.addrHistoryToSave[key] // not yet saved
+	if !ok {
+		var err error
+		if j, err = b.store.ReadState(key); err != nil {
+			return
+		}
+	}
+	var h addressHistory
+	if err := json.Unmarshal(j, &h); err != nil {
+		b.logf("invalid address history %q in StateStore: %v", j, err)
+		return
+	}
+	b.addrHistory = h
+}
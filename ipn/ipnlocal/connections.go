@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tstun"
+	"tailscale.com/tstime"
+)
+
+// flowTrackingIdle is how long the tun device keeps tracking flows after
+// the last call to trackFlows.
+const flowTrackingIdle = 10 * time.Minute
+
+// ActiveConnections returns the TCP, UDP and ICMP flows that tailscaled
+// carried traffic for within the last two minutes, most recently active
+// first, each annotated with the peer it's to or from and whether that
+// peer is reached directly or via DERP.
+//
+// Flows are only tracked from the first call until flowTrackingIdle after
+// the last one, so that the packet path doesn't pay for tracking when
+// nobody's looking. The first call, and the first after flowTrackingIdle
+// without any, thus return no flows.
+func (b *LocalBackend) ActiveConnections() []apitype.Connection {
+	tw, ok := b.trackFlows()
+	if !ok {
+		return nil
+	}
+	flows := tw.ActiveFlows()
+	if len(flows) == 0 {
+		return nil
+	}
+	return connectionsForFlows(flows, b.Status())
+}
+
+// trackFlows turns on flow tracking in the tun device, if it isn't already,
+// and keeps it on for another flowTrackingIdle. It reports false if there's
+// no tun device.
+func (b *LocalBackend) trackFlows() (_ *tstun.Wrapper, ok bool) {
+	tw, ok := b.sys.Tun.GetOK()
+	if !ok {
+		return nil, false
+	}
+	b.flowTrackingMu.Lock()
+	defer b.flowTrackingMu.Unlock()
+	tw.SetFlowTracking(true)
+	if b.flowTrackingOff == nil || !b.flowTrackingOff.Reset(flowTrackingIdle) {
+		// No timer, or it already fired and its func is waiting for
+		// flowTrackingMu; replace it, so that func does nothing.
+		var t tstime.TimerController
+		t = b.clock.AfterFunc(flowTrackingIdle, func() {
+			b.flowTrackingMu.Lock()
+			defer b.flowTrackingMu.Unlock()
+			if b.flowTrackingOff != t {
+				return
+			}
+			b.flowTrackingOff = nil
+			tw.SetFlowTracking(false)
+		})
+		b.flowTrackingOff = t
+	}
+	return tw, true
+}
+
+// connectionsForFlows converts flows to their LocalAPI representation,
+// using st to find the peer each flow is with.
+func connectionsForFlows(flows []tstun.Flow, st *ipnstate.Status) []apitype.Connection {
+	res := make([]apitype.Connection, 0, len(flows))
+	for _, f := range flows {
+		c := apitype.Connection{
+			Proto:      f.Proto.String(),
+			Src:        f.Local,
+			Dst:        f.Remote,
+			TxPackets:  f.TxPackets,
+			TxBytes:    f.TxBytes,
+			RxPackets:  f.RxPackets,
+			RxBytes:    f.RxBytes,
+			LastActive: f.LastActive,
+		}
+		// For traffic forwarded by a subnet router or exit node, the
+		// peer is on the local side of the flow.
+		ps := peerForAddr(st, f.Remote.Addr())
+		if ps == nil {
+			ps = peerForAddr(st, f.Local.Addr())
+		}
+		if ps != nil {
+			c.PeerID = ps.ID
+			c.PeerName = ps.DNSName
+			c.CurAddr = ps.CurAddr
+			c.Direct = ps.CurAddr != ""
+			if !c.Direct {
+				c.Relay = ps.Relay
+			}
+		}
+		res = append(res, c)
+	}
+	slices.SortFunc(res, func(a, b apitype.Connection) int {
+		return cmp.Or(
+			b.LastActive.Compare(a.LastActive),
+			a.Src.Compare(b.Src),
+			a.Dst.Compare(b.Dst),
+		)
+	})
+	return res
+}
+
+// peerForAddr returns the peer in st whose AllowedIPs most specifically
+// contain ip, or nil if none do. Default routes only match the current
+// exit node.
+func peerForAddr(st *ipnstate.Status, ip netip.Addr) *ipnstate.PeerStatus {
+	var best *ipnstate.PeerStatus
+	bestBits := -1
+	for _, ps := range st.Peer {
+		if ps.AllowedIPs == nil {
+			continue
+		}
+		for _, pfx := range ps.AllowedIPs.All() {
+			if pfx.Bits() == 0 && !ps.ExitNode {
+				continue
+			}
+			if pfx.Bits() > bestBits && pfx.Contains(ip) {
+				best, bestBits = ps, pfx.Bits()
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine/filter/filtertype"
+)
+
+// InboundAccess reports which sources the packet filter this node enforces
+// permits to initiate connections to it, and on which protocols and ports.
+// It returns nil if there's no netmap yet, and no rules if shields are up.
+func (b *LocalBackend) InboundAccess() []apitype.InboundAccessRule {
+	b.mu.Lock()
+	nm := b.netMap
+	prefs := b.pm.CurrentPrefs()
+	b.mu.Unlock()
+	if nm == nil {
+		return nil
+	}
+	return inboundAccess(nm, prefs)
+}
+
+// inboundAccess returns the rules of nm's packet filter that permit
+// connections to nm's self addresses, with each rule's sources resolved to
+// the peers in nm that they match. If prefs has shields up, the filter
+// blocks all incoming connections, so there are no such rules.
+func inboundAccess(nm *netmap.NetworkMap, prefs ipn.PrefsView) []apitype.InboundAccessRule {
+	if prefs.ShieldsUp() {
+		return []apitype.InboundAccessRule{}
+	}
+	self := nm.GetAddresses()
+	toSelf := func(dst filtertype.NetPortRange) bool {
+		for i := range self.Len() {
+			if dst.Net.Contains(self.At(i).Addr()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	rules := []apitype.InboundAccessRule{}
+	for _, m := range nm.PacketFilter {
+		var ports []string
+		for _, dst := range m.Dsts {
+			if toSelf(dst) {
+				ports = append(ports, dst.Ports.String())
+			}
+		}
+		if len(ports) == 0 {
+			continue
+		}
+		slices.Sort(ports)
+		r := apitype.InboundAccessRule{
+			Ports:   slices.Compact(ports),
+			SrcCaps: m.SrcCaps,
+		}
+		for _, p := range m.IPProto.All() {
+			name, _ := p.MarshalText()
+			r.Protos = append(r.Protos, string(name))
+		}
+		for _, src := range m.Srcs {
+			r.Srcs = append(r.Srcs, src.String())
+		}
+		for _, p := range nm.Peers {
+			if !matchesSrc(m, p) {
+				continue
+			}
+			r.Peers = append(r.Peers, apitype.InboundAccessPeer{
+				ID:   p.StableID(),
+				Name: p.Name(),
+				Tags: p.Tags().AsSlice(),
+			})
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// matchesSrc reports whether m permits traffic from peer's self addresses,
+// by address or by capability.
+func matchesSrc(m filtertype.Match, peer tailcfg.NodeView) bool {
+	if peer.Addresses().ContainsFunc(func(a netip.Prefix) bool {
+		return a.IsSingleIP() && slices.ContainsFunc(m.Srcs, func(src netip.Prefix) bool {
+			return src.Contains(a.Addr())
+		})
+	}) {
+		return true
+	}
+	return slices.ContainsFunc(m.SrcCaps, peer.HasCap)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/views"
+	"tailscale.com/wgengine/filter/filtertype"
+)
+
+func TestInboundAccess(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	nm := &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			Addresses: []netip.Prefix{pfx("100.64.0.1/32")},
+		}).View(),
+		Peers: []tailcfg.NodeView{
+			(&tailcfg.Node{
+				StableID:  "admin",
+				Name:      "admin.ts.net.",
+				Addresses: []netip.Prefix{pfx("100.64.0.2/32")},
+			}).View(),
+			(&tailcfg.Node{
+				StableID:  "server",
+				Name:      "server.ts.net.",
+				Tags:      []string{"tag:server"},
+				Addresses: []netip.Prefix{pfx("100.64.0.3/32")},
+				CapMap:    tailcfg.NodeCapMap{"example.com/cap/monitor": nil},
+			}).View(),
+		},
+		PacketFilter: []filtertype.Match{
+			{
+				// SSH from admin.
+				IPProto: views.SliceOf([]ipproto.Proto{ipproto.TCP}),
+				Srcs:    []netip.Prefix{pfx("100.64.0.2/32")},
+				Dsts: []filtertype.NetPortRange{
+					{Net: pfx("100.64.0.1/32"), Ports: filtertype.PortRange{First: 22, Last: 22}},
+				},
+			},
+			{
+				// Monitoring from nodes with a capability, to any port.
+				IPProto: views.SliceOf([]ipproto.Proto{ipproto.TCP, ipproto.UDP}),
+				SrcCaps: []tailcfg.NodeCapability{"example.com/cap/monitor"},
+				Dsts: []filtertype.NetPortRange{
+					{Net: pfx("0.0.0.0/0"), Ports: filtertype.AllPorts},
+				},
+			},
+			{
+				// Traffic to a different node, such as behind a subnet
+				// route, isn't inbound to this one.
+				IPProto: views.SliceOf([]ipproto.Proto{ipproto.TCP}),
+				Srcs:    []netip.Prefix{pfx("0.0.0.0/0")},
+				Dsts: []filtertype.NetPortRange{
+					{Net: pfx("10.0.0.0/24"), Ports: filtertype.AllPorts},
+				},
+			},
+		},
+	}
+
+	prefs := ipn.NewPrefs().View()
+	got := inboundAccess(nm, prefs)
+	want := []apitype.InboundAccessRule{
+		{
+			Protos: []string{"tcp"},
+			Ports:  []string{"22"},
+			Srcs:   []string{"100.64.0.2/32"},
+			Peers:  []apitype.InboundAccessPeer{{ID: "admin", Name: "admin.ts.net."}},
+		},
+		{
+			Protos:  []string{"tcp", "udp"},
+			Ports:   []string{"*"},
+			SrcCaps: []tailcfg.NodeCapability{"example.com/cap/monitor"},
+			Peers:   []apitype.InboundAccessPeer{{ID: "server", Name: "server.ts.net.", Tags: []string{"tag:server"}}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("inboundAccess (-want +got):\n%s", diff)
+	}
+
+	shieldsUp := ipn.NewPrefs()
+	shieldsUp.ShieldsUp = true
+	if got := inboundAccess(nm, shieldsUp.View()); got == nil || len(got) != 0 {
+		t.Errorf("with shields up, got %v; want empty non-nil", got)
+	}
+
+	nm.PacketFilter = nil
+	if got := inboundAccess(nm, prefs); got == nil || len(got) != 0 {
+		t.Errorf("with no filter, got %v; want empty non-nil", got)
+	}
+}
@@ -60,8 +60,10 @@
 	"tailscale.com/ipn/policy"
 	"tailscale.com/log/sockstatlog"
 	"tailscale.com/logpolicy"
+	"tailscale.com/logtail/filch"
 	"tailscale.com/net/captivedetection"
 	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/dnscache"
 	"tailscale.com/net/dnsfallback"
 	"tailscale.com/net/ipset"
@@ -190,6 +192,7 @@ type LocalBackend struct {
 	gotPortPollRes        chan struct{}    // closed upon first readPoller result
 	varRoot               string           // or empty if SetVarRoot never called
 	logFlushFunc          func()           // or nil if SetLogFlusher wasn't called
+	logBuffer             *filch.Filch     // or nil if SetLogBuffer wasn't called
 	em                    *expiryManager   // non-nil
 	sshAtomicBool         atomic.Bool
 	// webClientAtomicBool controls whether the web client is running. This should
@@ -305,6 +308,21 @@ type LocalBackend struct {
 	lastServeConfJSON mem.RO              // last JSON that was parsed into serveConfig
 	serveConfig       ipn.ServeConfigView // or !Valid if none
 
+	tuning ipn.Tuning // engine tuning parameters; guarded by mu
+
+	// acceptedRoutes are the subnet routes individually accepted when the
+	// RouteAll pref is off. Guarded by mu.
+	acceptedRoutes []netip.Prefix
+
+	addrHistory addressHistory // guarded by mu
+
+	// addrHistoryToSave holds the JSON-encoded address histories not yet
+	// written to the store by saveAddressHistory, keyed by their state
+	// key. Guarded by mu.
+	addrHistoryToSave map[ipn.StateKey][]byte
+	// addrHistorySaveMu serializes saveAddressHistory's store writes.
+	addrHistorySaveMu sync.Mutex
+
 	webClient          webClient
 	webClientListeners map[netip.AddrPort]*localListener // listeners for local web client traffic
 
@@ -316,6 +334,11 @@ type LocalBackend struct {
 	statusLock    sync.Mutex
 	statusChanged *sync.Cond
 
+	// statusGen is incremented whenever something Status reports may
+	// have changed: on every IPN bus notification, netmap delta and
+	// engine status update. See StatusGeneration.
+	statusGen atomic.Uint64
+
 	// dialPlan is any dial plan that we've received from the control
 	// server during a previous connection; it is cleared on logout.
 	dialPlan atomic.Pointer[tailcfg.ControlDialPlan]
@@ -363,6 +386,16 @@ type LocalBackend struct {
 	// backend is healthy and captive portal detection is not required
 	// (sending false).
 	needsCaptiveDetection chan bool
+
+	// flowTrackingMu guards flowTrackingOff.
+	flowTrackingMu sync.Mutex
+	// flowTrackingOff, if non-nil, is the timer that turns off the tun
+	// device's flow tracking once ActiveConnections stops being called.
+	flowTrackingOff tstime.TimerController
+
+	// ackNoticesMu serializes AckNotice's read-modify-write of the
+	// acknowledged notice IDs in the state store.
+	ackNoticesMu sync.Mutex
 }
 
 // HealthTracker returns the health tracker for the backend.
@@ -499,6 +532,12 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		}
 	}
 
+	b.loadTuning()
+	b.mu.Lock()
+	b.loadAcceptedRoutesLocked()
+	b.loadAddressHistoryLocked()
+	b.mu.Unlock()
+
 	// initialize Taildrive shares from saved state
 	fs, ok := b.sys.DriveForRemote.GetOK()
 	if ok {
@@ -529,16 +568,24 @@ func componentStateKey(component string) ipn.StateKey {
 //
 // The following components are recognized:
 //
+//   - dns
 //   - magicsock
 //   - sockstats
+//   - wgengine
 func (b *LocalBackend) SetComponentDebugLogging(component string, until time.Time) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	var setEnabled func(bool)
 	switch component {
+	case "dns":
+		if dm, ok := b.sys.DNSManager.GetOK(); ok {
+			setEnabled = dm.SetDebugLoggingEnabled
+		}
 	case "magicsock":
 		setEnabled = b.MagicConn().SetDebugLoggingEnabled
+	case "wgengine":
+		setEnabled = b.e.SetDebugLoggingEnabled
 	case "sockstats":
 		if b.sockstatLogger != nil {
 			setEnabled = func(v bool) {
@@ -903,6 +950,12 @@ func (b *LocalBackend) StatusWithoutPeers() *ipnstate.Status {
 func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	b.e.UpdateStatus(sb) // does wireguard + magicsock status
 
+	if dm, ok := b.sys.DNSManager.GetOK(); ok {
+		sb.MutateStatus(func(s *ipnstate.Status) {
+			s.SplitDNSActive = dm.SplitDNSActive()
+		})
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -932,7 +985,7 @@ func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 			s.CurrentTailnet.MagicDNSEnabled = b.netMap.DNS.Proxied
 			s.CurrentTailnet.Name = b.netMap.Domain
 			if prefs := b.pm.CurrentPrefs(); prefs.Valid() {
-				if !prefs.RouteAll() && b.netMap.AnyPeersAdvertiseRoutes() {
+				if !prefs.RouteAll() && len(b.acceptedRoutes) == 0 && b.netMap.AnyPeersAdvertiseRoutes() {
 					s.Health = append(s.Health, healthmsg.WarnAcceptRoutesOff)
 				}
 				if !prefs.ExitNodeID().IsZero() {
@@ -1112,6 +1165,26 @@ func (b *LocalBackend) WhoIsNodeKey(k key.NodePublic) (n tailcfg.NodeView, u tai
 	return n, u, false
 }
 
+// WhoIsStableID reports the node and user who owns the node with the given
+// stable node ID. If ok == true, n and u are valid.
+func (b *LocalBackend) WhoIsStableID(id tailcfg.StableNodeID) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.netMap == nil || id == "" {
+		return n, u, false
+	}
+	if self := b.netMap.SelfNode; self.Valid() && self.StableID() == id {
+		return self, b.netMap.UserProfiles[self.User()], true
+	}
+	for _, n := range b.peers {
+		if n.StableID() == id {
+			u, ok = b.netMap.UserProfiles[n.User()]
+			return n, u, ok
+		}
+	}
+	return n, u, false
+}
+
 // WhoIs reports the node and user who owns the node with the given IP:port.
 // If the IP address is a Tailscale IP, the provided port may be 0.
 //
@@ -1555,6 +1628,7 @@ func (b *LocalBackend) UpdateNetmapDelta(muts []netmap.NodeMutation) (handled bo
 	if !b.updateNetmapDeltaLocked(muts) {
 		return false
 	}
+	b.statusGen.Add(1)
 
 	if b.netMap != nil && mutationsAreWorthyOfTellingIPNBus(muts) {
 		nm := ptr.To(*b.netMap) // shallow clone
@@ -1727,7 +1801,17 @@ func (b *LocalBackend) setWgengineStatus(s *wgengine.Status, err error) {
 	b.send(ipn.Notify{Engine: &es})
 }
 
+// StatusGeneration returns a number that changes whenever the result of
+// Status may have changed, other than the traffic counters and timestamps
+// that magicsock and wireguard update continuously, which are refreshed by
+// the next engine status update. It lets callers skip building a Status
+// that would be unchanged.
+func (b *LocalBackend) StatusGeneration() uint64 {
+	return b.statusGen.Load()
+}
+
 func (b *LocalBackend) broadcastStatusChanged() {
+	b.statusGen.Add(1)
 	// The sync.Cond docs say: "It is allowed but not required for the caller to hold c.L during the call."
 	// In this particular case, we must acquire b.statusLock. Otherwise we might broadcast before
 	// the waiter (in requestEngineStatusAndWait) starts to wait, in which case
@@ -2740,6 +2824,27 @@ func (b *LocalBackend) DebugPickNewDERP() error {
 	return b.sys.MagicSock.Get().DebugPickNewDERP()
 }
 
+// DebugSetDERPRegionFailed sets whether DERP region regionID is treated as
+// unreachable, to exercise home region failover. See
+// magicsock.Conn.DebugSetDERPRegionFailed.
+func (b *LocalBackend) DebugSetDERPRegionFailed(regionID int, failed bool) (*apitype.DERPRegionFailResponse, error) {
+	home, err := b.sys.MagicSock.Get().DebugSetDERPRegionFailed(regionID, failed)
+	if err != nil {
+		return nil, err
+	}
+	res := &apitype.DERPRegionFailResponse{
+		Region:     regionID,
+		Failed:     failed,
+		HomeRegion: home,
+	}
+	if dm := b.DERPMap(); dm != nil {
+		if r := dm.Regions[home]; r != nil {
+			res.HomeRegionCode = r.RegionCode
+		}
+	}
+	return res, nil
+}
+
 // send delivers n to the connected frontend and any API watchers from
 // LocalBackend.WatchNotifications (via the LocalAPI).
 //
@@ -2757,6 +2862,7 @@ func (b *LocalBackend) send(n ipn.Notify) {
 
 // sendLocked is like send, but assumes b.mu is already held.
 func (b *LocalBackend) sendLocked(n ipn.Notify) {
+	b.statusGen.Add(1)
 	if n.Prefs != nil {
 		n.Prefs = ptr.To(stripKeysFromPrefs(*n.Prefs))
 	}
@@ -3133,6 +3239,20 @@ func (b *LocalBackend) tryLookupUserName(uid string) string {
 	return u.Username
 }
 
+// LoginInfo returns the control server this node logs in to.
+func (b *LocalBackend) LoginInfo() *apitype.LoginInfoResponse {
+	prefs := b.Prefs()
+	res := &apitype.LoginInfoResponse{
+		ControlURL: prefs.ControlURLOrDefault(),
+		AdminURL:   prefs.AdminPageURL(),
+	}
+	res.IsDefaultControlURL = ipn.IsLoginServerSynonym(res.ControlURL)
+	if v, _ := syspolicy.GetString(syspolicy.ControlURL, ""); v != "" {
+		res.ControlURLFromPolicy = true
+	}
+	return res
+}
+
 // StartLoginInteractive requests a new interactive login from controlclient,
 // unless such a flow is already in progress, in which case
 // StartLoginInteractive attempts to pick up the in-progress flow where it left
@@ -3510,6 +3630,89 @@ func (b *LocalBackend) SetUseExitNodeEnabled(v bool) (ipn.PrefsView, error) {
 	return b.editPrefsLockedOnEntry(mp, unlock)
 }
 
+// SetExitNode sets the exit node to the peer with the given Tailscale IP or
+// MagicDNS name, with or without the tailnet suffix. The peer must offer to
+// be an exit node. An empty nameOrIP clears the exit node.
+func (b *LocalBackend) SetExitNode(nameOrIP string) (ipn.PrefsView, error) {
+	mp := &ipn.MaskedPrefs{
+		ExitNodeIDSet: true,
+		ExitNodeIPSet: true,
+	}
+	if nameOrIP != "" {
+		nm := b.NetMap()
+		if nm == nil {
+			return ipn.PrefsView{}, errors.New("no netmap")
+		}
+		id, err := exitNodeByNameOrIP(nm, nameOrIP)
+		if err != nil {
+			return ipn.PrefsView{}, err
+		}
+		mp.ExitNodeID = id
+	}
+	return b.EditPrefs(mp)
+}
+
+// SetHostname sets the hostname this node reports to control, which its
+// MagicDNS name is derived from, and re-sends its Hostinfo. An empty name
+// reverts to the OS hostname. The name must be a valid DNS label.
+func (b *LocalBackend) SetHostname(name string) (ipn.PrefsView, error) {
+	if name != "" {
+		if err := dnsname.ValidLabel(name); err != nil {
+			return ipn.PrefsView{}, err
+		}
+	}
+	return b.EditPrefs(&ipn.MaskedPrefs{
+		Prefs:       ipn.Prefs{Hostname: name},
+		HostnameSet: true,
+	})
+}
+
+// ReportedHostname returns the hostname this node reports to control, and
+// whether it comes from prefs rather than the OS.
+func (b *LocalBackend) ReportedHostname() (name string, fromPrefs bool) {
+	if h := b.Prefs().Hostname(); h != "" {
+		return h, true
+	}
+	if hi := b.Hostinfo(); hi != nil {
+		return hi.Hostname, false
+	}
+	return "", false
+}
+
+// exitNodeByNameOrIP returns the stable ID of the peer in nm with the given
+// Tailscale IP or MagicDNS name. It returns an error if there's no such
+// peer, more than one, or it doesn't offer to be an exit node.
+func exitNodeByNameOrIP(nm *netmap.NetworkMap, nameOrIP string) (tailcfg.StableNodeID, error) {
+	ip, _ := netip.ParseAddr(nameOrIP)
+	name := strings.TrimSuffix(nameOrIP, ".")
+	var match tailcfg.NodeView
+	for _, p := range nm.Peers {
+		var ok bool
+		if ip.IsValid() {
+			ok = p.Addresses().ContainsFunc(func(a netip.Prefix) bool {
+				return a.IsSingleIP() && a.Addr() == ip
+			})
+		} else {
+			full := strings.TrimSuffix(p.Name(), ".")
+			ok = strings.EqualFold(name, full) || strings.EqualFold(name, dnsname.TrimSuffix(full, nm.MagicDNSSuffix()))
+		}
+		if !ok {
+			continue
+		}
+		if match.Valid() {
+			return "", fmt.Errorf("ambiguous exit node name %q matches multiple nodes", nameOrIP)
+		}
+		match = p
+	}
+	if !match.Valid() {
+		return "", fmt.Errorf("no node found in netmap with name or IP %q", nameOrIP)
+	}
+	if !tsaddr.ContainsExitRoutes(match.AllowedIPs()) {
+		return "", fmt.Errorf("node %q is not advertising an exit node", nameOrIP)
+	}
+	return match.StableID(), nil
+}
+
 // MaybeClearAppConnector clears the routes from any AppConnector if
 // AdvertiseRoutes has been set in the MaskedPrefs.
 func (b *LocalBackend) MaybeClearAppConnector(mp *ipn.MaskedPrefs) error {
@@ -3539,6 +3742,36 @@ func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
 	return b.editPrefsLockedOnEntry(mp, unlock)
 }
 
+// ResetPrefs resets the current profile's preferences to their defaults,
+// clearing the exit node, advertised routes, hostname override and so on.
+// The login is kept: the persisted node identity, control server URL,
+// profile name, operator user, and whether Tailscale is running or logged
+// out are preserved. It returns the resulting prefs.
+func (b *LocalBackend) ResetPrefs() (ipn.PrefsView, error) {
+	unlock := b.lockAndGetUnlock()
+	defer unlock()
+
+	p0 := b.pm.CurrentPrefs()
+	p1 := ipn.NewPrefs()
+	p1.ControlURL = p0.ControlURL()
+	p1.ProfileName = p0.ProfileName()
+	p1.OperatorUser = p0.OperatorUser()
+	p1.WantRunning = p0.WantRunning()
+	p1.LoggedOut = p0.LoggedOut()
+	if p0.Persist().Valid() {
+		p1.Persist = p0.Persist().AsStruct()
+	}
+	if err := b.checkPrefsLocked(p1); err != nil {
+		b.logf("ResetPrefs check error: %v", err)
+		return ipn.PrefsView{}, err
+	}
+	if p1.View().Equals(p0) {
+		return stripKeysFromPrefs(p0), nil
+	}
+	b.logf("ResetPrefs")
+	return stripKeysFromPrefs(b.setPrefsLockedOnEntry(p1, unlock)), nil
+}
+
 // Warning: b.mu must be held on entry, but it unlocks it on the way out.
 // TODO(bradfitz): redo the locking on all these weird methods like this.
 func (b *LocalBackend) editPrefsLockedOnEntry(mp *ipn.MaskedPrefs, unlock unlockOnce) (ipn.PrefsView, error) {
@@ -3976,6 +4209,7 @@ func (b *LocalBackend) authReconfig() {
 	userDialUseRoutes := nm.HasCap(tailcfg.NodeAttrUserDialUseRoutes)
 	dohURL, dohURLOK := exitNodeCanProxyDNS(nm, b.peers, prefs.ExitNodeID())
 	dcfg := dnsConfigForNetmap(nm, b.peers, prefs, b.logf, version.OS())
+	acceptedRoutes := b.acceptedRoutes
 	// If the current node is an app connector, ensure the app connector machine is started
 	b.reconfigAppConnectorLocked(nm, prefs)
 	b.mu.Unlock()
@@ -3994,7 +4228,8 @@ func (b *LocalBackend) authReconfig() {
 	}
 
 	var flags netmap.WGConfigFlags
-	if prefs.RouteAll() {
+	filterRoutes := !prefs.RouteAll() && len(acceptedRoutes) > 0
+	if prefs.RouteAll() || filterRoutes {
 		flags |= netmap.AllowSubnetRoutes
 	}
 	if hasPAC && disableSubnetsIfPAC {
@@ -4018,6 +4253,9 @@ func (b *LocalBackend) authReconfig() {
 		b.logf("wgcfg: %v", err)
 		return
 	}
+	if filterRoutes && flags&netmap.AllowSubnetRoutes != 0 {
+		filterAcceptedRoutes(cfg, nm, acceptedRoutes)
+	}
 
 	oneCGNATRoute := shouldUseOneCGNATRoute(b.logf, b.sys.ControlKnobs(), version.OS())
 	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute)
@@ -4070,6 +4308,36 @@ func shouldUseOneCGNATRoute(logf logger.Logf, controlKnobs *controlknobs.Knobs,
 	return false
 }
 
+// tailnetSearchDomainFirst is whether the tailnet's MagicDNS suffix should be
+// placed first in the OS search domains, ahead of any others.
+var tailnetSearchDomainFirst = envknob.RegisterBool("TS_DNS_TAILNET_SEARCH_DOMAIN_FIRST")
+
+// dnsServiceIPEnv, if set, is an additional IP address on which to serve
+// MagicDNS, for environments where 100.100.100.100 conflicts with an
+// existing address. The OS is pointed at it instead of 100.100.100.100.
+var dnsServiceIPEnv = envknob.RegisterString("TS_DNS_SERVICE_IP")
+
+// parsedDNSServiceIP is the parsed value of TS_DNS_SERVICE_IP.
+var parsedDNSServiceIP lazy.SyncValue[netip.Addr]
+
+// dnsServiceIP returns the parsed value of TS_DNS_SERVICE_IP, or the zero
+// value if unset or invalid. It's parsed, and logged with logf if invalid,
+// on the first call only.
+func dnsServiceIP(logf logger.Logf) netip.Addr {
+	return parsedDNSServiceIP.Get(func() netip.Addr {
+		v := dnsServiceIPEnv()
+		if v == "" {
+			return netip.Addr{}
+		}
+		ip, err := netip.ParseAddr(v)
+		if err != nil {
+			logf("ignoring invalid TS_DNS_SERVICE_IP %q: %v", v, err)
+			return netip.Addr{}
+		}
+		return ip.Unmap()
+	})
+}
+
 // dnsConfigForNetmap returns a *dns.Config for the given netmap,
 // prefs, client OS version, and cloud hosting environment.
 //
@@ -4080,8 +4348,9 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 		return nil
 	}
 	dcfg := &dns.Config{
-		Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
-		Hosts:  map[dnsname.FQDN][]netip.Addr{},
+		Routes:    map[dnsname.FQDN][]*dnstype.Resolver{},
+		Hosts:     map[dnsname.FQDN][]netip.Addr{},
+		ServiceIP: dnsServiceIP(logf),
 	}
 
 	// selfV6Only is whether we only have IPv6 addresses ourselves.
@@ -4167,6 +4436,11 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 		}
 		dcfg.SearchDomains = append(dcfg.SearchDomains, fqdn)
 	}
+	if suffix := nm.MagicDNSSuffix(); suffix != "" && tailnetSearchDomainFirst() {
+		if fqdn, err := dnsname.ToFQDN(suffix); err == nil {
+			dcfg.PrimarySearchDomain = fqdn
+		}
+	}
 	if nm.DNS.Proxied { // actually means "enable MagicDNS"
 		for _, dom := range magicDNSRootDomains(nm) {
 			dcfg.Routes[dom] = nil // resolve internally with dcfg.Hosts
@@ -4265,6 +4539,20 @@ func (b *LocalBackend) SetLogFlusher(flushFunc func()) {
 	b.logFlushFunc = flushFunc
 }
 
+// SetLogBuffer sets the on-disk buffer of log lines pending upload, which is
+// made available to LocalAPI clients by LogBuffer.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetLogBuffer(buf *filch.Filch) {
+	b.logBuffer = buf
+}
+
+// LogBuffer returns the on-disk buffer of log lines pending upload, or nil
+// if SetLogBuffer wasn't called.
+func (b *LocalBackend) LogBuffer() *filch.Filch {
+	return b.logBuffer
+}
+
 // TryFlushLogs calls the log flush function. It returns false if a log flush
 // function was never initialized with SetLogFlusher.
 //
@@ -4607,6 +4895,9 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 	if slices.ContainsFunc(rs.LocalAddrs, tsaddr.PrefixIs6) {
 		rs.Routes = append(rs.Routes, netip.PrefixFrom(tsaddr.TailscaleServiceIPv6(), 128))
 	}
+	if ip := dnsServiceIP(b.logf); ip.IsValid() {
+		rs.Routes = append(rs.Routes, netip.PrefixFrom(ip, ip.BitLen()))
+	}
 
 	return rs
 }
@@ -4773,6 +5064,14 @@ func (b *LocalBackend) hasNodeKeyLocked() bool {
 	return p.Valid() && p.Persist().Valid() && !p.Persist().PrivateNodeKey().IsZero()
 }
 
+// Hostinfo returns a copy of the Hostinfo that b most recently reported (or
+// will next report) to control. It returns nil if none has been built yet.
+func (b *LocalBackend) Hostinfo() *tailcfg.Hostinfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.hostinfo.Clone()
+}
+
 // NodeKey returns the public node key.
 func (b *LocalBackend) NodeKey() key.NodePublic {
 	b.mu.Lock()
@@ -5252,6 +5551,7 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 		b.nodeByAddr = nil
 		return
 	}
+	b.updateAddressHistoryLocked(nm.GetAddresses())
 
 	// Update the nodeByAddr index.
 	if b.nodeByAddr == nil {
@@ -6135,6 +6435,18 @@ func (b *LocalBackend) DebugReSTUN() error {
 	return nil
 }
 
+// MeasureDERPLatency forces an immediate re-measurement of DERP region
+// latencies. See magicsock.Conn.MeasureDERPLatency.
+func (b *LocalBackend) MeasureDERPLatency(ctx context.Context) (*netcheck.Report, error) {
+	return b.MagicConn().MeasureDERPLatency(ctx)
+}
+
+// Netcheck runs a netcheck now and returns its report. See
+// magicsock.Conn.Netcheck.
+func (b *LocalBackend) Netcheck(ctx context.Context, full bool) (*netcheck.Report, error) {
+	return b.MagicConn().Netcheck(ctx, full)
+}
+
 // ControlKnobs returns the node's control knobs.
 func (b *LocalBackend) ControlKnobs() *controlknobs.Knobs {
 	return b.sys.ControlKnobs()
@@ -6487,6 +6799,8 @@ func (b *LocalBackend) resetForProfileChangeLockedOnEntry(unlock unlockOnce) err
 	b.lastServeConfJSON = mem.B(nil)
 	b.serveConfig = ipn.ServeConfigView{}
 	b.lastSuggestedExitNode = ""
+	b.loadAcceptedRoutesLocked()
+	b.loadAddressHistoryLocked()
 	b.enterStateLockedOnEntry(ipn.NoState, unlock) // Reset state; releases b.mu
 	b.health.SetLocalLogConfigHealth(nil)
 	return b.Start(ipn.Options{})
@@ -6540,6 +6854,19 @@ func (b *LocalBackend) ListProfiles() []ipn.LoginProfile {
 	return b.pm.Profiles()
 }
 
+// ProfilePrefs returns the prefs of the profile with the given id, with
+// keys stripped as by Prefs. Unlike Prefs, the profile needn't be the
+// current one.
+func (b *LocalBackend) ProfilePrefs(id ipn.ProfileID) (ipn.PrefsView, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prefs, err := b.pm.ProfilePrefs(id)
+	if err != nil {
+		return ipn.PrefsView{}, err
+	}
+	return stripKeysFromPrefs(prefs), nil
+}
+
 // ResetAuth resets the authentication state, including persisted keys. Also
 // has the side effect of removing all profiles and reseting preferences. The
 // backend is left with a new profile, ready for StartLoginInterative to be
@@ -6630,10 +6957,39 @@ func (b *LocalBackend) DebugBreakTCPConns() error {
 	return breakTCPConns()
 }
 
-func (b *LocalBackend) DebugBreakDERPConns() error {
+// DebugBreakDERPConns closes all of magicsock's DERP connections, to test
+// reconnection, and returns how many were closed.
+func (b *LocalBackend) DebugBreakDERPConns() (closed int, err error) {
 	return b.MagicConn().DebugBreakDERPConns()
 }
 
+// DebugSetDERPDisabled sets whether DERP relaying is disabled, forcing
+// direct-only connectivity to peers. See magicsock.Conn.DebugSetDERPDisabled.
+func (b *LocalBackend) DebugSetDERPDisabled(disabled bool) apitype.DERPDisabledResponse {
+	regions, peers := b.MagicConn().DebugSetDERPDisabled(disabled)
+	res := apitype.DERPDisabledResponse{
+		DERPDisabled:  disabled,
+		ClosedRegions: regions,
+	}
+	if len(peers) == 0 {
+		return res
+	}
+	names := make(map[key.NodePublic]string)
+	if nm := b.NetMap(); nm != nil {
+		for _, p := range nm.Peers {
+			names[p.Key()] = p.Name()
+		}
+	}
+	for _, k := range peers {
+		name, ok := names[k]
+		if !ok || name == "" {
+			name = k.ShortString()
+		}
+		res.DroppedPeers = append(res.DroppedPeers, name)
+	}
+	return res
+}
+
 func (b *LocalBackend) pushSelfUpdateProgress(up ipnstate.UpdateProgress) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -6697,6 +7053,102 @@ func (b *LocalBackend) ObserveDNSResponse(res []byte) {
 	appConnector.ObserveDNSResponse(res)
 }
 
+// Tuning returns the engine's current tuning parameters.
+func (b *LocalBackend) Tuning() ipn.Tuning {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tuning
+}
+
+// SetTuning validates t, persists it, and applies it to the running engine.
+// The zero value of each field restores the stock behavior.
+func (b *LocalBackend) SetTuning(t ipn.Tuning) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	j, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := ipn.WriteState(b.store, ipn.TuningStateKey, j); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.tuning = t
+	b.mu.Unlock()
+	b.applyTuning(t)
+	b.logf("tuning: keepalive=%v derp-fallback=%v", t.EffectiveKeepaliveInterval(), t.EffectiveDERPFallbackAfter())
+	return nil
+}
+
+// loadTuning loads and applies the tuning parameters saved in the state
+// store, if any.
+func (b *LocalBackend) loadTuning() {
+	j, err := b.store.ReadState(ipn.TuningStateKey)
+	if err != nil {
+		return
+	}
+	var t ipn.Tuning
+	if err := json.Unmarshal(j, &t); err != nil {
+		b.logf("invalid tuning %q in StateStore: %v", j, err)
+		return
+	}
+	if err := t.Validate(); err != nil {
+		b.logf("ignoring saved tuning: %v", err)
+		return
+	}
+	b.mu.Lock()
+	b.tuning = t
+	b.mu.Unlock()
+	b.applyTuning(t)
+}
+
+func (b *LocalBackend) applyTuning(t ipn.Tuning) {
+	b.MagicConn().SetKeepaliveTuning(t.KeepaliveInterval, t.DERPFallbackAfter)
+}
+
+// DNSConfigChanges returns the recent DNS configuration changes made by the
+// DNS manager, oldest first. It returns nil if there's no DNS manager.
+func (b *LocalBackend) DNSConfigChanges() []dns.ConfigChange {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return nil
+	}
+	return dm.ConfigChanges()
+}
+
+// DNSAppliedConfig returns the OS and resolver DNS configurations currently
+// applied by the DNS manager. ok is false if there's no DNS manager or no
+// configuration is applied.
+func (b *LocalBackend) DNSAppliedConfig() (ocfg dns.OSConfig, rcfg resolver.Config, ok bool) {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return ocfg, rcfg, false
+	}
+	return dm.AppliedConfig()
+}
+
+// DNSSplitActive reports whether the applied OS DNS configuration uses
+// split DNS. See dns.Manager.SplitDNSActive.
+func (b *LocalBackend) DNSSplitActive() bool {
+	dm, ok := b.sys.DNSManager.GetOK()
+	return ok && dm.SplitDNSActive()
+}
+
+// AddDNSQueryObserver registers fn to be called with each DNS query
+// answered by the MagicDNS resolver. It fails unless query logging is
+// enabled with TS_DEBUG_DNS_QUERY_LOG. See resolver.Resolver.AddQueryObserver.
+func (b *LocalBackend) AddDNSQueryObserver(fn func(resolver.QueryEvent)) (remove func(), err error) {
+	if !resolver.QueryLogEnabled() {
+		return nil, errors.New("DNS query logging is disabled; set TS_DEBUG_DNS_QUERY_LOG=1 in tailscaled's environment to enable it")
+	}
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return nil, errors.New("no DNS manager")
+	}
+	return dm.Resolver().AddQueryObserver(fn), nil
+}
+
 // ErrDisallowedAutoRoute is returned by AdvertiseRoute when a route that is not allowed is requested.
 var ErrDisallowedAutoRoute = errors.New("route is not allowed")
 
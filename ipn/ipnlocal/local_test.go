@@ -24,6 +24,7 @@
 	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/appc"
 	"tailscale.com/appc/appctest"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/clientupdate"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/drive"
@@ -34,8 +35,10 @@
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netmon"
+	"tailscale.com/net/packet"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
@@ -502,6 +505,45 @@ func TestZeroExitNodeViaLocalAPI(t *testing.T) {
 
 }
 
+func TestResetPrefs(t *testing.T) {
+	lb := newTestLocalBackend(t)
+
+	if _, err := lb.EditPrefs(&ipn.MaskedPrefs{
+		ExitNodeIDSet:      true,
+		HostnameSet:        true,
+		AdvertiseRoutesSet: true,
+		ControlURLSet:      true,
+		Prefs: ipn.Prefs{
+			ExitNodeID:      "foo",
+			Hostname:        "custom",
+			AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+			ControlURL:      "https://control.example.com",
+		},
+	}); err != nil {
+		t.Fatalf("EditPrefs: %v", err)
+	}
+
+	pv, err := lb.ResetPrefs()
+	if err != nil {
+		t.Fatalf("ResetPrefs: %v", err)
+	}
+	if got := pv.ExitNodeID(); got != "" {
+		t.Errorf("ExitNodeID = %q; want empty", got)
+	}
+	if got := pv.Hostname(); got != "" {
+		t.Errorf("Hostname = %q; want empty", got)
+	}
+	if got := pv.AdvertiseRoutes().Len(); got != 0 {
+		t.Errorf("got %d AdvertiseRoutes; want 0", got)
+	}
+	if got, want := pv.ControlURL(), "https://control.example.com"; got != want {
+		t.Errorf("ControlURL = %q; want %q", got, want)
+	}
+	if !pv.CorpDNS() || !pv.RouteAll() {
+		t.Errorf("CorpDNS = %v, RouteAll = %v; want defaults (true)", pv.CorpDNS(), pv.RouteAll())
+	}
+}
+
 func TestSetUseExitNodeEnabled(t *testing.T) {
 	lb := newTestLocalBackend(t)
 
@@ -3998,3 +4040,357 @@ func TestFillAllowedSuggestions(t *testing.T) {
 		})
 	}
 }
+
+func TestExitNodeByNameOrIP(t *testing.T) {
+	exitRoutes := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+	peer := func(id tailcfg.StableNodeID, name, ip string, exit bool) tailcfg.NodeView {
+		addrs := []netip.Prefix{netip.MustParsePrefix(ip + "/32")}
+		allowed := slices.Clone(addrs)
+		if exit {
+			allowed = append(allowed, exitRoutes...)
+		}
+		return (&tailcfg.Node{
+			StableID:   id,
+			Name:       name,
+			Addresses:  addrs,
+			AllowedIPs: allowed,
+		}).View()
+	}
+	nm := &netmap.NetworkMap{
+		Name: "self.tail-scale.ts.net.",
+		Peers: []tailcfg.NodeView{
+			peer("exit1", "exit1.tail-scale.ts.net.", "100.64.0.1", true),
+			peer("plain", "plain.tail-scale.ts.net.", "100.64.0.2", false),
+			peer("dup1", "dup.tail-scale.ts.net.", "100.64.0.3", true),
+			peer("dup2", "dup.tail-scale.ts.net.", "100.64.0.4", true),
+		},
+	}
+	tests := []struct {
+		arg     string
+		want    tailcfg.StableNodeID
+		wantErr bool
+	}{
+		{arg: "exit1", want: "exit1"},
+		{arg: "EXIT1", want: "exit1"},
+		{arg: "exit1.tail-scale.ts.net", want: "exit1"},
+		{arg: "exit1.tail-scale.ts.net.", want: "exit1"},
+		{arg: "100.64.0.1", want: "exit1"},
+		{arg: "plain", wantErr: true},
+		{arg: "100.64.0.2", wantErr: true},
+		{arg: "dup", wantErr: true},
+		{arg: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := exitNodeByNameOrIP(nm, tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("exitNodeByNameOrIP(%q) error = %v; wantErr %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("exitNodeByNameOrIP(%q) = %q; want %q", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestLoginInfo(t *testing.T) {
+	b := newTestLocalBackend(t)
+	b.SetPrefsForTest(&ipn.Prefs{ControlURL: ipn.DefaultControlURL})
+	got := b.LoginInfo()
+	if !got.IsDefaultControlURL {
+		t.Errorf("IsDefaultControlURL = false for %q", got.ControlURL)
+	}
+
+	b.SetPrefsForTest(&ipn.Prefs{ControlURL: "https://login.tailscale.com"})
+	if got := b.LoginInfo(); !got.IsDefaultControlURL {
+		t.Errorf("IsDefaultControlURL = false for synonym %q", got.ControlURL)
+	}
+
+	b.SetPrefsForTest(&ipn.Prefs{ControlURL: "https://headscale.example.com"})
+	got = b.LoginInfo()
+	if got.IsDefaultControlURL || got.ControlURL != "https://headscale.example.com" {
+		t.Errorf("got ControlURL %q, IsDefaultControlURL %v; want custom", got.ControlURL, got.IsDefaultControlURL)
+	}
+}
+
+func TestNotices(t *testing.T) {
+	b := newTestLocalBackend(t)
+	if got := b.Notices(); got != nil {
+		t.Fatalf("Notices with no netmap = %v; want nil", got)
+	}
+	setHealth := func(msgs ...string) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.netMap = &netmap.NetworkMap{ControlHealth: msgs}
+	}
+	setHealth("first", "second")
+	got := b.Notices()
+	if len(got) != 2 || got[0].Text != "first" || got[0].ID != noticeID("first") {
+		t.Fatalf("Notices = %v; want first and second", got)
+	}
+	first, second := got[0].ID, got[1].ID
+
+	if err := b.AckNotice(""); err == nil {
+		t.Error("AckNotice with empty ID succeeded; want error")
+	}
+	for range 2 { // acknowledging twice is a no-op
+		if err := b.AckNotice(first); err != nil {
+			t.Fatalf("AckNotice: %v", err)
+		}
+	}
+	if got := b.Notices(); len(got) != 1 || got[0].ID != second {
+		t.Errorf("Notices after ack = %v; want only second", got)
+	}
+
+	// Acknowledgements are per profile.
+	profileID := b.pm.CurrentProfile().ID
+	j, err := b.store.ReadState(ipn.AckedNoticesKey(profileID))
+	if err != nil {
+		t.Fatalf("reading acked notices: %v", err)
+	}
+	if want := `["` + first + `"]`; string(j) != want {
+		t.Errorf("persisted acked notices = %s; want %s", j, want)
+	}
+	if got := b.ackedNotices("other-profile"); got != nil {
+		t.Errorf("acked notices of another profile = %v; want none", got)
+	}
+
+	// Once control stops sending a notice, its acknowledgement is pruned
+	// by the next one, so it's shown again if it comes back.
+	setHealth("second")
+	if err := b.AckNotice(second); err != nil {
+		t.Fatalf("AckNotice: %v", err)
+	}
+	if got := b.ackedNotices(profileID); !slices.Equal(got, []string{second}) {
+		t.Errorf("acked notices = %v; want only second", got)
+	}
+	setHealth("first", "second")
+	if got := b.Notices(); len(got) != 1 || got[0].ID != first {
+		t.Errorf("Notices after first came back = %v; want only first", got)
+	}
+}
+
+func TestAcceptedRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	self := pfx("100.64.0.1/32")
+	peer := &tailcfg.Node{
+		ID:         1,
+		Key:        key.NewNode().Public(),
+		Addresses:  []netip.Prefix{self},
+		AllowedIPs: []netip.Prefix{self, pfx("10.0.0.0/24"), pfx("10.1.0.0/16"), pfx("0.0.0.0/0")},
+	}
+	nm := &netmap.NetworkMap{Peers: []tailcfg.NodeView{peer.View()}}
+
+	b := newTestLocalBackend(t)
+	b.netMap = nm
+	if err := b.SetAcceptedRoutes(nil); err == nil {
+		t.Error("SetAcceptedRoutes without a profile succeeded; want error")
+	}
+	b.pm.currentProfile = &ipn.LoginProfile{ID: "id1"}
+	got := b.AcceptedRoutes()
+	if want := []netip.Prefix{pfx("10.1.0.0/16"), pfx("10.0.0.0/24")}; !slices.Equal(got.Available, want) {
+		t.Errorf("Available = %v; want %v", got.Available, want)
+	}
+	if len(got.Accepted) != 0 {
+		t.Errorf("Accepted = %v; want none", got.Accepted)
+	}
+
+	if err := b.SetAcceptedRoutes([]netip.Prefix{pfx("0.0.0.0/0")}); err == nil {
+		t.Error("SetAcceptedRoutes with a default route succeeded; want error")
+	}
+	if err := b.SetAcceptedRoutes([]netip.Prefix{pfx("10.1.2.3/16"), pfx("10.1.0.0/16")}); err != nil {
+		t.Fatalf("SetAcceptedRoutes: %v", err)
+	}
+	accepted := []netip.Prefix{pfx("10.1.0.0/16")}
+	if got := b.AcceptedRoutes().Accepted; !slices.Equal(got, accepted) {
+		t.Errorf("Accepted = %v; want %v", got, accepted)
+	}
+	j, err := b.store.ReadState(ipn.AcceptedRoutesKey("id1"))
+	if err != nil {
+		t.Fatalf("reading accepted routes: %v", err)
+	}
+	if want := `["10.1.0.0/16"]`; string(j) != want {
+		t.Errorf("persisted accepted routes = %s; want %s", j, want)
+	}
+
+	// The selection belongs to the profile it was made in.
+	b.mu.Lock()
+	b.pm.currentProfile = &ipn.LoginProfile{ID: "id2"}
+	b.loadAcceptedRoutesLocked()
+	b.mu.Unlock()
+	if got := b.AcceptedRoutes().Accepted; len(got) != 0 {
+		t.Errorf("Accepted in another profile = %v; want none", got)
+	}
+	b.mu.Lock()
+	b.pm.currentProfile = &ipn.LoginProfile{ID: "id1"}
+	b.loadAcceptedRoutesLocked()
+	b.mu.Unlock()
+	if got := b.AcceptedRoutes().Accepted; !slices.Equal(got, accepted) {
+		t.Errorf("Accepted after switching back = %v; want %v", got, accepted)
+	}
+
+	cfg := &wgcfg.Config{Peers: []wgcfg.Peer{{PublicKey: peer.Key, AllowedIPs: slices.Clone(peer.AllowedIPs)}}}
+	filterAcceptedRoutes(cfg, nm, accepted)
+	if want := []netip.Prefix{self, pfx("10.1.0.0/16"), pfx("0.0.0.0/0")}; !slices.Equal(cfg.Peers[0].AllowedIPs, want) {
+		t.Errorf("filtered AllowedIPs = %v; want %v", cfg.Peers[0].AllowedIPs, want)
+	}
+}
+
+func TestAddressHistory(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	v4, v6, newV4 := pfx("100.64.0.1/32"), pfx("fd7a:115c:a1e0::1/128"), pfx("100.64.0.2/32")
+	netmapWith := func(addrs ...netip.Prefix) *netmap.NetworkMap {
+		return &netmap.NetworkMap{SelfNode: (&tailcfg.Node{Addresses: addrs}).View()}
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := tstest.NewClock(tstest.ClockOpts{Start: start})
+
+	b := newTestLocalBackend(t)
+	b.clock = clock
+	b.pm.currentProfile = &ipn.LoginProfile{ID: "id1"}
+	b.mu.Lock()
+	b.setNetMapLocked(netmapWith(v6, v4))
+	b.mu.Unlock()
+	got := b.Addresses()
+	if want := []netip.Prefix{v4, v6}; !slices.Equal(got.Current, want) {
+		t.Errorf("Current = %v; want %v", got.Current, want)
+	}
+	if len(got.Previous) != 0 {
+		t.Errorf("Previous = %v; want none", got.Previous)
+	}
+
+	clock.Advance(time.Hour)
+	b.mu.Lock()
+	b.setNetMapLocked(netmapWith(newV4, v6))
+	b.mu.Unlock()
+	got = b.Addresses()
+	if want := []netip.Prefix{newV4, v6}; !slices.Equal(got.Current, want) {
+		t.Errorf("Current = %v; want %v", got.Current, want)
+	}
+	wantPrev := []apitype.PastAddress{{Addr: v4, Assigned: start, Unassigned: start.Add(time.Hour)}}
+	if !reflect.DeepEqual(got.Previous, wantPrev) {
+		t.Errorf("Previous = %+v; want %+v", got.Previous, wantPrev)
+	}
+
+	// The history survives a restart, once saved.
+	b.saveAddressHistory()
+	b2 := newTestLocalBackend(t)
+	b2.store = b.store
+	b2.mu.Lock()
+	b2.pm.currentProfile = &ipn.LoginProfile{ID: "id1"}
+	b2.loadAddressHistoryLocked()
+	gotHist := b2.addrHistory
+	b2.mu.Unlock()
+	if !reflect.DeepEqual(gotHist.Previous, wantPrev) || len(gotHist.Current) != 2 {
+		t.Errorf("loaded history = %+v; want Previous %+v and 2 current addresses", gotHist, wantPrev)
+	}
+
+	// Other profiles have their own history.
+	b2.mu.Lock()
+	b2.pm.currentProfile = &ipn.LoginProfile{ID: "id2"}
+	b2.loadAddressHistoryLocked()
+	gotHist = b2.addrHistory
+	b2.mu.Unlock()
+	if len(gotHist.Previous) != 0 || len(gotHist.Current) != 0 {
+		t.Errorf("history of another profile = %+v; want empty", gotHist)
+	}
+
+	// Only the most recent previous addresses are kept.
+	for i := range maxPreviousAddresses + 5 {
+		clock.Advance(time.Minute)
+		b.mu.Lock()
+		b.setNetMapLocked(netmapWith(netip.PrefixFrom(netip.AddrFrom4([4]byte{100, 64, 1, byte(i)}), 32)))
+		b.mu.Unlock()
+	}
+	if got := len(b.Addresses().Previous); got != maxPreviousAddresses {
+		t.Errorf("len(Previous) = %d; want %d", got, maxPreviousAddresses)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	b := newTestLocalBackend(t)
+	tw := b.sys.Tun.Get()
+	tw.SetFilter(filter.NewAllowAllForTest(t.Logf))
+
+	self := netip.MustParsePrefix("100.64.0.1/32")
+	prefs := &ipn.Prefs{
+		AdvertiseRoutes: []netip.Prefix{tsaddr.AllIPv4(), tsaddr.AllIPv6()},
+	}
+	if err := b.pm.SetPrefs(prefs.View(), ipn.NetworkProfile{}); err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	b.netMap = &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+		Addresses:  []netip.Prefix{self},
+		AllowedIPs: []netip.Prefix{self, tsaddr.AllIPv4(), tsaddr.AllIPv6()},
+	}).View()}
+	b.mu.Unlock()
+
+	if got := b.Roles(); !got.ExitNode || got.ExitNodeUsers != 0 {
+		t.Fatalf("before any traffic, Roles = %+v; want exit node with no users", got)
+	}
+
+	// Roles turned flow tracking on, so traffic from now on is counted.
+	pkt := packet.Generate(&packet.UDP4Header{
+		IP4Header: packet.IP4Header{
+			Src: netip.MustParseAddr("100.64.0.2"),
+			Dst: netip.MustParseAddr("8.8.8.8"),
+		},
+		SrcPort: 5000,
+		DstPort: 53,
+	}, []byte("query"))
+	if _, err := tw.Write([][]byte{pkt}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Roles().ExitNodeUsers; got != 1 {
+		t.Errorf("ExitNodeUsers = %d; want 1", got)
+	}
+}
+
+func TestRolesFor(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	ap := netip.MustParseAddrPort
+	self := pfx("100.64.0.1/32")
+	lan, pendingLAN := pfx("192.168.1.0/24"), pfx("10.0.0.0/8")
+
+	prefs := &ipn.Prefs{
+		AdvertiseRoutes: []netip.Prefix{lan, pendingLAN, tsaddr.AllIPv4(), tsaddr.AllIPv6()},
+		AppConnector:    ipn.AppConnectorPrefs{Advertise: true},
+	}
+	nm := &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+		Addresses:  []netip.Prefix{self},
+		AllowedIPs: []netip.Prefix{self, lan, tsaddr.AllIPv4(), tsaddr.AllIPv6()},
+	}).View()}
+	flows := []tstun.Flow{
+		{Local: ap("192.168.1.10:22"), Remote: ap("100.64.0.2:5000")},        // subnet
+		{Local: ap("192.168.1.11:22"), Remote: ap("100.64.0.2:5001")},        // same peer again
+		{Local: ap("1.1.1.1:443"), Remote: ap("100.64.0.3:5000")},            // exit
+		{Local: ap("8.8.8.8:53"), Remote: ap("100.64.0.4:5000")},             // exit
+		{Local: ap("100.64.0.1:22"), Remote: ap("100.64.0.5:5000")},          // to this node
+		{Local: ap("[fd7a:115c:a1e0::1]:22"), Remote: ap("100.64.0.6:5000")}, // tailnet
+	}
+
+	got := rolesFor(prefs.View(), nm, flows)
+	want := &apitype.RolesResponse{
+		ExitNode:            true,
+		ExitNodeUsers:       2,
+		SubnetRoutes:        []netip.Prefix{lan},
+		PendingSubnetRoutes: []netip.Prefix{pendingLAN},
+		SubnetRouterUsers:   1,
+		AppConnector:        true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rolesFor = %+v; want %+v", got, want)
+	}
+
+	// Without a netmap nothing is approved, so nobody is counted.
+	got = rolesFor(prefs.View(), nil, flows)
+	want = &apitype.RolesResponse{
+		ExitNodePending:     true,
+		SubnetRoutes:        []netip.Prefix{},
+		PendingSubnetRoutes: []netip.Prefix{lan, pendingLAN},
+		AppConnector:        true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rolesFor without netmap = %+v; want %+v", got, want)
+	}
+}
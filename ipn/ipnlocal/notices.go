@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+)
+
+// noticeID returns the ID of the notice with the given text.
+func noticeID(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// currentNotices returns the messages control currently has for the user,
+// which are the health problems it reports in the netmap, and the profile
+// they're for.
+func (b *LocalBackend) currentNotices() ([]apitype.Notice, ipn.ProfileID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	profileID := b.pm.CurrentProfile().ID
+	if b.netMap == nil {
+		return nil, profileID
+	}
+	var ret []apitype.Notice
+	for _, text := range b.netMap.ControlHealth {
+		ret = append(ret, apitype.Notice{ID: noticeID(text), Text: text})
+	}
+	return ret, profileID
+}
+
+// Notices returns the messages from the control plane for the user that the
+// user hasn't acknowledged yet while using the current profile.
+func (b *LocalBackend) Notices() []apitype.Notice {
+	all, profileID := b.currentNotices()
+	if len(all) == 0 {
+		return nil
+	}
+	acked := b.ackedNotices(profileID)
+	var ret []apitype.Notice
+	for _, n := range all {
+		if !slices.Contains(acked, n.ID) {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
+
+// AckNotice records that the user has acknowledged the notice with the
+// given ID, so that Notices no longer returns it for the current profile.
+// The acknowledgement is persisted in the state store and survives
+// restarts. Acknowledgements of notices control no longer sends are
+// forgotten, so a message that goes away and comes back is shown again, and
+// acknowledging a notice control isn't sending does nothing.
+func (b *LocalBackend) AckNotice(id string) error {
+	if id == "" {
+		return errors.New("missing notice ID")
+	}
+	b.ackNoticesMu.Lock()
+	defer b.ackNoticesMu.Unlock()
+	all, profileID := b.currentNotices()
+	if !slices.ContainsFunc(all, func(n apitype.Notice) bool { return n.ID == id }) {
+		return nil // not shown anyway
+	}
+	acked := b.ackedNotices(profileID)
+	keep := []string{id}
+	for _, n := range all {
+		if n.ID != id && slices.Contains(acked, n.ID) {
+			keep = append(keep, n.ID)
+		}
+	}
+	j, err := json.Marshal(keep)
+	if err != nil {
+		return err
+	}
+	if err := ipn.WriteState(b.store, ipn.AckedNoticesKey(profileID), j); err != nil {
+		return fmt.Errorf("saving acknowledged notices: %w", err)
+	}
+	return nil
+}
+
+// ackedNotices returns the IDs of the notices the user has acknowledged
+// while using the given profile.
+func (b *LocalBackend) ackedNotices(profileID ipn.ProfileID) []string {
+	j, err := b.store.ReadState(ipn.AckedNoticesKey(profileID))
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	if err := json.Unmarshal(j, &ids); err != nil {
+		b.logf("invalid acknowledged notices %q in StateStore: %v", j, err)
+		return nil
+	}
+	return ids
+}
@@ -314,6 +314,20 @@ func (pm *profileManager) Profiles() []ipn.LoginProfile {
 	return out
 }
 
+// ProfilePrefs returns the prefs of the profile with the given id, which
+// needn't be the current one. If the profile is not known, it returns
+// errProfileNotFound.
+func (pm *profileManager) ProfilePrefs(id ipn.ProfileID) (ipn.PrefsView, error) {
+	if pm.currentProfile != nil && id == pm.currentProfile.ID && pm.prefs.Valid() {
+		return pm.prefs, nil
+	}
+	kp, ok := pm.knownProfiles[id]
+	if !ok || kp.LocalUserID != pm.currentUserID {
+		return ipn.PrefsView{}, errProfileNotFound
+	}
+	return pm.loadSavedPrefs(kp.Key)
+}
+
 // SwitchProfile switches to the profile with the given id.
 // If the profile is not known, it returns an errProfileNotFound.
 func (pm *profileManager) SwitchProfile(id ipn.ProfileID) error {
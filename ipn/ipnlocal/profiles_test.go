@@ -140,6 +140,59 @@ func TestProfileList(t *testing.T) {
 	checkProfiles(t, "carol")
 }
 
+func TestProfilePrefs(t *testing.T) {
+	store := new(mem.Store)
+	pm, err := newProfileManagerWithGOOS(store, logger.Discard, new(health.Tracker), "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProfile := func(loginName string, shieldsUp bool) ipn.ProfileID {
+		t.Helper()
+		pm.NewProfile()
+		p := pm.CurrentPrefs().AsStruct()
+		p.ShieldsUp = shieldsUp
+		p.Persist = &persist.Persist{
+			NodeID: tailcfg.StableNodeID(loginName),
+			UserProfile: tailcfg.UserProfile{
+				ID:        tailcfg.UserID(len(loginName)),
+				LoginName: loginName,
+			},
+		}
+		if err := pm.SetPrefs(p.View(), ipn.NetworkProfile{}); err != nil {
+			t.Fatal(err)
+		}
+		return pm.CurrentProfile().ID
+	}
+	alice := newProfile("alice", true)
+	bob := newProfile("bob", false)
+
+	for _, tt := range []struct {
+		id        ipn.ProfileID
+		wantLogin string
+		wantSU    bool
+	}{
+		{alice, "alice", true}, // saved profile
+		{bob, "bob", false},    // current profile
+	} {
+		prefs, err := pm.ProfilePrefs(tt.id)
+		if err != nil {
+			t.Fatalf("ProfilePrefs(%q): %v", tt.id, err)
+		}
+		if got := prefs.Persist().UserProfile().LoginName; got != tt.wantLogin {
+			t.Errorf("ProfilePrefs(%q) login = %q; want %q", tt.id, got, tt.wantLogin)
+		}
+		if got := prefs.ShieldsUp(); got != tt.wantSU {
+			t.Errorf("ProfilePrefs(%q) ShieldsUp = %v; want %v", tt.id, got, tt.wantSU)
+		}
+	}
+	if pm.CurrentProfile().ID != bob {
+		t.Errorf("ProfilePrefs switched the current profile")
+	}
+	if _, err := pm.ProfilePrefs("unknown"); err != errProfileNotFound {
+		t.Errorf("ProfilePrefs(unknown) error = %v; want %v", err, errProfileNotFound)
+	}
+}
+
 func TestProfileDupe(t *testing.T) {
 	newPersist := func(user, node int) *persist.Persist {
 		return &persist.Persist{
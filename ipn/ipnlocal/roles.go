@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/net/tstun"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/views"
+)
+
+// Roles reports whether the node is an exit node, subnet router or app
+// connector and, for the first two, how many peers are using it.
+//
+// Users are counted from the flows the tun device tracks, which Roles
+// keeps on like ActiveConnections does. Traffic from before tracking was
+// last turned on isn't counted, so the first call reports no users.
+func (b *LocalBackend) Roles() *apitype.RolesResponse {
+	b.mu.Lock()
+	prefs := b.pm.CurrentPrefs()
+	nm := b.netMap
+	b.mu.Unlock()
+	var flows []tstun.Flow
+	if tw, ok := b.trackFlows(); ok {
+		flows = tw.ActiveFlows()
+	}
+	return rolesFor(prefs, nm, flows)
+}
+
+// rolesFor computes the node's roles from the routes advertised in prefs,
+// those approved in nm (which may be nil), and the recently active flows.
+func rolesFor(prefs ipn.PrefsView, nm *netmap.NetworkMap, flows []tstun.Flow) *apitype.RolesResponse {
+	res := &apitype.RolesResponse{
+		SubnetRoutes: []netip.Prefix{},
+		AppConnector: prefs.AppConnector().Advertise,
+	}
+	var approved, self views.Slice[netip.Prefix]
+	if nm != nil && nm.SelfNode.Valid() {
+		approved = nm.SelfNode.AllowedIPs()
+		self = nm.SelfNode.Addresses()
+	}
+	advertised := prefs.AdvertiseRoutes()
+	if tsaddr.ContainsExitRoutes(advertised) {
+		if tsaddr.ContainsExitRoutes(approved) {
+			res.ExitNode = true
+		} else {
+			res.ExitNodePending = true
+		}
+	}
+	for _, r := range advertised.All() {
+		switch {
+		case r.Bits() == 0:
+		case views.SliceContains(approved, r):
+			res.SubnetRoutes = append(res.SubnetRoutes, r)
+		default:
+			res.PendingSubnetRoutes = append(res.PendingSubnetRoutes, r)
+		}
+	}
+
+	// Forwarded traffic is seen from the node as a flow between a peer
+	// (remote) and an address that's neither the node's own nor in the
+	// tailnet (local).
+	exitUsers := map[netip.Addr]bool{}
+	subnetUsers := map[netip.Addr]bool{}
+	for _, f := range flows {
+		peer, dst := f.Remote.Addr(), f.Local.Addr()
+		if !tsaddr.IsTailscaleIP(peer) || tsaddr.IsTailscaleIP(dst) {
+			continue
+		}
+		if slices.ContainsFunc(self.AsSlice(), func(p netip.Prefix) bool { return p.Addr() == dst }) {
+			continue
+		}
+		if slices.ContainsFunc(res.SubnetRoutes, func(p netip.Prefix) bool { return p.Contains(dst) }) {
+			subnetUsers[peer] = true
+		} else if res.ExitNode {
+			exitUsers[peer] = true
+		}
+	}
+	res.ExitNodeUsers = len(exitUsers)
+	res.SubnetRouterUsers = len(subnetUsers)
+	return res
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/netcheck"
+)
+
+// setupChecksNetcheckTimeout bounds the netcheck run by SetupChecks if
+// magicsock hasn't run one yet.
+const setupChecksNetcheckTimeout = 5 * time.Second
+
+// SetupChecks runs the host prerequisite checks relevant to this node's
+// role and reports whether each passed, with a suggested fix for those that
+// didn't. Nodes that advertise subnet routes or an exit node are checked for
+// IP forwarding and UDP GRO forwarding; all nodes are checked for working
+// UDP, per magicsock's most recent netcheck.
+func (b *LocalBackend) SetupChecks(ctx context.Context) *apitype.SetupChecksResponse {
+	res := &apitype.SetupChecksResponse{
+		Router: b.Prefs().AdvertiseRoutes().Len() > 0,
+	}
+	if res.Router {
+		res.Checks = append(res.Checks,
+			setupCheckFromErr("ip-forwarding", b.CheckIPForwarding(), "IP forwarding is enabled"),
+			setupCheckFromErr("udp-gro-forwarding", b.CheckUDPGROForwarding(), "UDP GRO forwarding is optimally configured"),
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, setupChecksNetcheckTimeout)
+	defer cancel()
+	report := b.MagicConn().GetLastNetcheckReport(ctx)
+	res.Checks = append(res.Checks, udpCheck(b.MagicConn().LocalPort(), report))
+	return res
+}
+
+// setupCheckFromErr returns the result of the check name whose error was
+// err, using okMsg as the message if it passed. A "See <link>" line in err
+// becomes the check's fix.
+func setupCheckFromErr(name string, err error, okMsg string) apitype.SetupCheck {
+	if err == nil {
+		return apitype.SetupCheck{Name: name, Pass: true, Message: okMsg}
+	}
+	msg, rest, _ := strings.Cut(err.Error(), "\n")
+	return apitype.SetupCheck{
+		Name:    name,
+		Message: msg,
+		Fix:     strings.TrimSpace(rest),
+	}
+}
+
+// udpCheck returns the result of checking that WireGuard traffic can use
+// UDP, given the local UDP port and the most recent netcheck report, if
+// any. The netcheck only shows whether UDP to the STUN servers and back
+// works; whether inbound UDP is let through isn't checked.
+func udpCheck(port uint16, report *netcheck.Report) apitype.SetupCheck {
+	c := apitype.SetupCheck{Name: "udp"}
+	switch {
+	case port == 0:
+		c.Message = "not listening on a UDP port"
+		c.Fix = "check the tailscaled logs for errors binding its UDP socket"
+	case report == nil:
+		c.Message = "no netcheck report is available yet"
+		c.Fix = `run "tailscale netcheck"`
+	case !report.UDP:
+		c.Message = fmt.Sprintf("UDP from port %d appears blocked; connections will be relayed via DERP", port)
+		c.Fix = "allow outbound UDP through the host and network firewalls"
+	default:
+		c.Pass = true
+		c.Message = fmt.Sprintf("UDP from port %d works", port)
+		if report.GlobalV4.IsValid() {
+			c.Message += fmt.Sprintf(" (public address %v)", report.GlobalV4)
+		}
+	}
+	return c
+}
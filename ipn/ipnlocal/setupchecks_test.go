@@ -0,0 +1,83 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"tailscale.com/net/netcheck"
+)
+
+func TestSetupCheckFromErr(t *testing.T) {
+	c := setupCheckFromErr("ip-forwarding", nil, "ok")
+	if !c.Pass || c.Message != "ok" || c.Fix != "" {
+		t.Errorf("passing check = %+v", c)
+	}
+
+	c = setupCheckFromErr("ip-forwarding", errors.New("IPv4 forwarding is disabled.\nSee https://tailscale.com/s/ip-forwarding"), "ok")
+	if c.Pass {
+		t.Error("failing check passed")
+	}
+	if c.Message != "IPv4 forwarding is disabled." {
+		t.Errorf("Message = %q", c.Message)
+	}
+	if c.Fix != "See https://tailscale.com/s/ip-forwarding" {
+		t.Errorf("Fix = %q", c.Fix)
+	}
+}
+
+func TestUDPCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     uint16
+		report   *netcheck.Report
+		wantPass bool
+		wantFix  bool
+		wantMsg  string
+	}{
+		{
+			name:    "not-listening",
+			report:  &netcheck.Report{UDP: true},
+			wantFix: true,
+			wantMsg: "not listening",
+		},
+		{
+			name:    "no-report",
+			port:    41641,
+			wantFix: true,
+			wantMsg: "no netcheck report",
+		},
+		{
+			name:    "blocked",
+			port:    41641,
+			report:  &netcheck.Report{},
+			wantFix: true,
+			wantMsg: "blocked",
+		},
+		{
+			name:     "works",
+			port:     41641,
+			report:   &netcheck.Report{UDP: true, GlobalV4: netip.MustParseAddrPort("1.2.3.4:41641")},
+			wantPass: true,
+			wantMsg:  "public address 1.2.3.4:41641",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := udpCheck(tt.port, tt.report)
+			if c.Pass != tt.wantPass {
+				t.Errorf("Pass = %v; want %v", c.Pass, tt.wantPass)
+			}
+			if (c.Fix != "") != tt.wantFix {
+				t.Errorf("Fix = %q; want fix %v", c.Fix, tt.wantFix)
+			}
+			if !strings.Contains(c.Message, tt.wantMsg) {
+				t.Errorf("Message = %q; want it to contain %q", c.Message, tt.wantMsg)
+			}
+		})
+	}
+}
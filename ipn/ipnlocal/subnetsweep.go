@@ -0,0 +1,108 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/netns"
+	"tailscale.com/net/ping"
+)
+
+const (
+	// maxSweepSubnetBits is the most host bits a prefix passed to
+	// DebugSweepSubnet may have; a /24 for IPv4.
+	maxSweepSubnetBits = 8
+
+	// sweepSubnetPingTimeout is how long DebugSweepSubnet waits for each
+	// host to reply.
+	sweepSubnetPingTimeout = 2 * time.Second
+
+	// maxConcurrentSweepPings is the most pings DebugSweepSubnet has in
+	// flight at once.
+	maxConcurrentSweepPings = 32
+)
+
+// DebugSweepSubnet sends an ICMP echo request from this node to every host
+// address in cidr, which must be within one of the subnet routes it
+// advertises, and reports which hosts replied. It's used to check that a
+// subnet router can actually reach the hosts behind it.
+func (b *LocalBackend) DebugSweepSubnet(ctx context.Context, cidr netip.Prefix) (*apitype.SubnetSweepResponse, error) {
+	cidr = cidr.Masked()
+	if hostBits := cidr.Addr().BitLen() - cidr.Bits(); hostBits > maxSweepSubnetBits {
+		return nil, fmt.Errorf("prefix %v too large; at most %d host bits allowed", cidr, maxSweepSubnetBits)
+	}
+	advertised := b.Prefs().AdvertiseRoutes()
+	var routed bool
+	for i := range advertised.Len() {
+		r := advertised.At(i)
+		if r.Bits() > 0 && r.Bits() <= cidr.Bits() && r.Contains(cidr.Addr()) {
+			routed = true
+			break
+		}
+	}
+	if !routed {
+		return nil, fmt.Errorf("%v is not within an advertised subnet route", cidr)
+	}
+
+	p := ping.New(ctx, b.logf, netns.Listener(b.logf, b.NetMon()))
+	defer p.Close()
+
+	addrs := sweepAddrs(cidr)
+	res := &apitype.SubnetSweepResponse{
+		CIDR:   cidr.String(),
+		Probed: len(addrs),
+	}
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, maxConcurrentSweepPings)
+	)
+	for _, ip := range addrs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(ctx, sweepSubnetPingTimeout)
+			defer cancel()
+			d, err := p.Send(ctx, &net.IPAddr{IP: ip.AsSlice()}, nil)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			res.Alive = append(res.Alive, apitype.SubnetSweepHost{Addr: ip, Latency: d})
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(res.Alive, func(a, b apitype.SubnetSweepHost) int {
+		return a.Addr.Compare(b.Addr)
+	})
+	return res, nil
+}
+
+// sweepAddrs returns the host addresses in the masked prefix cidr. For IPv4
+// prefixes shorter than /31, the network and broadcast addresses are
+// omitted.
+func sweepAddrs(cidr netip.Prefix) []netip.Addr {
+	var addrs []netip.Addr
+	for ip := cidr.Addr(); ip.IsValid() && cidr.Contains(ip); ip = ip.Next() {
+		addrs = append(addrs, ip)
+	}
+	if cidr.Addr().Is4() && cidr.Bits() < 31 && len(addrs) > 2 {
+		addrs = addrs[1 : len(addrs)-1]
+	}
+	return addrs
+}
@@ -0,0 +1,38 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestSweepAddrs(t *testing.T) {
+	tests := []struct {
+		cidr      string
+		wantLen   int
+		wantFirst string
+		wantLast  string
+	}{
+		{"192.168.1.0/24", 254, "192.168.1.1", "192.168.1.254"},
+		{"10.0.0.8/30", 2, "10.0.0.9", "10.0.0.10"},
+		{"10.0.0.8/31", 2, "10.0.0.8", "10.0.0.9"},
+		{"10.0.0.8/32", 1, "10.0.0.8", "10.0.0.8"},
+		{"fd7a::/124", 16, "fd7a::", "fd7a::f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			got := sweepAddrs(netip.MustParsePrefix(tt.cidr))
+			if len(got) != tt.wantLen {
+				t.Fatalf("got %d addrs; want %d", len(got), tt.wantLen)
+			}
+			if first := got[0].String(); first != tt.wantFirst {
+				t.Errorf("first = %v; want %v", first, tt.wantFirst)
+			}
+			if last := got[len(got)-1].String(); last != tt.wantLast {
+				t.Errorf("last = %v; want %v", last, tt.wantLast)
+			}
+		})
+	}
+}
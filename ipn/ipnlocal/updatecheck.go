@@ -0,0 +1,30 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/version"
+)
+
+// CheckForUpdate reports whether a newer client version is available for
+// this platform, based on the ClientVersion last sent by the control server
+// in a MapResponse. If control hasn't sent one yet, Latest is empty and no
+// update is reported as available.
+func (b *LocalBackend) CheckForUpdate() *apitype.UpdateCheckResponse {
+	b.mu.Lock()
+	cv := b.lastClientVersion
+	b.mu.Unlock()
+
+	res := &apitype.UpdateCheckResponse{Current: version.Short()}
+	switch {
+	case cv == nil:
+	case cv.RunningLatest:
+		res.Latest = res.Current
+	case cv.LatestVersion != "":
+		res.Latest = cv.LatestVersion
+		res.UpdateAvailable = true
+	}
+	return res
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/version"
+)
+
+func TestCheckForUpdate(t *testing.T) {
+	cur := version.Short()
+	tests := []struct {
+		name string
+		cv   *tailcfg.ClientVersion
+		want apitype.UpdateCheckResponse
+	}{
+		{
+			name: "no-client-version",
+			want: apitype.UpdateCheckResponse{Current: cur},
+		},
+		{
+			name: "running-latest",
+			cv:   &tailcfg.ClientVersion{RunningLatest: true},
+			want: apitype.UpdateCheckResponse{Current: cur, Latest: cur},
+		},
+		{
+			name: "update-available",
+			cv:   &tailcfg.ClientVersion{LatestVersion: "99.0.0"},
+			want: apitype.UpdateCheckResponse{Current: cur, Latest: "99.0.0", UpdateAvailable: true},
+		},
+		{
+			name: "not-latest-unknown-version",
+			cv:   &tailcfg.ClientVersion{},
+			want: apitype.UpdateCheckResponse{Current: cur},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestLocalBackend(t)
+			if tt.cv != nil {
+				b.onClientVersion(tt.cv)
+			}
+			if got := b.CheckForUpdate(); *got != tt.want {
+				t.Errorf("got %+v; want %+v", *got, tt.want)
+			}
+		})
+	}
+}
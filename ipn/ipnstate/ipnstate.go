@@ -74,6 +74,12 @@ type Status struct {
 	// trailing periods, and without any "_acme-challenge." prefix.
 	CertDomains []string
 
+	// SplitDNSActive is whether the DNS configuration Tailscale applied
+	// to the OS uses split DNS, sending only some domains to Tailscale's
+	// resolvers, rather than taking over as the primary DNS resolver. It's
+	// false when no DNS configuration is applied.
+	SplitDNSActive bool `json:",omitempty"`
+
 	// Peer is the state of each peer, keyed by each peer's current public key.
 	Peer map[key.NodePublic]*PeerStatus
 
@@ -692,6 +698,11 @@ type PingResult struct {
 	// a ping to the local node.
 	IsLocalIP bool `json:",omitempty"`
 
+	// Path is "direct" if the reply came over a direct UDP path
+	// (Endpoint), or "derp" if it was relayed (DERPRegionID). It's empty
+	// when unknown, such as for TSMP pings.
+	Path string `json:",omitempty"`
+
 	// TODO(bradfitz): details like whether port mapping was used on either side? (Once supported)
 }
 
@@ -5,6 +5,7 @@
 package localapi
 
 import (
+	"archive/zip"
 	"bytes"
 	"cmp"
 	"context"
@@ -25,7 +26,10 @@
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"runtime/debug"
 	"slices"
 	"strconv"
 	"strings"
@@ -43,19 +47,26 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/logtail"
+	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/portmapper"
+	"tailscale.com/omit"
 	"tailscale.com/tailcfg"
 	"tailscale.com/taildrop"
 	"tailscale.com/tka"
 	"tailscale.com/tstime"
+	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
+	"tailscale.com/types/netmap"
 	"tailscale.com/types/ptr"
 	"tailscale.com/types/tkatype"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/httphdr"
 	"tailscale.com/util/httpm"
 	"tailscale.com/util/mak"
@@ -63,6 +74,7 @@
 	"tailscale.com/util/osuser"
 	"tailscale.com/util/progresstracking"
 	"tailscale.com/util/rands"
+	"tailscale.com/util/set"
 	"tailscale.com/version"
 	"tailscale.com/wgengine/magicsock"
 )
@@ -81,11 +93,14 @@
 
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
+	"accepted-routes":             (*Handler).serveAcceptedRoutes,
+	"addresses":                   (*Handler).serveAddresses,
 	"bugreport":                   (*Handler).serveBugReport,
 	"check-ip-forwarding":         (*Handler).serveCheckIPForwarding,
 	"check-prefs":                 (*Handler).serveCheckPrefs,
 	"check-udp-gro-forwarding":    (*Handler).serveCheckUDPGROForwarding,
 	"component-debug-logging":     (*Handler).serveComponentDebugLogging,
+	"connections":                 (*Handler).serveConnections,
 	"debug":                       (*Handler).serveDebug,
 	"debug-capture":               (*Handler).serveDebugCapture,
 	"debug-derp-region":           (*Handler).serveDebugDERPRegion,
@@ -95,34 +110,55 @@
 	"debug-packet-filter-rules":   (*Handler).serveDebugPacketFilterRules,
 	"debug-peer-endpoint-changes": (*Handler).serveDebugPeerEndpointChanges,
 	"debug-portmap":               (*Handler).serveDebugPortmap,
+	"derp/measure":                (*Handler).serveDERPMeasure,
 	"derpmap":                     (*Handler).serveDERPMap,
 	"dev-set-state-store":         (*Handler).serveDevSetStateStore,
 	"dial":                        (*Handler).serveDial,
+	"dns-config":                  (*Handler).serveDNSConfig,
+	"dns/history":                 (*Handler).serveDNSHistory,
+	"dns/tailnet-config":          (*Handler).serveTailnetDNSConfig,
 	"drive/fileserver-address":    (*Handler).serveDriveServerAddr,
 	"drive/shares":                (*Handler).serveShares,
+	"file-send":                   (*Handler).serveFileSend,
 	"file-targets":                (*Handler).serveFileTargets,
 	"goroutines":                  (*Handler).serveGoroutines,
 	"handle-push-message":         (*Handler).serveHandlePushMessage,
+	"hostname":                    (*Handler).serveHostname,
 	"id-token":                    (*Handler).serveIDToken,
+	"inbound-access":              (*Handler).serveInboundAccess,
+	"inventory":                   (*Handler).serveInventory,
+	"key-expiry":                  (*Handler).serveKeyExpiry,
+	"login-info":                  (*Handler).serveLoginInfo,
 	"login-interactive":           (*Handler).serveLoginInteractive,
 	"logout":                      (*Handler).serveLogout,
+	"logs":                        (*Handler).serveLogs,
+	"logs/recent":                 (*Handler).serveRecentLogs,
 	"logtap":                      (*Handler).serveLogTap,
 	"metrics":                     (*Handler).serveMetrics,
+	"metrics.json":                (*Handler).serveMetricsJSON,
+	"netcheck":                    (*Handler).serveNetcheck,
+	"notices":                     (*Handler).serveNotices,
+	"peer-endpoints":              (*Handler).servePeerEndpoints,
+	"peer-latency":                (*Handler).servePeerLatency,
 	"ping":                        (*Handler).servePing,
 	"pprof":                       (*Handler).servePprof,
 	"prefs":                       (*Handler).servePrefs,
 	"query-feature":               (*Handler).serveQueryFeature,
 	"reload-config":               (*Handler).reloadConfig,
 	"reset-auth":                  (*Handler).serveResetAuth,
+	"roles":                       (*Handler).serveRoles,
 	"serve-config":                (*Handler).serveServeConfig,
 	"set-dns":                     (*Handler).serveSetDNS,
+	"set-exit-node":               (*Handler).serveSetExitNode,
 	"set-expiry-sooner":           (*Handler).serveSetExpirySooner,
 	"set-gui-visible":             (*Handler).serveSetGUIVisible,
 	"set-push-device-token":       (*Handler).serveSetPushDeviceToken,
 	"set-udp-gro-forwarding":      (*Handler).serveSetUDPGROForwarding,
 	"set-use-exit-node-enabled":   (*Handler).serveSetUseExitNodeEnabled,
+	"setup-checks":                (*Handler).serveSetupChecks,
 	"start":                       (*Handler).serveStart,
 	"status":                      (*Handler).serveStatus,
+	"status-watch":                (*Handler).serveStatusWatch,
 	"suggest-exit-node":           (*Handler).serveSuggestExitNode,
 	"tka/affected-sigs":           (*Handler).serveTKAAffectedSigs,
 	"tka/cosign-recovery-aum":     (*Handler).serveTKACosignRecoveryAUM,
@@ -137,10 +173,13 @@
 	"tka/submit-recovery-aum":     (*Handler).serveTKASubmitRecoveryAUM,
 	"tka/verify-deeplink":         (*Handler).serveTKAVerifySigningDeeplink,
 	"tka/wrap-preauth-key":        (*Handler).serveTKAWrapPreauthKey,
+	"tuning":                      (*Handler).serveTuning,
 	"update/check":                (*Handler).serveUpdateCheck,
 	"update/install":              (*Handler).serveUpdateInstall,
 	"update/progress":             (*Handler).serveUpdateProgress,
 	"upload-client-metrics":       (*Handler).serveUploadClientMetrics,
+	"version":                     (*Handler).serveVersion,
+	"watch-dns":                   (*Handler).serveWatchDNS,
 	"watch-ipn-bus":               (*Handler).serveWatchIPNBus,
 	"whois":                       (*Handler).serveWhoIs,
 }
@@ -151,10 +190,20 @@
 	// clientmetric.Metric instances that we've created for them. These need to
 	// be globals because we end up creating many Handler instances for the
 	// lifetime of a client.
-	metricsMu sync.Mutex
-	metrics   = map[string]*clientmetric.Metric{}
+	metricsMu  sync.Mutex
+	metrics    = map[string]*clientmetric.Metric{}
+	histograms = map[string]*clientmetric.Histogram{}
 )
 
+// defaultClientHistogramBuckets are the buckets of histograms created by
+// clients that don't specify any, suitable for latencies in milliseconds.
+var defaultClientHistogramBuckets = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// maxClientHistogramBuckets is the most buckets a client may give a
+// histogram, as each is kept for the life of the process and exported
+// with every scrape.
+const maxClientHistogramBuckets = 50
+
 // NewHandler creates a new LocalAPI HTTP handler. All parameters except netMon
 // are required (if non-nil it's used to do faster interface lookups).
 func NewHandler(b *ipnlocal.LocalBackend, logf logger.Logf, logID logid.PublicID) *Handler {
@@ -384,6 +433,13 @@ func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
 
 	if defBool(r.URL.Query().Get("diagnose"), false) {
 		h.b.Doctor(r.Context(), logger.WithPrefix(h.logf, "diag: "))
+
+		// Clients that accept a zip get a diagnostic bundle instead of
+		// just the marker.
+		if strings.Contains(r.Header.Get("Accept"), "application/zip") {
+			h.writeBugReportBundle(w, r, startMarker)
+			return
+		}
 	}
 	w.Header().Set("Content-Type", "text/plain")
 	fmt.Fprintln(w, startMarker)
@@ -440,6 +496,107 @@ func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, endMarker)
 }
 
+// bugReportNetMap is the netmap summary in a bugreport bundle.
+type bugReportNetMap struct {
+	Self  bugReportNode
+	Peers []bugReportNode
+}
+
+type bugReportNode struct {
+	Name      string
+	StableID  tailcfg.StableNodeID
+	Addresses []netip.Prefix
+	Online    *bool  `json:",omitempty"`
+	HomeDERP  string `json:",omitempty"`
+}
+
+func newBugReportNode(n tailcfg.NodeView) bugReportNode {
+	return bugReportNode{
+		Name:      n.Name(),
+		StableID:  n.StableID(),
+		Addresses: n.Addresses().AsSlice(),
+		Online:    n.Online(),
+		HomeDERP:  n.DERP(),
+	}
+}
+
+// writeBugReportBundle streams a zip of diagnostics to w: the bugreport
+// marker, a summary of the netmap, the latest DERP latencies, the prefs
+// (with private keys removed) and, if h.PermitWrite, the most recent local
+// log lines. The optional "lines" parameter limits the number of log lines
+// included.
+func (h *Handler) writeBugReportBundle(w http.ResponseWriter, r *http.Request, marker string) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="tailscale-bugreport.zip"`)
+	zw := zip.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	add := func(name string, write func(io.Writer) error) {
+		f, err := zw.Create(name)
+		if err != nil {
+			h.logf("bugreport bundle: %s: %v", name, err)
+			return
+		}
+		if err := write(f); err != nil {
+			h.logf("bugreport bundle: %s: %v", name, err)
+		}
+		if flusher != nil {
+			zw.Flush()
+			flusher.Flush()
+		}
+	}
+	addJSON := func(name string, v any) {
+		add(name, func(w io.Writer) error {
+			e := json.NewEncoder(w)
+			e.SetIndent("", "\t")
+			return e.Encode(v)
+		})
+	}
+
+	add("marker.txt", func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, marker)
+		return err
+	})
+	if nm := h.b.NetMap(); nm != nil {
+		var sum bugReportNetMap
+		if nm.SelfNode.Valid() {
+			sum.Self = newBugReportNode(nm.SelfNode)
+		}
+		for _, p := range nm.Peers {
+			sum.Peers = append(sum.Peers, newBugReportNode(p))
+		}
+		addJSON("netmap.json", sum)
+		if report := h.b.MagicConn().GetLastNetcheckReport(r.Context()); report != nil && nm.DERPMap != nil {
+			addJSON("derp.json", derpMeasureResponse(report, nm.DERPMap, 0))
+		}
+	}
+	addJSON("prefs.json", h.b.Prefs())
+	add("logs.txt", func(w io.Writer) error {
+		// Like serveRecentLogs, require write access (~root) for the
+		// logs, as they could contain something sensitive.
+		if !h.PermitWrite {
+			_, err := io.WriteString(w, "logs omitted; they require write access to tailscaled (run as root)\n")
+			return err
+		}
+		logs := logtail.RecentLogs()
+		if len(logs) == 0 {
+			_, err := io.WriteString(w, "no recent logs retained by tailscaled\n")
+			return err
+		}
+		if n, err := strconv.Atoi(r.FormValue("lines")); err == nil && n >= 0 && n < len(logs) {
+			logs = logs[len(logs)-n:]
+		}
+		for _, l := range logs {
+			if _, err := io.WriteString(w, strings.TrimRight(l, "\n")+"\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err := zw.Close(); err != nil {
+		h.logf("bugreport bundle: %v", err)
+	}
+}
+
 func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) {
 	h.serveWhoIsWithBackend(w, r, h.b)
 }
@@ -449,6 +606,7 @@ func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) {
 type localBackendWhoIsMethods interface {
 	WhoIs(string, netip.AddrPort) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool)
 	WhoIsNodeKey(key.NodePublic) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool)
+	WhoIsStableID(tailcfg.StableNodeID) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool)
 	PeerCaps(netip.Addr) tailcfg.PeerCapMap
 }
 
@@ -463,6 +621,7 @@ func (h *Handler) serveWhoIsWithBackend(w http.ResponseWriter, r *http.Request,
 		ok bool
 	)
 	var ipp netip.AddrPort
+	stableID := tailcfg.StableNodeID(r.FormValue("stableid"))
 	if v := r.FormValue("addr"); v != "" {
 		if strings.HasPrefix(v, "nodekey:") {
 			var k key.NodePublic
@@ -484,12 +643,15 @@ func (h *Handler) serveWhoIsWithBackend(w http.ResponseWriter, r *http.Request,
 		if ipp.IsValid() {
 			n, u, ok = b.WhoIs(r.FormValue("proto"), ipp)
 		}
-	} else {
-		http.Error(w, "missing 'addr' parameter", http.StatusBadRequest)
+	} else if stableID == "" {
+		http.Error(w, "missing 'addr' or 'stableid' parameter", http.StatusBadRequest)
 		return
 	}
+	if !ok && stableID != "" {
+		n, u, ok = b.WhoIsStableID(stableID)
+	}
 	if !ok {
-		http.Error(w, "no match for IP:port", http.StatusNotFound)
+		http.Error(w, "no match for IP:port or stable ID", http.StatusNotFound)
 		return
 	}
 	res := &apitype.WhoIsResponse{
@@ -515,12 +677,60 @@ func (h *Handler) serveGoroutines(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "goroutine dump access denied", http.StatusForbidden)
 		return
 	}
-	buf := make([]byte, 2<<20)
-	buf = buf[:runtime.Stack(buf, true)]
+	match := r.FormValue("match")
+	if match == "" && r.FormValue("count") == "1" {
+		writeGoroutineCount(w, runtime.NumGoroutine())
+		return
+	}
+	buf := allGoroutineStacks()
+	if match != "" {
+		var n int
+		buf, n = filterGoroutines(buf, match)
+		if r.FormValue("count") == "1" {
+			writeGoroutineCount(w, n)
+			return
+		}
+	}
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write(buf)
 }
 
+// allGoroutineStacks returns the stacks of all goroutines, growing the
+// buffer until they all fit, so that none are cut off before filtering.
+func allGoroutineStacks() []byte {
+	for size := 2 << 20; ; size *= 2 {
+		buf := make([]byte, size)
+		if n := runtime.Stack(buf, true); n < size {
+			return buf[:n]
+		}
+	}
+}
+
+func writeGoroutineCount(w http.ResponseWriter, n int) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Count int }{n})
+}
+
+// filterGoroutines returns the stacks in the goroutine dump that contain
+// substr, and how many there are.
+func filterGoroutines(dump []byte, substr string) (filtered []byte, n int) {
+	var out bytes.Buffer
+	for _, stack := range bytes.Split(bytes.TrimRight(dump, "\n"), []byte("\n\n")) {
+		if !bytes.Contains(stack, []byte(substr)) {
+			continue
+		}
+		if n > 0 {
+			out.WriteString("\n\n")
+		}
+		out.Write(stack)
+		n++
+	}
+	if n > 0 {
+		out.WriteString("\n")
+	}
+	return out.Bytes(), n
+}
+
 // serveLogTap taps into the tailscaled/logtail server output and streams
 // it to the client.
 func (h *Handler) serveLogTap(w http.ResponseWriter, r *http.Request) {
@@ -567,10 +777,39 @@ func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "metric access denied", http.StatusForbidden)
 		return
 	}
+	// Default to the Prometheus text format that existing scrapers expect,
+	// serving OpenMetrics only to those that ask for it.
+	if r.FormValue("format") == "openmetrics" || strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+		w.Header().Set("Content-Type", clientmetric.OpenMetricsContentType)
+		clientmetric.WriteOpenMetricsFormat(w)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain")
 	clientmetric.WritePrometheusExpositionFormat(w)
 }
 
+// serveMetricsJSON serves the same metrics as serveMetrics, as a JSON array
+// of clientmetric.MetricSnapshot.
+func (h *Handler) serveMetricsJSON(w http.ResponseWriter, r *http.Request) {
+	// Same paranoia as serveMetrics.
+	if !h.PermitWrite {
+		http.Error(w, "metric access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	snap := clientmetric.Snapshot()
+	if snap == nil {
+		snap = []clientmetric.MetricSnapshot{} // [] rather than null
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(snap)
+}
+
 func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -611,8 +850,15 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 		h.b.DebugNotifyLastNetMap()
 	case "break-tcp-conns":
 		err = h.b.DebugBreakTCPConns()
-	case "break-derp-conns":
-		err = h.b.DebugBreakDERPConns()
+	case "break-derp-conns", "break-derp-connections":
+		var closed int
+		closed, err = h.b.DebugBreakDERPConns()
+		if err != nil {
+			break
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "done; closed %d DERP connections\n", closed)
+		return
 	case "force-netmap-update":
 		h.b.DebugForceNetmapUpdate()
 	case "control-knobs":
@@ -624,6 +870,66 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 		}
 	case "pick-new-derp":
 		err = h.b.DebugPickNewDERP()
+	case "fail-derp-region", "restore-derp-region":
+		var region int
+		region, err = strconv.Atoi(r.FormValue("region"))
+		if err != nil {
+			err = fmt.Errorf("invalid 'region' parameter: %w", err)
+			break
+		}
+		var res *apitype.DERPRegionFailResponse
+		res, err = h.b.DebugSetDERPRegionFailed(region, action == "fail-derp-region")
+		if err != nil {
+			break
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(res)
+		if err == nil {
+			return
+		}
+	case "disable-derp", "enable-derp":
+		res := h.b.DebugSetDERPDisabled(action == "disable-derp")
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(res)
+		if err == nil {
+			return
+		}
+	case "trace":
+		var secs int
+		secs, err = strconv.Atoi(r.FormValue("seconds"))
+		if err != nil || secs < 0 || secs > maxTraceSeconds {
+			err = fmt.Errorf("invalid 'seconds' parameter; want 0 to %d", maxTraceSeconds)
+			break
+		}
+		var until time.Time // zero to stop tracing
+		if secs > 0 {
+			until = h.clock.Now().Add(time.Duration(secs) * time.Second)
+		}
+		if err = h.b.SetComponentDebugLogging(r.FormValue("subsystem"), until); err != nil {
+			break
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(h.activeTraces())
+		if err == nil {
+			return
+		}
+	case "sweep-subnet":
+		var cidr netip.Prefix
+		cidr, err = netip.ParsePrefix(r.FormValue("cidr"))
+		if err != nil {
+			break
+		}
+		var res *apitype.SubnetSweepResponse
+		res, err = h.b.DebugSweepSubnet(r.Context(), cidr)
+		if err != nil {
+			break
+		}
+		mak.NonNilSliceForJSON(&res.Alive)
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(res)
+		if err == nil {
+			return
+		}
 	case "":
 		err = fmt.Errorf("missing parameter 'action'")
 	default:
@@ -637,6 +943,21 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "done\n")
 }
 
+// maxTraceSeconds is the longest the debug "trace" action elevates a
+// subsystem's logging for.
+const maxTraceSeconds = 60 * 60
+
+// activeTraces returns the subsystems whose logging is currently elevated.
+func (h *Handler) activeTraces() []apitype.DebugTrace {
+	res := []apitype.DebugTrace{}
+	for _, c := range ipn.DebuggableComponents {
+		if until := h.b.GetComponentDebugLogging(c); !until.IsZero() {
+			res = append(res, apitype.DebugTrace{Subsystem: c, Until: until})
+		}
+	}
+	return res
+}
+
 func (h *Handler) serveDevSetStateStore(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -1181,6 +1502,25 @@ func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// serveSetupChecks runs the host prerequisite checks for this node's role,
+// such as IP forwarding on subnet routers and exit nodes, and returns their
+// results with suggested fixes.
+func (h *Handler) serveSetupChecks(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "setup-checks access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	res := h.b.SetupChecks(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
 func (h *Handler) serveCheckUDPGROForwarding(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "UDP GRO forwarding check access denied", http.StatusForbidden)
@@ -1220,107 +1560,479 @@ func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "status access denied", http.StatusForbidden)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
+	h.writeStatus(w, r, defBool(r.FormValue("peers"), true))
+}
+
+// writeStatus writes the status, with or without peers, and its ETag. If
+// the request's If-None-Match header matches the ETag, it writes just a 304
+// Not Modified without building the status.
+func (h *Handler) writeStatus(w http.ResponseWriter, r *http.Request, peers bool) {
+	// Get the ETag before building the status, so that a change while
+	// building it makes the ETag stale rather than hiding the change.
+	etag := h.statusETag(peers)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	var st *ipnstate.Status
-	if defBool(r.FormValue("peers"), true) {
+	if peers {
 		st = h.b.Status()
 	} else {
 		st = h.b.StatusWithoutPeers()
 	}
+	w.Header().Set("Content-Type", "application/json")
 	e := json.NewEncoder(w)
 	e.SetIndent("", "\t")
 	e.Encode(st)
 }
 
-func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.Request) {
+const (
+	// statusWatchDefaultTimeout is how long serveStatusWatch blocks waiting
+	// for a change when the request has no "timeout" parameter.
+	statusWatchDefaultTimeout = 30 * time.Second
+
+	// statusWatchMaxTimeout is the longest "timeout" serveStatusWatch
+	// honors.
+	statusWatchMaxTimeout = 5 * time.Minute
+
+	// statusWatchCoalesce is how long serveStatusWatch waits after an IPN
+	// bus notification before re-reading the status, so that a burst of
+	// notifications produces a single response.
+	statusWatchCoalesce = 250 * time.Millisecond
+)
+
+// serveStatusWatch is a long-poll variant of serveStatus. It blocks until the
+// status differs from the one with the ETag in the request's If-None-Match
+// header (or, without one, from the status when the request arrived), then
+// returns the new status. If the "timeout" duration elapses first, it
+// returns the current status, or 304 Not Modified if the client's ETag
+// still matches.
+func (h *Handler) serveStatusWatch(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "status access denied", http.StatusForbidden)
 		return
 	}
-
-	ipStr := r.FormValue("ip")
-	if ipStr == "" {
-		http.Error(w, "missing 'ip' parameter", http.StatusBadRequest)
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
 		return
 	}
-	ip, err := netip.ParseAddr(ipStr)
-	if err != nil {
-		http.Error(w, "invalid IP", http.StatusBadRequest)
+	peers := defBool(r.FormValue("peers"), true)
+	timeout := statusWatchDefaultTimeout
+	if v := r.FormValue("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid 'timeout' parameter", http.StatusBadRequest)
+			return
+		}
+		timeout = min(d, statusWatchMaxTimeout)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	// Start watching before reading the baseline status so that no change
+	// in between is missed.
+	watching := make(chan struct{})
+	changed := make(chan struct{}, 1)
+	go h.b.WatchNotifications(ctx, ipn.NotifyNoPrivateKeys, func() { close(watching) }, func(*ipn.Notify) bool {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+		return true
+	})
+	select {
+	case <-watching:
+	case <-ctx.Done():
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	chs, err := h.b.GetPeerEndpointChanges(r.Context(), ip)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	base := r.Header.Get("If-None-Match")
+	if base == "" {
+		base = h.statusETag(peers)
+	} else if !etagMatches(base, h.statusETag(peers)) {
+		h.writeStatus(w, r, peers)
 		return
 	}
 
-	e := json.NewEncoder(w)
-	e.SetIndent("", "\t")
-	e.Encode(chs)
+	for {
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if r.Context().Err() != nil {
+				return // client went away
+			}
+			h.writeStatus(w, r, peers)
+			return
+		}
+		select {
+		case <-time.After(statusWatchCoalesce):
+		case <-ctx.Done():
+			continue
+		}
+		select {
+		case <-changed:
+		default:
+		}
+		if !etagMatches(base, h.statusETag(peers)) {
+			h.writeStatus(w, r, peers)
+			return
+		}
+	}
 }
 
-// InUseOtherUserIPNStream reports whether r is a request for the watch-ipn-bus
-// handler. If so, it writes an ipn.Notify InUseOtherUser message to the user
-// and returns true. Otherwise it returns false, in which case it doesn't write
-// to w.
-//
-// Unlike the regular watch-ipn-bus handler, this one doesn't block. The caller
-// (in ipnserver.Server) provides the blocking until the connection is no longer
-// in use.
-func InUseOtherUserIPNStream(w http.ResponseWriter, r *http.Request, err error) (handled bool) {
-	if r.Method != "GET" || r.URL.Path != "/localapi/v0/watch-ipn-bus" {
-		return false
+// statusETagEpoch distinguishes status ETags from different runs of
+// tailscaled, whose status generations both start at zero.
+var statusETagEpoch = rands.HexString(8)
+
+// statusETag returns the ETag for the current status, with or without
+// peers. It's derived from the backend's status generation rather than the
+// status itself, so checking it doesn't require building the status.
+func (h *Handler) statusETag(peers bool) string {
+	variant := "peers"
+	if !peers {
+		variant = "nopeers"
 	}
-	js, err := json.Marshal(&ipn.Notify{
-		Version:    version.Long(),
-		State:      ptr.To(ipn.InUseOtherUser),
-		ErrMessage: ptr.To(err.Error()),
-	})
-	if err != nil {
-		return false
+	return fmt.Sprintf(`"%s-%d-%s"`, statusETagEpoch, h.b.StatusGeneration(), variant)
+}
+
+// etagMatches reports whether the If-None-Match header value ifNoneMatch
+// matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
 	}
-	js = append(js, '\n')
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(js)
-	return true
+	return false
 }
 
-func (h *Handler) serveWatchIPNBus(w http.ResponseWriter, r *http.Request) {
+// serveInboundAccess returns the rules of the packet filter this node
+// enforces that permit other nodes to connect to it, answering "who can
+// reach me, and on which ports?".
+func (h *Handler) serveInboundAccess(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
-		http.Error(w, "watch ipn bus access denied", http.StatusForbidden)
+		http.Error(w, "inbound-access access denied", http.StatusForbidden)
 		return
 	}
-	f, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "not a flusher", http.StatusInternalServerError)
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
 		return
 	}
+	rules := h.b.InboundAccess()
+	if rules == nil {
+		http.Error(w, "no netmap", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(rules)
+}
 
-	var mask ipn.NotifyWatchOpt
-	if s := r.FormValue("mask"); s != "" {
-		v, err := strconv.ParseUint(s, 10, 64)
+// serveLogs lists the segments of the on-disk log buffer, which hold the
+// log lines not yet uploaded, or with a "name" parameter, returns the
+// contents of one of them. When listing, an RFC 3339 "since" parameter
+// omits segments not modified since then.
+func (h *Handler) serveLogs(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "logs access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	buf := h.b.LogBuffer()
+	if buf == nil {
+		http.Error(w, "no local log buffer", http.StatusNotFound)
+		return
+	}
+	if name := r.FormValue("name"); name != "" {
+		f, err := buf.OpenSegment(name)
 		if err != nil {
-			http.Error(w, "bad mask", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		mask = ipn.NotifyWatchOpt(v)
+		defer f.Close()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.Copy(w, f)
+		return
 	}
-	// Users with only read access must request private key filtering. If they
-	// don't filter out private keys, require write access.
-	if (mask & ipn.NotifyNoPrivateKeys) == 0 {
-		if !h.PermitWrite {
-			http.Error(w, "watch IPN bus access denied, must set ipn.NotifyNoPrivateKeys when not running as admin/root or operator", http.StatusForbidden)
+	var since time.Time
+	if v := r.FormValue("since"); v != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid 'since' parameter: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	ctx := r.Context()
-	enc := json.NewEncoder(w)
-	h.b.WatchNotifications(ctx, mask, f.Flush, func(roNotify *ipn.Notify) (keepGoing bool) {
-		err := enc.Encode(roNotify)
-		if err != nil {
+	segs, err := buf.Segments()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := make([]apitype.LogSegment, 0, len(segs))
+	for _, s := range segs {
+		if s.ModTime.Before(since) {
+			continue
+		}
+		res = append(res, apitype.LogSegment{
+			Name:    s.Name,
+			Size:    s.Size,
+			ModTime: s.ModTime,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// serveRecentLogs returns the log writes tailscaled has retained in
+// memory, oldest first, one JSON object per line. Unlike the on-disk
+// buffer served by serveLogs, they include lines already uploaded.
+func (h *Handler) serveRecentLogs(w http.ResponseWriter, r *http.Request) {
+	// Require write access (~root) as the logs could contain something
+	// sensitive.
+	if !h.PermitWrite {
+		http.Error(w, "logs access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, l := range logtail.RecentLogs() {
+		if _, err := io.WriteString(w, strings.TrimRight(l, "\n")+"\n"); err != nil {
+			return
+		}
+	}
+}
+
+// servePeerLatency returns the round-trip times recently observed to the
+// peer with the "stableid" parameter, from the disco pings tailscaled
+// already sends. It doesn't send any pings itself.
+func (h *Handler) servePeerLatency(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "peer-latency access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	id := tailcfg.StableNodeID(r.FormValue("stableid"))
+	if id == "" {
+		http.Error(w, "missing 'stableid' parameter", http.StatusBadRequest)
+		return
+	}
+	nm := h.b.NetMap()
+	if nm == nil {
+		http.Error(w, "no netmap", http.StatusServiceUnavailable)
+		return
+	}
+	peer, ok := nm.PeerWithStableID(id)
+	if !ok {
+		http.Error(w, "unknown peer", http.StatusNotFound)
+		return
+	}
+	samples, err := h.b.MagicConn().GetLatencyHistory(peer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := apitype.PeerLatencyResponse{
+		StableID: id,
+		Samples:  peerLatencySamples(samples),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// servePeerEndpoints returns the candidate endpoints magicsock knows for
+// the peer with the "stableid" parameter, or for all peers if it's empty,
+// and which one it currently sends to.
+func (h *Handler) servePeerEndpoints(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "peer-endpoints access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	nm := h.b.NetMap()
+	if nm == nil {
+		http.Error(w, "no netmap", http.StatusServiceUnavailable)
+		return
+	}
+	peers := nm.Peers
+	if id := tailcfg.StableNodeID(r.FormValue("stableid")); id != "" {
+		peer, ok := nm.PeerWithStableID(id)
+		if !ok {
+			http.Error(w, "unknown peer", http.StatusNotFound)
+			return
+		}
+		peers = []tailcfg.NodeView{peer}
+	}
+	res := make([]apitype.PeerEndpoints, 0, len(peers))
+	for _, peer := range peers {
+		eps, err := h.b.MagicConn().GetPeerEndpoints(peer)
+		if err != nil {
+			if len(peers) == 1 {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			continue // e.g. a peer without a key magicsock doesn't track
+		}
+		pe := apitype.PeerEndpoints{
+			StableID:        peer.StableID(),
+			Name:            strings.TrimSuffix(peer.Name(), "."),
+			Candidates:      make([]apitype.EndpointCandidate, 0, len(eps.Candidates)),
+			BestAddrTrusted: eps.BestAddrTrusted,
+			DERPRegion:      eps.DERPRegion,
+		}
+		if eps.BestAddr.IsValid() {
+			pe.BestAddr = eps.BestAddr.String()
+		}
+		if nm.DERPMap != nil {
+			if reg, ok := nm.DERPMap.Regions[eps.DERPRegion]; ok {
+				pe.DERPRegionCode = reg.RegionCode
+			}
+		}
+		for _, c := range eps.Candidates {
+			ec := apitype.EndpointCandidate{
+				Addr:      c.Addr,
+				Source:    c.Source,
+				LatencyMs: float64(c.Latency) / float64(time.Millisecond),
+			}
+			if !c.LastPing.IsZero() {
+				ec.LastPing = &c.LastPing
+			}
+			pe.Candidates = append(pe.Candidates, ec)
+		}
+		res = append(res, pe)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// peerLatencySamples converts samples to their LocalAPI representation.
+// It returns a non-nil slice so that an empty history encodes as [].
+func peerLatencySamples(samples []magicsock.LatencySample) []apitype.PeerLatencySample {
+	res := make([]apitype.PeerLatencySample, 0, len(samples))
+	for _, s := range samples {
+		ps := apitype.PeerLatencySample{
+			Time:      s.When,
+			LatencyMs: float64(s.Latency) / float64(time.Millisecond),
+		}
+		if s.DERP {
+			ps.DERPRegion = int(s.Addr.Port())
+		} else {
+			ps.Endpoint = s.Addr.String()
+		}
+		res = append(res, ps)
+	}
+	return res
+}
+
+func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+
+	ipStr := r.FormValue("ip")
+	if ipStr == "" {
+		http.Error(w, "missing 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		http.Error(w, "invalid IP", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	chs, err := h.b.GetPeerEndpointChanges(r.Context(), ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(chs)
+}
+
+// InUseOtherUserIPNStream reports whether r is a request for the watch-ipn-bus
+// handler. If so, it writes an ipn.Notify InUseOtherUser message to the user
+// and returns true. Otherwise it returns false, in which case it doesn't write
+// to w.
+//
+// Unlike the regular watch-ipn-bus handler, this one doesn't block. The caller
+// (in ipnserver.Server) provides the blocking until the connection is no longer
+// in use.
+func InUseOtherUserIPNStream(w http.ResponseWriter, r *http.Request, err error) (handled bool) {
+	if r.Method != "GET" || r.URL.Path != "/localapi/v0/watch-ipn-bus" {
+		return false
+	}
+	js, err := json.Marshal(&ipn.Notify{
+		Version:    version.Long(),
+		State:      ptr.To(ipn.InUseOtherUser),
+		ErrMessage: ptr.To(err.Error()),
+	})
+	if err != nil {
+		return false
+	}
+	js = append(js, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	return true
+}
+
+func (h *Handler) serveWatchIPNBus(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "watch ipn bus access denied", http.StatusForbidden)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "not a flusher", http.StatusInternalServerError)
+		return
+	}
+
+	var mask ipn.NotifyWatchOpt
+	if s := r.FormValue("mask"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "bad mask", http.StatusBadRequest)
+			return
+		}
+		mask = ipn.NotifyWatchOpt(v)
+	}
+	// Users with only read access must request private key filtering. If they
+	// don't filter out private keys, require write access.
+	if (mask & ipn.NotifyNoPrivateKeys) == 0 {
+		if !h.PermitWrite {
+			http.Error(w, "watch IPN bus access denied, must set ipn.NotifyNoPrivateKeys when not running as admin/root or operator", http.StatusForbidden)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+	enc := json.NewEncoder(w)
+	h.b.WatchNotifications(ctx, mask, f.Flush, func(roNotify *ipn.Notify) (keepGoing bool) {
+		err := enc.Encode(roNotify)
+		if err != nil {
 			h.logf("json.Encode: %v", err)
 			return false
 		}
@@ -1329,6 +2041,23 @@ func (h *Handler) serveWatchIPNBus(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// serveLoginInfo returns the control server in effect and whether it's
+// Tailscale's default one, so clients can show the right login UI.
+func (h *Handler) serveLoginInfo(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "login-info access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.LoginInfo())
+}
+
 func (h *Handler) serveLoginInteractive(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "login access denied", http.StatusForbidden)
@@ -1414,6 +2143,25 @@ func (h *Handler) servePrefs(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(resJSON{Error: err.Error()})
 			return
 		}
+	case "DELETE":
+		if !h.PermitWrite {
+			http.Error(w, "prefs write access denied", http.StatusForbidden)
+			return
+		}
+		if err := h.b.MaybeClearAppConnector(&ipn.MaskedPrefs{AdvertiseRoutesSet: true}); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(resJSON{Error: err.Error()})
+			return
+		}
+		var err error
+		prefs, err = h.b.ResetPrefs()
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resJSON{Error: err.Error()})
+			return
+		}
 	case "GET", "HEAD":
 		prefs = h.b.Prefs()
 	default:
@@ -1624,13 +2372,63 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Periodically report progress of outgoing files.
+	// With progress=1, a PUT's response is a stream of server-sent events
+	// reporting the bytes sent so far, ending with the outcome of the PUT.
+	// The query is checked directly, as r.FormValue would consume the body.
+	var events *filePutEventWriter
+	if r.Method == "PUT" && r.URL.Query().Get("progress") == "1" {
+		events, ok = newFilePutEventWriter(w)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var onUpdate func(ipn.OutgoingFile)
+	if events != nil {
+		onUpdate = func(u ipn.OutgoingFile) { events.send("progress", u) }
+	}
+	progressUpdates, stopUpdates := h.trackOutgoingFiles(onUpdate)
+	defer stopUpdates()
+
+	switch r.Method {
+	case "PUT":
+		file := ipn.OutgoingFile{
+			ID:           uuid.Must(uuid.NewRandom()).String(),
+			PeerID:       peerID,
+			Name:         filenameEscaped,
+			DeclaredSize: r.ContentLength,
+		}
+		if events == nil {
+			h.singleFilePut(r.Context(), progressUpdates, w, r.Body, dstURL, file)
+			return
+		}
+		// Buffer the peer's response so it can be reported in the final
+		// event, after the last progress event has been sent.
+		ww := &multiFilePostResponseWriter{}
+		h.singleFilePut(r.Context(), progressUpdates, ww, r.Body, dstURL, file)
+		stopUpdates()
+		events.sendResult(ww)
+	case "POST":
+		h.multiFilePost(progressUpdates, w, r, peerID, dstURL)
+	default:
+		http.Error(w, "want PUT to put file", http.StatusBadRequest)
+		return
+	}
+}
+
+// trackOutgoingFiles starts periodically reporting to the backend the
+// progress of the outgoing files sent on the returned channel, calling
+// onUpdate (if non-nil) with each. Call stop once no more updates will be
+// sent; it returns after the final progress has been reported.
+func (h *Handler) trackOutgoingFiles(onUpdate func(ipn.OutgoingFile)) (progressUpdates chan ipn.OutgoingFile, stop func()) {
 	outgoingFiles := make(map[string]*ipn.OutgoingFile)
 	t := time.NewTicker(1 * time.Second)
-	progressUpdates := make(chan ipn.OutgoingFile)
-	defer close(progressUpdates)
+	progressUpdates = make(chan ipn.OutgoingFile)
+	updatesDone := make(chan struct{})
 
 	go func() {
+		defer close(updatesDone)
 		defer t.Stop()
 		defer h.b.UpdateOutgoingFiles(outgoingFiles)
 		for {
@@ -1640,27 +2438,101 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 				outgoingFiles[u.ID] = &u
+				if onUpdate != nil {
+					onUpdate(u)
+				}
 			case <-t.C:
 				h.b.UpdateOutgoingFiles(outgoingFiles)
 			}
 		}
 	}()
 
-	switch r.Method {
-	case "PUT":
-		file := ipn.OutgoingFile{
-			ID:           uuid.Must(uuid.NewRandom()).String(),
-			PeerID:       peerID,
-			Name:         filenameEscaped,
-			DeclaredSize: r.ContentLength,
+	return progressUpdates, sync.OnceFunc(func() {
+		close(progressUpdates)
+		<-updatesDone
+	})
+}
+
+// serveFileSend sends a file on the local filesystem to a peer with
+// Taildrop, reading it in tailscaled so the client needn't stream it as
+// with serveFilePut. The request body is a JSON apitype.FileSendRequest.
+// Like a file-put with progress=1, it responds with a stream of "progress"
+// events, the first of which has the transfer's ID, and a final "done"
+// event with the outcome. The transfer is canceled if the client goes away.
+//
+// As tailscaled may be able to read files the client can't, only local
+// admins may use it.
+func (h *Handler) serveFileSend(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite || !h.connIsLocalAdmin() {
+		http.Error(w, "file-send access denied; use file-put instead", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req apitype.FileSendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !filepath.IsAbs(req.Path) {
+		http.Error(w, "path must be absolute", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fi, err := f.Stat()
+	if err == nil && !fi.Mode().IsRegular() {
+		err = fmt.Errorf("%s is not a regular file", req.Path)
+	}
+	if err != nil {
+		f.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fts, err := h.b.FileTargets()
+	if err != nil {
+		f.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var dstURL *url.URL
+	for _, ft := range fts {
+		if ft.Node.StableID == req.StableID {
+			dstURL, err = url.Parse(ft.PeerAPIURL)
+			break
 		}
-		h.singleFilePut(r.Context(), progressUpdates, w, r.Body, dstURL, file)
-	case "POST":
-		h.multiFilePost(progressUpdates, w, r, peerID, dstURL)
-	default:
-		http.Error(w, "want PUT to put file", http.StatusBadRequest)
+	}
+	if dstURL == nil || err != nil {
+		f.Close()
+		http.Error(w, "node not found", http.StatusNotFound)
 		return
 	}
+
+	file := ipn.OutgoingFile{
+		ID:           uuid.Must(uuid.NewRandom()).String(),
+		PeerID:       req.StableID,
+		Name:         url.PathEscape(filepath.Base(req.Path)),
+		DeclaredSize: fi.Size(),
+	}
+	defer f.Close()
+	events, ok := newFilePutEventWriter(w)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events.send("progress", file)
+	progressUpdates, stopUpdates := h.trackOutgoingFiles(func(u ipn.OutgoingFile) { events.send("progress", u) })
+	defer stopUpdates()
+	ww := &multiFilePostResponseWriter{}
+	h.singleFilePut(r.Context(), progressUpdates, ww, f, dstURL, file)
+	stopUpdates()
+	events.sendResult(ww)
 }
 
 func (h *Handler) multiFilePost(progressUpdates chan (ipn.OutgoingFile), w http.ResponseWriter, r *http.Request, peerID tailcfg.StableNodeID, dstURL *url.URL) {
@@ -1765,6 +2637,47 @@ func (ww *multiFilePostResponseWriter) Flush(w http.ResponseWriter) error {
 	return nil
 }
 
+// filePutEventWriter writes the text/event-stream response of a file-put
+// request made with progress=1.
+type filePutEventWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+// newFilePutEventWriter starts an event stream on w. It reports false if w
+// doesn't support streaming.
+func newFilePutEventWriter(w http.ResponseWriter) (_ *filePutEventWriter, ok bool) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return &filePutEventWriter{w: w, f: f}, true
+}
+
+// send writes an event of the given type with v encoded as JSON as its data.
+func (ew *filePutEventWriter) send(event string, v any) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(ew.w, "event: %s\ndata: %s\n\n", event, j)
+	ew.f.Flush()
+}
+
+// sendResult writes the final "done" event, reporting the peer's response
+// to the PUT buffered in ww.
+func (ew *filePutEventWriter) sendResult(ww *multiFilePostResponseWriter) {
+	res := apitype.FilePutStatus{StatusCode: cmp.Or(ww.statusCode, http.StatusOK)}
+	if res.StatusCode >= 400 && ww.body != nil {
+		res.Error = strings.TrimSpace(ww.body.String())
+	}
+	ew.send("done", res)
+}
+
 func (h *Handler) singleFilePut(
 	ctx context.Context,
 	progressUpdates chan (ipn.OutgoingFile),
@@ -1853,29 +2766,537 @@ func (h *Handler) serveSetDNS(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "access denied", http.StatusForbidden)
 		return
 	}
-	if r.Method != "POST" {
-		http.Error(w, "want POST", http.StatusBadRequest)
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	err := h.b.SetDNS(ctx, r.FormValue("name"), r.FormValue("value"))
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+// serveDNSHistory returns the recent DNS configuration changes, newest
+// first, each with a diff against the configuration before it. The optional
+// "limit" parameter bounds the number of changes returned.
+func (h *Handler) serveDNSHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "dns history access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 0
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid 'limit' parameter", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	res := dnsHistory(h.b.DNSConfigChanges(), limit)
+	mak.NonNilSliceForJSON(&res)
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// serveWatchDNS streams the DNS queries answered by the MagicDNS resolver
+// as server-sent events, each an apitype.DNSQueryEvent, until the client
+// disconnects. Queries are dropped if the client reads too slowly.
+func (h *Handler) serveWatchDNS(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "watch-dns access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "not a flusher", http.StatusInternalServerError)
+		return
+	}
+	evc := make(chan resolver.QueryEvent, 64)
+	remove, err := h.b.AddDNSQueryObserver(func(ev resolver.QueryEvent) {
+		select {
+		case evc <- ev:
+		default:
+		}
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	defer remove()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-evc:
+			j, err := json.Marshal(apitype.DNSQueryEvent{
+				Time:      ev.Time,
+				Name:      ev.Name.WithTrailingDot(),
+				Type:      ev.Type,
+				Took:      ev.Took,
+				Forwarded: ev.Forwarded,
+				Upstream:  ev.Upstream,
+				Cached:    ev.Cached,
+				RCode:     ev.RCode,
+				Error:     ev.Err,
+			})
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", j); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
+// serveDNSConfig returns the DNS configuration tailscaled currently has
+// applied to the OS and to its internal resolver.
+func (h *Handler) serveDNSConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "dns-config access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	var res apitype.DNSConfigResponse
+	if ocfg, rcfg, ok := h.b.DNSAppliedConfig(); ok {
+		res = dnsConfigResponse(ocfg, rcfg)
+		res.SplitDNS = h.b.DNSSplitActive()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// dnsConfigResponse returns the dns-config response for the applied
+// configurations ocfg and rcfg.
+func dnsConfigResponse(ocfg dns.OSConfig, rcfg resolver.Config) apitype.DNSConfigResponse {
+	res := apitype.DNSConfigResponse{
+		Applied: true,
+		OS: apitype.DNSOSConfig{
+			Nameservers:   ocfg.Nameservers,
+			SearchDomains: fqdnStrings(ocfg.SearchDomains),
+			MatchDomains:  fqdnStrings(ocfg.MatchDomains),
+			NumHosts:      len(ocfg.Hosts),
+		},
+		Resolver: apitype.DNSResolverConfig{
+			LocalDomains: fqdnStrings(rcfg.LocalDomains),
+			NumHosts:     len(rcfg.Hosts),
+		},
+	}
+	for suffix, rs := range rcfg.Routes {
+		mak.Set(&res.Resolver.Routes, suffix.WithTrailingDot(), resolverAddrs(rs))
+	}
+	return res
+}
+
+func fqdnStrings(names []dnsname.FQDN) []string {
+	var ret []string
+	for _, n := range names {
+		ret = append(ret, n.WithTrailingDot())
+	}
+	return ret
+}
+
+// serveVersion returns metadata about the tailscaled build, beyond the
+// version in the Tailscale-Version header.
+func (h *Handler) serveVersion(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "version access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	var settings []debug.BuildSetting
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		settings = bi.Settings
+	}
+	res := versionResponse(version.GetMeta(), settings)
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// versionResponse builds the version response from the build's version
+// metadata and its Go build settings.
+func versionResponse(m version.Meta, settings []debug.BuildSetting) apitype.VersionResponse {
+	res := apitype.VersionResponse{
+		MajorMinorPatch: m.MajorMinorPatch,
+		Short:           m.Short,
+		Long:            m.Long,
+		IsDev:           m.IsDev,
+		UnstableBranch:  m.UnstableBranch,
+		GitCommit:       m.GitCommit,
+		GitDirty:        m.GitDirty,
+		ExtraGitCommit:  m.ExtraGitCommit,
+		GitCommitTime:   m.GitCommitTime,
+		Cap:             tailcfg.CapabilityVersion(m.Cap),
+		GoVersion:       runtime.Version(),
+		OS:              runtime.GOOS,
+		Arch:            runtime.GOARCH,
+		AWS:             !omit.AWS,
+	}
+	for _, s := range settings {
+		if s.Key == "-tags" && s.Value != "" {
+			res.BuildTags = strings.Split(s.Value, ",")
+		}
+	}
+	return res
+}
+
+// serveConnections returns the flows tailscaled recently carried traffic
+// for, most recently active first. Flows are only tracked while they're
+// being asked for; see LocalBackend.ActiveConnections.
+func (h *Handler) serveConnections(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "connections access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	res := h.b.ActiveConnections()
+	mak.NonNilSliceForJSON(&res)
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// serveTailnetDNSConfig returns the DNS configuration the control plane
+// pushed to this node in its netmap, as opposed to the configuration
+// applied to the OS.
+func (h *Handler) serveTailnetDNSConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "dns tailnet-config access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	nm := h.b.NetMap()
+	if nm == nil {
+		http.Error(w, "no netmap", http.StatusServiceUnavailable)
+		return
+	}
+	res := tailnetDNSConfig(&nm.DNS, h.b.Prefs().CorpDNS())
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// tailnetDNSConfig converts the control-provided DNS configuration dc to
+// its LocalAPI representation.
+func tailnetDNSConfig(dc *tailcfg.DNSConfig, acceptDNS bool) *apitype.TailnetDNSConfig {
+	res := &apitype.TailnetDNSConfig{
+		MagicDNS:          dc.Proxied,
+		AcceptDNS:         acceptDNS,
+		Nameservers:       resolverAddrs(dc.Resolvers),
+		FallbackResolvers: resolverAddrs(dc.FallbackResolvers),
+		SearchDomains:     slices.Clone(dc.Domains),
+		CertDomains:       slices.Clone(dc.CertDomains),
+		ExtraRecords:      len(dc.ExtraRecords),
+	}
+	for _, ip := range dc.Nameservers {
+		res.Nameservers = append(res.Nameservers, ip.String())
+	}
+	for suffix, rs := range dc.Routes {
+		addrs := resolverAddrs(rs)
+		mak.NonNilSliceForJSON(&addrs)
+		mak.Set(&res.Routes, suffix, addrs)
+	}
+	return res
+}
+
+// dnsHistory converts changes, oldest first, to their LocalAPI
+// representation, newest first, with each diffed against its predecessor.
+// If limit is positive, at most limit changes are returned.
+func dnsHistory(changes []dns.ConfigChange, limit int) []apitype.DNSConfigChange {
+	res := make([]apitype.DNSConfigChange, len(changes))
+	for i, c := range changes {
+		res[i] = dnsConfigChange(c)
+		if i > 0 {
+			res[i].Diff = diffDNSConfigChanges(&res[i-1], &res[i])
+		}
+	}
+	slices.Reverse(res)
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+func dnsConfigChange(c dns.ConfigChange) apitype.DNSConfigChange {
+	res := apitype.DNSConfigChange{
+		Time:          c.Time,
+		Resolvers:     resolverAddrs(c.Config.DefaultResolvers),
+		OSNameservers: c.OSConfig.Nameservers,
+	}
+	if c.Err != nil {
+		res.Error = c.Err.Error()
+	}
+	for suffix, rs := range c.Config.Routes {
+		mak.Set(&res.Routes, suffix.WithTrailingDot(), resolverAddrs(rs))
+	}
+	for _, sd := range c.Config.SearchDomains {
+		res.SearchDomains = append(res.SearchDomains, sd.WithTrailingDot())
+	}
+	return res
+}
+
+func resolverAddrs(rs []*dnstype.Resolver) []string {
+	var addrs []string
+	for _, r := range rs {
+		addrs = append(addrs, r.Addr)
+	}
+	return addrs
+}
+
+// diffDNSConfigChanges returns how cur differs from prev, or nil if the
+// resolvers, routes, search domains and OS nameservers are all unchanged.
+func diffDNSConfigChanges(prev, cur *apitype.DNSConfigChange) *apitype.DNSConfigDiff {
+	var d apitype.DNSConfigDiff
+	d.ResolversAdded, d.ResolversRemoved = diffStrings(prev.Resolvers, cur.Resolvers)
+	d.SearchDomainsAdded, d.SearchDomainsRemoved = diffStrings(prev.SearchDomains, cur.SearchDomains)
+	d.OSNameserversAdded, d.OSNameserversRemoved = diffStrings(addrStrings(prev.OSNameservers), addrStrings(cur.OSNameservers))
+	d.RoutesAdded, d.RoutesRemoved = diffStrings(slices.Collect(maps.Keys(prev.Routes)), slices.Collect(maps.Keys(cur.Routes)))
+	for suffix, rs := range cur.Routes {
+		if old, ok := prev.Routes[suffix]; ok && !slices.Equal(old, rs) {
+			d.RoutesChanged = append(d.RoutesChanged, suffix)
+		}
+	}
+	slices.Sort(d.RoutesChanged)
+	if reflect.ValueOf(d).IsZero() {
+		return nil
+	}
+	return &d
+}
+
+// diffStrings returns the elements of cur not in prev (added) and those of
+// prev not in cur (removed), each sorted.
+func diffStrings(prev, cur []string) (added, removed []string) {
+	for _, s := range cur {
+		if !slices.Contains(prev, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range prev {
+		if !slices.Contains(cur, s) {
+			removed = append(removed, s)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	return added, removed
+}
+
+func addrStrings(addrs []netip.Addr) []string {
+	var ss []string
+	for _, a := range addrs {
+		ss = append(ss, a.String())
+	}
+	return ss
+}
+
+func (h *Handler) serveDERPMap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.DERPMap())
+}
+
+// serveTuning gets (GET) or sets (POST) the engine's tuning parameters.
+//
+// A POST sets only the parameters present: "keepalive" and "derp-fallback",
+// each a duration. A value of "0" restores that parameter's default.
+func (h *Handler) serveTuning(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case httpm.GET:
+		if !h.PermitRead {
+			http.Error(w, "tuning access denied", http.StatusForbidden)
+			return
+		}
+	case httpm.POST:
+		if !h.PermitWrite {
+			http.Error(w, "tuning access denied", http.StatusForbidden)
+			return
+		}
+		t := h.b.Tuning()
+		for _, p := range []struct {
+			name string
+			dst  *time.Duration
+		}{
+			{"keepalive", &t.KeepaliveInterval},
+			{"derp-fallback", &t.DERPFallbackAfter},
+		} {
+			v := r.FormValue(p.name)
+			if v == "" {
+				continue
+			}
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				http.Error(w, fmt.Sprintf("invalid %q parameter", p.name), http.StatusBadRequest)
+				return
+			}
+			*p.dst = d
+		}
+		if err := h.b.SetTuning(t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.Tuning())
+}
+
+// netcheckTimeout is how long serveNetcheck waits for a netcheck report.
+const netcheckTimeout = 10 * time.Second
+
+// serveNetcheck runs a netcheck and returns its netcheck.Report. With a
+// "full" parameter, all DERP regions are probed rather than only the
+// quickest few. If a netcheck is already running, its report is returned.
+func (h *Handler) serveNetcheck(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "netcheck access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
 		return
 	}
-	ctx := r.Context()
-	err := h.b.SetDNS(ctx, r.FormValue("name"), r.FormValue("value"))
+	ctx, cancel := context.WithTimeout(r.Context(), netcheckTimeout)
+	defer cancel()
+	report, err := h.b.Netcheck(ctx, defBool(r.FormValue("full"), false))
 	if err != nil {
-		writeErrorJSON(w, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(struct{}{})
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(report)
 }
 
-func (h *Handler) serveDERPMap(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "want GET", http.StatusBadRequest)
+// defaultDERPMeasureTimeout is how long serveDERPMeasure waits for a DERP
+// latency measurement if the request doesn't specify a timeout.
+const defaultDERPMeasureTimeout = 5 * time.Second
+
+// serveDERPMeasure forces an immediate re-measurement of DERP region
+// latencies and returns the fresh results. The optional "region" parameter
+// limits the results to a single region ID and "timeout" bounds how long to
+// wait for the measurement.
+func (h *Handler) serveDERPMeasure(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "derp measure access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	timeout := defaultDERPMeasureTimeout
+	if v := r.FormValue("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid 'timeout' parameter", http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+	var regionID int
+	if v := r.FormValue("region"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid 'region' parameter", http.StatusBadRequest)
+			return
+		}
+		regionID = n
+	}
+	dm := h.b.DERPMap()
+	if dm == nil {
+		http.Error(w, "no DERP map", http.StatusServiceUnavailable)
+		return
+	}
+	if regionID != 0 && dm.Regions[regionID] == nil {
+		http.Error(w, fmt.Sprintf("unknown DERP region %d", regionID), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	report, err := h.b.MeasureDERPLatency(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	e := json.NewEncoder(w)
 	e.SetIndent("", "\t")
-	e.Encode(h.b.DERPMap())
+	e.Encode(derpMeasureResponse(report, dm, regionID))
+}
+
+// derpMeasureResponse returns the derp/measure response for report. If
+// regionID is non-zero, only that region is included.
+func derpMeasureResponse(report *netcheck.Report, dm *tailcfg.DERPMap, regionID int) apitype.DERPMeasureResponse {
+	res := apitype.DERPMeasureResponse{
+		PreferredDERP: report.PreferredDERP,
+		Regions:       []apitype.DERPRegionLatency{},
+	}
+	for rid, d := range report.RegionLatency {
+		if regionID != 0 && rid != regionID {
+			continue
+		}
+		rl := apitype.DERPRegionLatency{
+			RegionID:  rid,
+			Latency:   d,
+			LatencyV4: report.RegionV4Latency[rid],
+			LatencyV6: report.RegionV6Latency[rid],
+		}
+		if reg := dm.Regions[rid]; reg != nil {
+			rl.RegionCode = reg.RegionCode
+		}
+		res.Regions = append(res.Regions, rl)
+	}
+	slices.SortFunc(res.Regions, func(a, b apitype.DERPRegionLatency) int {
+		return cmp.Or(cmp.Compare(a.Latency, b.Latency), cmp.Compare(a.RegionID, b.RegionID))
+	})
+	return res
 }
 
 // serveSetExpirySooner sets the expiry date on the current machine, specified
@@ -1911,6 +3332,108 @@ func (h *Handler) serveSetExpirySooner(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "done\n")
 }
 
+// serveInventory returns an apitype.NodeInventory describing the local node.
+func (h *Handler) serveInventory(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "inventory access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	hi := h.b.Hostinfo()
+	if hi == nil {
+		hi = hostinfo.New()
+	}
+	inv := nodeInventory(hi, h.b.Prefs(), h.b.NetMap())
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(inv)
+}
+
+// nodeInventory builds the inventory response from the node's Hostinfo,
+// its prefs, and its current netmap. The prefs may be invalid and nm may
+// be nil if the node isn't logged in.
+func nodeInventory(hi *tailcfg.Hostinfo, prefs ipn.PrefsView, nm *netmap.NetworkMap) *apitype.NodeInventory {
+	inv := &apitype.NodeInventory{
+		Hostname:  hi.Hostname,
+		OS:        hi.OS,
+		OSVersion: hi.OSVersion,
+		Version:   version.Long(),
+		Hostinfo:  hi,
+	}
+	if prefs.Valid() {
+		inv.AdvertisedRoutes = prefs.AdvertiseRoutes().AsSlice()
+	}
+	if nm != nil && nm.SelfNode.Valid() {
+		self := nm.SelfNode
+		inv.StableID = self.StableID()
+		inv.DNSName = strings.TrimSuffix(self.Name(), ".")
+		inv.Tags = self.Tags().AsSlice()
+		if t := self.KeyExpiry(); !t.IsZero() {
+			inv.KeyExpiry = &t
+		}
+		for _, pfx := range nm.GetAddresses().All() {
+			if pfx.IsSingleIP() {
+				inv.TailscaleIPs = append(inv.TailscaleIPs, pfx.Addr())
+			}
+		}
+	}
+	mak.NonNilSliceForJSON(&inv.TailscaleIPs)
+	return inv
+}
+
+// defaultKeyExpiryWarning is the default threshold within which
+// serveKeyExpiry reports an upcoming node key expiry as a warning.
+const defaultKeyExpiryWarning = 7 * 24 * time.Hour
+
+// serveKeyExpiry reports when the current node key expires and whether that's
+// within an optional `threshold` duration (e.g. "48h") of now.
+func (h *Handler) serveKeyExpiry(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "key-expiry access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	threshold := defaultKeyExpiryWarning
+	if v := r.FormValue("threshold"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid 'threshold' parameter", http.StatusBadRequest)
+			return
+		}
+		threshold = d
+	}
+	nm := h.b.NetMap()
+	if nm == nil || !nm.SelfNode.Valid() {
+		http.Error(w, "no netmap", http.StatusServiceUnavailable)
+		return
+	}
+	res := keyExpiryResponse(nm.SelfNode.KeyExpiry(), h.clock.Now(), threshold)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// keyExpiryResponse returns the key-expiry response for a node key expiring
+// at expiry, as of now. A zero expiry means key expiry is disabled.
+func keyExpiryResponse(expiry, now time.Time, threshold time.Duration) apitype.KeyExpiryResponse {
+	if expiry.IsZero() {
+		return apitype.KeyExpiryResponse{ExpiryDisabled: true}
+	}
+	remaining := expiry.Sub(now)
+	return apitype.KeyExpiryResponse{
+		Expiry:    expiry,
+		Remaining: remaining,
+		Warning:   remaining <= threshold,
+		Expired:   remaining <= 0,
+	}
+}
+
 func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	if r.Method != "POST" {
@@ -1949,13 +3472,83 @@ func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	res, err := h.b.Ping(ctx, ip, tailcfg.PingType(pingTypeStr), size)
-	if err != nil {
-		writeErrorJSON(w, err)
+	pingType := tailcfg.PingType(pingTypeStr)
+	if !knownPingTypes.Contains(pingType) {
+		http.Error(w, fmt.Sprintf("unknown ping type %q", pingTypeStr), http.StatusBadRequest)
+		return
+	}
+	countStr := r.FormValue("count")
+	if countStr == "" {
+		res, err := h.b.Ping(ctx, ip, pingType, size)
+		if err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+		res.Path = pingPath(res)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 1 || count > maxPingCount {
+		http.Error(w, fmt.Sprintf("'count' must be between 1 and %d", maxPingCount), http.StatusBadRequest)
 		return
 	}
+	results := make([]*ipnstate.PingResult, 0, count)
+	for i := range count {
+		if i > 0 {
+			select {
+			case <-time.After(pingCountInterval):
+			case <-ctx.Done():
+				writeErrorJSON(w, ctx.Err())
+				return
+			}
+		}
+		// Give each ping its own timeout, so a lost one is recorded as
+		// failed rather than holding up the rest.
+		pctx, cancel := context.WithTimeout(ctx, pingCountTimeout)
+		res, err := h.b.Ping(pctx, ip, pingType, size)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				writeErrorJSON(w, ctx.Err())
+				return
+			}
+			res = &ipnstate.PingResult{IP: ip.String(), Err: err.Error()}
+		}
+		res.Path = pingPath(res)
+		results = append(results, res)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(results)
+}
+
+// knownPingTypes are the ping types servePing accepts.
+var knownPingTypes = set.Of(tailcfg.PingDisco, tailcfg.PingTSMP, tailcfg.PingICMP, tailcfg.PingPeerAPI)
+
+const (
+	// maxPingCount is the largest "count" servePing accepts.
+	maxPingCount = 10
+
+	// pingCountInterval is how long servePing waits between pings when
+	// sending more than one.
+	pingCountInterval = time.Second
+
+	// pingCountTimeout is how long servePing waits for each reply when
+	// sending more than one ping.
+	pingCountTimeout = 5 * time.Second
+)
+
+// pingPath returns the value for res.Path: whether the ping was answered
+// over a direct path or via DERP.
+func pingPath(res *ipnstate.PingResult) string {
+	switch {
+	case res.Endpoint != "":
+		return "direct"
+	case res.DERPRegionID != 0:
+		return "derp"
+	}
+	return ""
 }
 
 func (h *Handler) serveDial(w http.ResponseWriter, r *http.Request) {
@@ -2063,8 +3656,13 @@ func (h *Handler) serveUploadClientMetrics(w http.ResponseWriter, r *http.Reques
 	}
 	type clientMetricJSON struct {
 		Name  string `json:"name"`
-		Type  string `json:"type"`  // one of "counter" or "gauge"
-		Value int    `json:"value"` // amount to increment metric by
+		Type  string `json:"type"`  // one of "counter", "gauge" or "histogram"
+		Value int    `json:"value"` // amount to increment metric by, or the observation for a histogram
+
+		// Buckets are the inclusive upper bounds of a histogram's buckets,
+		// used when the histogram is created. If empty, the buckets are
+		// suitable for latencies in milliseconds.
+		Buckets []int64 `json:"buckets,omitempty"`
 	}
 
 	var clientMetrics []clientMetricJSON
@@ -2077,6 +3675,31 @@ type clientMetricJSON struct {
 	defer metricsMu.Unlock()
 
 	for _, m := range clientMetrics {
+		if m.Type == "histogram" {
+			hist, ok := histograms[m.Name]
+			if !ok {
+				if clientmetric.HistogramNameTaken(m.Name) {
+					http.Error(w, "Already have a metric named "+m.Name+" or one of its samples", http.StatusBadRequest)
+					return
+				}
+				buckets := m.Buckets
+				if len(buckets) == 0 {
+					buckets = defaultClientHistogramBuckets
+				}
+				if len(buckets) > maxClientHistogramBuckets {
+					http.Error(w, fmt.Sprintf("Histogram has %d buckets; at most %d are allowed", len(buckets), maxClientHistogramBuckets), http.StatusBadRequest)
+					return
+				}
+				if !slices.IsSorted(buckets) || len(slices.Compact(slices.Clone(buckets))) != len(buckets) {
+					http.Error(w, "Histogram buckets must be ascending", http.StatusBadRequest)
+					return
+				}
+				hist = clientmetric.NewHistogram(m.Name, buckets)
+				histograms[m.Name] = hist
+			}
+			hist.Observe(int64(m.Value))
+			continue
+		}
 		if metric, ok := metrics[m.Name]; ok {
 			metric.Add(int64(m.Value))
 		} else {
@@ -2143,6 +3766,173 @@ type setGUIVisibleRequest struct {
 	w.WriteHeader(http.StatusOK)
 }
 
+// serveSetExitNode sets the exit node to the peer given by the "name" or
+// "ip" form value, or clears it if that's empty, and returns the new prefs.
+func (h *Handler) serveSetExitNode(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	nameOrIP := r.FormValue("name")
+	if nameOrIP == "" {
+		nameOrIP = r.FormValue("ip")
+	}
+	prefs, err := h.b.SetExitNode(nameOrIP)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resJSON{Error: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(prefs)
+}
+
+// serveHostname returns the hostname the node reports to control or, with
+// POST, sets it from the "hostname" parameter. An empty hostname reverts to
+// the OS hostname.
+func (h *Handler) serveHostname(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case httpm.GET:
+		if !h.PermitRead {
+			http.Error(w, "hostname access denied", http.StatusForbidden)
+			return
+		}
+	case httpm.POST:
+		if !h.PermitWrite {
+			http.Error(w, "hostname access denied", http.StatusForbidden)
+			return
+		}
+		if _, err := h.b.SetHostname(strings.TrimSpace(r.FormValue("hostname"))); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resJSON{Error: err.Error()})
+			return
+		}
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var res apitype.HostnameResponse
+	res.Hostname, res.FromPrefs = h.b.ReportedHostname()
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+// serveAcceptedRoutes reports the subnet routes advertised by peers and
+// which are individually accepted (GET), or sets the accepted subset from an
+// apitype.AcceptedRoutesRequest body (POST).
+func (h *Handler) serveAcceptedRoutes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case httpm.GET:
+		if !h.PermitRead {
+			http.Error(w, "accepted-routes access denied", http.StatusForbidden)
+			return
+		}
+	case httpm.POST:
+		if !h.PermitWrite {
+			http.Error(w, "accepted-routes access denied", http.StatusForbidden)
+			return
+		}
+		var req apitype.AcceptedRoutesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.SetAcceptedRoutes(req.Routes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.AcceptedRoutes())
+}
+
+// serveAddresses reports the node's current tailnet addresses and those
+// it had previously.
+func (h *Handler) serveAddresses(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "addresses access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.Addresses())
+}
+
+// serveRoles reports whether the node is an exit node, subnet router or app
+// connector, and how many peers are using it as one.
+func (h *Handler) serveRoles(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "roles access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.Roles())
+}
+
+// serveNotices lists the control plane notices the user hasn't acknowledged
+// (GET) or acknowledges the one given by the "id" parameter (POST). Both
+// respond with the remaining unacknowledged notices.
+func (h *Handler) serveNotices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case httpm.GET:
+		if !h.PermitRead {
+			http.Error(w, "notices access denied", http.StatusForbidden)
+			return
+		}
+	case httpm.POST:
+		if !h.PermitWrite {
+			http.Error(w, "notices access denied", http.StatusForbidden)
+			return
+		}
+		id := r.FormValue("id")
+		if id == "" {
+			http.Error(w, "missing 'id' parameter", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.AckNotice(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	notices := h.b.Notices()
+	if notices == nil {
+		notices = []apitype.Notice{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(notices)
+}
+
 func (h *Handler) serveSetUseExitNodeEnabled(w http.ResponseWriter, r *http.Request) {
 	if r.Method != httpm.POST {
 		http.Error(w, "use POST", http.StatusMethodNotAllowed)
@@ -2576,6 +4366,23 @@ func (h *Handler) serveProfiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if id, ok := strings.CutSuffix(suffix, "/prefs"); ok {
+		if r.Method != httpm.GET {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		prefs, err := h.b.ProfilePrefs(ipn.ProfileID(id))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.Encode(prefs)
+		return
+	}
+
 	profileID := ipn.ProfileID(suffix)
 	switch r.Method {
 	case httpm.GET:
@@ -2735,21 +4542,28 @@ type logRequestJSON struct {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// serveUpdateCheck returns the ClientVersion from Status, which contains
-// information on whether an update is available, and if so, what version,
-// *if* we support auto-updates on this platform. If we don't, this endpoint
-// always returns a ClientVersion saying we're running the newest version.
+// serveUpdateCheck returns an apitype.UpdateCheckResponse. Its ClientVersion
+// is the one from Status, which contains information on whether an update is
+// available, and if so, what version, *if* we support auto-updates on this
+// platform. If we don't, it always says we're running the newest version.
 // Effectively, it tells us whether serveUpdateInstall will be able to install
-// an update for us.
+// an update for us. The response's Latest and UpdateAvailable fields report
+// the newest version control knows of for this platform regardless.
 func (h *Handler) serveUpdateCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "update check access denied", http.StatusForbidden)
+		return
+	}
 	if r.Method != "GET" {
 		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	res := h.b.CheckForUpdate()
 	if !clientupdate.CanAutoUpdate() {
 		// if we don't support auto-update, just say that we're up to date
-		json.NewEncoder(w).Encode(tailcfg.ClientVersion{RunningLatest: true})
+		res.ClientVersion = tailcfg.ClientVersion{RunningLatest: true}
+		json.NewEncoder(w).Encode(res)
 		return
 	}
 
@@ -2761,8 +4575,9 @@ func (h *Handler) serveUpdateCheck(w http.ResponseWriter, r *http.Request) {
 	if cv == nil {
 		cv = &tailcfg.ClientVersion{RunningLatest: true}
 	}
+	res.ClientVersion = *cv
 
-	json.NewEncoder(w).Encode(cv)
+	json.NewEncoder(w).Encode(res)
 }
 
 // serveUpdateInstall sends a request to the LocalBackend to start a Tailscale
@@ -4,6 +4,7 @@
 package localapi
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -19,23 +20,41 @@
 	"net/netip"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
 	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/store/mem"
+	"tailscale.com/logtail"
+	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/omit"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/slicesx"
+	"tailscale.com/version"
 	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/magicsock"
 )
 
 func TestValidHost(t *testing.T) {
@@ -102,6 +121,7 @@ func TestSetPushDeviceToken(t *testing.T) {
 type whoIsBackend struct {
 	whoIs        func(proto string, ipp netip.AddrPort) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool)
 	whoIsNodeKey func(key.NodePublic) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool)
+	whoIsStable  func(tailcfg.StableNodeID) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool)
 	peerCaps     map[netip.Addr]tailcfg.PeerCapMap
 }
 
@@ -113,6 +133,10 @@ func (b whoIsBackend) WhoIsNodeKey(k key.NodePublic) (n tailcfg.NodeView, u tail
 	return b.whoIsNodeKey(k)
 }
 
+func (b whoIsBackend) WhoIsStableID(id tailcfg.StableNodeID) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool) {
+	return b.whoIsStable(id)
+}
+
 func (b whoIsBackend) PeerCaps(ip netip.Addr) tailcfg.PeerCapMap {
 	return b.peerCaps[ip]
 }
@@ -187,6 +211,56 @@ func TestWhoIsArgTypes(t *testing.T) {
 	}
 }
 
+func TestWhoIsStableID(t *testing.T) {
+	h := &Handler{
+		PermitRead: true,
+	}
+	b := whoIsBackend{
+		whoIs: func(proto string, ipp netip.AddrPort) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool) {
+			if ipp.Addr() == netip.MustParseAddr("100.101.102.103") {
+				return (&tailcfg.Node{ID: 1}).View(), tailcfg.UserProfile{}, true
+			}
+			return n, u, false
+		},
+		whoIsStable: func(id tailcfg.StableNodeID) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool) {
+			if id == "nABC" {
+				return (&tailcfg.Node{ID: 2, StableID: id}).View(), tailcfg.UserProfile{}, true
+			}
+			return n, u, false
+		},
+	}
+	tests := []struct {
+		query    string
+		wantCode int
+		wantID   tailcfg.NodeID
+	}{
+		{"stableid=nABC", 200, 2},
+		{"stableid=nXYZ", 404, 0},
+		{"addr=100.101.102.103&stableid=nABC", 200, 1}, // addr wins
+		{"addr=100.1.1.1&stableid=nABC", 200, 2},       // falls back to stableid
+		{"", 400, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.serveWhoIsWithBackend(rec, httptest.NewRequest("GET", "/v0/whois?"+tt.query, nil), b)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("response code %d; want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != 200 {
+				return
+			}
+			var res apitype.WhoIsResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatalf("parsing response %#q: %v", rec.Body.Bytes(), err)
+			}
+			if res.Node.ID != tt.wantID {
+				t.Errorf("res.Node.ID=%v, want %v", res.Node.ID, tt.wantID)
+			}
+		})
+	}
+}
+
 func TestShouldDenyServeConfigForGOOSAndUserContext(t *testing.T) {
 	newHandler := func(connIsLocalAdmin bool) *Handler {
 		return &Handler{testConnIsLocalAdmin: &connIsLocalAdmin}
@@ -414,3 +488,1125 @@ func TestKeepItSorted(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyExpiryResponse(t *testing.T) {
+	now := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		expiry time.Time
+		want   apitype.KeyExpiryResponse
+	}{
+		{
+			name: "disabled",
+			want: apitype.KeyExpiryResponse{ExpiryDisabled: true},
+		},
+		{
+			name:   "far",
+			expiry: now.Add(30 * 24 * time.Hour),
+			want: apitype.KeyExpiryResponse{
+				Expiry:    now.Add(30 * 24 * time.Hour),
+				Remaining: 30 * 24 * time.Hour,
+			},
+		},
+		{
+			name:   "soon",
+			expiry: now.Add(time.Hour),
+			want: apitype.KeyExpiryResponse{
+				Expiry:    now.Add(time.Hour),
+				Remaining: time.Hour,
+				Warning:   true,
+			},
+		},
+		{
+			name:   "expired",
+			expiry: now.Add(-time.Hour),
+			want: apitype.KeyExpiryResponse{
+				Expiry:    now.Add(-time.Hour),
+				Remaining: -time.Hour,
+				Warning:   true,
+				Expired:   true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keyExpiryResponse(tt.expiry, now, 24*time.Hour)
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSHistory(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	res := func(addrs ...string) (ret []*dnstype.Resolver) {
+		for _, a := range addrs {
+			ret = append(ret, &dnstype.Resolver{Addr: a})
+		}
+		return ret
+	}
+	changes := []dns.ConfigChange{
+		{
+			Time: t0,
+			Config: dns.Config{
+				DefaultResolvers: res("1.1.1.1"),
+				SearchDomains:    []dnsname.FQDN{"a.ts.net."},
+			},
+		},
+		{
+			Time: t0.Add(time.Second),
+			Config: dns.Config{
+				DefaultResolvers: res("8.8.8.8"),
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+					"corp.example.": res("10.0.0.1"),
+				},
+				SearchDomains: []dnsname.FQDN{"a.ts.net."},
+			},
+		},
+		{
+			Time: t0.Add(2 * time.Second),
+			Config: dns.Config{
+				DefaultResolvers: res("8.8.8.8"),
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+					"corp.example.": res("10.0.0.2"),
+				},
+				SearchDomains: []dnsname.FQDN{"a.ts.net."},
+			},
+			Err: errors.New("boom"),
+		},
+		{
+			Time: t0.Add(3 * time.Second),
+			Config: dns.Config{
+				DefaultResolvers: res("8.8.8.8"),
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+					"corp.example.": res("10.0.0.2"),
+				},
+				SearchDomains: []dnsname.FQDN{"a.ts.net."},
+			},
+		},
+	}
+
+	got := dnsHistory(changes, 0)
+	if len(got) != len(changes) {
+		t.Fatalf("got %d changes; want %d", len(got), len(changes))
+	}
+	for i, c := range got {
+		if want := changes[len(changes)-1-i].Time; !c.Time.Equal(want) {
+			t.Errorf("change %d at %v; want %v (newest first)", i, c.Time, want)
+		}
+	}
+	if got[0].Diff != nil {
+		t.Errorf("unchanged config has diff %+v; want nil", got[0].Diff)
+	}
+	if want := (&apitype.DNSConfigDiff{RoutesChanged: []string{"corp.example."}}); !reflect.DeepEqual(got[1].Diff, want) {
+		t.Errorf("route change diff = %+v; want %+v", got[1].Diff, want)
+	}
+	if got[1].Error != "boom" {
+		t.Errorf("Error = %q; want %q", got[1].Error, "boom")
+	}
+	want := &apitype.DNSConfigDiff{
+		ResolversAdded:   []string{"8.8.8.8"},
+		ResolversRemoved: []string{"1.1.1.1"},
+		RoutesAdded:      []string{"corp.example."},
+	}
+	if !reflect.DeepEqual(got[2].Diff, want) {
+		t.Errorf("resolver change diff = %+v; want %+v", got[2].Diff, want)
+	}
+	if got[3].Diff != nil {
+		t.Errorf("oldest change has diff %+v; want nil", got[3].Diff)
+	}
+
+	if got := dnsHistory(changes, 2); len(got) != 2 || !got[0].Time.Equal(changes[3].Time) {
+		t.Errorf("dnsHistory with limit 2 = %+v; want the 2 newest", got)
+	}
+}
+
+func TestDERPMeasureResponse(t *testing.T) {
+	dm := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, RegionCode: "nyc"},
+			2: {RegionID: 2, RegionCode: "sfo"},
+			3: {RegionID: 3, RegionCode: "fra"},
+		},
+	}
+	report := &netcheck.Report{
+		PreferredDERP: 2,
+		RegionLatency: map[int]time.Duration{
+			1: 30 * time.Millisecond,
+			2: 10 * time.Millisecond,
+			3: 90 * time.Millisecond,
+		},
+		RegionV4Latency: map[int]time.Duration{
+			1: 30 * time.Millisecond,
+			2: 10 * time.Millisecond,
+			3: 90 * time.Millisecond,
+		},
+		RegionV6Latency: map[int]time.Duration{
+			2: 12 * time.Millisecond,
+		},
+	}
+
+	got := derpMeasureResponse(report, dm, 0)
+	want := apitype.DERPMeasureResponse{
+		PreferredDERP: 2,
+		Regions: []apitype.DERPRegionLatency{
+			{RegionID: 2, RegionCode: "sfo", Latency: 10 * time.Millisecond, LatencyV4: 10 * time.Millisecond, LatencyV6: 12 * time.Millisecond},
+			{RegionID: 1, RegionCode: "nyc", Latency: 30 * time.Millisecond, LatencyV4: 30 * time.Millisecond},
+			{RegionID: 3, RegionCode: "fra", Latency: 90 * time.Millisecond, LatencyV4: 90 * time.Millisecond},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("all regions:\n got %+v\nwant %+v", got, want)
+	}
+
+	got = derpMeasureResponse(report, dm, 3)
+	if len(got.Regions) != 1 || got.Regions[0].RegionID != 3 {
+		t.Errorf("region 3 only: got %+v", got.Regions)
+	}
+}
+
+func TestNetcheckRequiresRead(t *testing.T) {
+	h := &Handler{
+		b:    newTestLocalBackend(t),
+		logf: t.Logf,
+	}
+	for path, serve := range map[string]func(*Handler, http.ResponseWriter, *http.Request){
+		"/localapi/v0/netcheck":     (*Handler).serveNetcheck,
+		"/localapi/v0/derp/measure": (*Handler).serveDERPMeasure,
+	} {
+		rec := httptest.NewRecorder()
+		serve(h, rec, httptest.NewRequest("POST", path, nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s without PermitRead: status = %v; want 403", path, rec.Code)
+		}
+	}
+}
+
+func TestServeRecentLogs(t *testing.T) {
+	logtail.RetainRecentLogs(1 << 10)
+	defer logtail.RetainRecentLogs(0)
+
+	uploads := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer uploads.Close()
+	lg := logtail.NewLogger(logtail.Config{BaseURL: uploads.URL, Stderr: io.Discard}, t.Logf)
+	defer lg.Shutdown(context.Background())
+	fmt.Fprintf(lg, "hello from the recent logs test\n")
+
+	h := &Handler{
+		PermitWrite: true,
+		b:           newTestLocalBackend(t),
+		logf:        t.Logf,
+	}
+	rec := httptest.NewRecorder()
+	h.serveRecentLogs(rec, httptest.NewRequest("GET", "/localapi/v0/logs/recent", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+	}
+	var found bool
+	for _, l := range strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n") {
+		var line struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal([]byte(l), &line); err != nil {
+			t.Fatalf("line %q: %v", l, err)
+		}
+		if strings.Contains(line.Text, "hello from the recent logs test") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("body = %q; missing the logged line", rec.Body)
+	}
+
+	h.PermitWrite = false
+	rec = httptest.NewRecorder()
+	h.serveRecentLogs(rec, httptest.NewRequest("GET", "/localapi/v0/logs/recent", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitWrite: status = %v; want 403", rec.Code)
+	}
+}
+
+func TestBugReportBundle(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+
+	h := &Handler{
+		PermitRead: true,
+		b:          newTestLocalBackend(t),
+		logf:       t.Logf,
+		clock:      tstime.StdClock{},
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	bugReport := func(accept string) *http.Response {
+		req, err := http.NewRequest("POST", s.URL+"/localapi/v0/bugreport?diagnose=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := s.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status = %v", res.Status)
+		}
+		return res
+	}
+
+	// Without asking for a zip, the response is just the marker.
+	res := bugReport("")
+	if got := res.Header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Content-Type = %q; want text/plain", got)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if !strings.HasPrefix(string(body), "BUG-") {
+		t.Errorf("body = %q; want marker", body)
+	}
+
+	res = bugReport("application/zip")
+	if got := res.Header.Get("Content-Type"); got != "application/zip" {
+		t.Fatalf("Content-Type = %q; want application/zip", got)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	for _, want := range []string{"marker.txt", "prefs.json", "logs.txt"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("bundle files = %q; missing %q", names, want)
+		}
+	}
+
+	// The logs need write access, as for logs/recent.
+	f, err := zr.Open("logs.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, _ := io.ReadAll(f)
+	if !strings.HasPrefix(string(logs), "logs omitted") {
+		t.Errorf("logs.txt without PermitWrite = %q; want them omitted", logs)
+	}
+}
+
+func TestStatusETag(t *testing.T) {
+	h := &Handler{
+		PermitRead: true,
+		b:          newTestLocalBackend(t),
+		logf:       t.Logf,
+	}
+
+	status := func(query, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/localapi/v0/status"+query, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.serveStatus(rec, req)
+		return rec
+	}
+
+	full := status("", "")
+	if full.Code != http.StatusOK {
+		t.Fatalf("status = %v", full.Code)
+	}
+	etag := full.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if rec := status("", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("matching If-None-Match: status = %v, body len %d; want 304 and empty body", rec.Code, rec.Body.Len())
+	}
+	if rec := status("", `"stale", W/`+etag); rec.Code != http.StatusNotModified {
+		t.Errorf("weak ETag in list: status = %v; want 304", rec.Code)
+	}
+	if rec := status("", `"stale"`); rec.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match: status = %v; want 200", rec.Code)
+	}
+
+	noPeers := status("?peers=false", "")
+	if got := noPeers.Header().Get("ETag"); got == "" || got == etag {
+		t.Errorf("peers=false ETag = %q; want non-empty and distinct from %q", got, etag)
+	}
+	if rec := status("?peers=false", etag); rec.Code != http.StatusOK {
+		t.Errorf("peers=false with full ETag: status = %v; want 200", rec.Code)
+	}
+
+	// Any IPN bus notification, such as for a prefs change, invalidates
+	// the ETag.
+	if _, err := h.b.EditPrefs(&ipn.MaskedPrefs{
+		Prefs:       ipn.Prefs{Hostname: "renamed"},
+		HostnameSet: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := status("", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("after prefs change: status = %v, ETag %q; want 200 and a new ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestStatusWatch(t *testing.T) {
+	h := &Handler{
+		PermitRead: true,
+		b:          newTestLocalBackend(t),
+		logf:       t.Logf,
+	}
+
+	watch := func(query, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/localapi/v0/status-watch"+query, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.serveStatusWatch(rec, req)
+		return rec
+	}
+
+	etag := h.statusETag(true)
+
+	// A stale ETag returns the current status straight away.
+	start := time.Now()
+	rec := watch("?timeout=1m", `"stale"`)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Errorf("stale ETag: status = %v, ETag %q; want 200 and %q", rec.Code, rec.Header().Get("ETag"), etag)
+	}
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("stale ETag blocked for %v", d)
+	}
+
+	// An unchanged status times out with 304 when the client sent its ETag,
+	// and with the current status when it didn't.
+	if rec := watch("?timeout=50ms", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("unchanged with ETag: status = %v; want 304", rec.Code)
+	}
+	if rec := watch("?timeout=50ms", ""); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("unchanged without ETag: status = %v, body len %d; want 200 with body", rec.Code, rec.Body.Len())
+	}
+
+	if rec := watch("?timeout=bogus", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad timeout: status = %v; want 400", rec.Code)
+	}
+}
+
+func TestServeDebugBreakDERPConnections(t *testing.T) {
+	h := &Handler{
+		PermitWrite: true,
+		b:           newTestLocalBackend(t),
+		logf:        t.Logf,
+	}
+	for _, action := range []string{"break-derp-conns", "break-derp-connections"} {
+		req := httptest.NewRequest("POST", "/localapi/v0/debug?action="+action, nil)
+		rec := httptest.NewRecorder()
+		h.serveDebug(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %v: %s", action, rec.Code, rec.Body)
+		}
+		if got, want := rec.Body.String(), "done; closed 0 DERP connections\n"; got != want {
+			t.Errorf("%s: body = %q; want %q", action, got, want)
+		}
+	}
+
+	h.PermitWrite = false
+	rec := httptest.NewRecorder()
+	h.serveDebug(rec, httptest.NewRequest("POST", "/localapi/v0/debug?action=break-derp-connections", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitWrite: status = %v; want 403", rec.Code)
+	}
+}
+
+func TestServeDebugDisableDERP(t *testing.T) {
+	h := &Handler{
+		PermitWrite: true,
+		b:           newTestLocalBackend(t),
+		logf:        t.Logf,
+	}
+	do := func(action string) apitype.DERPDisabledResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.serveDebug(rec, httptest.NewRequest("POST", "/localapi/v0/debug?action="+action, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %v: %s", action, rec.Code, rec.Body)
+		}
+		var res apitype.DERPDisabledResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: %v", action, err)
+		}
+		return res
+	}
+	if res := do("disable-derp"); !res.DERPDisabled {
+		t.Errorf("disable-derp: got %+v; want DERPDisabled", res)
+	}
+	if res := do("enable-derp"); res.DERPDisabled {
+		t.Errorf("enable-derp: got %+v; want !DERPDisabled", res)
+	}
+
+	h.PermitWrite = false
+	rec := httptest.NewRecorder()
+	h.serveDebug(rec, httptest.NewRequest("POST", "/localapi/v0/debug?action=disable-derp", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitWrite: status = %v; want 403", rec.Code)
+	}
+}
+
+func TestServeDebugTrace(t *testing.T) {
+	h := &Handler{
+		PermitWrite: true,
+		b:           newTestLocalBackend(t),
+		logf:        t.Logf,
+		clock:       tstime.StdClock{},
+	}
+	trace := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.serveDebug(rec, httptest.NewRequest("POST", "/localapi/v0/debug?action=trace&"+query, nil))
+		return rec
+	}
+	for _, q := range []string{
+		"subsystem=wgengine&seconds=-1",
+		"subsystem=wgengine&seconds=86400",
+		"subsystem=wgengine",
+		"subsystem=bogus&seconds=60",
+	} {
+		if rec := trace(q); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %v; want 400", q, rec.Code)
+		}
+	}
+
+	rec := trace("subsystem=wgengine&seconds=60")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+	}
+	var got []apitype.DebugTrace
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Subsystem != "wgengine" || time.Until(got[0].Until) <= 0 {
+		t.Errorf("active traces = %+v; want wgengine", got)
+	}
+
+	if rec := trace("subsystem=wgengine&seconds=0"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("stopping trace: status = %v, body %q; want 200, []", rec.Code, rec.Body)
+	}
+}
+
+func TestServeWatchDNSDisabled(t *testing.T) {
+	h := &Handler{
+		PermitRead: true,
+		b:          newTestLocalBackend(t),
+		logf:       t.Logf,
+	}
+	rec := httptest.NewRecorder()
+	h.serveWatchDNS(rec, httptest.NewRequest("GET", "/localapi/v0/watch-dns", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitWrite: status = %v; want 403", rec.Code)
+	}
+
+	h.PermitWrite = true
+	rec = httptest.NewRecorder()
+	h.serveWatchDNS(rec, httptest.NewRequest("GET", "/localapi/v0/watch-dns", nil))
+	if rec.Code != http.StatusPreconditionFailed || !strings.Contains(rec.Body.String(), "TS_DEBUG_DNS_QUERY_LOG") {
+		t.Errorf("with query logging disabled: status = %v, body %q; want 412 mentioning TS_DEBUG_DNS_QUERY_LOG", rec.Code, rec.Body)
+	}
+}
+
+func TestServePingValidation(t *testing.T) {
+	h := &Handler{
+		PermitRead: true,
+		b:          newTestLocalBackend(t),
+		logf:       t.Logf,
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"ip=100.64.0.1&type=dsico", `unknown ping type "dsico"`},
+		{"ip=100.64.0.1&type=disco&count=0", "'count' must be between 1 and 10"},
+		{"ip=100.64.0.1&type=disco&count=11", "'count' must be between 1 and 10"},
+		{"ip=100.64.0.1&type=disco&count=x", "'count' must be between 1 and 10"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.servePing(rec, httptest.NewRequest("POST", "/localapi/v0/ping?"+tt.query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %v; want 400", tt.query, rec.Code)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+			t.Errorf("%s: body = %q; want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestPingPath(t *testing.T) {
+	tests := []struct {
+		res  ipnstate.PingResult
+		want string
+	}{
+		{ipnstate.PingResult{Endpoint: "1.2.3.4:41641"}, "direct"},
+		{ipnstate.PingResult{DERPRegionID: 1, DERPRegionCode: "nyc"}, "derp"},
+		{ipnstate.PingResult{}, ""},
+	}
+	for _, tt := range tests {
+		if got := pingPath(&tt.res); got != tt.want {
+			t.Errorf("pingPath(%+v) = %q; want %q", tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestDNSConfigResponse(t *testing.T) {
+	ocfg := dns.OSConfig{
+		Nameservers:   []netip.Addr{netip.MustParseAddr("100.100.100.100")},
+		SearchDomains: []dnsname.FQDN{"tail1234.ts.net."},
+		MatchDomains:  []dnsname.FQDN{"corp.example.", "tail1234.ts.net."},
+	}
+	rcfg := resolver.Config{
+		Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+			"corp.example.": {{Addr: "10.0.0.53"}},
+		},
+		Hosts: map[dnsname.FQDN][]netip.Addr{
+			"foo.tail1234.ts.net.": {netip.MustParseAddr("100.64.0.1")},
+		},
+		LocalDomains: []dnsname.FQDN{"tail1234.ts.net."},
+	}
+	got := dnsConfigResponse(ocfg, rcfg)
+	want := apitype.DNSConfigResponse{
+		Applied: true,
+		OS: apitype.DNSOSConfig{
+			Nameservers:   []netip.Addr{netip.MustParseAddr("100.100.100.100")},
+			SearchDomains: []string{"tail1234.ts.net."},
+			MatchDomains:  []string{"corp.example.", "tail1234.ts.net."},
+		},
+		Resolver: apitype.DNSResolverConfig{
+			Routes:       map[string][]string{"corp.example.": {"10.0.0.53"}},
+			LocalDomains: []string{"tail1234.ts.net."},
+			NumHosts:     1,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestVersionResponse(t *testing.T) {
+	m := version.Meta{
+		MajorMinorPatch: "1.75.0",
+		Short:           "1.75.0-dev20240801",
+		Long:            "1.75.0-dev20240801-tabc123",
+		IsDev:           true,
+		GitCommit:       "abc123",
+		GitDirty:        true,
+		Cap:             104,
+	}
+	settings := []debug.BuildSetting{
+		{Key: "-compiler", Value: "gc"},
+		{Key: "-tags", Value: "ts_omit_aws,ts_include_cli"},
+		{Key: "vcs.modified", Value: "true"},
+	}
+	got := versionResponse(m, settings)
+	want := apitype.VersionResponse{
+		MajorMinorPatch: "1.75.0",
+		Short:           "1.75.0-dev20240801",
+		Long:            "1.75.0-dev20240801-tabc123",
+		IsDev:           true,
+		GitCommit:       "abc123",
+		GitDirty:        true,
+		Cap:             104,
+		GoVersion:       runtime.Version(),
+		OS:              runtime.GOOS,
+		Arch:            runtime.GOARCH,
+		BuildTags:       []string{"ts_omit_aws", "ts_include_cli"},
+		AWS:             !omit.AWS,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if got := versionResponse(m, nil); got.BuildTags != nil {
+		t.Errorf("no build settings: BuildTags = %q; want nil", got.BuildTags)
+	}
+
+	// The version fields must keep version.Meta's JSON names, which
+	// clients already know from "tailscale version --json".
+	var fromMeta, fromRes map[string]any
+	mj, _ := json.Marshal(m)
+	rj, _ := json.Marshal(got)
+	if err := json.Unmarshal(mj, &fromMeta); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(rj, &fromRes); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range fromMeta {
+		if !reflect.DeepEqual(fromRes[k], v) {
+			t.Errorf("JSON field %q = %v; want %v as in version.Meta", k, fromRes[k], v)
+		}
+	}
+}
+
+func TestTailnetDNSConfig(t *testing.T) {
+	dc := &tailcfg.DNSConfig{
+		Proxied:           true,
+		Resolvers:         []*dnstype.Resolver{{Addr: "1.1.1.1"}},
+		Nameservers:       []netip.Addr{netip.MustParseAddr("8.8.8.8")},
+		FallbackResolvers: []*dnstype.Resolver{{Addr: "9.9.9.9"}},
+		Routes: map[string][]*dnstype.Resolver{
+			"corp.example.":    {{Addr: "10.0.0.53"}},
+			"tail1234.ts.net.": nil,
+		},
+		Domains:      []string{"tail1234.ts.net"},
+		CertDomains:  []string{"box.tail1234.ts.net"},
+		ExtraRecords: []tailcfg.DNSRecord{{Name: "foo.example.", Value: "100.64.0.2"}},
+	}
+	got := tailnetDNSConfig(dc, true)
+	want := &apitype.TailnetDNSConfig{
+		MagicDNS:          true,
+		AcceptDNS:         true,
+		Nameservers:       []string{"1.1.1.1", "8.8.8.8"},
+		FallbackResolvers: []string{"9.9.9.9"},
+		Routes: map[string][]string{
+			"corp.example.":    {"10.0.0.53"},
+			"tail1234.ts.net.": {},
+		},
+		SearchDomains: []string{"tail1234.ts.net"},
+		CertDomains:   []string{"box.tail1234.ts.net"},
+		ExtraRecords:  1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	// A local-only route must encode as an empty list, not null, so
+	// clients can tell it apart from a missing suffix.
+	j, err := json.Marshal(got.Routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"corp.example.":["10.0.0.53"],"tail1234.ts.net.":[]}`; string(j) != want {
+		t.Errorf("routes JSON = %s; want %s", j, want)
+	}
+}
+
+func TestServeTailnetDNSConfig(t *testing.T) {
+	h := &Handler{
+		b:    newTestLocalBackend(t),
+		logf: t.Logf,
+	}
+	rec := httptest.NewRecorder()
+	h.serveTailnetDNSConfig(rec, httptest.NewRequest("GET", "/localapi/v0/dns/tailnet-config", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitRead: status = %v; want 403", rec.Code)
+	}
+
+	h.PermitRead = true
+	rec = httptest.NewRecorder()
+	h.serveTailnetDNSConfig(rec, httptest.NewRequest("GET", "/localapi/v0/dns/tailnet-config", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without netmap: status = %v; want 503", rec.Code)
+	}
+}
+
+func TestPeerLatencySamples(t *testing.T) {
+	if got := peerLatencySamples(nil); got == nil || len(got) != 0 {
+		t.Errorf("no samples: got %#v; want empty, non-nil slice", got)
+	}
+
+	t0 := time.Unix(1700000000, 0)
+	got := peerLatencySamples([]magicsock.LatencySample{
+		{When: t0, Latency: 80500 * time.Microsecond, Addr: netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 7), DERP: true},
+		{When: t0.Add(time.Second), Latency: 10 * time.Millisecond, Addr: netip.MustParseAddrPort("192.168.1.2:41641")},
+	})
+	want := []apitype.PeerLatencySample{
+		{Time: t0, LatencyMs: 80.5, DERPRegion: 7},
+		{Time: t0.Add(time.Second), LatencyMs: 10, Endpoint: "192.168.1.2:41641"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestServePeerLatencyValidation(t *testing.T) {
+	h := &Handler{
+		b:    newTestLocalBackend(t),
+		logf: t.Logf,
+	}
+	do := func(method, query string) int {
+		rec := httptest.NewRecorder()
+		h.servePeerLatency(rec, httptest.NewRequest(method, "/localapi/v0/peer-latency"+query, nil))
+		return rec.Code
+	}
+	if code := do("GET", "?stableid=nFoo"); code != http.StatusForbidden {
+		t.Errorf("without PermitRead: status = %v; want 403", code)
+	}
+	h.PermitRead = true
+	if code := do("POST", "?stableid=nFoo"); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %v; want 405", code)
+	}
+	if code := do("GET", ""); code != http.StatusBadRequest {
+		t.Errorf("missing stableid: status = %v; want 400", code)
+	}
+	if code := do("GET", "?stableid=nFoo"); code != http.StatusServiceUnavailable {
+		t.Errorf("without netmap: status = %v; want 503", code)
+	}
+}
+
+func TestNodeInventory(t *testing.T) {
+	hi := &tailcfg.Hostinfo{Hostname: "box", OS: "linux", OSVersion: "Debian 12"}
+
+	inv := nodeInventory(hi, ipn.PrefsView{}, nil)
+	if inv.StableID != "" || inv.KeyExpiry != nil || inv.AdvertisedRoutes != nil {
+		t.Errorf("logged out: got %+v; want no node fields", inv)
+	}
+	if inv.TailscaleIPs == nil {
+		t.Error("logged out: TailscaleIPs is nil; want empty, non-nil slice for JSON")
+	}
+
+	expiry := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	prefs := &ipn.Prefs{
+		AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+	}
+	nm := &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			StableID:  "nStable",
+			Name:      "box.tail1234.ts.net.",
+			Tags:      []string{"tag:server"},
+			KeyExpiry: expiry,
+			Addresses: []netip.Prefix{
+				netip.MustParsePrefix("100.64.0.1/32"),
+				netip.MustParsePrefix("fd7a:115c:a1e0::1/128"),
+				netip.MustParsePrefix("10.1.0.0/16"),
+			},
+		}).View(),
+	}
+	inv = nodeInventory(hi, prefs.View(), nm)
+	want := &apitype.NodeInventory{
+		StableID:         "nStable",
+		DNSName:          "box.tail1234.ts.net",
+		Hostname:         "box",
+		OS:               "linux",
+		OSVersion:        "Debian 12",
+		Version:          inv.Version,
+		TailscaleIPs:     []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")},
+		Tags:             []string{"tag:server"},
+		AdvertisedRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+		KeyExpiry:        &expiry,
+		Hostinfo:         hi,
+	}
+	if inv.Version == "" {
+		t.Error("Version is empty")
+	}
+	if !reflect.DeepEqual(inv, want) {
+		t.Errorf("got %+v\nwant %+v", inv, want)
+	}
+}
+
+func TestServeInventoryPermissions(t *testing.T) {
+	h := &Handler{
+		b:    newTestLocalBackend(t),
+		logf: t.Logf,
+	}
+	rec := httptest.NewRecorder()
+	h.serveInventory(rec, httptest.NewRequest("GET", "/localapi/v0/inventory", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitRead: status = %v; want 403", rec.Code)
+	}
+
+	h.PermitRead = true
+	rec = httptest.NewRecorder()
+	h.serveInventory(rec, httptest.NewRequest("GET", "/localapi/v0/inventory", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v; want 200", rec.Code)
+	}
+	var inv apitype.NodeInventory
+	if err := json.Unmarshal(rec.Body.Bytes(), &inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv.OS == "" || inv.Version == "" {
+		t.Errorf("got %+v; want OS and Version set", inv)
+	}
+}
+
+func TestServeHostname(t *testing.T) {
+	h := &Handler{
+		PermitRead:  true,
+		PermitWrite: true,
+		b:           newTestLocalBackend(t),
+		logf:        t.Logf,
+	}
+	do := func(method, query string) (int, apitype.HostnameResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.serveHostname(rec, httptest.NewRequest(method, "/localapi/v0/hostname"+query, nil))
+		var res apitype.HostnameResponse
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, res
+	}
+
+	if code, _ := do("POST", "?hostname=bad_name!"); code != http.StatusBadRequest {
+		t.Errorf("invalid hostname: status = %v; want 400", code)
+	}
+	code, res := do("POST", "?hostname=renamed")
+	if code != http.StatusOK {
+		t.Fatalf("set: status = %v; want 200", code)
+	}
+	if want := (apitype.HostnameResponse{Hostname: "renamed", FromPrefs: true}); res != want {
+		t.Errorf("set: got %+v; want %+v", res, want)
+	}
+	if _, res := do("GET", ""); res.Hostname != "renamed" {
+		t.Errorf("get: hostname = %q; want %q", res.Hostname, "renamed")
+	}
+	if code, res := do("POST", "?hostname="); code != http.StatusOK || res.FromPrefs {
+		t.Errorf("clear: status = %v, FromPrefs = %v; want 200, false", code, res.FromPrefs)
+	}
+
+	h.PermitWrite = false
+	if code, _ := do("POST", "?hostname=other"); code != http.StatusForbidden {
+		t.Errorf("without PermitWrite: status = %v; want 403", code)
+	}
+}
+
+func TestFilePutEventWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ew, ok := newFilePutEventWriter(rec)
+	if !ok {
+		t.Fatal("recorder doesn't support streaming")
+	}
+	ew.send("progress", ipn.OutgoingFile{Name: "a.txt", DeclaredSize: 10, Sent: 4})
+	ww := &multiFilePostResponseWriter{}
+	http.Error(ww, "no space left", http.StatusInsufficientStorage)
+	ew.sendResult(ww)
+
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q; want text/event-stream", got)
+	}
+	want := "event: progress\n" +
+		`data: {"Name":"a.txt","Started":"0001-01-01T00:00:00Z","DeclaredSize":10,"Sent":4,"Finished":false,"Succeeded":false}` + "\n\n" +
+		"event: done\n" +
+		`data: {"StatusCode":507,"Error":"no space left"}` + "\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFilterGoroutines(t *testing.T) {
+	dump := []byte("goroutine 1 [running]:\nmain.main()\n\n" +
+		"goroutine 7 [select]:\ntailscale.com/net/dns.(*Manager).run()\n\n" +
+		"goroutine 9 [IO wait]:\ntailscale.com/net/dns.(*forwarder).send()\n")
+	got, n := filterGoroutines(dump, "net/dns")
+	if n != 2 {
+		t.Errorf("n = %d; want 2", n)
+	}
+	want := "goroutine 7 [select]:\ntailscale.com/net/dns.(*Manager).run()\n\n" +
+		"goroutine 9 [IO wait]:\ntailscale.com/net/dns.(*forwarder).send()\n"
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got, n := filterGoroutines(dump, "nothing"); n != 0 || len(got) != 0 {
+		t.Errorf("no match: got %q, %d; want empty", got, n)
+	}
+}
+
+func TestServeFileSendValidation(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		admin    bool
+		path     string
+		wantCode int
+	}{
+		{"not-admin", false, filepath.Join(dir, "f"), http.StatusForbidden},
+		{"relative", true, "f", http.StatusBadRequest},
+		{"missing", true, filepath.Join(dir, "missing"), http.StatusBadRequest},
+		{"directory", true, dir, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{
+				PermitWrite:          true,
+				testConnIsLocalAdmin: &tt.admin,
+				b:                    newTestLocalBackend(t),
+				logf:                 t.Logf,
+			}
+			body, _ := json.Marshal(apitype.FileSendRequest{StableID: "n1", Path: tt.path})
+			rec := httptest.NewRecorder()
+			h.serveFileSend(rec, httptest.NewRequest("POST", "/localapi/v0/file-send", bytes.NewReader(body)))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %v; want %v (%s)", rec.Code, tt.wantCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServeMetricsFormat(t *testing.T) {
+	h := &Handler{
+		PermitRead: true,
+		b:          newTestLocalBackend(t),
+		logf:       t.Logf,
+	}
+	rec := httptest.NewRecorder()
+	h.serveMetrics(rec, httptest.NewRequest("GET", "/localapi/v0/metrics", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitWrite: status = %v; want 403", rec.Code)
+	}
+
+	h.PermitWrite = true
+	tests := []struct {
+		name            string
+		url             string
+		accept          string
+		wantOpenMetrics bool
+	}{
+		{name: "default", url: "/localapi/v0/metrics"},
+		{name: "accept", url: "/localapi/v0/metrics", accept: "application/openmetrics-text; version=1.0.0", wantOpenMetrics: true},
+		{name: "query", url: "/localapi/v0/metrics?format=openmetrics", wantOpenMetrics: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.serveMetrics(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %v; want 200", rec.Code)
+			}
+			ct := rec.Header().Get("Content-Type")
+			eof := strings.HasSuffix(rec.Body.String(), "# EOF\n")
+			if tt.wantOpenMetrics {
+				if ct != clientmetric.OpenMetricsContentType || !eof {
+					t.Errorf("Content-Type = %q, ends with # EOF = %v; want OpenMetrics", ct, eof)
+				}
+			} else if ct != "text/plain" || eof {
+				t.Errorf("Content-Type = %q, ends with # EOF = %v; want Prometheus text", ct, eof)
+			}
+		})
+	}
+}
+
+func TestServeMetricsJSON(t *testing.T) {
+	h := &Handler{PermitRead: true, logf: t.Logf}
+	rec := httptest.NewRecorder()
+	h.serveMetricsJSON(rec, httptest.NewRequest("GET", "/localapi/v0/metrics.json", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitWrite: status = %v; want 403", rec.Code)
+	}
+
+	h.PermitWrite = true
+	rec = httptest.NewRecorder()
+	h.serveMetricsJSON(rec, httptest.NewRequest("POST", "/localapi/v0/metrics.json", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %v; want 405", rec.Code)
+	}
+
+	c := clientmetric.NewCounter("test_localapi_metrics_json")
+	c.Add(3)
+	rec = httptest.NewRecorder()
+	h.serveMetricsJSON(rec, httptest.NewRequest("GET", "/localapi/v0/metrics.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v; want 200", rec.Code)
+	}
+	var got []clientmetric.MetricSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(got, func(m clientmetric.MetricSnapshot) bool { return m.Name == c.Name() })
+	if i == -1 {
+		t.Fatalf("%s missing from %s", c.Name(), rec.Body)
+	}
+	if got[i].Type != "counter" || got[i].Value != 3 {
+		t.Errorf("got %+v; want counter with value 3", got[i])
+	}
+}
+
+func TestServeUploadClientMetricsHistogram(t *testing.T) {
+	h := &Handler{logf: t.Logf}
+	upload := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.serveUploadClientMetrics(rec, httptest.NewRequest("POST", "/localapi/v0/upload-client-metrics", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := upload(`[{"name":"test_ui_latency_ms","type":"histogram","value":42,"buckets":[10,100]},{"name":"test_ui_latency_ms","type":"histogram","value":7}]`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %v; want 200: %s", rec.Code, rec.Body)
+	}
+	var buf strings.Builder
+	clientmetric.WritePrometheusExpositionFormat(&buf)
+	for _, want := range []string{
+		"# TYPE test_ui_latency_ms histogram\n",
+		`test_ui_latency_ms_bucket{le="10"} 1` + "\n",
+		`test_ui_latency_ms_bucket{le="100"} 2` + "\n",
+		"test_ui_latency_ms_sum 49\n",
+		"test_ui_latency_ms_count 2\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	// A histogram's name can't be reused for another type, nor can it
+	// have unordered buckets.
+	if rec := upload(`[{"name":"test_ui_latency_ms","type":"counter","value":1}]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("counter with histogram's name: status = %v; want 400", rec.Code)
+	}
+	if rec := upload(`[{"name":"test_ui_other_ms","type":"histogram","value":1,"buckets":[10,5]}]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unordered buckets: status = %v; want 400", rec.Code)
+	}
+
+	// Nor can its samples' names be taken by other metrics, or theirs by
+	// its samples.
+	if rec := upload(`[{"name":"test_ui_latency_ms_count","type":"counter","value":1}]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("counter with histogram sample's name: status = %v; want 400", rec.Code)
+	}
+	if rec := upload(`[{"name":"test_ui_taps_sum","type":"counter","value":1},{"name":"test_ui_taps","type":"histogram","value":1}]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("histogram with a sample named like a counter: status = %v; want 400", rec.Code)
+	}
+
+	// Clients can't make histograms with unbounded numbers of buckets.
+	buckets := make([]string, maxClientHistogramBuckets+1)
+	for i := range buckets {
+		buckets[i] = fmt.Sprint(i)
+	}
+	if rec := upload(`[{"name":"test_ui_big_ms","type":"histogram","value":1,"buckets":[` + strings.Join(buckets, ",") + `]}]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("too many buckets: status = %v; want 400", rec.Code)
+	}
+}
+
+func TestServeUpdateCheck(t *testing.T) {
+	h := &Handler{
+		b:    newTestLocalBackend(t),
+		logf: t.Logf,
+	}
+	rec := httptest.NewRecorder()
+	h.serveUpdateCheck(rec, httptest.NewRequest("GET", "/localapi/v0/update/check", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without PermitRead: status = %v; want 403", rec.Code)
+	}
+
+	h.PermitRead = true
+	rec = httptest.NewRecorder()
+	h.serveUpdateCheck(rec, httptest.NewRequest("GET", "/localapi/v0/update/check", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v; body = %s", rec.Code, rec.Body)
+	}
+	var res apitype.UpdateCheckResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	// Without a ClientVersion from control, nothing is known to be newer.
+	if res.Current != version.Short() || res.UpdateAvailable || !res.RunningLatest {
+		t.Errorf("response = %+v; want current version, running latest", res)
+	}
+}
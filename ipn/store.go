@@ -58,6 +58,10 @@
 	// has ever been received (even if partially).
 	// Any non-empty value indicates that at least one file has been received.
 	TaildropReceivedKey = StateKey("_taildrop-received")
+
+	// TuningStateKey is the key under which we store the engine tuning
+	// parameters. The value is a JSON-encoded Tuning.
+	TuningStateKey = StateKey("_tuning")
 )
 
 // CurrentProfileID returns the StateKey that stores the
@@ -71,6 +75,27 @@ func CurrentProfileKey(userID string) StateKey {
 	return StateKey("_current/" + userID)
 }
 
+// AcceptedRoutesKey returns the StateKey under which we store the subnet
+// routes the user has individually chosen to accept while using the given
+// profile. The value is a JSON-encoded list of prefixes.
+func AcceptedRoutesKey(profileID ProfileID) StateKey {
+	return StateKey("_accepted-routes/" + profileID)
+}
+
+// AddressHistoryKey returns the StateKey under which we store the node's
+// current and previous tailnet addresses for a profile. The value is
+// JSON-encoded.
+func AddressHistoryKey(profileID ProfileID) StateKey {
+	return StateKey("_address-history/" + profileID)
+}
+
+// AckedNoticesKey returns the StateKey under which we store the IDs of the
+// control plane notices the user has acknowledged while using the given
+// profile. The value is a JSON-encoded list of strings.
+func AckedNoticesKey(profileID ProfileID) StateKey {
+	return StateKey("_acked-notices/" + profileID)
+}
+
 // StateStore persists state, and produces it back on request.
 // Implementations of StateStore are expected to be safe for concurrent use.
 type StateStore interface {
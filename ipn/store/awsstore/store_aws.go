@@ -10,7 +10,9 @@
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"regexp"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
@@ -45,6 +47,8 @@ type awsSSMClient interface {
 type awsStore struct {
 	ssmClient awsSSMClient
 	ssmARN    arn.ARN
+	kmsKey    string // KMS key to encrypt the parameter with, or empty for the account's default key
+	region    string // region of the SSM API, overriding the ARN's; empty means the ARN's
 
 	memory mem.Store
 }
@@ -57,6 +61,16 @@ type awsStore struct {
 // Tailscaled to only only store new state in-memory and
 // restarting Tailscaled can fail until you delete your state
 // from the AWS Parameter Store.
+//
+// The ARN may be followed by query-style options, as in
+// "arn:aws:ssm:us-east-1:123456789012:parameter/ts?kmsKey=alias/ts&region=us-west-2".
+// The supported options are:
+//
+//   - kmsKey: the ID, ARN or alias of the KMS key to encrypt the
+//     SecureString parameter with, instead of the account's default
+//     key for SSM.
+//   - region: the region of the SSM API to use, instead of the
+//     ARN's.
 func New(_ logger.Logf, ssmARN string) (ipn.StateStore, error) {
 	return newStore(ssmARN, nil)
 }
@@ -70,6 +84,28 @@ func newStore(ssmARN string, client awsSSMClient) (ipn.StateStore, error) {
 
 	var err error
 
+	// Parse the options, if any, which follow the ARN.
+	ssmARN, rawOpts, _ := strings.Cut(ssmARN, "?")
+	if rawOpts != "" {
+		opts, err := url.ParseQuery(rawOpts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the options %q: %v", rawOpts, err)
+		}
+		for k, v := range opts {
+			if len(v) != 1 || v[0] == "" {
+				return nil, fmt.Errorf("option %q must be given exactly one non-empty value", k)
+			}
+			switch k {
+			case "kmsKey":
+				s.kmsKey = v[0]
+			case "region":
+				s.region = v[0]
+			default:
+				return nil, fmt.Errorf("unknown option %q, expected 'kmsKey' or 'region'", k)
+			}
+		}
+	}
+
 	// Parse the ARN
 	if s.ssmARN, err = arn.Parse(ssmARN); err != nil {
 		return nil, fmt.Errorf("unable to parse the ARN correctly: %v", err)
@@ -86,10 +122,14 @@ func newStore(ssmARN string, client awsSSMClient) (ipn.StateStore, error) {
 	}
 
 	if s.ssmClient == nil {
+		region := s.ssmARN.Region
+		if s.region != "" {
+			region = s.region
+		}
 		var cfg aws.Config
 		if cfg, err = config.LoadDefaultConfig(
 			context.TODO(),
-			config.WithRegion(s.ssmARN.Region),
+			config.WithRegion(region),
 		); err != nil {
 			return nil, err
 		}
@@ -172,15 +212,16 @@ func (s *awsStore) persistState() error {
 	// which is free. However, if it exceeds 4kb it switches the parameter to advanced tiering
 	// doubling the capacity to 8kb per the following docs:
 	// https://aws.amazon.com/about-aws/whats-new/2019/08/aws-systems-manager-parameter-store-announces-intelligent-tiering-to-enable-automatic-parameter-tier-selection/
-	_, err = s.ssmClient.PutParameter(
-		context.TODO(),
-		&ssm.PutParameterInput{
-			Name:      aws.String(s.ParameterName()),
-			Value:     aws.String(string(bs)),
-			Overwrite: aws.Bool(true),
-			Tier:      ssmTypes.ParameterTierIntelligentTiering,
-			Type:      ssmTypes.ParameterTypeSecureString,
-		},
-	)
+	in := &ssm.PutParameterInput{
+		Name:      aws.String(s.ParameterName()),
+		Value:     aws.String(string(bs)),
+		Overwrite: aws.Bool(true),
+		Tier:      ssmTypes.ParameterTierIntelligentTiering,
+		Type:      ssmTypes.ParameterTypeSecureString,
+	}
+	if s.kmsKey != "" {
+		in.KeyId = aws.String(s.kmsKey)
+	}
+	_, err = s.ssmClient.PutParameter(context.TODO(), in)
 	return err
 }
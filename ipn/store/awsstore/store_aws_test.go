@@ -19,6 +19,7 @@
 
 type mockedAWSSSMClient struct {
 	value string
+	keyID string // KeyId of the last PutParameter call
 }
 
 func (sp *mockedAWSSSMClient) GetParameter(_ context.Context, input *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
@@ -36,6 +37,7 @@ func (sp *mockedAWSSSMClient) GetParameter(_ context.Context, input *ssm.GetPara
 
 func (sp *mockedAWSSSMClient) PutParameter(_ context.Context, input *ssm.PutParameterInput, _ ...func(*ssm.Options)) (*ssm.PutParameterOutput, error) {
 	sp.value = *input.Value
+	sp.keyID = aws.ToString(input.KeyId)
 	return new(ssm.PutParameterOutput), nil
 }
 
@@ -98,6 +100,50 @@ func TestNewAWSStore(t *testing.T) {
 	}
 }
 
+func TestNewAWSStoreOptions(t *testing.T) {
+	tstest.PanicOnLog()
+
+	const paramARN = "arn:aws:ssm:eu-west-1:123456789:parameter/foo"
+
+	mc := &mockedAWSSSMClient{}
+	s, err := newStore(paramARN+"?kmsKey=alias/tailscale&region=us-west-2", mc)
+	if err != nil {
+		t.Fatalf("creating aws store failed: %v", err)
+	}
+	as := s.(*awsStore)
+	if as.kmsKey != "alias/tailscale" || as.region != "us-west-2" {
+		t.Errorf("got kmsKey %q, region %q; want alias/tailscale, us-west-2", as.kmsKey, as.region)
+	}
+	if got := as.ParameterName(); got != "/foo" {
+		t.Errorf("ParameterName = %q; want /foo", got)
+	}
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if mc.keyID != "alias/tailscale" {
+		t.Errorf("PutParameter KeyId = %q; want alias/tailscale", mc.keyID)
+	}
+
+	// Without options, the default key is used.
+	mc = &mockedAWSSSMClient{}
+	s, err = newStore(paramARN, mc)
+	if err != nil {
+		t.Fatalf("creating aws store failed: %v", err)
+	}
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if mc.keyID != "" {
+		t.Errorf("PutParameter KeyId = %q; want none", mc.keyID)
+	}
+
+	for _, opts := range []string{"?kmsKey=", "?region=a&region=b", "?kmskey=x", "?%zz"} {
+		if _, err := newStore(paramARN+opts, &mockedAWSSSMClient{}); err == nil {
+			t.Errorf("newStore with options %q succeeded; want error", opts)
+		}
+	}
+}
+
 func testStoreSemantics(t *testing.T, store ipn.StateStore) {
 	t.Helper()
 
@@ -0,0 +1,177 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+)
+
+// encryptedPrefix is the prefix of the store argument that selects an
+// EncryptedFileStore, as in "encrypted:/etc/tailscale/state.key:/var/lib/tailscale/tailscaled.state".
+const encryptedPrefix = "encrypted:"
+
+// errDecrypt is returned when a state value can't be decrypted, either
+// because the key is wrong or because the value was tampered with.
+var errDecrypt = errors.New("decrypting state failed; wrong key or corrupted state file")
+
+// newEncryptedStore returns an EncryptedFileStore for arg, which is of the
+// form "encrypted:<keyfile>:<path>". The key file path can't contain a
+// colon, other than after a Windows drive letter.
+func newEncryptedStore(logf logger.Logf, arg string) (ipn.StateStore, error) {
+	rest, _ := strings.CutPrefix(arg, encryptedPrefix)
+	keyFile, path, ok := cutKeyFile(rest, runtime.GOOS)
+	if !ok || keyFile == "" || path == "" {
+		return nil, fmt.Errorf("invalid encrypted store argument %q; want %s<keyfile>:<path>", arg, encryptedPrefix)
+	}
+	return NewEncryptedFileStore(logf, keyFile, path)
+}
+
+// cutKeyFile splits the "<keyfile>:<path>" part of an encrypted store
+// argument at the first colon, skipping the colon of a drive letter such as
+// "C:\" at the start of keyfile on Windows.
+func cutKeyFile(s, goos string) (keyFile, path string, ok bool) {
+	start := 0
+	if goos == "windows" && len(s) >= 3 && isASCIILetter(s[0]) && s[1] == ':' && (s[2] == '\\' || s[2] == '/') {
+		start = 2
+	}
+	i := strings.IndexByte(s[start:], ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:start+i], s[start+i+1:], true
+}
+
+func isASCIILetter(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// EncryptedFileStore is a StateStore that wraps a FileStore, encrypting
+// each state value at rest with XChaCha20-Poly1305 under a key read from a
+// file. Each value is bound to its state key, so values can't be swapped
+// between keys without detection.
+//
+// Only the values are encrypted; the state keys, such as profile IDs,
+// remain in the clear.
+type EncryptedFileStore struct {
+	fs   *FileStore
+	aead cipher.AEAD
+
+	mu sync.Mutex // serializes WriteState
+}
+
+// NewEncryptedFileStore returns a new encrypted file store that persists to
+// path, using the key in keyFile. The key file must contain a 32 byte key,
+// either raw or as 64 hex digits.
+//
+// It returns an error if any state already at path can't be decrypted with
+// the key.
+func NewEncryptedFileStore(logf logger.Logf, keyFile, path string) (*EncryptedFileStore, error) {
+	key, err := readStateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := NewFileStore(logf, path)
+	if err != nil {
+		return nil, err
+	}
+	s := &EncryptedFileStore{
+		fs:   fs.(*FileStore),
+		aead: aead,
+	}
+
+	// Check the key now rather than on first read, so a wrong key is
+	// reported when tailscaled starts.
+	s.fs.mu.RLock()
+	defer s.fs.mu.RUnlock()
+	for id, ct := range s.fs.cache {
+		if _, err := s.open(id, ct); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// readStateKey reads a state encryption key from path.
+func readStateKey(path string) ([]byte, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading state key: %w", err)
+	}
+	if len(bs) == chacha20poly1305.KeySize {
+		return bs, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(bs)))
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("invalid state key in %s; want %d bytes, raw or hex-encoded", path, chacha20poly1305.KeySize)
+	}
+	return key, nil
+}
+
+// Path returns the path of the underlying file store.
+func (s *EncryptedFileStore) Path() string { return s.fs.Path() }
+
+func (s *EncryptedFileStore) String() string {
+	return fmt.Sprintf("EncryptedFileStore(%q)", s.fs.Path())
+}
+
+// ReadState implements the StateStore interface.
+func (s *EncryptedFileStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	ct, err := s.fs.ReadState(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(id, ct)
+}
+
+// WriteState implements the StateStore interface.
+func (s *EncryptedFileStore) WriteState(id ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Every encryption uses a fresh nonce, so the underlying FileStore
+	// can't tell that an unchanged value is unchanged. Check here instead.
+	if old, err := s.ReadState(id); err == nil && bytes.Equal(old, bs) {
+		return nil
+	}
+	return s.fs.WriteState(id, s.seal(id, bs))
+}
+
+// seal encrypts the value of id, returning the nonce followed by the
+// ciphertext.
+func (s *EncryptedFileStore) seal(id ipn.StateKey, bs []byte) []byte {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(bs)+s.aead.Overhead())
+	if _, err := crand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("crypto/rand: %v", err))
+	}
+	return s.aead.Seal(nonce, nonce, bs, []byte(id))
+}
+
+// open decrypts the value of id sealed by seal.
+func (s *EncryptedFileStore) open(id ipn.StateKey, ct []byte) ([]byte, error) {
+	if len(ct) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("state %q: %w", id, errDecrypt)
+	}
+	nonce, ct := ct[:s.aead.NonceSize()], ct[s.aead.NonceSize():]
+	bs, err := s.aead.Open(nil, nonce, ct, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("state %q: %w", id, errDecrypt)
+	}
+	return bs, nil
+}
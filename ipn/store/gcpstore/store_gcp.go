@@ -0,0 +1,350 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package gcpstore contains an ipn.StateStore implementation using Google
+// Cloud Secret Manager.
+package gcpstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/cloudenv"
+)
+
+// Prefix is the prefix of the store argument that selects a GCP store.
+const Prefix = "gcp-secret:"
+
+const (
+	secretManagerURL = "https://secretmanager.googleapis.com/v1/"
+	metadataURL      = "http://" + cloudenv.CommonNonRoutableMetadataIP + "/computeMetadata/v1/"
+
+	// requestTimeout bounds each request to Secret Manager or the metadata
+	// server.
+	requestTimeout = 30 * time.Second
+
+	// maxPayloadSize is the largest secret version Secret Manager accepts.
+	maxPayloadSize = 64 << 10
+)
+
+var (
+	// errNotFound is returned by Secret Manager requests for secrets or
+	// versions that don't exist.
+	errNotFound = errors.New("not found")
+
+	// errAlreadyExists is returned by Secret Manager requests to create
+	// secrets that already exist.
+	errAlreadyExists = errors.New("already exists")
+)
+
+// gcpStore is a store which persists the state as a Secret Manager secret,
+// writing a new secret version on every change.
+type gcpStore struct {
+	logf    logger.Logf
+	hc      *http.Client
+	apiURL  string // Secret Manager API base URL, with trailing slash
+	metaURL string // metadata server base URL, with trailing slash
+
+	project string // project ID or number
+	secret  string // secret ID
+
+	tokenMu     sync.Mutex
+	token       string    // OAuth2 access token; guarded by tokenMu
+	tokenExpiry time.Time // guarded by tokenMu
+
+	// writeMu serializes writes, so that secret versions are added in
+	// the order the state changed and each supersedes the last.
+	writeMu     sync.Mutex
+	lastVersion string // resource name of the current secret version, if known; guarded by writeMu
+
+	memory mem.Store
+}
+
+// New returns a new ipn.StateStore using the Secret Manager secret named by
+// arg, which is of the form "gcp-secret:projects/<project>/secrets/<secret>"
+// or "gcp-secret:<secret>". In the latter form, the project is the one the
+// instance runs in. The secret is created if it doesn't exist.
+//
+// Requests are authenticated as the instance's default service account,
+// using the GCE metadata server, so the store only works on GCP. The
+// service account needs the secretmanager.secrets.create (unless the secret
+// already exists), secretmanager.versions.add,
+// secretmanager.versions.access and secretmanager.versions.destroy
+// permissions.
+//
+// The entire state is stored in a single secret version, so it must be no
+// more than 64KiB. Each write destroys the version it supersedes, so that
+// only the current state is retained (and billed).
+func New(logf logger.Logf, arg string) (ipn.StateStore, error) {
+	return newStore(logf, arg, &http.Client{}, secretManagerURL, metadataURL)
+}
+
+// newStore is New, but for tests.
+func newStore(logf logger.Logf, arg string, hc *http.Client, apiURL, metaURL string) (*gcpStore, error) {
+	s := &gcpStore{
+		logf:    logf,
+		hc:      hc,
+		apiURL:  apiURL,
+		metaURL: metaURL,
+	}
+	name, ok := strings.CutPrefix(arg, Prefix)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid GCP store argument %q; want %sprojects/<project>/secrets/<secret> or %s<secret>", arg, Prefix, Prefix)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if f := strings.Split(name, "/"); len(f) == 4 && f[0] == "projects" && f[2] == "secrets" && f[1] != "" && f[3] != "" {
+		s.project, s.secret = f[1], f[3]
+	} else if !strings.Contains(name, "/") {
+		project, err := s.metadata(ctx, "project/project-id")
+		if err != nil {
+			return nil, fmt.Errorf("getting project ID: %w", err)
+		}
+		s.project, s.secret = project, name
+	} else {
+		return nil, fmt.Errorf("invalid secret name %q; want projects/<project>/secrets/<secret> or <secret>", name)
+	}
+
+	// Hydrate cache with the potentially current state.
+	if err := s.loadState(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *gcpStore) secretName() string {
+	return "projects/" + s.project + "/secrets/" + s.secret
+}
+
+// String returns the gcpStore and the secret it stores state in.
+func (s *gcpStore) String() string { return fmt.Sprintf("gcpStore(%q)", s.secretName()) }
+
+// ReadState implements the StateStore interface.
+func (s *gcpStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	return s.memory.ReadState(id)
+}
+
+// WriteState implements the StateStore interface.
+func (s *gcpStore) WriteState(id ipn.StateKey, bs []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if old, err := s.memory.ReadState(id); err == nil && bytes.Equal(old, bs) {
+		return nil
+	}
+	// Persist the new state before updating memory, so that a failed
+	// write leaves neither changed and can be retried.
+	var next mem.Store
+	state, err := s.memory.ExportToJSON()
+	if err != nil {
+		return err
+	}
+	if err := next.LoadFromJSON(state); err != nil {
+		return err
+	}
+	if err := next.WriteState(id, bs); err != nil {
+		return err
+	}
+	if state, err = next.ExportToJSON(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := s.persistState(ctx, state); err != nil {
+		return err
+	}
+	return s.memory.WriteState(id, bs)
+}
+
+// loadState reads the latest version of the secret into memory, creating
+// the secret if it doesn't exist.
+func (s *gcpStore) loadState(ctx context.Context) error {
+	var res struct {
+		Name    string `json:"name"` // of the version
+		Payload struct {
+			Data []byte `json:"data"` // base64 in JSON
+		} `json:"payload"`
+	}
+	err := s.do(ctx, "GET", s.secretName()+"/versions/latest:access", nil, &res)
+	if errors.Is(err, errNotFound) {
+		// Either the secret or any version of it is missing. Create the
+		// secret if needed, then its first version, as it's defacto
+		// empty.
+		s.logf("gcpstore: no state in %s; creating it", s.secretName())
+		if err := s.createSecret(ctx); err != nil {
+			return err
+		}
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		state, err := s.memory.ExportToJSON()
+		if err != nil {
+			return err
+		}
+		return s.persistState(ctx, state)
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", s.secretName(), err)
+	}
+	s.writeMu.Lock()
+	s.lastVersion = res.Name
+	s.writeMu.Unlock()
+	return s.memory.LoadFromJSON(res.Payload.Data)
+}
+
+// createSecret creates the secret, with automatic replication. It's not an
+// error if the secret already exists.
+func (s *gcpStore) createSecret(ctx context.Context) error {
+	req := map[string]any{
+		"replication": map[string]any{"automatic": map[string]any{}},
+		"labels":      map[string]string{"created-by": "tailscaled"},
+	}
+	err := s.do(ctx, "POST", "projects/"+s.project+"/secrets?secretId="+url.QueryEscape(s.secret), req, nil)
+	if err != nil && !errors.Is(err, errAlreadyExists) {
+		return fmt.Errorf("creating %s: %w", s.secretName(), err)
+	}
+	return nil
+}
+
+// persistState saves bs, the JSON-encoded state, as a new version of the
+// secret and destroys the version it supersedes. s.writeMu must be held.
+func (s *gcpStore) persistState(ctx context.Context, bs []byte) error {
+	if len(bs) > maxPayloadSize {
+		return fmt.Errorf("state is %d bytes; Secret Manager allows at most %d", len(bs), maxPayloadSize)
+	}
+	req := map[string]any{
+		"payload": map[string]any{"data": bs}, // base64 in JSON
+	}
+	var res struct {
+		Name string `json:"name"` // of the new version
+	}
+	if err := s.do(ctx, "POST", s.secretName()+":addVersion", req, &res); err != nil {
+		return fmt.Errorf("writing %s: %w", s.secretName(), err)
+	}
+	old := s.lastVersion
+	s.lastVersion = res.Name
+	if old != "" && old != res.Name {
+		// The state is safely written, so failing to destroy the old
+		// version only costs storage; don't fail the write.
+		if err := s.do(ctx, "POST", old+":destroy", struct{}{}, nil); err != nil && !errors.Is(err, errNotFound) {
+			s.logf("gcpstore: destroying superseded version %s: %v", old, err)
+		}
+	}
+	return nil
+}
+
+// do makes a Secret Manager API request for path, relative to the API's
+// base URL, sending req (if non-nil) and decoding the response into res
+// (if non-nil).
+func (s *gcpStore) do(ctx context.Context, method, path string, req, res any) error {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("getting access token: %w", err)
+	}
+	var body io.Reader
+	if req != nil {
+		j, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(j)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, method, s.apiURL+path, body)
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Authorization", "Bearer "+token)
+	if req != nil {
+		hreq.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.hc.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rb, err := io.ReadAll(io.LimitReader(resp.Body, 4*maxPayloadSize))
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return errNotFound
+	case http.StatusConflict:
+		return errAlreadyExists
+	default:
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(rb, &e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, e.Error.Message)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(rb))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(rb, res)
+}
+
+// accessToken returns an OAuth2 access token for the instance's default
+// service account, from the metadata server. Tokens are cached until
+// shortly before they expire.
+func (s *gcpStore) accessToken(ctx context.Context) (string, error) {
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+	j, err := s.metadata(ctx, "instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"` // seconds
+	}
+	if err := json.Unmarshal([]byte(j), &tok); err != nil {
+		return "", fmt.Errorf("parsing token: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("metadata server returned no access token")
+	}
+	s.token = tok.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+// metadata returns the value at path on the GCE metadata server.
+func (s *gcpStore) metadata(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.metaURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("querying metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return strings.TrimSpace(string(b)), nil
+}
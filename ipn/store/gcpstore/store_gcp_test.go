@@ -0,0 +1,225 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package gcpstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+// fakeGCP is a fake GCE metadata server and Secret Manager API.
+type fakeGCP struct {
+	mu      sync.Mutex
+	secrets map[string][]*fakeVersion // secret name => versions, oldest first
+	tokens  int                       // number of access tokens handed out
+	failAdd bool                      // whether to fail requests to add versions
+}
+
+type fakeVersion struct {
+	data      []byte
+	destroyed bool
+}
+
+// enabled returns the number of versions of secret that haven't been
+// destroyed.
+func (f *fakeGCP) enabled(secret string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, v := range f.secrets[secret] {
+		if !v.destroyed {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeGCP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if path, ok := strings.CutPrefix(r.URL.Path, "/meta/"); ok {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		switch path {
+		case "project/project-id":
+			w.Write([]byte("my-project"))
+		case "instance/service-accounts/default/token":
+			f.tokens++
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "tok", "expires_in": 3600})
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		http.Error(w, `{"error":{"message":"bad token"}}`, http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(path, "/versions/latest:access"):
+		name := strings.TrimSuffix(path, "/versions/latest:access")
+		versions := f.secrets[name]
+		if len(versions) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":    fmt.Sprintf("%s/versions/%d", name, len(versions)),
+			"payload": map[string]any{"data": versions[len(versions)-1].data},
+		})
+	case r.Method == "POST" && strings.HasSuffix(path, "/secrets"):
+		name := path + "/" + r.URL.Query().Get("secretId")
+		if _, ok := f.secrets[name]; ok {
+			http.Error(w, `{"error":{"message":"exists"}}`, http.StatusConflict)
+			return
+		}
+		f.secrets[name] = nil
+		w.Write([]byte("{}"))
+	case r.Method == "POST" && strings.HasSuffix(path, ":addVersion"):
+		if f.failAdd {
+			http.Error(w, `{"error":{"message":"unavailable"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		name := strings.TrimSuffix(path, ":addVersion")
+		if _, ok := f.secrets[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Payload struct {
+				Data []byte `json:"data"`
+			} `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.secrets[name] = append(f.secrets[name], &fakeVersion{data: req.Payload.Data})
+		json.NewEncoder(w).Encode(map[string]any{"name": fmt.Sprintf("%s/versions/%d", name, len(f.secrets[name]))})
+	case r.Method == "POST" && strings.HasSuffix(path, ":destroy"):
+		name, num, _ := strings.Cut(strings.TrimSuffix(path, ":destroy"), "/versions/")
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 || n > len(f.secrets[name]) {
+			http.NotFound(w, r)
+			return
+		}
+		f.secrets[name][n-1].destroyed = true
+		w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestGCPStore(t *testing.T) {
+	fake := &fakeGCP{secrets: map[string][]*fakeVersion{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	newTestStore := func(arg string) *gcpStore {
+		t.Helper()
+		s, err := newStore(t.Logf, arg, ts.Client(), ts.URL+"/api/", ts.URL+"/meta/")
+		if err != nil {
+			t.Fatalf("creating store %q: %v", arg, err)
+		}
+		return s
+	}
+
+	// The secret is created, in the instance's project, on first use.
+	s := newTestStore("gcp-secret:ts-state")
+	if got, want := s.String(), `gcpStore("projects/my-project/secrets/ts-state")`; got != want {
+		t.Errorf("String = %s; want %s", got, want)
+	}
+	if versions, ok := fake.secrets["projects/my-project/secrets/ts-state"]; !ok || len(versions) != 1 {
+		t.Fatalf("secret after creating store has %d versions, %v; want 1 version", len(versions), ok)
+	}
+	if _, err := s.ReadState("foo"); err != ipn.ErrStateNotExist {
+		t.Errorf("ReadState of missing key = %v; want ErrStateNotExist", err)
+	}
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fake.secrets["projects/my-project/secrets/ts-state"]); got != 2 {
+		t.Errorf("got %d versions; want 2, as unchanged writes aren't persisted", got)
+	}
+	if got := fake.enabled("projects/my-project/secrets/ts-state"); got != 1 {
+		t.Errorf("got %d enabled versions; want 1, as superseded versions are destroyed", got)
+	}
+
+	// A write that fails to persist leaves the old value, and retrying
+	// it persists the new one.
+	fake.failAdd = true
+	if err := s.WriteState("foo", []byte("baz")); err == nil {
+		t.Fatal("WriteState succeeded while Secret Manager is failing")
+	}
+	if bs, err := s.ReadState("foo"); err != nil || string(bs) != "bar" {
+		t.Errorf("ReadState after failed write = %q, %v; want bar", bs, err)
+	}
+	fake.failAdd = false
+	if err := s.WriteState("foo", []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new store reads the latest version.
+	s = newTestStore("gcp-secret:projects/my-project/secrets/ts-state")
+	if bs, err := s.ReadState("foo"); err != nil || string(bs) != "bar" {
+		t.Errorf("ReadState = %q, %v; want bar", bs, err)
+	}
+	if fake.tokens != 2 {
+		t.Errorf("got %d access tokens; want 1 per store", fake.tokens)
+	}
+
+	// Concurrent writes are all persisted, and still only the latest
+	// version is retained.
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.WriteState(ipn.StateKey(fmt.Sprint("key", i)), []byte("val")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	s = newTestStore("gcp-secret:ts-state")
+	for i := range 10 {
+		if _, err := s.ReadState(ipn.StateKey(fmt.Sprint("key", i))); err != nil {
+			t.Errorf("ReadState(key%d) after concurrent writes: %v", i, err)
+		}
+	}
+	if got := fake.enabled("projects/my-project/secrets/ts-state"); got != 1 {
+		t.Errorf("got %d enabled versions after concurrent writes; want 1", got)
+	}
+
+	// An existing secret without versions is used as is.
+	fake.secrets["projects/other/secrets/empty"] = nil
+	newTestStore("gcp-secret:projects/other/secrets/empty")
+	if got := len(fake.secrets["projects/other/secrets/empty"]); got != 1 {
+		t.Errorf("got %d versions of existing secret; want 1", got)
+	}
+
+	for _, arg := range []string{"gcp-secret:", "gcp-secret:a/b", "gcp-secret:projects//secrets/x", "arn:foo"} {
+		if _, err := newStore(t.Logf, arg, ts.Client(), ts.URL+"/api/", ts.URL+"/meta/"); err == nil {
+			t.Errorf("newStore(%q) succeeded; want error", arg)
+		}
+	}
+}
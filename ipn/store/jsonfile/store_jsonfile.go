@@ -0,0 +1,193 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package jsonfile provides an ipn.StateStore that persists state to a
+// human-readable JSON file, for inspecting and editing state by hand.
+package jsonfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn"
+	"tailscale.com/paths"
+	"tailscale.com/types/logger"
+)
+
+// Prefix is the prefix of the store argument that selects a Store, as in
+// "json:/var/lib/tailscale/tailscaled.state.json".
+const Prefix = "json:"
+
+// New returns a Store for arg, which is Prefix followed by a file path.
+func New(logf logger.Logf, arg string) (ipn.StateStore, error) {
+	path, ok := strings.CutPrefix(arg, Prefix)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("invalid JSON store argument %q; want %s<path>", arg, Prefix)
+	}
+	return NewStore(path)
+}
+
+// Store is an ipn.StateStore that persists state to a pretty-printed JSON
+// file. Unlike the default file store, which base64-encodes every value,
+// each value is written in the most readable form that round-trips
+// exactly: as JSON if it's compact JSON, as a string if it's other UTF-8
+// text, and as base64 otherwise. For example:
+//
+//	{
+//	  "_machinekey": {
+//	    "Text": "privkey:..."
+//	  },
+//	  "profile-1234": {
+//	    "JSON": {
+//	      "ControlURL": "https://controlplane.tailscale.com",
+//	      ...
+//	    }
+//	  }
+//	}
+//
+// The file is rewritten atomically on every change.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	cache map[ipn.StateKey][]byte // +checklocks:mu
+}
+
+// NewStore returns a Store that persists to path, loading any state
+// already there.
+func NewStore(path string) (*Store, error) {
+	if err := paths.MkStateDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	s := &Store{
+		path:  path,
+		cache: map[ipn.StateKey][]byte{},
+	}
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(bytes.TrimSpace(bs)) == 0) {
+		// Write out an initial file, to verify that we can write to the path.
+		if err := atomicfile.WriteFile(path, []byte("{}\n"), 0600); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var vals map[ipn.StateKey]value
+	if err := json.Unmarshal(bs, &vals); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for k, v := range vals {
+		bs, err := v.bytes()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: key %q: %w", path, k, err)
+		}
+		s.cache[k] = bs
+	}
+	return s, nil
+}
+
+// Path returns the path of the file s persists to.
+func (s *Store) Path() string { return s.path }
+
+func (s *Store) String() string { return fmt.Sprintf("jsonfile.Store(%q)", s.path) }
+
+// ReadState implements the StateStore interface.
+func (s *Store) ReadState(id ipn.StateKey) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bs, ok := s.cache[id]
+	if !ok {
+		return nil, ipn.ErrStateNotExist
+	}
+	return bs, nil
+}
+
+// WriteState implements the StateStore interface.
+func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.cache[id]; ok && bytes.Equal(old, bs) {
+		return nil
+	}
+	vals := make(map[ipn.StateKey]value, len(s.cache)+1)
+	for k, v := range s.cache {
+		vals[k] = valueOf(v)
+	}
+	vals[id] = valueOf(bs)
+	// Don't escape HTML characters, which would change JSON values'
+	// strings and so break round-tripping.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(vals); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(s.path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	s.cache[id] = bytes.Clone(bs)
+	return nil
+}
+
+// value is a state value as written to the file. At most one field is
+// set; none means an empty value.
+type value struct {
+	JSON   json.RawMessage `json:",omitempty"` // compact JSON, pretty-printed in the file
+	Text   string          `json:",omitempty"` // UTF-8 text that isn't JSON
+	Base64 []byte          `json:",omitempty"` // anything else
+}
+
+// valueOf returns the most readable value that round-trips to bs.
+func valueOf(bs []byte) value {
+	if len(bs) == 0 {
+		return value{}
+	}
+	if json.Valid(bs) {
+		// Only compact JSON round-trips exactly, as the file is
+		// pretty-printed and compacted again when read.
+		var buf bytes.Buffer
+		if json.Compact(&buf, bs) == nil && bytes.Equal(buf.Bytes(), bs) {
+			return value{JSON: bs}
+		}
+	}
+	if utf8.Valid(bs) {
+		return value{Text: string(bs)}
+	}
+	return value{Base64: bs}
+}
+
+// bytes returns the state value v represents.
+func (v value) bytes() ([]byte, error) {
+	n := 0
+	for _, set := range []bool{len(v.JSON) > 0, v.Text != "", len(v.Base64) > 0} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return nil, errors.New("more than one of JSON, Text and Base64 set")
+	}
+	switch {
+	case len(v.JSON) > 0:
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, v.JSON); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case v.Text != "":
+		return []byte(v.Text), nil
+	default:
+		return bytes.Clone(v.Base64), nil
+	}
+}
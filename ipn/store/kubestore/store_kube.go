@@ -22,14 +22,27 @@ type Store struct {
 	client     kube.Client
 	canPatch   bool
 	secretName string
+	field      string // if non-empty, prefix of the secret's data keys
 }
 
-// New returns a new Store that persists to the named secret.
-func New(_ logger.Logf, secretName string) (*Store, error) {
+// New returns a new Store that persists to the secret named by arg, which
+// is of the form "[<namespace>/]<secret>[:<field>]".
+//
+// Without a namespace, the secret is in the pod's namespace. With a field,
+// each state key is stored under the data key "<field>.<key>" instead of
+// "<key>", so that several tailscaled instances can share a secret.
+func New(_ logger.Logf, arg string) (*Store, error) {
+	ns, secretName, field, err := parseArg(arg)
+	if err != nil {
+		return nil, err
+	}
 	c, err := kube.New()
 	if err != nil {
 		return nil, err
 	}
+	if ns != "" {
+		c.SetNamespace(ns)
+	}
 	if os.Getenv("TS_KUBERNETES_READ_API_SERVER_ADDRESS_FROM_ENV") == "true" {
 		// Derive the API server address from the environment variables
 		c.SetURL(fmt.Sprintf("https://%s:%s", os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT_HTTPS")))
@@ -42,9 +55,29 @@ func New(_ logger.Logf, secretName string) (*Store, error) {
 		client:     c,
 		canPatch:   canPatch,
 		secretName: secretName,
+		field:      field,
 	}, nil
 }
 
+// parseArg parses the argument to New into its namespace (empty if not
+// given), secret name and field (empty if not given).
+func parseArg(arg string) (ns, secretName, field string, err error) {
+	secretName, field, hasField := strings.Cut(arg, ":")
+	if hasField && (field == "" || sanitizeKey(ipn.StateKey(field)) != field) {
+		return "", "", "", fmt.Errorf("invalid kube store argument %q: field must be non-empty and contain only alphanumerics, '-', '_' and '.'", arg)
+	}
+	if before, after, ok := strings.Cut(secretName, "/"); ok {
+		if before == "" {
+			return "", "", "", fmt.Errorf("invalid kube store argument %q: empty namespace", arg)
+		}
+		ns, secretName = before, after
+	}
+	if secretName == "" || strings.Contains(secretName, "/") {
+		return "", "", "", fmt.Errorf("invalid kube store argument %q; want [<namespace>/]<secret>[:<field>]", arg)
+	}
+	return ns, secretName, field, nil
+}
+
 func (s *Store) SetDialer(d func(ctx context.Context, network, address string) (net.Conn, error)) {
 	s.client.SetDialer(d)
 }
@@ -63,7 +96,7 @@ func (s *Store) ReadState(id ipn.StateKey) ([]byte, error) {
 		}
 		return nil, err
 	}
-	b, ok := secret.Data[sanitizeKey(id)]
+	b, ok := secret.Data[s.dataKey(id)]
 	if !ok {
 		return nil, ipn.ErrStateNotExist
 	}
@@ -81,6 +114,14 @@ func sanitizeKey(k ipn.StateKey) string {
 	}, string(k))
 }
 
+// dataKey returns the key in the secret's data of the state key k.
+func (s *Store) dataKey(k ipn.StateKey) string {
+	if s.field != "" {
+		return s.field + "." + sanitizeKey(k)
+	}
+	return sanitizeKey(k)
+}
+
 // WriteState implements the StateStore interface.
 func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -98,7 +139,7 @@ func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 					Name: s.secretName,
 				},
 				Data: map[string][]byte{
-					sanitizeKey(id): bs,
+					s.dataKey(id): bs,
 				},
 			})
 		}
@@ -110,7 +151,7 @@ func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 				{
 					Op:    "add",
 					Path:  "/data",
-					Value: map[string][]byte{sanitizeKey(id): bs},
+					Value: map[string][]byte{s.dataKey(id): bs},
 				},
 			}
 			if err := s.client.JSONPatchSecret(ctx, s.secretName, m); err != nil {
@@ -121,16 +162,16 @@ func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 		m := []kube.JSONPatch{
 			{
 				Op:    "add",
-				Path:  "/data/" + sanitizeKey(id),
+				Path:  "/data/" + s.dataKey(id),
 				Value: bs,
 			},
 		}
 		if err := s.client.JSONPatchSecret(ctx, s.secretName, m); err != nil {
-			return fmt.Errorf("error patching Secret %s with /data/%s field", s.secretName, sanitizeKey(id))
+			return fmt.Errorf("error patching Secret %s with /data/%s field", s.secretName, s.dataKey(id))
 		}
 		return nil
 	}
-	secret.Data[sanitizeKey(id)] = bs
+	secret.Data[s.dataKey(id)] = bs
 	if err := s.client.UpdateSecret(ctx, secret); err != nil {
 		return err
 	}
@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package kubestore
+
+import "testing"
+
+func TestParseArg(t *testing.T) {
+	tests := []struct {
+		arg                   string
+		ns, secretName, field string
+		wantErr               bool
+	}{
+		{arg: "ts-state", secretName: "ts-state"},
+		{arg: "tailscale/ts-state", ns: "tailscale", secretName: "ts-state"},
+		{arg: "ts-state:replica-0", secretName: "ts-state", field: "replica-0"},
+		{arg: "tailscale/ts-state:replica-0", ns: "tailscale", secretName: "ts-state", field: "replica-0"},
+		{arg: "", wantErr: true},
+		{arg: "/ts-state", wantErr: true},
+		{arg: "tailscale/", wantErr: true},
+		{arg: "a/b/c", wantErr: true},
+		{arg: "ts-state:", wantErr: true},
+		{arg: "ts-state:a/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		ns, secretName, field, err := parseArg(tt.arg)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseArg(%q) succeeded; want error", tt.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseArg(%q): %v", tt.arg, err)
+			continue
+		}
+		if ns != tt.ns || secretName != tt.secretName || field != tt.field {
+			t.Errorf("parseArg(%q) = %q, %q, %q; want %q, %q, %q", tt.arg, ns, secretName, field, tt.ns, tt.secretName, tt.field)
+		}
+	}
+}
+
+func TestDataKey(t *testing.T) {
+	s := &Store{}
+	if got, want := s.dataKey("profile-ab/cd"), "profile-ab_cd"; got != want {
+		t.Errorf("dataKey without field = %q; want %q", got, want)
+	}
+	s.field = "replica-0"
+	if got, want := s.dataKey("profile-ab/cd"), "replica-0.profile-ab_cd"; got != want {
+		t.Errorf("dataKey with field = %q; want %q", got, want)
+	}
+}
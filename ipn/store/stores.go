@@ -16,6 +16,7 @@
 
 	"tailscale.com/atomicfile"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/jsonfile"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/paths"
 	"tailscale.com/types/logger"
@@ -32,6 +33,8 @@
 
 func registerDefaultStores() {
 	Register("mem:", mem.New)
+	Register(jsonfile.Prefix, jsonfile.New)
+	Register(encryptedPrefix, newEncryptedStore)
 
 	for _, f := range registerAvailableExternalStores {
 		f()
@@ -49,10 +52,18 @@ func registerDefaultStores() {
 //
 //   - if the string begins with "mem:", the suffix
 //     is ignored and an in-memory store is used.
+//   - if the string begins with "json:", the suffix
+//     is the path of a human-readable JSON file.
+//   - if the string begins with "encrypted:", the suffix is
+//     "<keyfile>:<path>" and the file at path is encrypted
+//     with the key in keyfile.
 //   - (Linux-only) if the string begins with "arn:",
 //     the suffix an AWS ARN for an SSM.
 //   - (Linux-only) if the string begins with "kube:",
-//     the suffix is a Kubernetes secret name
+//     the suffix is a Kubernetes secret name, optionally
+//     preceded by "<namespace>/" and followed by ":<field>"
+//   - (Linux-only) if the string begins with "gcp-secret:",
+//     the suffix is a Google Cloud Secret Manager secret name.
 //   - In all other cases, the path is treated as a filepath.
 func New(logf logger.Logf, path string) (ipn.StateStore, error) {
 	regOnce.Do(registerDefaultStores)
@@ -4,10 +4,15 @@
 package store
 
 import (
+	"bytes"
+	"errors"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/jsonfile"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tstest"
 	"tailscale.com/types/logger"
@@ -177,3 +182,166 @@ func TestFileStore(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONFileStore(t *testing.T) {
+	tstest.PanicOnLog()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := New(nil, jsonfile.Prefix+path)
+	if err != nil {
+		t.Fatalf("creating JSON file store failed: %v", err)
+	}
+	if _, ok := store.(*jsonfile.Store); !ok {
+		t.Fatalf("got: %T, want: %T", store, new(jsonfile.Store))
+	}
+	testStoreSemantics(t, store)
+
+	values := map[ipn.StateKey]string{
+		"json":     `{"a":[1,2],"b":"<html> & stuff"}`,
+		"loose":    `{ "not": "compact" }`,
+		"text":     "privkey:0123abcd",
+		"binary":   "\x00\xff\xfe",
+		"empty":    "",
+		"jsonnull": "null",
+	}
+	for k, v := range values {
+		if err := store.WriteState(k, []byte(v)); err != nil {
+			t.Fatalf("writing %q: %v", k, err)
+		}
+	}
+
+	// A new store reads back exactly what was written.
+	store, err = jsonfile.NewStore(path)
+	if err != nil {
+		t.Fatalf("creating second JSON file store failed: %v", err)
+	}
+	values["foo"], values["baz"] = "bar", "quux"
+	for k, want := range values {
+		bs, err := store.ReadState(k)
+		if err != nil {
+			t.Errorf("reading %q (2nd store): %v", k, err)
+			continue
+		}
+		if string(bs) != want {
+			t.Errorf("reading %q (2nd store): got %q, want %q", k, bs, want)
+		}
+	}
+
+	// And the file is readable.
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Text": "privkey:0123abcd"`, `"b": "<html> & stuff"`, `"Base64": "AP/+"`} {
+		if !strings.Contains(string(bs), want) {
+			t.Errorf("file doesn't contain %s:\n%s", want, bs)
+		}
+	}
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	tstest.PanicOnLog()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "state.key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("0123456789abcdef", 4)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "state")
+
+	store, err := New(nil, encryptedPrefix+keyFile+":"+path)
+	if err != nil {
+		t.Fatalf("creating encrypted file store failed: %v", err)
+	}
+	if _, ok := store.(*EncryptedFileStore); !ok {
+		t.Fatalf("got: %T, want: %T", store, new(EncryptedFileStore))
+	}
+	testStoreSemantics(t, store)
+	if err := store.WriteState("_machinekey", []byte("privkey:secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The values aren't in the file in the clear.
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"privkey:secret", "quux"} {
+		if strings.Contains(string(bs), v) {
+			t.Errorf("file contains %q in the clear:\n%s", v, bs)
+		}
+	}
+
+	// A new store with the same key reads back what was written.
+	store, err = NewEncryptedFileStore(nil, keyFile, path)
+	if err != nil {
+		t.Fatalf("creating second encrypted file store failed: %v", err)
+	}
+	if bs, err := store.ReadState("_machinekey"); err != nil || string(bs) != "privkey:secret" {
+		t.Errorf("reading _machinekey (2nd store): got %q, %v; want privkey:secret", bs, err)
+	}
+
+	// A wrong key is rejected when the store is created.
+	wrongKeyFile := filepath.Join(dir, "wrong.key")
+	if err := os.WriteFile(wrongKeyFile, []byte(strings.Repeat("k", 32)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptedFileStore(nil, wrongKeyFile, path); !errors.Is(err, errDecrypt) {
+		t.Errorf("creating store with wrong key: got %v, want %v", err, errDecrypt)
+	}
+
+	// So is a tampered-with value, including one moved to another key.
+	fs, err := NewFileStore(nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := fs.ReadState("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteState("baz", ct); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptedFileStore(nil, keyFile, path); !errors.Is(err, errDecrypt) {
+		t.Errorf("creating store with swapped value: got %v, want %v", err, errDecrypt)
+	}
+	ct = bytes.Clone(ct)
+	ct[len(ct)-1] ^= 1
+	if err := fs.WriteState("baz", ct); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptedFileStore(nil, keyFile, path); !errors.Is(err, errDecrypt) {
+		t.Errorf("creating store with tampered value: got %v, want %v", err, errDecrypt)
+	}
+
+	for _, arg := range []string{"encrypted:", "encrypted:" + keyFile, "encrypted::" + path} {
+		if _, err := New(nil, arg); err == nil {
+			t.Errorf("New(%q) succeeded; want error", arg)
+		}
+	}
+	if _, err := NewEncryptedFileStore(nil, path, path); err == nil {
+		t.Errorf("creating store with invalid key file succeeded; want error")
+	}
+}
+
+func TestCutKeyFile(t *testing.T) {
+	tests := []struct {
+		in, goos      string
+		wantKey, want string
+		wantOK        bool
+	}{
+		{"/etc/ts.key:/var/lib/ts.state", "linux", "/etc/ts.key", "/var/lib/ts.state", true},
+		{"k:/var/lib/ts.state", "linux", "k", "/var/lib/ts.state", true},
+		{`C:\ts\ts.key:D:\ts\ts.state`, "windows", `C:\ts\ts.key`, `D:\ts\ts.state`, true},
+		{"C:/ts/ts.key:C:/ts/ts.state", "windows", "C:/ts/ts.key", "C:/ts/ts.state", true},
+		{`ts.key:C:\ts.state`, "windows", "ts.key", `C:\ts.state`, true},
+		{`C:\ts.key`, "windows", "", "", false},
+		{"/etc/ts.key", "linux", "", "", false},
+	}
+	for _, tt := range tests {
+		key, path, ok := cutKeyFile(tt.in, tt.goos)
+		if key != tt.wantKey || path != tt.want || ok != tt.wantOK {
+			t.Errorf("cutKeyFile(%q, %q) = %q, %q, %v; want %q, %q, %v", tt.in, tt.goos, key, path, ok, tt.wantKey, tt.want, tt.wantOK)
+		}
+	}
+}
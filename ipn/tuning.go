@@ -0,0 +1,70 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"fmt"
+	"time"
+)
+
+// Tuning holds advanced engine timing parameters, letting battery-constrained
+// or latency-sensitive deployments trade battery life for reconnection speed.
+//
+// The zero value of each field selects the stock behavior.
+type Tuning struct {
+	// KeepaliveInterval is how often the best direct path to an active
+	// peer is pinged to keep it alive. Zero means
+	// DefaultKeepaliveInterval.
+	KeepaliveInterval time.Duration `json:",omitempty"`
+
+	// DERPFallbackAfter is how long a direct path is trusted without a
+	// reply to a keepalive before packets are also sent via DERP. Zero
+	// means DefaultDERPFallbackAfter.
+	DERPFallbackAfter time.Duration `json:",omitempty"`
+}
+
+// Stock values and permitted ranges of the Tuning fields.
+const (
+	DefaultKeepaliveInterval = 3 * time.Second
+	MinKeepaliveInterval     = 1 * time.Second
+	MaxKeepaliveInterval     = 30 * time.Second
+
+	DefaultDERPFallbackAfter = 6500 * time.Millisecond
+	MinDERPFallbackAfter     = 2 * time.Second
+	MaxDERPFallbackAfter     = 60 * time.Second
+)
+
+// Validate reports whether t's values are within their permitted ranges.
+func (t Tuning) Validate() error {
+	if d := t.KeepaliveInterval; d != 0 && (d < MinKeepaliveInterval || d > MaxKeepaliveInterval) {
+		return fmt.Errorf("keepalive interval %v out of range [%v, %v]", d, MinKeepaliveInterval, MaxKeepaliveInterval)
+	}
+	if d := t.DERPFallbackAfter; d != 0 && (d < MinDERPFallbackAfter || d > MaxDERPFallbackAfter) {
+		return fmt.Errorf("DERP fallback delay %v out of range [%v, %v]", d, MinDERPFallbackAfter, MaxDERPFallbackAfter)
+	}
+	// A direct path must be able to renew its trust with a keepalive
+	// before it expires, or traffic would flap to DERP between pings.
+	if ka, fb := t.EffectiveKeepaliveInterval(), t.EffectiveDERPFallbackAfter(); fb <= ka {
+		return fmt.Errorf("DERP fallback delay %v must exceed keepalive interval %v", fb, ka)
+	}
+	return nil
+}
+
+// EffectiveKeepaliveInterval returns t.KeepaliveInterval, or its default
+// if unset.
+func (t Tuning) EffectiveKeepaliveInterval() time.Duration {
+	if t.KeepaliveInterval == 0 {
+		return DefaultKeepaliveInterval
+	}
+	return t.KeepaliveInterval
+}
+
+// EffectiveDERPFallbackAfter returns t.DERPFallbackAfter, or its default
+// if unset.
+func (t Tuning) EffectiveDERPFallbackAfter() time.Duration {
+	if t.DERPFallbackAfter == 0 {
+		return DefaultDERPFallbackAfter
+	}
+	return t.DERPFallbackAfter
+}
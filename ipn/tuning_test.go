@@ -0,0 +1,34 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTuningValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		t       Tuning
+		wantErr bool
+	}{
+		{"zero", Tuning{}, false},
+		{"in-range", Tuning{KeepaliveInterval: 10 * time.Second, DERPFallbackAfter: 25 * time.Second}, false},
+		{"keepalive-too-short", Tuning{KeepaliveInterval: 100 * time.Millisecond}, true},
+		{"keepalive-too-long", Tuning{KeepaliveInterval: time.Hour}, true},
+		{"fallback-too-short", Tuning{DERPFallbackAfter: time.Second}, true},
+		{"fallback-too-long", Tuning{DERPFallbackAfter: time.Hour}, true},
+		{"fallback-before-keepalive", Tuning{KeepaliveInterval: 10 * time.Second}, true},
+		{"fallback-at-keepalive", Tuning{KeepaliveInterval: 5 * time.Second, DERPFallbackAfter: 5 * time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.t.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v; want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
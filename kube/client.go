@@ -58,6 +58,7 @@ type Client interface {
 	CheckSecretPermissions(context.Context, string) (bool, bool, error)
 	SetDialer(dialer func(context.Context, string, string) (net.Conn, error))
 	SetURL(string)
+	SetNamespace(string)
 }
 
 type client struct {
@@ -102,6 +103,12 @@ func (c *client) SetURL(url string) {
 	c.url = url
 }
 
+// SetNamespace sets the namespace of the secrets the client acts on. It
+// defaults to the namespace of the pod the client runs in.
+func (c *client) SetNamespace(ns string) {
+	c.ns = ns
+}
+
 // SetDialer sets the dialer to use when establishing a connection
 // to the Kubernetes API server.
 func (c *client) SetDialer(dialer func(ctx context.Context, network, addr string) (net.Conn, error)) {
@@ -106,6 +106,9 @@ type Policy struct {
 	PublicID logid.PublicID
 	// Logf is where to write informational messages about this Logger.
 	Logf logger.Logf
+	// Buffer is the on-disk buffer of log lines not yet uploaded,
+	// or nil if it couldn't be created.
+	Buffer *filch.Filch
 }
 
 // NewConfig creates a Config with collection and a newly generated PrivateID.
@@ -641,6 +644,7 @@ func NewWithConfigPath(collection, dir, cmdName string, netMon *netmon.Monitor,
 		Logtail:  lw,
 		PublicID: newc.PublicID,
 		Logf:     logf,
+		Buffer:   filchBuf,
 	}
 }
 
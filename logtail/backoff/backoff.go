@@ -30,6 +30,11 @@ type Backoff struct {
 	// LogLongerThan sets the minimum time of a single backoff interval
 	// before we mention it in the log.
 	LogLongerThan time.Duration
+
+	// Base is the backoff interval after the first failure, growing
+	// quadratically with consecutive failures up to the max backoff. If
+	// zero, it's 10ms.
+	Base time.Duration
 }
 
 // NewBackoff returns a new Backoff timer with the provided name (for logging), logger,
@@ -60,10 +65,13 @@ func (b *Backoff) BackOff(ctx context.Context, err error) {
 	b.n++
 	// n^2 backoff timer is a little smoother than the
 	// common choice of 2^n.
-	d := time.Duration(b.n*b.n) * 10 * time.Millisecond
-	if d > b.maxBackoff {
-		d = b.maxBackoff
+	base := b.Base
+	if base == 0 {
+		base = 10 * time.Millisecond
 	}
+	// Compute in float64 so that a large n or base can't overflow
+	// time.Duration before the delay is capped.
+	d := time.Duration(min(float64(b.n)*float64(b.n)*float64(base), float64(b.maxBackoff)))
 	// Randomize the delay between 0.5-1.5 x msec, in order
 	// to prevent accidental "thundering herd" problems.
 	d = time.Duration(float64(d) * (rand.Float64() + 0.5))
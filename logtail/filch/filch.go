@@ -11,7 +11,9 @@
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 var stderrFD = 2 // a variable for testing
@@ -123,6 +125,51 @@ func (f *Filch) Write(b []byte) (int, error) {
 	return f.cur.Write(b)
 }
 
+// Segment describes one of the two files backing a Filch.
+type Segment struct {
+	Name    string    // base name of the file, e.g. "tailscaled.log1.txt"
+	Size    int64     // size in bytes
+	ModTime time.Time // last modification time
+}
+
+// Segments returns the files backing f, the one being read out (and thus
+// holding older lines) first. Their contents are the log lines not yet
+// consumed by the reader, such as logtail uploads.
+func (f *Filch) Segments() ([]Segment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var segs []Segment
+	for _, file := range []*os.File{f.alt, f.cur} {
+		fi, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, Segment{
+			Name:    filepath.Base(file.Name()),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	return segs, nil
+}
+
+// OpenSegment opens the segment with the given base name, as returned by
+// Segments, for reading. It doesn't affect what the reader of f sees.
+func (f *Filch) OpenSegment(name string) (*os.File, error) {
+	f.mu.Lock()
+	var path string
+	for _, file := range []*os.File{f.alt, f.cur} {
+		if filepath.Base(file.Name()) == name {
+			path = file.Name()
+		}
+	}
+	f.mu.Unlock()
+	if path == "" {
+		return nil, fmt.Errorf("filch: unknown segment %q", name)
+	}
+	return os.Open(path)
+}
+
 // Close closes the Filch, releasing all os resources.
 func (f *Filch) Close() (err error) {
 	f.mu.Lock()
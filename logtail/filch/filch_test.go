@@ -112,6 +112,51 @@ func TestQueue(t *testing.T) {
 	f.close(t)
 }
 
+func TestSegments(t *testing.T) {
+	filePrefix := t.TempDir()
+	f := newFilchTest(t, filePrefix, Options{ReplaceStderr: false})
+	defer f.close(t)
+
+	const line1 = "Hello, World!"
+	f.write(t, line1)
+
+	segs, err := f.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != 2 {
+		t.Fatalf("got %d segments; want 2", len(segs))
+	}
+	var total int64
+	for _, s := range segs {
+		total += s.Size
+	}
+	if want := int64(len(line1) + 1); total != want {
+		t.Errorf("total segment size = %d; want %d", total, want)
+	}
+
+	var got strings.Builder
+	for _, s := range segs {
+		sf, err := f.OpenSegment(s.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(&got, sf)
+		sf.Close()
+	}
+	if got.String() != line1+"\n" {
+		t.Errorf("segment contents = %q; want %q", got.String(), line1+"\n")
+	}
+
+	// Reading a segment doesn't consume its lines.
+	f.read(t, line1)
+	f.readEOF(t)
+
+	if _, err := f.OpenSegment("../etc/passwd"); err == nil {
+		t.Error("OpenSegment of unknown name succeeded")
+	}
+}
+
 func TestRecover(t *testing.T) {
 	t.Run("empty", func(t *testing.T) {
 		filePrefix := t.TempDir()
@@ -950,13 +950,72 @@ func RegisterLogTap(dst chan<- string) (unregister func()) {
 	}
 }
 
+// recentLogs holds the most recent log writes for RecentLogs, once
+// enabled with RetainRecentLogs.
+var recentLogs struct {
+	maxBytes atomic.Int64 // zero if disabled
+
+	mu   sync.Mutex
+	buf  []string // oldest first
+	size int      // total length of buf's entries
+}
+
+// RetainRecentLogs makes every Logger in the process retain its most recent
+// log writes, up to maxBytes in total, for RecentLogs. By default nothing is
+// retained and log writes aren't copied. A maxBytes of zero or less stops
+// retaining and discards what was retained.
+func RetainRecentLogs(maxBytes int) {
+	recentLogs.mu.Lock()
+	defer recentLogs.mu.Unlock()
+	recentLogs.maxBytes.Store(int64(max(maxBytes, 0)))
+	trimRecentLogsLocked()
+}
+
+// RecentLogs returns the log writes retained since RetainRecentLogs was
+// called, oldest first, as the JSON blobs that are uploaded. Like
+// RegisterLogTap, it covers every Logger in the process.
+func RecentLogs() []string {
+	recentLogs.mu.Lock()
+	defer recentLogs.mu.Unlock()
+	return slices.Clone(recentLogs.buf)
+}
+
+func addRecentLog(s string) {
+	recentLogs.mu.Lock()
+	defer recentLogs.mu.Unlock()
+	recentLogs.buf = append(recentLogs.buf, s)
+	recentLogs.size += len(s)
+	trimRecentLogsLocked()
+}
+
+// trimRecentLogsLocked drops the oldest retained logs until they fit
+// in recentLogs.maxBytes. recentLogs.mu must be held.
+func trimRecentLogsLocked() {
+	max := int(recentLogs.maxBytes.Load())
+	i := 0
+	for recentLogs.size > max {
+		recentLogs.size -= len(recentLogs.buf[i])
+		i++
+	}
+	clear(recentLogs.buf[:i]) // let the dropped strings be collected
+	recentLogs.buf = recentLogs.buf[i:]
+}
+
 // tapSend relays the JSON blob to any/all registered local debug log watchers
-// (somebody running "tailscale debug daemon-logs").
+// (somebody running "tailscale debug daemon-logs") and, if enabled, records
+// it for RecentLogs.
 func tapSend(jsonBlob []byte) {
-	if tapSetSize.Load() == 0 {
+	retain := recentLogs.maxBytes.Load() > 0
+	if !retain && tapSetSize.Load() == 0 {
 		return
 	}
 	s := string(jsonBlob)
+	if retain {
+		addRecentLog(s)
+	}
+	if tapSetSize.Load() == 0 {
+		return
+	}
 	tapMu.Lock()
 	defer tapMu.Unlock()
 	for _, dst := range tapSet {
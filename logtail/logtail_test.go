@@ -10,6 +10,7 @@
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -528,6 +529,34 @@ func TestAppendJSONAllocs(t *testing.T) {
 	}))
 }
 
+func TestRecentLogs(t *testing.T) {
+	defer RetainRecentLogs(0)
+
+	tapSend([]byte("before"))
+	if got := RecentLogs(); len(got) != 0 {
+		t.Fatalf("RecentLogs retained %q while disabled", got)
+	}
+
+	RetainRecentLogs(10)
+	for _, s := range []string{"aaaa", "bbbb", "cccc", "toolongtoretain"} {
+		tapSend([]byte(s))
+		if s == "cccc" {
+			if got, want := RecentLogs(), []string{"bbbb", "cccc"}; !slices.Equal(got, want) {
+				t.Errorf("RecentLogs = %q; want %q", got, want)
+			}
+		}
+	}
+	if got := RecentLogs(); len(got) != 0 {
+		t.Errorf("RecentLogs = %q; want entries larger than the limit dropped", got)
+	}
+
+	tapSend([]byte("dddd"))
+	RetainRecentLogs(0)
+	if got := RecentLogs(); len(got) != 0 {
+		t.Errorf("RecentLogs = %q after disabling; want none", got)
+	}
+}
+
 type discardBuffer struct{ Buffer }
 
 func (discardBuffer) Write(p []byte) (n int, err error) { return n, nil }
@@ -42,12 +42,68 @@ type Config struct {
 	// it to resolve, you also need to add appropriate routes to
 	// Routes.
 	Hosts map[dnsname.FQDN][]netip.Addr
+	// HostsFallback lists DNS suffixes within which names in Hosts are
+	// only answered locally if the upstream resolvers can't resolve
+	// them, for when Hosts may shadow real names. By default, Hosts
+	// take precedence over upstream resolvers.
+	HostsFallback []dnsname.FQDN
+	// NameserverFamily is which address families of upstream
+	// nameservers to use. The zero value uses nameservers of any family.
+	NameserverFamily NameserverFamily
 	// OnlyIPv6, if true, uses the IPv6 service IP (for MagicDNS)
 	// instead of the IPv4 version (100.100.100.100).
 	OnlyIPv6 bool
+	// ServiceIP, if valid, is the address the MagicDNS resolver is
+	// configured at in the OS, overriding 100.100.100.100 and OnlyIPv6.
+	// It's for deployments where the default address conflicts with
+	// another network. The caller must route DNS packets sent to it to
+	// the Manager.
+	ServiceIP netip.Addr
+	// PrimarySearchDomain, if non-empty, is placed first in the OS
+	// search domains, ahead of SearchDomains and any search domains
+	// blended in from the base OS configuration. It's typically the
+	// tailnet's MagicDNS suffix, so that short names resolve within the
+	// tailnet before anywhere else.
+	PrimarySearchDomain dnsname.FQDN
+}
+
+// NameserverFamily is a preference for the address family of the upstream
+// nameservers a Manager configures. Nameservers given as URLs, such as DoH
+// servers, are never filtered out.
+type NameserverFamily uint8
+
+const (
+	// NameserverFamilyAny uses nameservers of either address family.
+	NameserverFamilyAny NameserverFamily = iota
+	// NameserverFamilyAuto uses only nameservers of the address families
+	// the machine currently has connectivity for, per its interface
+	// state. If it appears to have connectivity for neither or both, no
+	// nameservers are filtered out.
+	NameserverFamilyAuto
+	// NameserverFamilyIPv4Only uses only IPv4 nameservers.
+	NameserverFamilyIPv4Only
+	// NameserverFamilyIPv6Only uses only IPv6 nameservers.
+	NameserverFamilyIPv6Only
+)
+
+func (f NameserverFamily) String() string {
+	switch f {
+	case NameserverFamilyAny:
+		return "any"
+	case NameserverFamilyAuto:
+		return "auto"
+	case NameserverFamilyIPv4Only:
+		return "ipv4"
+	case NameserverFamilyIPv6Only:
+		return "ipv6"
+	}
+	return fmt.Sprintf("NameserverFamily(%d)", uint8(f))
 }
 
 func (c *Config) serviceIP() netip.Addr {
+	if c.ServiceIP.IsValid() {
+		return c.ServiceIP
+	}
 	if c.OnlyIPv6 {
 		return tsaddr.TailscaleServiceIPv6()
 	}
@@ -65,6 +121,15 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 
 	fmt.Fprintf(w, " SearchDomains:%v", c.SearchDomains)
 	fmt.Fprintf(w, " Hosts:%v", len(c.Hosts))
+	if len(c.HostsFallback) > 0 {
+		fmt.Fprintf(w, " HostsFallback:%v", c.HostsFallback)
+	}
+	if c.NameserverFamily != NameserverFamilyAny {
+		fmt.Fprintf(w, " NameserverFamily:%v", c.NameserverFamily)
+	}
+	if c.ServiceIP.IsValid() {
+		fmt.Fprintf(w, " ServiceIP:%v", c.ServiceIP)
+	}
 	w.WriteString("}")
 }
 
@@ -85,7 +150,15 @@ func (c Config) hasDefaultIPResolversOnly() bool {
 	if !c.hasDefaultResolvers() || c.hasRoutes() {
 		return false
 	}
-	for _, r := range c.DefaultResolvers {
+	return allPlainIPResolvers(c.DefaultResolvers)
+}
+
+// allPlainIPResolvers reports whether all of resolvers are simple IP
+// addresses that speak regular port 53 DNS, which the OS can be configured
+// to use directly. Others, such as DoH servers, are only usable through
+// quad-100.
+func allPlainIPResolvers(resolvers []*dnstype.Resolver) bool {
+	for _, r := range resolvers {
 		if ipp, ok := r.IPPort(); !ok || ipp.Port() != 53 || publicdns.IPIsDoHOnlyServer(ipp.Addr()) {
 			return false
 		}
@@ -8,6 +8,7 @@
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/netip"
@@ -20,9 +21,11 @@
 
 	xmaps "golang.org/x/exp/maps"
 	"tailscale.com/control/controlknobs"
+	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/syncs"
 	"tailscale.com/tstime/rate"
@@ -30,12 +33,30 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/ringbuffer"
+	"tailscale.com/util/set"
 )
 
 var (
 	errFullQueue = errors.New("request queue full")
+
+	// ErrTailnetOnlyUnsupported is returned by Manager.SetTailnetOnly when
+	// the OS can't do split DNS, and so can't resolve tailnet names without
+	// taking over all of its DNS.
+	ErrTailnetOnlyUnsupported = errors.New("tailnet-only DNS mode requires OS split DNS support")
 )
 
+// upstreamUnreachableWarnable is raised while none of the upstream resolvers
+// of the catch-all DNS route have answered for a while.
+var upstreamUnreachableWarnable = health.Register(&health.Warnable{
+	Code:                "dns-upstream-unreachable",
+	Severity:            health.SeverityMedium,
+	Title:               "DNS servers unreachable",
+	DependsOn:           []*health.Warnable{health.NetworkStatusWarnable},
+	Text:                health.StaticMessage("None of the DNS servers Tailscale forwards queries to have answered recently. Name resolution is likely failing."),
+	ImpactsConnectivity: true,
+})
+
 // maxActiveQueries returns the maximal number of DNS requests that can
 // be running.
 const maxActiveQueries = 256
@@ -59,10 +80,42 @@ type Manager struct {
 	knobs    *controlknobs.Knobs // or nil
 	goos     string              // if empty, gets set to runtime.GOOS
 
+	// localFamilies reports which address families the machine has
+	// connectivity for, for NameserverFamilyAuto. Tests may replace it.
+	localFamilies func() (haveV4, haveV6 bool)
+
+	// changes records the most recent configuration changes, oldest
+	// first, for debugging.
+	changes *ringbuffer.RingBuffer[ConfigChange]
+
 	mu sync.Mutex // guards following
 	// config is the last configuration we successfully compiled or nil if there
 	// was any failure applying the last configuration.
 	config *Config
+	// lastCfg is the last configuration passed to Set, even if it
+	// failed to apply.
+	lastCfg *Config
+	// tailnetOnly is whether only the tailnet's own domains are routed
+	// to quad-100, with the OS DNS configuration otherwise left alone.
+	// See SetTailnetOnly.
+	tailnetOnly bool
+	// osConfig is the OS configuration last successfully applied, valid
+	// only if config is non-nil.
+	osConfig OSConfig
+	// resolverConfig is the resolver configuration last successfully
+	// applied, valid only if config is non-nil.
+	resolverConfig resolver.Config
+	// setDebounce is the minimum time between applying distinct
+	// configurations passed to Set. See Set.
+	setDebounce time.Duration
+	// lastApply is when a configuration was last applied.
+	lastApply time.Time
+	// pendingSet, if non-nil, fires to apply lastCfg once the debounce
+	// interval after a debounced Set call has passed.
+	pendingSet *time.Timer
+	// upstreamCbs are the callbacks registered with
+	// RegisterUpstreamCallback.
+	upstreamCbs set.HandleSet[func(reachable bool)]
 }
 
 // NewManagers created a new manager from the given config.
@@ -87,6 +140,17 @@ func NewManager(logf logger.Logf, oscfg OSConfigurator, health *health.Tracker,
 		health:   health,
 		knobs:    knobs,
 		goos:     goos,
+		changes:  ringbuffer.New[ConfigChange](maxConfigChanges),
+
+		setDebounce: defaultSetDebounce(goos),
+	}
+	netMon := dialer.NetMon()
+	m.localFamilies = func() (haveV4, haveV6 bool) {
+		st := netMon.InterfaceState()
+		if st == nil {
+			return true, true
+		}
+		return st.HaveV4, st.HaveV6
 	}
 
 	// Rate limit our attempts to correct our DNS configuration.
@@ -108,37 +172,253 @@ func NewManager(logf logger.Logf, oscfg OSConfigurator, health *health.Tracker,
 		}
 	})
 
+	m.resolver.SetUpstreamReachabilityCallback(m.onUpstreamReachability)
+
 	m.ctx, m.ctxCancel = context.WithCancel(context.Background())
 	m.logf("using %T", m.os)
 	return m
 }
 
+// onUpstreamReachability is called by the resolver when the upstreams of
+// the catch-all route become unreachable or recover.
+func (m *Manager) onUpstreamReachability(reachable bool) {
+	if reachable {
+		m.logf("upstream DNS servers reachable again")
+		m.health.SetHealthy(upstreamUnreachableWarnable)
+	} else {
+		m.logf("upstream DNS servers unreachable")
+		m.health.SetUnhealthy(upstreamUnreachableWarnable, nil)
+	}
+	m.mu.Lock()
+	cbs := xmaps.Values(m.upstreamCbs)
+	m.mu.Unlock()
+	for _, cb := range cbs {
+		cb(reachable)
+	}
+}
+
+// RegisterUpstreamCallback registers cb to be called with false when every
+// upstream resolver of the catch-all route has been failing for a grace
+// period, and with true when they recover. cb is called synchronously from
+// the DNS forwarding path and must not block. To remove the callback, call
+// unregister.
+func (m *Manager) RegisterUpstreamCallback(cb func(reachable bool)) (unregister func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	handle := m.upstreamCbs.Add(cb)
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.upstreamCbs, handle)
+	}
+}
+
 // Resolver returns the Manager's DNS Resolver.
 func (m *Manager) Resolver() *resolver.Resolver { return m.resolver }
 
+// Set sets the DNS configuration.
+//
+// If cfg compiles to the OS configuration last applied, the OS isn't
+// reconfigured; use Reapply if something else may have changed it. If
+// another configuration was applied within the debounce interval (only
+// non-zero on OSes where reconfiguration is expensive), Set returns nil
+// without waiting, and the most recent configuration passed to Set is
+// applied once the interval has passed. Errors applying it are logged and
+// reported to the health tracker, as for any OS configuration error.
 func (m *Manager) Set(cfg Config) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.lastCfg = &cfg
+	if wait := m.setDebounce - time.Since(m.lastApply); !m.lastApply.IsZero() && wait > 0 {
+		if m.pendingSet == nil {
+			var t *time.Timer
+			t = time.AfterFunc(wait, func() {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				if m.pendingSet != t {
+					return // stopped, or superseded by an undebounced Set
+				}
+				m.pendingSet = nil
+				if err := m.setLocked(*m.lastCfg); err != nil {
+					m.logf("applying debounced config: %v", err)
+				}
+			})
+			m.pendingSet = t
+		}
+		return nil
+	}
+	m.stopPendingSetLocked()
 	return m.setLocked(cfg)
 }
 
+// stopPendingSetLocked cancels the application of a debounced Set call, if
+// any. m.mu must be held.
+func (m *Manager) stopPendingSetLocked() {
+	if m.pendingSet != nil {
+		m.pendingSet.Stop()
+		m.pendingSet = nil
+	}
+}
+
+// Reapply is like Set, but always reconfigures the OS, even if it's already
+// configured for cfg, and doesn't debounce. It's for when something other
+// than m may have changed the OS configuration, such as NetworkManager
+// wiping systemd-resolved's configuration on a major link change.
+func (m *Manager) Reapply(cfg Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastCfg = &cfg
+	m.stopPendingSetLocked()
+	m.config = nil // so setLocked doesn't consider the OS configured
+	return m.setLocked(cfg)
+}
+
+// SetDebugLoggingEnabled sets whether m's resolver logs each query it
+// forwards upstream.
+func (m *Manager) SetDebugLoggingEnabled(v bool) {
+	m.resolver.SetDebugLoggingEnabled(v)
+}
+
+// Validate reports the error, if any, that Set would hit compiling cfg into
+// the OS and resolver configurations, such as failing to read the OS's base
+// configuration to emulate split DNS where the OS can't do it natively. It
+// uses the same compilation as Set but doesn't change any OS or resolver
+// state.
+func (m *Manager) Validate(cfg Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _, err := m.compileConfig(cfg)
+	return err
+}
+
+// defaultSetDebounce returns the default minimum time between applying
+// distinct configurations passed to Manager.Set on goos.
+func defaultSetDebounce(goos string) time.Duration {
+	if d := debugSetDebounce(); d != 0 {
+		return max(d, 0)
+	}
+	if goos == "windows" {
+		// Each reconfiguration rewrites the registry and NRPT rules and
+		// runs ipconfig, so coalesce netmap churn.
+		return 250 * time.Millisecond
+	}
+	return 0
+}
+
+// debugSetDebounce, if non-zero, overrides the default Set debounce
+// interval. A negative value disables debouncing.
+var debugSetDebounce = envknob.RegisterDuration("TS_DEBUG_DNS_SET_DEBOUNCE")
+
+// SetTailnetOnly sets whether m is in tailnet-only mode. In that mode, the
+// only OS DNS change made is a split DNS rule sending queries for the
+// tailnet's own domains (those answered authoritatively from Config.Hosts,
+// such as the MagicDNS suffix) to quad-100. The OS's default resolvers,
+// search domains and hosts are left exactly as found, and any other routes
+// and default resolvers in the Config are ignored.
+//
+// It returns ErrTailnetOnlyUnsupported if the OS can't do split DNS. The most
+// recent configuration passed to Set, if any, is reapplied in the new mode.
+func (m *Manager) SetTailnetOnly(tailnetOnly bool) error {
+	if tailnetOnly && !m.os.SupportsSplitDNS() {
+		return ErrTailnetOnlyUnsupported
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tailnetOnly == tailnetOnly {
+		return nil
+	}
+	m.tailnetOnly = tailnetOnly
+	if m.lastCfg == nil {
+		return nil
+	}
+	m.stopPendingSetLocked()
+	return m.setLocked(*m.lastCfg)
+}
+
+// maxConfigChanges is the number of recent configuration changes retained
+// by a Manager. See Manager.ConfigChanges.
+const maxConfigChanges = 32
+
+// ConfigChange is a record of a single attempt to set a Manager's
+// configuration.
+type ConfigChange struct {
+	// Time is when the change was made.
+	Time time.Time
+	// Config is the configuration passed to the Manager.
+	Config Config
+	// OSConfig is the OS configuration compiled from Config.
+	OSConfig OSConfig
+	// ResolverConfig is the resolver configuration compiled from Config.
+	ResolverConfig resolver.Config
+	// Err is the error, if any, from compiling or applying Config.
+	Err error
+}
+
+// AppliedConfig returns the OS and resolver configurations currently
+// applied. ok is false if no configuration is applied, such as when the last
+// one failed.
+func (m *Manager) AppliedConfig() (ocfg OSConfig, rcfg resolver.Config, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.config == nil {
+		return OSConfig{}, resolver.Config{}, false
+	}
+	return m.osConfig, m.resolverConfig, true
+}
+
+// SplitDNSActive reports whether the configuration currently applied to the
+// OS uses split DNS, routing only its match domains (via MatchDomains, or
+// NRPT rules on Windows) to the configured nameservers, as opposed to taking
+// over as the primary resolver. It returns false if no configuration is
+// applied.
+func (m *Manager) SplitDNSActive() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config != nil && len(m.osConfig.Nameservers) > 0 && len(m.osConfig.MatchDomains) > 0
+}
+
+// ConfigChanges returns the most recent configuration changes made to m,
+// oldest first. Only a bounded number of recent changes are retained.
+func (m *Manager) ConfigChanges() []ConfigChange {
+	return m.changes.GetAll()
+}
+
 // setLocked sets the DNS configuration.
 //
 // m.mu must be held.
-func (m *Manager) setLocked(cfg Config) error {
+func (m *Manager) setLocked(cfg Config) (err error) {
 	syncs.AssertLocked(&m.mu)
 
 	// On errors, the 'set' config is cleared.
+	wasSet := m.config != nil
 	m.config = nil
 
 	m.logf("Set: %v", logger.ArgWriter(func(w *bufio.Writer) {
 		cfg.WriteToBufioWriter(w)
 	}))
 
-	rcfg, ocfg, err := m.compileConfig(cfg)
+	var (
+		rcfg resolver.Config
+		ocfg OSConfig
+	)
+	defer func() {
+		m.changes.Add(ConfigChange{
+			Time:           time.Now(),
+			Config:         cfg,
+			OSConfig:       ocfg,
+			ResolverConfig: rcfg,
+			Err:            err,
+		})
+	}()
+
+	rcfg, ocfg, err = m.compileConfig(cfg)
 	if err != nil {
+		m.health.SetDNSOSHealth(err)
 		return err
 	}
+	if keep := m.nameserverFilter(cfg.NameserverFamily); keep != nil {
+		rcfg, ocfg = m.filterNameservers(rcfg, ocfg, keep)
+	}
 
 	m.logf("Resolvercfg: %v", logger.ArgWriter(func(w *bufio.Writer) {
 		rcfg.WriteToBufioWriter(w)
@@ -150,6 +430,14 @@ func (m *Manager) setLocked(cfg Config) error {
 	if err := m.resolver.SetConfig(rcfg); err != nil {
 		return err
 	}
+	m.resolverConfig = rcfg
+	m.lastApply = time.Now()
+	if wasSet && ocfg.Equal(m.osConfig) {
+		// The OS is already configured this way; skip the (on some
+		// platforms expensive) reconfiguration.
+		m.config = &cfg
+		return nil
+	}
 	if err := m.os.SetDNS(ocfg); err != nil {
 		m.health.SetDNSOSHealth(err)
 		return err
@@ -157,6 +445,7 @@ func (m *Manager) setLocked(cfg Config) error {
 
 	m.health.SetDNSOSHealth(nil)
 	m.config = &cfg
+	m.osConfig = ocfg
 
 	return nil
 }
@@ -215,10 +504,21 @@ func compileHostEntries(cfg Config) (hosts []*HostEntry) {
 // compileConfig converts cfg into a quad-100 resolver configuration
 // and an OS-level configuration.
 func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig, err error) {
+	if m.tailnetOnly {
+		return m.compileTailnetOnlyConfig(cfg)
+	}
+	defer func() {
+		if err == nil && cfg.PrimarySearchDomain != "" {
+			ocfg.SearchDomains = withSearchDomainFirst(ocfg.SearchDomains, cfg.PrimarySearchDomain)
+		}
+	}()
+
 	// The internal resolver always gets MagicDNS hosts and
 	// authoritative suffixes, even if we don't propagate MagicDNS to
 	// the OS.
 	rcfg.Hosts = cfg.Hosts
+	rcfg.HostsFallback = cfg.HostsFallback
+	rcfg.ServiceIP = cfg.ServiceIP
 	routes := map[dnsname.FQDN][]*dnstype.Resolver{} // assigned conditionally to rcfg.Routes below.
 	for suffix, resolvers := range cfg.Routes {
 		if len(resolvers) == 0 {
@@ -285,10 +585,23 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// workaround.
 	isWindows := m.goos == "windows"
 	isApple := (m.goos == "darwin" || m.goos == "ios")
-	if len(cfg.singleResolverSet()) > 0 && m.os.SupportsSplitDNS() && !isWindows && !isApple {
+	splitDNS := m.os.SupportsSplitDNS()
+	overLimit := false
+	limit, numDomains := m.maxMatchDomains(), numLinkDomains(cfg)
+	if splitDNS && limit > 0 && numDomains > limit {
+		// Installing only some of the match domains would silently break
+		// the rest, so instead make quad-100 the primary resolver, which
+		// handles every route itself.
+		m.logf("warning: %d split DNS domains exceeds the OS limit of %d; falling back to primary DNS mode", numDomains, limit)
+		splitDNS = false
+		overLimit = true
+	}
+	if rs := cfg.singleResolverSet(); len(rs) > 0 && allPlainIPResolvers(rs) && splitDNS && !isWindows && !isApple {
 		// Split DNS configuration requested, where all split domains
-		// go to the same resolvers. We can let the OS do it.
-		ocfg.Nameservers = toIPsOnly(cfg.singleResolverSet())
+		// go to the same plain DNS resolvers. We can let the OS do it.
+		// If they include DoH servers, which the OS can't speak, the
+		// queries go through quad-100 below instead.
+		ocfg.Nameservers = toIPsOnly(rs)
 		ocfg.MatchDomains = cfg.matchDomains()
 		return rcfg, ocfg, nil
 	}
@@ -305,7 +618,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// selectively answer ExtraRecords, and ignore other DNS traffic. As a
 	// workaround, we read the existing default resolver configuration and use
 	// that as the forwarder for all DNS traffic that quad-100 doesn't handle.
-	if isApple || !m.os.SupportsSplitDNS() {
+	if isApple || !splitDNS {
 		// If the OS can't do native split-dns, read out the underlying
 		// resolver config and blend it into our config.
 		cfg, err := m.os.GetBaseConfig()
@@ -314,8 +627,12 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 		} else if isApple && err == ErrGetBaseConfigNotSupported {
 			// This is currently (2022-10-13) expected on certain iOS and macOS
 			// builds.
+		} else if overLimit {
+			// Without the base config, quad-100 wouldn't know where to
+			// forward queries outside the split domains, and the OS
+			// would reject the full split config.
+			return resolver.Config{}, OSConfig{}, fmt.Errorf("%d split DNS domains exceeds the OS limit of %d, and primary DNS mode needs the OS's own DNS configuration: %w", numDomains, limit, err)
 		} else {
-			m.health.SetDNSOSHealth(err)
 			return resolver.Config{}, OSConfig{}, err
 		}
 	}
@@ -331,7 +648,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 		// we have any Routes outside the tailnet. Otherwise when app connectors are enabled,
 		// a query for 'work-laptop' might lead to search domain expansion, resolving
 		// as 'work-laptop.aws.com' for example.
-		if m.goos == "ios" && rcfg.RoutesRequireNoCustomResolvers() {
+		if m.goos == "ios" && !overLimit && rcfg.RoutesRequireNoCustomResolvers() {
 			if !m.disableSplitDNSOptimization() {
 				for r := range rcfg.Routes {
 					ocfg.MatchDomains = append(ocfg.MatchDomains, r)
@@ -351,6 +668,136 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	return rcfg, ocfg, nil
 }
 
+// nameserverFilter returns which nameserver addresses to use for the
+// preference f, or nil to use them all.
+func (m *Manager) nameserverFilter(f NameserverFamily) (keep func(netip.Addr) bool) {
+	switch f {
+	case NameserverFamilyIPv4Only:
+		return netip.Addr.Is4
+	case NameserverFamilyIPv6Only:
+		return netip.Addr.Is6
+	case NameserverFamilyAuto:
+		haveV4, haveV6 := m.localFamilies()
+		switch {
+		case haveV4 && !haveV6:
+			return netip.Addr.Is4
+		case haveV6 && !haveV4:
+			return netip.Addr.Is6
+		}
+	}
+	return nil
+}
+
+// filterNameservers removes the upstream nameservers from rcfg and ocfg
+// whose addresses keep rejects. The Tailscale service IPs are always kept,
+// as are all of a route's nameservers if keep would reject every one of
+// them, so that a route isn't left without any.
+func (m *Manager) filterNameservers(rcfg resolver.Config, ocfg OSConfig, keep func(netip.Addr) bool) (resolver.Config, OSConfig) {
+	if len(ocfg.Nameservers) > 0 {
+		var ns []netip.Addr
+		for _, ip := range ocfg.Nameservers {
+			if keep(ip) || tsaddr.IsTailscaleIP(ip) {
+				ns = append(ns, ip)
+			}
+		}
+		if len(ns) > 0 {
+			ocfg.Nameservers = ns
+		} else {
+			m.logf("no OS nameservers of the preferred address family; keeping %v", ocfg.Nameservers)
+		}
+	}
+	if len(rcfg.Routes) > 0 {
+		routes := make(map[dnsname.FQDN][]*dnstype.Resolver, len(rcfg.Routes))
+		for suffix, rs := range rcfg.Routes {
+			var kept []*dnstype.Resolver
+			for _, r := range rs {
+				if ipp, ok := r.IPPort(); !ok || keep(ipp.Addr()) {
+					kept = append(kept, r)
+				}
+			}
+			if len(kept) == 0 && len(rs) > 0 {
+				m.logf("no nameservers of the preferred address family for %q; keeping all", suffix)
+				kept = rs
+			}
+			routes[suffix] = kept
+		}
+		rcfg.Routes = routes
+	}
+	return rcfg, ocfg
+}
+
+// compileTailnetOnlyConfig is the tailnet-only mode variant of
+// compileConfig. See SetTailnetOnly.
+func (m *Manager) compileTailnetOnlyConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig, err error) {
+	if !m.os.SupportsSplitDNS() {
+		return resolver.Config{}, OSConfig{}, ErrTailnetOnlyUnsupported
+	}
+	rcfg.Hosts = cfg.Hosts
+	rcfg.ServiceIP = cfg.ServiceIP
+	for suffix, resolvers := range cfg.Routes {
+		if len(resolvers) == 0 {
+			rcfg.LocalDomains = append(rcfg.LocalDomains, suffix)
+		}
+	}
+	if len(rcfg.LocalDomains) == 0 {
+		// Nothing to route; leave the OS configuration untouched.
+		return rcfg, ocfg, nil
+	}
+	slices.Sort(rcfg.LocalDomains)
+	ocfg.Nameservers = []netip.Addr{cfg.serviceIP()}
+	ocfg.MatchDomains = slices.Clone(rcfg.LocalDomains)
+	return rcfg, ocfg, nil
+}
+
+// debugMaxMatchDomains, if positive, overrides the OS limit on the number of
+// split DNS match domains. It's for testing the fallback path.
+var debugMaxMatchDomains = envknob.RegisterInt("TS_DEBUG_DNS_MAX_MATCH_DOMAINS")
+
+// matchDomainLimiter is implemented by OSConfigurators whose OS can only
+// install a limited number of split DNS domains.
+type matchDomainLimiter interface {
+	// MaxMatchDomains returns the maximum number of distinct match and
+	// search domains the OS supports, or zero if there is no limit.
+	MaxMatchDomains() int
+}
+
+// numLinkDomains returns the number of distinct match and search domains
+// in cfg, which is what matchDomainLimiter limits.
+func numLinkDomains(cfg Config) int {
+	n := len(cfg.Routes)
+	for _, d := range cfg.SearchDomains {
+		if _, ok := cfg.Routes[d]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// maxMatchDomains returns the maximum number of split DNS match domains
+// the OS supports, or zero if it has no known limit.
+func (m *Manager) maxMatchDomains() int {
+	if n := debugMaxMatchDomains(); n > 0 {
+		return n
+	}
+	if l, ok := m.os.(matchDomainLimiter); ok {
+		return l.MaxMatchDomains()
+	}
+	return 0
+}
+
+// withSearchDomainFirst returns a new slice of search domains with first at
+// the front, followed by doms in their original order minus any copy of first.
+func withSearchDomainFirst(doms []dnsname.FQDN, first dnsname.FQDN) []dnsname.FQDN {
+	ret := make([]dnsname.FQDN, 0, len(doms)+1)
+	ret = append(ret, first)
+	for _, d := range doms {
+		if d != first {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
 func (m *Manager) disableSplitDNSOptimization() bool {
 	return m.knobs != nil && m.knobs.DisableSplitDNSWhenNoCustomResolvers.Load()
 }
@@ -524,6 +971,9 @@ func (m *Manager) HandleTCPConn(conn net.Conn, srcAddr netip.AddrPort) {
 
 func (m *Manager) Down() error {
 	m.ctxCancel()
+	m.mu.Lock()
+	m.stopPendingSetLocked()
+	m.mu.Unlock()
 	if err := m.os.Close(); err != nil {
 		return err
 	}
@@ -4,35 +4,51 @@
 package dns
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/netip"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/health"
 	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/tstest"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/dnsname"
 )
 
 type fakeOSConfigurator struct {
-	SplitDNS   bool
-	BaseConfig OSConfig
+	SplitDNS         bool
+	BaseConfig       OSConfig
+	BaseConfigErr    error // if non-nil, returned by GetBaseConfig
+	MatchDomainLimit int   // zero means unlimited
 
 	OSConfig       OSConfig
 	ResolverConfig resolver.Config
+	SetDNSCalls    int
+	SetDNSErr      error // if non-nil, returned by SetDNS
 }
 
 func (c *fakeOSConfigurator) SetDNS(cfg OSConfig) error {
+	if c.SetDNSErr != nil {
+		return c.SetDNSErr
+	}
 	if !c.SplitDNS && len(cfg.MatchDomains) > 0 {
 		panic("split DNS config passed to non-split OSConfigurator")
 	}
 	c.OSConfig = cfg
+	c.SetDNSCalls++
 	return nil
 }
 
@@ -45,11 +61,16 @@ func (c *fakeOSConfigurator) SupportsSplitDNS() bool {
 }
 
 func (c *fakeOSConfigurator) GetBaseConfig() (OSConfig, error) {
+	if c.BaseConfigErr != nil {
+		return OSConfig{}, c.BaseConfigErr
+	}
 	return c.BaseConfig, nil
 }
 
 func (c *fakeOSConfigurator) Close() error { return nil }
 
+func (c *fakeOSConfigurator) MaxMatchDomains() int { return c.MatchDomainLimit }
+
 func TestCompileHostEntries(t *testing.T) {
 	tests := []struct {
 		name string
@@ -220,6 +241,49 @@ func TestManager(t *testing.T) {
 				Routes: upstreams(".", "1.1.1.1", "9.9.9.9"),
 			},
 		},
+		{
+			name:  "hosts-with-global-dns-custom-service-ip",
+			split: true,
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "9.9.9.9"),
+				Hosts: hosts(
+					"foo.tld.", "1.2.3.4",
+					"bar.tld.", "2.3.4.5"),
+				ServiceIP: netip.MustParseAddr("100.100.200.53"),
+			},
+			os: OSConfig{
+				Nameservers: mustIPs("100.100.200.53"),
+			},
+			rs: resolver.Config{
+				Hosts: hosts(
+					"foo.tld.", "1.2.3.4",
+					"bar.tld.", "2.3.4.5"),
+				Routes:    upstreams(".", "1.1.1.1", "9.9.9.9"),
+				ServiceIP: netip.MustParseAddr("100.100.200.53"),
+			},
+		},
+		{
+			name:  "split-magicdns-custom-service-ip",
+			split: false,
+			in: Config{
+				Routes:    upstreams("ts.com", ""),
+				Hosts:     hosts("dave.ts.com.", "1.2.3.4"),
+				ServiceIP: netip.MustParseAddr("fd00::53"),
+				OnlyIPv6:  true,
+			},
+			bs: OSConfig{
+				Nameservers: mustIPs("8.8.8.8"),
+			},
+			os: OSConfig{
+				Nameservers: mustIPs("fd00::53"),
+			},
+			rs: resolver.Config{
+				Routes:       upstreams(".", "8.8.8.8"),
+				Hosts:        hosts("dave.ts.com.", "1.2.3.4"),
+				LocalDomains: fqdns("ts.com."),
+				ServiceIP:    netip.MustParseAddr("fd00::53"),
+			},
+		},
 		{
 			// This is the above hosts-with-global-dns-uses-quad100 test but
 			// verifying that if global DNS servers aren't set (the 1.1.1.1 and
@@ -386,6 +450,27 @@ func TestManager(t *testing.T) {
 					"corp.com.", "2.2.2.2"),
 			},
 		},
+		{
+			name: "routes-primary-search-domain",
+			in: Config{
+				Routes:              upstreams("corp.com", "2.2.2.2"),
+				SearchDomains:       fqdns("tailscale.com", "universe.tf", "tail-scale.ts.net"),
+				PrimarySearchDomain: "tail-scale.ts.net.",
+			},
+			bs: OSConfig{
+				Nameservers:   mustIPs("8.8.8.8"),
+				SearchDomains: fqdns("coffee.shop"),
+			},
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tail-scale.ts.net", "tailscale.com", "universe.tf", "coffee.shop"),
+			},
+			rs: resolver.Config{
+				Routes: upstreams(
+					".", "8.8.8.8",
+					"corp.com.", "2.2.2.2"),
+			},
+		},
 		{
 			name: "routes-split",
 			in: Config{
@@ -399,6 +484,60 @@ func TestManager(t *testing.T) {
 				MatchDomains:  fqdns("corp.com"),
 			},
 		},
+		{
+			name: "routes-split-doh",
+			in: Config{
+				Routes:        upstreams("corp.example", "https://dns.corp.example/dns-query"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+			},
+			split: true,
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+				MatchDomains:  fqdns("corp.example"),
+			},
+			rs: resolver.Config{
+				Routes: upstreams("corp.example.", "https://dns.corp.example/dns-query"),
+			},
+		},
+		{
+			name: "routes-split-doh-and-default",
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "9.9.9.9"),
+				Routes:           upstreams("corp.example", "https://dns.corp.example/dns-query"),
+				SearchDomains:    fqdns("tailscale.com", "universe.tf"),
+			},
+			split: true,
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+			},
+			rs: resolver.Config{
+				Routes: upstreams(
+					".", "1.1.1.1", "9.9.9.9",
+					"corp.example.", "https://dns.corp.example/dns-query"),
+			},
+		},
+		{
+			name: "routes-doh-no-split",
+			in: Config{
+				Routes:        upstreams("corp.example", "https://dns.corp.example/dns-query"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+			},
+			bs: OSConfig{
+				Nameservers:   mustIPs("8.8.8.8"),
+				SearchDomains: fqdns("coffee.shop"),
+			},
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf", "coffee.shop"),
+			},
+			rs: resolver.Config{
+				Routes: upstreams(
+					".", "8.8.8.8",
+					"corp.example.", "https://dns.corp.example/dns-query"),
+			},
+		},
 		{
 			name: "routes-multi",
 			in: Config{
@@ -873,6 +1012,154 @@ func TestManager(t *testing.T) {
 	}
 }
 
+func TestManagerNameserverFamily(t *testing.T) {
+	tests := []struct {
+		name           string
+		in             Config
+		haveV4, haveV6 bool
+		split          bool
+		os             OSConfig
+		rs             resolver.Config
+	}{
+		{
+			name: "any",
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "2606:4700:4700::1111"),
+			},
+			haveV6: true,
+			os:     OSConfig{Nameservers: mustIPs("1.1.1.1", "2606:4700:4700::1111")},
+		},
+		{
+			name: "auto-v6-only-host",
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "2606:4700:4700::1111"),
+				NameserverFamily: NameserverFamilyAuto,
+			},
+			haveV6: true,
+			os:     OSConfig{Nameservers: mustIPs("2606:4700:4700::1111")},
+		},
+		{
+			name: "auto-dual-stack-host",
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "2606:4700:4700::1111"),
+				NameserverFamily: NameserverFamilyAuto,
+			},
+			haveV4: true,
+			haveV6: true,
+			os:     OSConfig{Nameservers: mustIPs("1.1.1.1", "2606:4700:4700::1111")},
+		},
+		{
+			name: "auto-v6-only-host-routes",
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "2606:4700:4700::1111", "https://dns.example/dns-query"),
+				Routes:           upstreams("corp.com", "10.0.0.53", "fd00::53", "other.com", "10.0.0.54"),
+				NameserverFamily: NameserverFamilyAuto,
+			},
+			haveV6: true,
+			split:  true,
+			os: OSConfig{
+				Nameservers: mustIPs("100.100.100.100"),
+			},
+			rs: resolver.Config{
+				Routes: upstreams(
+					".", "2606:4700:4700::1111", "https://dns.example/dns-query",
+					"corp.com", "fd00::53",
+					// No IPv6 nameserver; all kept.
+					"other.com", "10.0.0.54"),
+			},
+		},
+		{
+			name: "ipv4-only",
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "2606:4700:4700::1111"),
+				NameserverFamily: NameserverFamilyIPv4Only,
+			},
+			haveV4: true,
+			haveV6: true,
+			os:     OSConfig{Nameservers: mustIPs("1.1.1.1")},
+		},
+	}
+	trIP := cmp.Transformer("ipStr", func(ip netip.Addr) string { return ip.String() })
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := fakeOSConfigurator{SplitDNS: test.split}
+			m := NewManager(t.Logf, &f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+			m.localFamilies = func() (bool, bool) { return test.haveV4, test.haveV6 }
+			m.resolver.TestOnlySetHook(f.SetResolver)
+
+			if err := m.Set(test.in); err != nil {
+				t.Fatalf("m.Set: %v", err)
+			}
+			if diff := cmp.Diff(f.OSConfig, test.os, trIP, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("wrong OSConfig (-got+want)\n%s", diff)
+			}
+			if diff := cmp.Diff(f.ResolverConfig, test.rs, trIP, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("wrong resolver.Config (-got+want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestManagerConfigChanges(t *testing.T) {
+	f := fakeOSConfigurator{}
+	m := NewManager(t.Logf, &f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+	m.resolver.TestOnlySetHook(f.SetResolver)
+
+	if got := m.ConfigChanges(); len(got) != 0 {
+		t.Fatalf("initial ConfigChanges = %v; want none", got)
+	}
+
+	configs := []Config{
+		{SearchDomains: fqdns("a.example.")},
+		{SearchDomains: fqdns("b.example.")},
+		{
+			DefaultResolvers: mustRes("1.1.1.1"),
+			SearchDomains:    fqdns("c.example."),
+		},
+	}
+	for _, cfg := range configs {
+		if err := m.Set(cfg); err != nil {
+			t.Fatalf("m.Set: %v", err)
+		}
+	}
+
+	changes := m.ConfigChanges()
+	if len(changes) != len(configs) {
+		t.Fatalf("got %d changes; want %d", len(changes), len(configs))
+	}
+	for i, c := range changes {
+		if c.Err != nil {
+			t.Errorf("change %d: unexpected error %v", i, c.Err)
+		}
+		if i > 0 && c.Time.Before(changes[i-1].Time) {
+			t.Errorf("change %d at %v is before change %d at %v", i, c.Time, i-1, changes[i-1].Time)
+		}
+		if diff := cmp.Diff(c.Config, configs[i], cmpopts.EquateComparable(netip.Addr{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("change %d: wrong Config (-got+want)\n%s", i, diff)
+		}
+		if diff := cmp.Diff(c.OSConfig.SearchDomains, configs[i].SearchDomains); diff != "" {
+			t.Errorf("change %d: wrong OSConfig.SearchDomains (-got+want)\n%s", i, diff)
+		}
+	}
+	// Only the last change sets OS nameservers.
+	if got := changes[1].OSConfig.Nameservers; len(got) != 0 {
+		t.Errorf("change 1 has nameservers %v; want none", got)
+	}
+	if got, want := changes[2].OSConfig.Nameservers, mustIPs("1.1.1.1"); !slices.Equal(got, want) {
+		t.Errorf("change 2 has nameservers %v; want %v", got, want)
+	}
+
+	// The history is bounded.
+	for range maxConfigChanges {
+		if err := m.Set(configs[0]); err != nil {
+			t.Fatalf("m.Set: %v", err)
+		}
+	}
+	if got := len(m.ConfigChanges()); got != maxConfigChanges {
+		t.Errorf("after many Sets, got %d changes; want %d", got, maxConfigChanges)
+	}
+}
+
 func mustIPs(strs ...string) (ret []netip.Addr) {
 	for _, s := range strs {
 		ret = append(ret, netip.MustParseAddr(s))
@@ -949,3 +1236,556 @@ func upstreams(strs ...string) (ret map[dnsname.FQDN][]*dnstype.Resolver) {
 	}
 	return ret
 }
+
+func TestManagerMatchDomainLimit(t *testing.T) {
+	const limit = 16
+	tests := []struct {
+		name       string
+		numDomains int
+		wantSplit  bool
+	}{
+		{"under-limit", limit, true},
+		{"over-limit", 4 * limit, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeOSConfigurator{
+				SplitDNS:         true,
+				MatchDomainLimit: limit,
+				BaseConfig: OSConfig{
+					Nameservers: mustIPs("8.8.8.8"),
+				},
+			}
+			m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+			m.resolver.TestOnlySetHook(f.SetResolver)
+
+			cfg := Config{Routes: map[dnsname.FQDN][]*dnstype.Resolver{}}
+			for i := range tt.numDomains {
+				cfg.Routes[dnsname.FQDN(fmt.Sprintf("corp%d.example.", i))] = mustRes("2.2.2.2")
+			}
+			if err := m.Set(cfg); err != nil {
+				t.Fatalf("m.Set: %v", err)
+			}
+
+			if tt.wantSplit {
+				if got := len(f.OSConfig.MatchDomains); got != tt.numDomains {
+					t.Errorf("got %d MatchDomains; want %d", got, tt.numDomains)
+				}
+				if got, want := f.OSConfig.Nameservers, mustIPs("2.2.2.2"); !slices.Equal(got, want) {
+					t.Errorf("Nameservers = %v; want %v", got, want)
+				}
+				return
+			}
+			if got := f.OSConfig.MatchDomains; len(got) != 0 {
+				t.Errorf("got %d MatchDomains; want primary DNS mode", len(got))
+			}
+			if got, want := f.OSConfig.Nameservers, mustIPs("100.100.100.100"); !slices.Equal(got, want) {
+				t.Errorf("Nameservers = %v; want %v", got, want)
+			}
+			rs := f.ResolverConfig.Routes
+			if got := len(rs); got != tt.numDomains+1 {
+				t.Errorf("got %d resolver routes; want %d", got, tt.numDomains+1)
+			}
+			if got := rs["."]; len(got) != 1 || got[0].Addr != "8.8.8.8" {
+				t.Errorf("default route = %v; want base config nameserver", got)
+			}
+		})
+	}
+}
+
+func TestManagerMatchDomainLimitNoBaseConfig(t *testing.T) {
+	// Like systemd-resolved: split DNS with a domain limit, but no way to
+	// read the OS's own DNS configuration to fall back to.
+	f := &fakeOSConfigurator{
+		SplitDNS:         true,
+		MatchDomainLimit: 4,
+		BaseConfigErr:    ErrGetBaseConfigNotSupported,
+	}
+	m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+	m.resolver.TestOnlySetHook(f.SetResolver)
+
+	cfg := Config{Routes: map[dnsname.FQDN][]*dnstype.Resolver{}}
+	for i := range 8 {
+		cfg.Routes[dnsname.FQDN(fmt.Sprintf("corp%d.example.", i))] = mustRes("2.2.2.2")
+	}
+	err := m.Set(cfg)
+	if !errors.Is(err, ErrGetBaseConfigNotSupported) || !strings.Contains(err.Error(), "OS limit of 4") {
+		t.Fatalf("Set = %v; want an error about the domain limit", err)
+	}
+	if f.SetDNSCalls != 0 {
+		t.Errorf("SetDNS called %d times; want 0", f.SetDNSCalls)
+	}
+	if m.health.DNSOSHealth() == nil {
+		t.Errorf("no DNS OS health error")
+	}
+}
+
+func TestManagerValidate(t *testing.T) {
+	errNoBase := errors.New("no base config")
+	f := &fakeOSConfigurator{
+		SplitDNS:      false,
+		BaseConfigErr: errNoBase,
+	}
+	m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "windows")
+	m.resolver.TestOnlySetHook(f.SetResolver)
+
+	// A single upstream for everything needs no base config.
+	if err := m.Validate(Config{DefaultResolvers: mustRes("1.1.1.1")}); err != nil {
+		t.Errorf("Validate(default resolvers): %v", err)
+	}
+
+	// Per-domain resolvers on an OS without split DNS need the base
+	// config to forward everything else.
+	split := Config{
+		Routes: upstreams("corp.example.com.", "2.2.2.2"),
+	}
+	if err := m.Validate(split); !errors.Is(err, errNoBase) {
+		t.Errorf("Validate(split) = %v; want %v", err, errNoBase)
+	}
+	if f.SetDNSCalls != 0 || f.ResolverConfig.Routes != nil {
+		t.Errorf("Validate changed the configuration: %d SetDNS calls, resolver routes %v", f.SetDNSCalls, f.ResolverConfig.Routes)
+	}
+	if err := m.Set(split); !errors.Is(err, errNoBase) {
+		t.Errorf("Set(split) = %v; want %v", err, errNoBase)
+	}
+}
+
+func TestManagerTailnetOnly(t *testing.T) {
+	cfg := Config{
+		DefaultResolvers: mustRes("1.1.1.1"),
+		Routes: upstreams(
+			"corp.com", "2.2.2.2",
+			"tailnet.ts.net", "",
+		),
+		SearchDomains:       fqdns("tailnet.ts.net", "corp.com"),
+		PrimarySearchDomain: "tailnet.ts.net.",
+		Hosts: hosts(
+			"dave.tailnet.ts.net.", "1.2.3.4",
+		),
+	}
+
+	t.Run("split", func(t *testing.T) {
+		base := OSConfig{
+			Nameservers:   mustIPs("8.8.8.8"),
+			SearchDomains: fqdns("home.arpa"),
+		}
+		f := &fakeOSConfigurator{SplitDNS: true, BaseConfig: base}
+		m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+		m.resolver.TestOnlySetHook(f.SetResolver)
+
+		before, err := f.GetBaseConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Set(cfg); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := m.SetTailnetOnly(true); err != nil {
+			t.Fatalf("SetTailnetOnly: %v", err)
+		}
+		want := OSConfig{
+			Nameservers:  mustIPs("100.100.100.100"),
+			MatchDomains: fqdns("tailnet.ts.net"),
+		}
+		if diff := cmp.Diff(f.OSConfig, want, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("OSConfig (-got+want):\n%s", diff)
+		}
+		if got := f.ResolverConfig.Routes; len(got) != 0 {
+			t.Errorf("resolver routes = %v; want none", got)
+		}
+		if got, want := f.ResolverConfig.LocalDomains, fqdns("tailnet.ts.net"); !slices.Equal(got, want) {
+			t.Errorf("LocalDomains = %v; want %v", got, want)
+		}
+		after, err := f.GetBaseConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(before, after); diff != "" {
+			t.Errorf("base config changed (-before+after):\n%s", diff)
+		}
+
+		// Turning it off restores the full configuration.
+		if err := m.SetTailnetOnly(false); err != nil {
+			t.Fatalf("SetTailnetOnly(false): %v", err)
+		}
+		if got := f.OSConfig.SearchDomains; len(got) == 0 {
+			t.Errorf("SearchDomains empty after leaving tailnet-only mode")
+		}
+	})
+
+	t.Run("no-split", func(t *testing.T) {
+		f := &fakeOSConfigurator{}
+		m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+		if err := m.SetTailnetOnly(true); err != ErrTailnetOnlyUnsupported {
+			t.Errorf("SetTailnetOnly = %v; want ErrTailnetOnlyUnsupported", err)
+		}
+	})
+}
+
+func TestManagerSetDedup(t *testing.T) {
+	f := &fakeOSConfigurator{}
+	m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+	m.resolver.TestOnlySetHook(f.SetResolver)
+
+	cfg := Config{
+		DefaultResolvers: mustRes("1.1.1.1"),
+		SearchDomains:    fqdns("a.example."),
+	}
+	for range 2 {
+		if err := m.Set(cfg); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	if f.SetDNSCalls != 1 {
+		t.Errorf("after two identical Sets, SetDNS called %d times; want 1", f.SetDNSCalls)
+	}
+
+	cfg.SearchDomains = fqdns("b.example.")
+	if err := m.Set(cfg); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if f.SetDNSCalls != 2 {
+		t.Errorf("after a distinct Set, SetDNS called %d times; want 2", f.SetDNSCalls)
+	}
+}
+
+func TestManagerSetDebounce(t *testing.T) {
+	f := &fakeOSConfigurator{}
+	m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+	m.resolver.TestOnlySetHook(f.SetResolver)
+	const debounce = 200 * time.Millisecond
+	m.setDebounce = debounce
+	defer m.Down()
+
+	calls := func() int {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return f.SetDNSCalls
+	}
+
+	if err := m.Set(Config{SearchDomains: fqdns("a.example.")}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// Set calls within the debounce interval return at once, and only
+	// the last of them is applied, when the interval has passed.
+	start := time.Now()
+	for _, d := range []string{"b.example.", "c.example."} {
+		if err := m.Set(Config{SearchDomains: fqdns(d)}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= debounce {
+		t.Errorf("debounced Sets took %v; want them not to wait", elapsed)
+	}
+	if got := calls(); got != 1 {
+		t.Errorf("SetDNS called %d times within the debounce interval; want 1", got)
+	}
+	if err := tstest.WaitFor(5*time.Second, func() error {
+		if got := calls(); got != 2 {
+			return fmt.Errorf("SetDNS called %d times; want 2", got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	if got, want := f.OSConfig.SearchDomains, fqdns("c.example."); !slices.Equal(got, want) {
+		t.Errorf("SearchDomains = %v; want %v", got, want)
+	}
+	m.mu.Unlock()
+
+	// Errors applying a debounced config are reported to the health
+	// tracker.
+	m.mu.Lock()
+	f.SetDNSErr = errors.New("boom")
+	m.mu.Unlock()
+	if err := m.Set(Config{SearchDomains: fqdns("d.example.")}); err != nil {
+		t.Fatalf("debounced Set: %v", err)
+	}
+	if err := tstest.WaitFor(5*time.Second, func() error {
+		if m.health.DNSOSHealth() == nil {
+			return errors.New("no DNS OS health error")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerReapply(t *testing.T) {
+	f := &fakeOSConfigurator{}
+	m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+	m.resolver.TestOnlySetHook(f.SetResolver)
+	m.setDebounce = 0
+
+	cfg := Config{SearchDomains: fqdns("a.example.")}
+	if err := m.Set(cfg); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	// Simulate another program wiping the OS configuration.
+	f.OSConfig = OSConfig{}
+	if err := m.Reapply(cfg); err != nil {
+		t.Fatalf("Reapply: %v", err)
+	}
+	if f.SetDNSCalls != 2 {
+		t.Errorf("SetDNS called %d times; want 2, as Reapply always reconfigures the OS", f.SetDNSCalls)
+	}
+	if got, want := f.OSConfig.SearchDomains, fqdns("a.example."); !slices.Equal(got, want) {
+		t.Errorf("SearchDomains = %v; want %v", got, want)
+	}
+
+	f.SetDNSErr = errors.New("boom")
+	if err := m.Reapply(cfg); err == nil {
+		t.Errorf("Reapply with failing OS succeeded; want error")
+	}
+}
+
+func TestManagerSplitDNSActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		osSplit  bool
+		goos     string
+		cfg      Config
+		wantTrue bool
+	}{
+		{
+			name:    "no-config",
+			osSplit: true,
+			goos:    "linux",
+		},
+		{
+			name:    "default-resolvers-only",
+			osSplit: true,
+			goos:    "linux",
+			cfg:     Config{DefaultResolvers: mustRes("1.1.1.1")},
+		},
+		{
+			name:     "split-routes",
+			osSplit:  true,
+			goos:     "linux",
+			cfg:      Config{Routes: upstreams("corp.com", "2.2.2.2")},
+			wantTrue: true,
+		},
+		{
+			name:     "split-routes-windows",
+			osSplit:  true,
+			goos:     "windows",
+			cfg:      Config{Routes: upstreams("corp.com", "2.2.2.2")},
+			wantTrue: true,
+		},
+		{
+			name:    "split-routes-os-cannot-split",
+			osSplit: false,
+			goos:    "linux",
+			cfg:     Config{Routes: upstreams("corp.com", "2.2.2.2")},
+		},
+		{
+			name:    "routes-with-default-resolvers",
+			osSplit: true,
+			goos:    "linux",
+			cfg: Config{
+				DefaultResolvers: mustRes("1.1.1.1"),
+				Routes:           upstreams("corp.com", "2.2.2.2"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeOSConfigurator{
+				SplitDNS:   tt.osSplit,
+				BaseConfig: OSConfig{Nameservers: mustIPs("8.8.8.8")},
+			}
+			m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, tt.goos)
+			m.setDebounce = 0
+			m.resolver.TestOnlySetHook(f.SetResolver)
+			if err := m.Set(tt.cfg); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if got := m.SplitDNSActive(); got != tt.wantTrue {
+				t.Errorf("SplitDNSActive = %v; want %v (OSConfig %+v)", got, tt.wantTrue, f.OSConfig)
+			}
+		})
+	}
+}
+
+func TestManagerUpstreamCallback(t *testing.T) {
+	ht := new(health.Tracker)
+	m := NewManager(t.Logf, &fakeOSConfigurator{}, ht, tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+
+	var got []bool
+	unregister := m.RegisterUpstreamCallback(func(reachable bool) { got = append(got, reachable) })
+
+	isWarned := func() bool {
+		_, ok := ht.CurrentState().Warnings[upstreamUnreachableWarnable.Code]
+		return ok
+	}
+
+	m.onUpstreamReachability(false)
+	if !isWarned() {
+		t.Error("no health warning while upstreams unreachable")
+	}
+	m.onUpstreamReachability(true)
+	if isWarned() {
+		t.Error("health warning remains after upstreams recovered")
+	}
+	if want := []bool{false, true}; !slices.Equal(got, want) {
+		t.Errorf("callbacks = %v; want %v", got, want)
+	}
+
+	unregister()
+	m.onUpstreamReachability(false)
+	if len(got) != 2 {
+		t.Errorf("callback called after unregister: %v", got)
+	}
+}
+
+func TestManagerAppliedConfig(t *testing.T) {
+	f := &fakeOSConfigurator{SplitDNS: true}
+	m := NewManager(t.Logf, f, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+	m.resolver.TestOnlySetHook(f.SetResolver)
+
+	if _, _, ok := m.AppliedConfig(); ok {
+		t.Fatal("AppliedConfig ok before any Set")
+	}
+	if err := m.Set(Config{Routes: upstreams("corp.com", "2.2.2.2", "tailnet.ts.net", "")}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	ocfg, rcfg, ok := m.AppliedConfig()
+	if !ok {
+		t.Fatal("AppliedConfig not ok after Set")
+	}
+	if diff := cmp.Diff(ocfg, f.OSConfig, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("OSConfig (-got+want):\n%s", diff)
+	}
+	if diff := cmp.Diff(rcfg, f.ResolverConfig, cmpopts.EquateComparable(netip.Addr{}), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("resolver Config (-got+want):\n%s", diff)
+	}
+
+	f.SetDNSErr = errors.New("boom")
+	if err := m.Set(Config{Routes: upstreams("other.com", "3.3.3.3")}); err == nil {
+		t.Fatal("Set succeeded; want error")
+	}
+	if _, _, ok := m.AppliedConfig(); ok {
+		t.Error("AppliedConfig ok after a failed Set")
+	}
+}
+
+func TestManagerHostsFallback(t *testing.T) {
+	// Run an upstream resolver that knows "shadow.tailnet.example." as
+	// 5.6.7.8 and nothing else.
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			h.Response = true
+			known := q.Name.String() == "shadow.tailnet.example." && q.Type == dnsmessage.TypeA
+			if !known {
+				h.RCode = dnsmessage.RCodeNameError
+			}
+			b := dnsmessage.NewBuilder(nil, h)
+			b.StartQuestions()
+			b.Question(q)
+			if known {
+				b.StartAnswers()
+				b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60},
+					dnsmessage.AResource{A: [4]byte{5, 6, 7, 8}})
+			}
+			res, err := b.Finish()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(res, addr)
+		}
+	}()
+
+	query := func(t *testing.T, m *Manager, name string) netip.Addr {
+		t.Helper()
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+		b.StartQuestions()
+		b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+		q, err := b.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := m.Query(context.Background(), q, "udp", netip.MustParseAddrPort("100.64.0.2:1234"))
+		if err != nil {
+			t.Fatalf("Query(%q): %v", name, err)
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(res); err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range msg.Answers {
+			if r, ok := a.Body.(*dnsmessage.AResource); ok {
+				return netip.AddrFrom4(r.A)
+			}
+		}
+		return netip.Addr{}
+	}
+
+	local := netip.MustParseAddr("100.64.0.1")
+	tests := []struct {
+		name          string
+		hostsFallback []dnsname.FQDN
+		wantShadow    netip.Addr // answer for a name both in Hosts and upstream
+	}{
+		{"hosts-first", nil, local},
+		{"upstream-first", fqdns("tailnet.example"), netip.MustParseAddr("5.6.7.8")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(t.Logf, &fakeOSConfigurator{SplitDNS: true}, new(health.Tracker), tsdial.NewDialer(netmon.NewStatic()), nil, &controlknobs.Knobs{}, "linux")
+			defer m.Down()
+			err := m.Set(Config{
+				DefaultResolvers: []*dnstype.Resolver{{Addr: pc.LocalAddr().String()}},
+				Routes:           upstreams("tailnet.example", ""),
+				Hosts: map[dnsname.FQDN][]netip.Addr{
+					"shadow.tailnet.example.": {local},
+					"only.tailnet.example.":   {local},
+				},
+				HostsFallback: tt.hostsFallback,
+			})
+			if err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if got := query(t, m, "shadow.tailnet.example."); got != tt.wantShadow {
+				t.Errorf("shadow.tailnet.example = %v; want %v", got, tt.wantShadow)
+			}
+			// Names upstream doesn't know are always answered from Hosts.
+			if got := query(t, m, "only.tailnet.example."); got != local {
+				t.Errorf("only.tailnet.example = %v; want %v", got, local)
+			}
+		})
+	}
+}
+
+func TestNumLinkDomains(t *testing.T) {
+	cfg := Config{
+		Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+			"corp.example.":  mustRes("2.2.2.2"),
+			"other.example.": mustRes("2.2.2.2"),
+		},
+		SearchDomains: fqdns("corp.example.", "search.example."),
+	}
+	if got, want := numLinkDomains(cfg), 3; got != want {
+		t.Errorf("numLinkDomains = %d; want %d", got, want)
+	}
+}
@@ -6,6 +6,8 @@
 import (
 	"bufio"
 	"bytes"
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"net/netip"
@@ -27,6 +29,7 @@
 	"tailscale.com/health"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/singleflight"
 	"tailscale.com/util/winutil"
 )
 
@@ -34,7 +37,18 @@
 	versionKey = `SOFTWARE\Microsoft\Windows NT\CurrentVersion`
 )
 
-var configureWSL = envknob.RegisterBool("TS_DEBUG_CONFIGURE_WSL")
+var (
+	configureWSL = envknob.RegisterBool("TS_DEBUG_CONFIGURE_WSL")
+	// skipDNSReregister disables running "ipconfig /registerdns" and
+	// "ipconfig /flushdns" after each DNS change, which is slow and noisy
+	// on some machines managed by group policy.
+	skipDNSReregister = envknob.RegisterBool("TS_DEBUG_SKIP_DNS_REREGISTER")
+)
+
+// getBaseConfigTimeout is how long GetBaseConfig waits for the system's
+// primary resolvers to be enumerated before falling back to the last
+// known good base config.
+const getBaseConfigTimeout = 5 * time.Second
 
 type windowsManager struct {
 	logf       logger.Logf
@@ -43,8 +57,27 @@ type windowsManager struct {
 	nrptDB     *nrptRuleDatabase
 	wslManager *wslManager
 
+	// getBaseResolvers, if non-nil, overrides getBasePrimaryResolver
+	// in GetBaseConfig. It's used by tests.
+	getBaseResolvers func() ([]netip.Addr, error)
+	// baseConfigTimeout, if non-zero, overrides getBaseConfigTimeout.
+	// It's used by tests.
+	baseConfigTimeout time.Duration
+	// skipReregister is whether SetDNS skips the "ipconfig /registerdns"
+	// and "ipconfig /flushdns" calls. It's initialized from
+	// TS_DEBUG_SKIP_DNS_REREGISTER and may be set by tests.
+	skipReregister bool
+
 	mu      sync.Mutex
 	closing bool
+	// lastBaseConfig is the last base config GetBaseConfig successfully
+	// determined, if any. It's returned instead when a later call times out.
+	lastBaseConfig *OSConfig
+
+	// baseResolversFlight shares an enumeration of the base resolvers
+	// that's still running among GetBaseConfig calls, so that one that
+	// blocks doesn't pile up another blocked goroutine on each call.
+	baseResolversFlight singleflight.Group[string, []netip.Addr]
 }
 
 // NewOSConfigurator created a new OS configurator.
@@ -56,6 +89,8 @@ func NewOSConfigurator(logf logger.Logf, health *health.Tracker, knobs *controlk
 		guid:       interfaceName,
 		knobs:      knobs,
 		wslManager: newWSLManager(logf, health),
+
+		skipReregister: skipDNSReregister(),
 	}
 
 	if isWindows10OrBetter() {
@@ -376,46 +411,11 @@ func (m *windowsManager) SetDNS(cfg OSConfig) error {
 		}
 	}
 
-	// Force DNS re-registration in Active Directory. What we actually
-	// care about is that this command invokes the undocumented hidden
-	// function that forces Windows to notice that adapter settings
-	// have changed, which makes the DNS settings actually take
-	// effect.
-	//
-	// This command can take a few seconds to run, so run it async, best effort.
-	//
-	// After re-registering DNS, also flush the DNS cache to clear out
-	// any cached split-horizon queries that are no longer the correct
-	// answer.
-	go func() {
-		t0 := time.Now()
-		m.logf("running ipconfig /registerdns ...")
-		cmd := exec.Command("ipconfig", "/registerdns")
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			CreationFlags: windows.DETACHED_PROCESS,
-		}
-		err := cmd.Run()
-		d := time.Since(t0).Round(time.Millisecond)
-		if err != nil {
-			m.logf("error running ipconfig /registerdns after %v: %v", d, err)
-		} else {
-			m.logf("ran ipconfig /registerdns in %v", d)
-		}
-
-		t0 = time.Now()
-		m.logf("running ipconfig /flushdns ...")
-		cmd = exec.Command("ipconfig", "/flushdns")
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			CreationFlags: windows.DETACHED_PROCESS,
-		}
-		err = cmd.Run()
-		d = time.Since(t0).Round(time.Millisecond)
-		if err != nil {
-			m.logf("error running ipconfig /flushdns after %v: %v", d, err)
-		} else {
-			m.logf("ran ipconfig /flushdns in %v", d)
-		}
-	}()
+	if m.skipReregister {
+		m.logf("DNS re-registration disabled; not running ipconfig /registerdns or /flushdns")
+	} else {
+		go m.reregisterDNS()
+	}
 
 	// On initial setup of WSL, the restart caused by --shutdown is slow,
 	// so we do it out-of-line.
@@ -432,6 +432,47 @@ func (m *windowsManager) SetDNS(cfg OSConfig) error {
 	return nil
 }
 
+// reregisterDNS forces DNS re-registration in Active Directory. What we
+// actually care about is that this command invokes the undocumented hidden
+// function that forces Windows to notice that adapter settings have changed,
+// which makes the DNS settings actually take effect.
+//
+// This command can take a few seconds to run, so SetDNS runs it async,
+// best effort.
+//
+// After re-registering DNS, also flush the DNS cache to clear out
+// any cached split-horizon queries that are no longer the correct
+// answer.
+func (m *windowsManager) reregisterDNS() {
+	t0 := time.Now()
+	m.logf("running ipconfig /registerdns ...")
+	cmd := exec.Command("ipconfig", "/registerdns")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS,
+	}
+	err := cmd.Run()
+	d := time.Since(t0).Round(time.Millisecond)
+	if err != nil {
+		m.logf("error running ipconfig /registerdns after %v: %v", d, err)
+	} else {
+		m.logf("ran ipconfig /registerdns in %v", d)
+	}
+
+	t0 = time.Now()
+	m.logf("running ipconfig /flushdns ...")
+	cmd = exec.Command("ipconfig", "/flushdns")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS,
+	}
+	err = cmd.Run()
+	d = time.Since(t0).Round(time.Millisecond)
+	if err != nil {
+		m.logf("error running ipconfig /flushdns after %v: %v", d, err)
+	} else {
+		m.logf("ran ipconfig /flushdns in %v", d)
+	}
+}
+
 func (m *windowsManager) SupportsSplitDNS() bool {
 	return m.nrptDB != nil
 }
@@ -502,18 +543,48 @@ func (m *windowsManager) disableNetBIOS() error {
 	return m.setSingleDWORD(winutil.NetBTInterfacePrefix, "NetbiosOptions", 2)
 }
 
+// GetBaseConfig returns the system's primary resolvers. Enumerating them can
+// block on some systems, so if it doesn't finish within getBaseConfigTimeout,
+// the last base config successfully determined is returned instead. Calls
+// made while an enumeration is still running wait for it rather than
+// starting another.
 func (m *windowsManager) GetBaseConfig() (OSConfig, error) {
-	resolvers, err := m.getBasePrimaryResolver()
-	if err != nil {
-		return OSConfig{}, err
-	}
-	return OSConfig{
-		Nameservers: resolvers,
-		// Don't return any search domains here, because even Windows
-		// 7 correctly handles blending search domains from multiple
-		// sources, and any search domains we add here will get tacked
-		// onto the Tailscale config unnecessarily.
-	}, nil
+	timeout := cmp.Or(m.baseConfigTimeout, getBaseConfigTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	getResolvers := m.getBaseResolvers
+	if getResolvers == nil {
+		getResolvers = m.getBasePrimaryResolver
+	}
+	resc := m.baseResolversFlight.DoChan("", getResolvers)
+
+	select {
+	case res := <-resc:
+		if res.Err != nil {
+			return OSConfig{}, res.Err
+		}
+		cfg := OSConfig{
+			Nameservers: res.Val,
+			// Don't return any search domains here, because even Windows
+			// 7 correctly handles blending search domains from multiple
+			// sources, and any search domains we add here will get tacked
+			// onto the Tailscale config unnecessarily.
+		}
+		m.mu.Lock()
+		m.lastBaseConfig = &cfg
+		m.mu.Unlock()
+		return cfg, nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		last := m.lastBaseConfig
+		m.mu.Unlock()
+		if last == nil {
+			return OSConfig{}, fmt.Errorf("getting base DNS config: %w", ctx.Err())
+		}
+		m.logf("getting base DNS config timed out after %v; using last known config with nameservers %v", timeout, last.Nameservers)
+		return *last, nil
+	}
 }
 
 // getBasePrimaryResolver returns a guess of the non-Tailscale primary
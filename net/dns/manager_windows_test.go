@@ -9,7 +9,9 @@
 	"fmt"
 	"math/rand"
 	"net/netip"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -35,6 +37,64 @@ func TestHostFileNewLines(t *testing.T) {
 	}
 }
 
+func TestGetBaseConfigTimeout(t *testing.T) {
+	ns := []netip.Addr{netip.MustParseAddr("192.0.2.53")}
+	var calls atomic.Int32
+	var gate atomic.Pointer[chan struct{}] // if non-nil, blocks enumerations until closed
+	m := &windowsManager{
+		logf:              t.Logf,
+		baseConfigTimeout: 50 * time.Millisecond,
+		getBaseResolvers: func() ([]netip.Addr, error) {
+			calls.Add(1)
+			if ch := gate.Load(); ch != nil {
+				<-*ch
+			}
+			return ns, nil
+		},
+	}
+
+	// A slow first call has nothing to fall back to, and a call made
+	// while it's still blocked waits for it rather than starting another.
+	block1 := make(chan struct{})
+	gate.Store(&block1)
+	for range 2 {
+		if _, err := m.GetBaseConfig(); err == nil {
+			t.Fatal("GetBaseConfig succeeded on timeout without a cached config")
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("enumerated resolvers %d times while blocked; want 1", got)
+	}
+
+	gate.Store(nil)
+	close(block1)
+	cfg, err := m.GetBaseConfig()
+	if err != nil {
+		t.Fatalf("GetBaseConfig: %v", err)
+	}
+	if !slices.Equal(cfg.Nameservers, ns) {
+		t.Fatalf("Nameservers = %v; want %v", cfg.Nameservers, ns)
+	}
+
+	// Once a config is known, a slow call returns it rather than failing.
+	block2 := make(chan struct{})
+	defer close(block2)
+	gate.Store(&block2)
+	before := calls.Load()
+	for range 2 {
+		cfg, err = m.GetBaseConfig()
+		if err != nil {
+			t.Fatalf("GetBaseConfig after timeout: %v", err)
+		}
+		if !slices.Equal(cfg.Nameservers, ns) {
+			t.Errorf("fallback Nameservers = %v; want %v", cfg.Nameservers, ns)
+		}
+	}
+	if got := calls.Load() - before; got != 1 {
+		t.Errorf("enumerated resolvers %d times while blocked; want 1", got)
+	}
+}
+
 func TestManagerWindowsLocal(t *testing.T) {
 	if !isWindows10OrBetter() || !winutil.IsCurrentProcessElevated() {
 		t.Skipf("test requires running as elevated user on Windows 10+")
@@ -8,6 +8,7 @@
 	"errors"
 	"fmt"
 	"net/netip"
+	"slices"
 	"strings"
 
 	"tailscale.com/types/logger"
@@ -116,6 +117,9 @@ func (a OSConfig) Equal(b OSConfig) bool {
 	if len(a.MatchDomains) != len(b.MatchDomains) {
 		return false
 	}
+	if len(a.Hosts) != len(b.Hosts) {
+		return false
+	}
 
 	for i := range a.Nameservers {
 		if a.Nameservers[i] != b.Nameservers[i] {
@@ -132,6 +136,12 @@ func (a OSConfig) Equal(b OSConfig) bool {
 			return false
 		}
 	}
+	for i := range a.Hosts {
+		ha, hb := a.Hosts[i], b.Hosts[i]
+		if ha.Addr != hb.Addr || !slices.Equal(ha.Hosts, hb.Hosts) {
+			return false
+		}
+	}
 
 	return true
 }
@@ -371,6 +371,16 @@ func (m *resolvedManager) SupportsSplitDNS() bool {
 	return true
 }
 
+// resolvedMaxLinkDomains is the most domains systemd-resolved accepts for a
+// link (LINK_SEARCH_DOMAINS_MAX), counting both search and routing-only
+// domains. SetLinkDomains fails with E2BIG beyond it.
+const resolvedMaxLinkDomains = 256
+
+// MaxMatchDomains implements matchDomainLimiter.
+func (m *resolvedManager) MaxMatchDomains() int {
+	return resolvedMaxLinkDomains
+}
+
 func (m *resolvedManager) GetBaseConfig() (OSConfig, error) {
 	return OSConfig{}, ErrGetBaseConfigNotSupported
 }
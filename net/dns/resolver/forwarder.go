@@ -184,6 +184,53 @@ func clampEDNSSize(packet []byte, maxSize uint16) {
 	TimeToVisible:       5 * time.Second,
 })
 
+// upstreamDownGrace is how long every upstream of the catch-all route must
+// keep failing before the upstreams are reported unreachable.
+const upstreamDownGrace = 30 * time.Second
+
+// upstreamTracker tracks whether the upstreams of the catch-all (".") route
+// are reachable, from the outcome of the queries forwarded to them.
+type upstreamTracker struct {
+	grace time.Duration
+	now   func() time.Time
+
+	// cb, if non-nil, is called with false when the upstreams have been
+	// failing for the grace period, and with true when they recover.
+	// It's set before the forwarder is used.
+	cb func(reachable bool)
+
+	mu           sync.Mutex
+	failingSince time.Time // zero if the last query succeeded
+	down         bool      // whether cb was last called with false
+}
+
+// note records whether a query forwarded to the catch-all route got an
+// answer, calling t.cb on a change in reachability.
+func (t *upstreamTracker) note(ok bool) {
+	t.mu.Lock()
+	changed := false
+	if ok {
+		t.failingSince = time.Time{}
+		if t.down {
+			t.down = false
+			changed = true
+		}
+	} else {
+		now := t.now()
+		if t.failingSince.IsZero() {
+			t.failingSince = now
+		}
+		if !t.down && now.Sub(t.failingSince) >= t.grace {
+			t.down = true
+			changed = true
+		}
+	}
+	t.mu.Unlock()
+	if changed && t.cb != nil {
+		t.cb(ok)
+	}
+}
+
 type route struct {
 	Suffix    dnsname.FQDN
 	Resolvers []resolverAndDelay
@@ -212,6 +259,8 @@ type forwarder struct {
 
 	controlKnobs *controlknobs.Knobs // or nil
 
+	debugLogging atomic.Bool // log each forwarded query; see verbose
+
 	ctx       context.Context    // good until Close
 	ctxCancel context.CancelFunc // closes ctx
 
@@ -238,6 +287,9 @@ type forwarder struct {
 	//
 	// This should attempt to properly (re)set the upstream resolvers.
 	missingUpstreamRecovery func()
+
+	// upstream tracks the reachability of the catch-all route's upstreams.
+	upstream upstreamTracker
 }
 
 func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkSelector, dialer *tsdial.Dialer, health *health.Tracker, knobs *controlknobs.Knobs) *forwarder {
@@ -252,6 +304,10 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 		health:                  health,
 		controlKnobs:            knobs,
 		missingUpstreamRecovery: func() {},
+		upstream: upstreamTracker{
+			grace: upstreamDownGrace,
+			now:   time.Now,
+		},
 	}
 	f.ctx, f.ctxCancel = context.WithCancel(context.Background())
 	return f
@@ -490,15 +546,22 @@ func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client,
 	skipTCPRetry      = envknob.RegisterBool("TS_DNS_FORWARD_SKIP_TCP_RETRY")
 
 	// For correlating log messages in the send() function; only used when
-	// verboseDNSForward() is true.
+	// forwarder.verbose() is true.
 	forwarderCount atomic.Uint64
 )
 
+// verbose reports whether f should log each query it forwards, either
+// because TS_DEBUG_DNS_FORWARD_SEND is set or because debug logging was
+// enabled with Resolver.SetDebugLoggingEnabled.
+func (f *forwarder) verbose() bool {
+	return verboseDNSForward() || f.debugLogging.Load()
+}
+
 // send sends packet to dst. It is best effort.
 //
 // send expects the reply to have the same txid as txidOut.
 func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDelay) (ret []byte, err error) {
-	if verboseDNSForward() {
+	if f.verbose() {
 		id := forwarderCount.Add(1)
 		domain, typ, _ := nameFromQuery(fq.packet)
 		f.logf("forwarder.send(%q, %d, %v, %d) [%d] ...", rr.name.Addr, fq.txid, typ, len(domain), id)
@@ -820,18 +883,19 @@ func (f *forwarder) sendTCP(ctx context.Context, fq *forwardQuery, rr resolverAn
 	return out, nil
 }
 
-// resolvers returns the resolvers to use for domain.
-func (f *forwarder) resolvers(domain dnsname.FQDN) []resolverAndDelay {
+// resolvers returns the resolvers to forward queries for domain to, and
+// whether they're those of the catch-all (".") route.
+func (f *forwarder) resolvers(domain dnsname.FQDN) (_ []resolverAndDelay, catchAll bool) {
 	f.mu.Lock()
 	routes := f.routes
 	cloudHostFallback := f.cloudHostFallback
 	f.mu.Unlock()
 	for _, route := range routes {
 		if route.Suffix == "." || route.Suffix.Contains(domain) {
-			return route.Resolvers
+			return route.Resolvers, route.Suffix == "."
 		}
 	}
-	return cloudHostFallback // or nil if no fallback
+	return cloudHostFallback, false // or nil if no fallback
 }
 
 // forwardQuery is information and state about a forwarded DNS query that's
@@ -906,8 +970,9 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 
 	clampEDNSSize(query.bs, maxResponseBytes)
 
+	var catchAll bool
 	if len(resolvers) == 0 {
-		resolvers = f.resolvers(domain)
+		resolvers, catchAll = f.resolvers(domain)
 		if len(resolvers) == 0 {
 			metricDNSFwdErrorNoUpstream.Add(1)
 			f.health.SetUnhealthy(dnsForwarderFailing, health.Args{health.ArgDNSServers: ""})
@@ -946,13 +1011,13 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	}
 	defer fq.closeOnCtxDone.Close()
 
-	if verboseDNSForward() {
+	if f.verbose() {
 		domainSha256 := sha256.Sum256([]byte(domain))
 		domainSig := base64.RawStdEncoding.EncodeToString(domainSha256[:3])
 		f.logf("request(%d, %v, %d, %s) %d...", fq.txid, typ, len(domain), domainSig, len(fq.packet))
 	}
 
-	resc := make(chan []byte, 1) // it's fine buffered or not
+	resc := make(chan packet, 1) // it's fine buffered or not
 	errc := make(chan error, 1)  // it's fine buffered or not too
 	for i := range resolvers {
 		go func(rr *resolverAndDelay) {
@@ -975,7 +1040,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 				return
 			}
 			select {
-			case resc <- resb:
+			case resc <- packet{bs: resb, upstream: rr.name.Addr}:
 			case <-ctx.Done():
 			}
 		}(&resolvers[i])
@@ -983,6 +1048,21 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 
 	var firstErr error
 	var numErr int
+	var answered bool // whether any upstream responded, even with an error
+	// noteUpstream records the outcome of a query to the catch-all route
+	// that got no usable response. Only failures to reach the upstreams
+	// count against them: not error responses, which show they're
+	// reachable, nor the caller giving up on the query.
+	noteUpstream := func() {
+		switch {
+		case !catchAll:
+		case answered:
+			f.upstream.note(true)
+		case errors.Is(ctx.Err(), context.Canceled):
+		default:
+			f.upstream.note(false)
+		}
+	}
 	for {
 		select {
 		case v := <-resc:
@@ -990,20 +1070,27 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
 				return fmt.Errorf("waiting to send response: %w", ctx.Err())
-			case responseChan <- packet{v, query.family, query.addr}:
-				if verboseDNSForward() {
-					f.logf("response(%d, %v, %d) = %d, nil", fq.txid, typ, len(domain), len(v))
+			case responseChan <- packet{bs: v.bs, family: query.family, addr: query.addr, upstream: v.upstream}:
+				if f.verbose() {
+					f.logf("response(%d, %v, %d) = %d, nil", fq.txid, typ, len(domain), len(v.bs))
 				}
 				metricDNSFwdSuccess.Add(1)
 				f.health.SetHealthy(dnsForwarderFailing)
+				if catchAll {
+					f.upstream.note(true)
+				}
 				return nil
 			}
 		case err := <-errc:
 			if firstErr == nil {
 				firstErr = err
 			}
+			if errors.Is(err, errServerFailure) {
+				answered = true
+			}
 			numErr++
 			if numErr == len(resolvers) {
+				noteUpstream()
 				if errors.Is(firstErr, errServerFailure) {
 					res, err := servfailResponse(query)
 					if err != nil {
@@ -1021,7 +1108,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 						}
 						f.health.SetUnhealthy(dnsForwarderFailing, health.Args{health.ArgDNSServers: strings.Join(resolverAddrs, ",")})
 					case responseChan <- res:
-						if verboseDNSForward() {
+						if f.verbose() {
 							f.logf("forwarder response(%d, %v, %d) = %d, %v", fq.txid, typ, len(domain), len(res.bs), firstErr)
 						}
 					}
@@ -1030,6 +1117,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 			}
 		case <-ctx.Done():
 			metricDNSFwdErrorContext.Add(1)
+			noteUpstream()
 			if firstErr != nil {
 				metricDNSFwdErrorContextGotError.Add(1)
 				return firstErr
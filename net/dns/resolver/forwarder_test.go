@@ -28,6 +28,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
 )
 
 func (rr resolverAndDelay) String() string {
@@ -658,3 +659,132 @@ func TestForwarderTCPFallbackError(t *testing.T) {
 		t.Errorf("wanted errServerFailure, got: %v", err)
 	}
 }
+
+func TestForwarderUpstreamReachability(t *testing.T) {
+	// A fake upstream that answers every query while up, and drops them
+	// while down. With servfail, its answers are SERVFAIL.
+	var up, servfail atomic.Bool
+	up.Store(true)
+	ln, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := ln.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			if !up.Load() {
+				continue
+			}
+			res := bytes.Clone(buf[:n])
+			res[2] |= 0x80 // QR: this is a response
+			if servfail.Load() {
+				res[3] = res[3]&^0x0f | byte(dns.RCodeServerFailure)
+			}
+			ln.WriteToUDPAddrPort(res, addr)
+		}
+	}()
+
+	netMon, err := netmon.New(t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	fwd := newForwarder(t.Logf, netMon, nil, &dialer, new(health.Tracker), nil)
+	t.Cleanup(func() { fwd.Close() })
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: ln.LocalAddr().String()}},
+	})
+
+	now := time.Unix(1000, 0)
+	fwd.upstream.now = func() time.Time { return now }
+	var got []bool
+	fwd.upstream.cb = func(reachable bool) { got = append(got, reachable) }
+
+	request := func() []byte {
+		b := dns.NewBuilder(nil, dns.Header{ID: 1234, RecursionDesired: true})
+		b.StartQuestions()
+		b.Question(dns.Question{
+			Name:  dns.MustNewName("example.com."),
+			Type:  dns.TypeA,
+			Class: dns.ClassINET,
+		})
+		req, err := b.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}()
+	query := func() {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+		ch := make(chan packet, 1)
+		err := fwd.forwardWithDestChan(ctx, packet{bs: request, family: "udp"}, ch)
+		if !up.Load() {
+			return
+		}
+		if err != nil {
+			t.Fatalf("query while up: %v", err)
+		}
+		if res := <-ch; res.upstream != ln.LocalAddr().String() {
+			t.Fatalf("response from upstream %q; want %q", res.upstream, ln.LocalAddr())
+		}
+	}
+	check := func(want ...bool) {
+		t.Helper()
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("callbacks = %v; want %v", got, want)
+		}
+	}
+
+	query()
+	check()
+
+	up.Store(false)
+	query()
+	check() // failing, but not yet for the grace period
+
+	now = now.Add(upstreamDownGrace)
+	query()
+	check(false)
+	query()
+	check(false) // no repeat while still down
+
+	up.Store(true)
+	query()
+	check(false, true)
+	query()
+	check(false, true)
+
+	// A failure after recovering starts the grace period over.
+	up.Store(false)
+	now = now.Add(time.Hour)
+	query()
+	check(false, true)
+
+	// A SERVFAIL shows the upstream is reachable, so it ends the failure.
+	up.Store(true)
+	servfail.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	if err := fwd.forwardWithDestChan(ctx, packet{bs: request, family: "udp"}, make(chan packet, 1)); !errors.Is(err, errServerFailure) {
+		t.Fatalf("query answered with SERVFAIL: got %v; want errServerFailure", err)
+	}
+	up.Store(false)
+	now = now.Add(upstreamDownGrace)
+	query()
+	check(false, true)
+
+	// Nor does a query the caller gives up on count as a failure.
+	now = now.Add(upstreamDownGrace)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	fwd.forwardWithDestChan(ctx, packet{bs: request, family: "udp"}, make(chan packet, 1))
+	check(false, true)
+}
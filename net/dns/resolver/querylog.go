@@ -0,0 +1,97 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"strings"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/util/dnsname"
+)
+
+// debugQueryLog enables passing the queries a Resolver handles to the
+// observers registered with AddQueryObserver. It's off by default, as the
+// names looked up are private.
+var debugQueryLog = envknob.RegisterBool("TS_DEBUG_DNS_QUERY_LOG")
+
+// QueryLogEnabled reports whether query observers are enabled with
+// TS_DEBUG_DNS_QUERY_LOG.
+func QueryLogEnabled() bool { return debugQueryLog() }
+
+// QueryEvent describes a DNS query handled by a Resolver.
+type QueryEvent struct {
+	Time time.Time     // when the query arrived
+	Name dnsname.FQDN  // the name queried, or empty if unparseable
+	Type string        // the query type, such as "A" or "AAAA"
+	Took time.Duration // how long the query took to answer
+
+	// Forwarded is whether the query was forwarded upstream rather than
+	// answered locally.
+	Forwarded bool
+	// Upstream is the address of the upstream resolver that answered the
+	// query, if Forwarded and one did.
+	Upstream string
+	// Cached is whether the answer came from a cache of earlier answers
+	// rather than from the Resolver's own records or an upstream. The
+	// Resolver doesn't cache answers itself, so it's false for now.
+	Cached bool
+
+	RCode string // the response code, such as "Success" or "NameError"
+	Err   string // the error handling the query, if any
+}
+
+// AddQueryObserver registers fn to be called with each query r handles, if
+// TS_DEBUG_DNS_QUERY_LOG is set. fn is called synchronously after the query
+// is answered and must not block. To remove it, call remove.
+func (r *Resolver) AddQueryObserver(fn func(QueryEvent)) (remove func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := r.queryObservers.Add(fn)
+	r.updateQueryObserverFnsLocked()
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.queryObservers, h)
+		r.updateQueryObserverFnsLocked()
+	}
+}
+
+// updateQueryObserverFnsLocked updates the copy of the query observers that
+// Query reads. r.mu must be held.
+func (r *Resolver) updateQueryObserverFnsLocked() {
+	var fns []func(QueryEvent)
+	for _, fn := range r.queryObservers {
+		fns = append(fns, fn)
+	}
+	r.queryObserverFns.Store(fns)
+}
+
+// observeQuery passes the query bs, answered with out and err after
+// starting at start, to fns.
+func observeQuery(fns []func(QueryEvent), start time.Time, bs, out []byte, forwarded bool, upstream string, err error) {
+	ev := QueryEvent{
+		Time:      start,
+		Took:      time.Since(start),
+		Forwarded: forwarded,
+		Upstream:  upstream,
+	}
+	if name, typ, perr := nameFromQuery(bs); perr == nil {
+		ev.Name = name
+		ev.Type = strings.TrimPrefix(typ.String(), "Type")
+	}
+	if len(out) > 0 {
+		var p dns.Parser
+		if h, perr := p.Start(out); perr == nil {
+			ev.RCode = strings.TrimPrefix(h.RCode.String(), "RCode")
+		}
+	}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	for _, fn := range fns {
+		fn(ev)
+	}
+}
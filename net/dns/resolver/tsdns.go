@@ -37,6 +37,7 @@
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
 )
 
 const dnsSymbolicFQDN = "magicdns.localhost-tailscale-daemon."
@@ -58,10 +59,16 @@ type packet struct {
 	bs     []byte
 	family string         // either "tcp" or "udp"
 	addr   netip.AddrPort // src for a request, dst for a response
+
+	// upstream is the address of the upstream resolver that answered,
+	// for a response from the forwarder.
+	upstream string
 }
 
 // Config is a resolver configuration.
 // Given a Config, queries are resolved in the following order:
+// If the query is within a HostsFallback suffix and has a matching entry in
+// Routes, forward it, and return the upstream answer if there is one.
 // If the query is an exact match for an entry in LocalHosts, return that.
 // Else if the query suffix matches an entry in LocalDomains, return NXDOMAIN.
 // Else forward the query to the most specific matching entry in Routes.
@@ -77,6 +84,13 @@ type Config struct {
 	// LocalDomains is a list of DNS name suffixes that should not be
 	// routed to upstream resolvers.
 	LocalDomains []dnsname.FQDN
+	// HostsFallback is a list of DNS name suffixes within which Hosts are
+	// only used if the upstream resolvers can't answer a query, rather
+	// than taking precedence over them.
+	HostsFallback []dnsname.FQDN
+	// ServiceIP, if valid, is the address queries to the resolver are
+	// sent to, in place of 100.100.100.100 or its IPv6 equivalent.
+	ServiceIP netip.Addr
 }
 
 // WriteToBufioWriter write a debug version of c for logs to w, omitting
@@ -102,6 +116,12 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 	if arpa > 0 {
 		fmt.Fprintf(w, "+%darpa", arpa)
 	}
+	if len(c.HostsFallback) > 0 {
+		fmt.Fprintf(w, " HostsFallback:%v", c.HostsFallback)
+	}
+	if c.ServiceIP.IsValid() {
+		fmt.Fprintf(w, " ServiceIP:%v", c.ServiceIP)
+	}
 	if c := cloudenv.Get(); c != "" {
 		fmt.Fprintf(w, ", cloud=%q", string(c))
 	}
@@ -212,10 +232,18 @@ type Resolver struct {
 	closed chan struct{}
 
 	// mu guards the following fields from being updated while used.
-	mu           sync.Mutex
-	localDomains []dnsname.FQDN
-	hostToIP     map[dnsname.FQDN][]netip.Addr
-	ipToHost     map[netip.Addr]dnsname.FQDN
+	mu            sync.Mutex
+	localDomains  []dnsname.FQDN
+	hostsFallback []dnsname.FQDN
+	hostToIP      map[dnsname.FQDN][]netip.Addr
+	ipToHost      map[netip.Addr]dnsname.FQDN
+	serviceIP     netip.Addr // custom address of the resolver, if valid
+	// queryObservers are the functions registered with AddQueryObserver.
+	queryObservers set.HandleSet[func(QueryEvent)]
+
+	// queryObserverFns is a copy of the functions in queryObservers, for
+	// Query to read without taking mu. It's only stored with mu held.
+	queryObserverFns syncs.AtomicValue[[]func(QueryEvent)]
 }
 
 type ForwardLinkSelector interface {
@@ -260,8 +288,25 @@ func (r *Resolver) SetMissingUpstreamRecovery(f func()) {
 	r.forwarder.missingUpstreamRecovery = f
 }
 
+// SetUpstreamReachabilityCallback sets a callback to be called with false
+// when every upstream of the catch-all route has been failing for a grace
+// period, and with true when one answers again. It's called synchronously
+// and must not block.
+//
+// This call should only happen before the resolver is used. It is not safe
+// for concurrent use.
+func (r *Resolver) SetUpstreamReachabilityCallback(f func(reachable bool)) {
+	r.forwarder.upstream.cb = f
+}
+
 func (r *Resolver) TestOnlySetHook(hook func(Config)) { r.saveConfigForTests = hook }
 
+// SetDebugLoggingEnabled sets whether r logs each query it forwards upstream
+// and its outcome, as if TS_DEBUG_DNS_FORWARD_SEND were set.
+func (r *Resolver) SetDebugLoggingEnabled(v bool) {
+	r.forwarder.debugLogging.Store(v)
+}
+
 func (r *Resolver) SetConfig(cfg Config) error {
 	if r.saveConfigForTests != nil {
 		r.saveConfigForTests(cfg)
@@ -280,11 +325,29 @@ func (r *Resolver) SetConfig(cfg Config) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.localDomains = cfg.LocalDomains
+	r.hostsFallback = cfg.HostsFallback
 	r.hostToIP = cfg.Hosts
 	r.ipToHost = reverse
+	r.serviceIP = cfg.ServiceIP
 	return nil
 }
 
+// isServiceIP reports whether ip is an address the resolver is reached at:
+// the default MagicDNS addresses or the configured ServiceIP.
+func (r *Resolver) isServiceIP(ip netip.Addr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.isServiceIPLocked(ip)
+}
+
+// r.mu must be held.
+func (r *Resolver) isServiceIPLocked(ip netip.Addr) bool {
+	if ip == tsaddr.TailscaleServiceIP() || ip == tsaddr.TailscaleServiceIPv6() {
+		return true
+	}
+	return r.serviceIP.IsValid() && ip == r.serviceIP
+}
+
 // Close shuts down the resolver and ensures poll goroutines have exited.
 // The Resolver cannot be used again after Close is called.
 func (r *Resolver) Close() {
@@ -313,28 +376,110 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 	default:
 	}
 
+	observers := r.queryObserverFns.Load()
+	if len(observers) == 0 || !debugQueryLog() {
+		out, _, _, err := r.query(ctx, bs, family, from)
+		return out, err
+	}
+	start := time.Now()
+	out, forwarded, upstream, err := r.query(ctx, bs, family, from)
+	observeQuery(observers, start, bs, out, forwarded, upstream, err)
+	return out, err
+}
+
+// query answers the query bs, either locally or by forwarding it upstream.
+// forwarded reports which, and upstream is the address of the upstream
+// resolver that answered a forwarded query, if any did.
+func (r *Resolver) query(ctx context.Context, bs []byte, family string, from netip.AddrPort) (_ []byte, forwarded bool, upstream string, _ error) {
+	if r.preferUpstream(bs) {
+		if out, upstream, ok := r.queryUpstreamFirst(ctx, bs, family, from); ok {
+			return out, true, upstream, nil
+		}
+	}
+
 	out, err := r.respond(bs)
 	if err == errNotOurName {
 		responses := make(chan packet, 1)
 		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
 		defer close(responses)
 		defer cancel()
-		err = r.forwarder.forwardWithDestChan(ctx, packet{bs, family, from}, responses)
+		err = r.forwarder.forwardWithDestChan(ctx, packet{bs: bs, family: family, addr: from}, responses)
 		if err != nil {
 			select {
 			// Best effort: use any error response sent by forwardWithDestChan.
 			// This is present in some errors paths, such as when all upstream
 			// DNS servers replied with an error.
 			case resp := <-responses:
-				return resp.bs, err
+				return resp.bs, true, resp.upstream, err
 			default:
-				return nil, err
+				return nil, true, "", err
 			}
 		}
-		return (<-responses).bs, nil
+		resp := <-responses
+		return resp.bs, true, resp.upstream, nil
 	}
 
-	return out, err
+	return out, false, "", err
+}
+
+// preferUpstream reports whether the query bs is for a name within one of
+// the HostsFallback suffixes that the forwarder has upstream resolvers for,
+// so should be forwarded before consulting Hosts.
+func (r *Resolver) preferUpstream(bs []byte) bool {
+	r.mu.Lock()
+	fallback := r.hostsFallback
+	r.mu.Unlock()
+	if len(fallback) == 0 {
+		return false
+	}
+	q := parseExitNodeQuery(bs)
+	if q == nil {
+		return false
+	}
+	name, err := dnsname.ToFQDN(q.Question.Name.String())
+	if err != nil {
+		return false
+	}
+	for _, suffix := range fallback {
+		if suffix.Contains(name) {
+			rs, _ := r.forwarder.resolvers(name)
+			return len(rs) > 0
+		}
+	}
+	return false
+}
+
+// queryUpstreamFirst forwards the query bs upstream. It reports false if no
+// upstream resolver answered it with a record, in which case the caller
+// should fall back to answering from Hosts. Otherwise, upstream is the
+// address of the resolver that answered.
+func (r *Resolver) queryUpstreamFirst(ctx context.Context, bs []byte, family string, from netip.AddrPort) (_ []byte, upstream string, ok bool) {
+	responses := make(chan packet, 1)
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer close(responses)
+	defer cancel()
+	if err := r.forwarder.forwardWithDestChan(ctx, packet{bs: bs, family: family, addr: from}, responses); err != nil {
+		select {
+		case <-responses:
+		default:
+		}
+		return nil, "", false
+	}
+	resp := <-responses
+	out := resp.bs
+	var p dns.Parser
+	h, err := p.Start(out)
+	if err != nil || h.RCode != dns.RCodeSuccess {
+		return nil, "", false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, "", false
+	}
+	if _, err := p.AnswerHeader(); err != nil {
+		// No answers (or a malformed one); Hosts may have a record.
+		return nil, "", false
+	}
+	return out, resp.upstream, true
 }
 
 // parseExitNodeQuery parses a DNS request packet.
@@ -396,13 +541,13 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 		// TODO: more than 1 resolver from /etc/resolv.conf?
 
 		var resolvers []resolverAndDelay
-		switch nameserver {
-		case tsaddr.TailscaleServiceIP(), tsaddr.TailscaleServiceIPv6():
+		switch {
+		case r.isServiceIP(nameserver):
 			// If resolv.conf says 100.100.100.100, it's coming right back to us anyway
 			// so avoid the loop through the kernel and just do what we
 			// would've done anyway. By not passing any resolvers, the forwarder
 			// will use its default ones from our DNS config.
-		case netip.Addr{}:
+		case !nameserver.IsValid():
 			// Likewise, if the platform has no resolv.conf, just use our defaults.
 		default:
 			resolvers = []resolverAndDelay{{
@@ -410,7 +555,7 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 			}}
 		}
 
-		err = r.forwarder.forwardWithDestChan(ctx, packet{q, "tcp", from}, ch, resolvers...)
+		err = r.forwarder.forwardWithDestChan(ctx, packet{bs: q, family: "tcp", addr: from}, ch, resolvers...)
 		if err != nil {
 			metricDNSExitProxyErrorForward.Add(1)
 			return nil, err
@@ -618,10 +763,17 @@ func (r *Resolver) resolveLocal(domain dnsname.FQDN, typ dns.Type) (netip.Addr,
 	// DNS endpoint. To round out this special case, we also do the inverse
 	// (returning the endpoint IP if someone looks up the symbolic domain).
 	if domain == dnsSymbolicFQDN {
-		switch typ {
-		case dns.TypeA:
+		r.mu.Lock()
+		custom := r.serviceIP
+		r.mu.Unlock()
+		switch {
+		case typ == dns.TypeA && custom.Is4():
+			return custom, dns.RCodeSuccess
+		case typ == dns.TypeAAAA && custom.Is6():
+			return custom, dns.RCodeSuccess
+		case typ == dns.TypeA:
 			return tsaddr.TailscaleServiceIP(), dns.RCodeSuccess
-		case dns.TypeAAAA:
+		case typ == dns.TypeAAAA:
 			return tsaddr.TailscaleServiceIPv6(), dns.RCodeSuccess
 		}
 	}
@@ -832,7 +984,7 @@ func (r *Resolver) fqdnForIPLocked(ip netip.Addr, name dnsname.FQDN) (dnsname.FQ
 	// return a domain that helps indicate that Tailscale is using
 	// this IP for a special purpose and it is not a node on their
 	// tailnet.
-	if ip == tsaddr.TailscaleServiceIP() || ip == tsaddr.TailscaleServiceIPv6() {
+	if r.isServiceIPLocked(ip) {
 		return dnsSymbolicFQDN, dns.RCodeSuccess
 	}
 
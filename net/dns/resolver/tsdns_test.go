@@ -23,9 +23,11 @@
 
 	miekdns "github.com/miekg/dns"
 	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/tstest"
 	"tailscale.com/types/dnstype"
@@ -425,6 +427,25 @@ func TestResolveLocal(t *testing.T) {
 	}
 }
 
+func TestResolveCustomServiceIP(t *testing.T) {
+	r := newResolver(t)
+	defer r.Close()
+	custom := netip.MustParseAddr("100.100.200.53")
+	r.SetConfig(Config{ServiceIP: custom})
+
+	if ip, code := r.resolveLocal(dnsSymbolicFQDN, dns.TypeA); ip != custom || code != dns.RCodeSuccess {
+		t.Errorf("A %s = %v, %v; want %v", dnsSymbolicFQDN, ip, code, custom)
+	}
+	if ip, code := r.resolveLocal(dnsSymbolicFQDN, dns.TypeAAAA); ip != tsaddr.TailscaleServiceIPv6() || code != dns.RCodeSuccess {
+		t.Errorf("AAAA %s = %v, %v; want %v", dnsSymbolicFQDN, ip, code, tsaddr.TailscaleServiceIPv6())
+	}
+	for _, name := range []dnsname.FQDN{"53.200.100.100.in-addr.arpa.", "100.100.100.100.in-addr.arpa."} {
+		if got, code := r.resolveLocalReverse(name); got != dnsSymbolicFQDN || code != dns.RCodeSuccess {
+			t.Errorf("PTR %s = %q, %v; want %q", name, got, code, dnsSymbolicFQDN)
+		}
+	}
+}
+
 func TestResolveLocalReverse(t *testing.T) {
 	r := newResolver(t)
 	defer r.Close()
@@ -1522,3 +1543,41 @@ func TestServfail(t *testing.T) {
 		t.Errorf("response was %X, want %X", pkt, wantPkt)
 	}
 }
+
+func TestQueryObserver(t *testing.T) {
+	r := newResolver(t)
+	defer r.Close()
+	r.SetConfig(dnsCfg)
+
+	var got []QueryEvent
+	remove := r.AddQueryObserver(func(ev QueryEvent) { got = append(got, ev) })
+
+	query := func() {
+		t.Helper()
+		if _, err := r.Query(context.Background(), dnspacket("test1.ipn.dev.", dns.TypeA, noEdns), "udp", netip.AddrPort{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query() // not observed with TS_DEBUG_DNS_QUERY_LOG unset
+	if len(got) != 0 {
+		t.Fatalf("observed %d queries with query logging disabled", len(got))
+	}
+
+	envknob.Setenv("TS_DEBUG_DNS_QUERY_LOG", "true")
+	t.Cleanup(func() { envknob.Setenv("TS_DEBUG_DNS_QUERY_LOG", "") })
+	query()
+	if len(got) != 1 {
+		t.Fatalf("observed %d queries; want 1", len(got))
+	}
+	ev := got[0]
+	if ev.Name != "test1.ipn.dev." || ev.Type != "A" || ev.Forwarded || ev.Upstream != "" || ev.RCode != "Success" || ev.Err != "" {
+		t.Errorf("got event %+v; want local answer for test1.ipn.dev. A", ev)
+	}
+
+	remove()
+	query()
+	if len(got) != 1 {
+		t.Errorf("observed %d queries after removal; want 1", len(got))
+	}
+}
@@ -14,6 +14,7 @@
 
 	"github.com/golang/groupcache/lru"
 	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/clientmetric"
 )
 
 // MessageCache is a cache that works at the DNS message layer,
@@ -54,6 +55,7 @@ func (c *MessageCache) SetMaxCacheSize(n int) {
 func (c *MessageCache) Flush() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	metricMessageCacheEntries.Add(-int64(c.cache.Len()))
 	c.cache.Clear()
 }
 
@@ -63,6 +65,8 @@ func (c *MessageCache) pruneLocked() {
 	max := cmp.Or(c.cacheSizeSet, 500)
 	for c.cache.Len() > max {
 		c.cache.RemoveOldest()
+		metricMessageCacheEntries.Add(-1)
+		metricMessageCacheEvict.Add(1)
 	}
 }
 
@@ -112,6 +116,7 @@ type msgResource struct {
 func (c *MessageCache) ReplyFromCache(w io.Writer, dnsQueryMessage []byte) error {
 	cacheKey, txID, ok := getDNSQueryCacheKey(dnsQueryMessage)
 	if !ok {
+		metricMessageCacheMiss.Add(1)
 		return ErrCacheMiss
 	}
 	now := c.now()
@@ -121,13 +126,16 @@ func (c *MessageCache) ReplyFromCache(w io.Writer, dnsQueryMessage []byte) error
 	v, ok := cacheEntI.(*msgCacheValue)
 	if ok && now.After(v.Expires) {
 		c.cache.Remove(cacheKey)
+		metricMessageCacheEntries.Add(-1)
 		ok = false
 	}
 	c.mu.Unlock()
 
 	if !ok {
+		metricMessageCacheMiss.Add(1)
 		return ErrCacheMiss
 	}
+	metricMessageCacheHit.Add(1)
 
 	ttl := uint32(v.Expires.Sub(now).Seconds())
 
@@ -211,7 +219,9 @@ func (c *MessageCache) AddCacheEntry(qPacket, res []byte) error {
 func (c *MessageCache) addCacheValue(cacheKey msgQ, v *msgCacheValue) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	n := c.cache.Len()
 	c.cache.Add(cacheKey, v)
+	metricMessageCacheEntries.Add(int64(c.cache.Len() - n))
 	c.pruneLocked()
 }
 
@@ -309,3 +319,12 @@ func packDNSResponse(q msgQ, txID uint16, ttl uint32, answers []msgResource) ([]
 	}
 	return b.Finish()
 }
+
+// Metrics for all MessageCaches, for tuning their size. The hit rate is
+// hits/(hits+misses).
+var (
+	metricMessageCacheEntries = clientmetric.NewGauge("dnscache_message_entries")
+	metricMessageCacheHit     = clientmetric.NewCounter("dnscache_message_hit")
+	metricMessageCacheMiss    = clientmetric.NewCounter("dnscache_message_miss")
+	metricMessageCacheEvict   = clientmetric.NewCounter("dnscache_message_evict")
+)
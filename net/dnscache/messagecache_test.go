@@ -15,6 +15,7 @@
 
 	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/tstest"
+	"tailscale.com/util/clientmetric"
 )
 
 func TestMessageCache(t *testing.T) {
@@ -178,6 +179,49 @@ func makeDNSPkt(txID uint16, name string, opt ...any) []byte {
 	return buf
 }
 
+func TestMessageCacheMetrics(t *testing.T) {
+	metrics := []*clientmetric.Metric{
+		metricMessageCacheEntries,
+		metricMessageCacheHit,
+		metricMessageCacheMiss,
+		metricMessageCacheEvict,
+	}
+	base := make([]int64, len(metrics))
+	for i, m := range metrics {
+		base[i] = m.Value()
+	}
+	check := func(when string, entries, hits, misses, evictions int64) {
+		t.Helper()
+		want := []int64{entries, hits, misses, evictions}
+		for i, m := range metrics {
+			if got := m.Value() - base[i]; got != want[i] {
+				t.Errorf("%s: %s changed by %d; want %d", when, m.Name(), got, want[i])
+			}
+		}
+	}
+
+	mc := &MessageCache{}
+	mc.SetMaxCacheSize(1)
+	var out bytes.Buffer
+	add := func(name string) {
+		t.Helper()
+		if err := mc.AddCacheEntry(makeQ(1, name), makeRes(1, name, ttlOpt(10),
+			&dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add("foo.com.")
+	check("after add", 1, 0, 0, 0)
+	mc.ReplyFromCache(&out, makeQ(2, "foo.com."))
+	mc.ReplyFromCache(&out, makeQ(3, "bar.com."))
+	check("after lookups", 1, 1, 1, 0)
+	add("bar.com.")
+	check("after add past max size", 1, 1, 1, 1)
+	mc.Flush()
+	check("after flush", 0, 1, 1, 1)
+}
+
 func TestASCIILowerName(t *testing.T) {
 	n := asciiLowerName(dnsmessage.MustNewName("Foo.COM."))
 	if got, want := n.String(), "foo.com."; got != want {
@@ -52,8 +52,9 @@ func (t Tuple) DstAddr() netip.Addr {
 	return netip.AddrFrom16(t.dst).Unmap()
 }
 
-func (t Tuple) SrcPort() uint16 { return t.srcPort }
-func (t Tuple) DstPort() uint16 { return t.dstPort }
+func (t Tuple) SrcPort() uint16      { return t.srcPort }
+func (t Tuple) DstPort() uint16      { return t.dstPort }
+func (t Tuple) Proto() ipproto.Proto { return t.proto }
 
 func (t Tuple) String() string {
 	return fmt.Sprintf("(%v %v => %v)", t.proto,
@@ -9,6 +9,7 @@
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/netip"
 	"runtime"
 	"sync"
@@ -19,6 +20,10 @@
 	"tailscale.com/util/set"
 )
 
+// ErrNoDefaultRoute is returned by Monitor.DefaultRouteInterface when
+// there's no default route, as when the machine is offline.
+var ErrNoDefaultRoute = errors.New("no default route")
+
 // pollWallTimeInterval is how often we check the time to check
 // for big jumps in wall (non-monotonic) time as a backup mechanism
 // to get notified of a sleeping device waking back up.
@@ -60,19 +65,24 @@ type Monitor struct {
 	// and not change at runtime.
 	tsIfName string // tailscale interface name, if known/set ("tailscale0", "utun3", ...)
 
-	mu         sync.Mutex // guards all following fields
-	cbs        set.HandleSet[ChangeFunc]
-	ruleDelCB  set.HandleSet[RuleDeleteCallback]
-	ifState    *State
-	gwValid    bool       // whether gw and gwSelfIP are valid
-	gw         netip.Addr // our gateway's IP
-	gwSelfIP   netip.Addr // our own IP address (that corresponds to gw)
-	started    bool
-	closed     bool
-	goroutines sync.WaitGroup
-	wallTimer  *time.Timer // nil until Started; re-armed AfterFunc per tick
-	lastWall   time.Time
-	timeJumped bool // whether we need to send a changed=true after a big time jump
+	// checkMu serializes reading the network state with applying it, so
+	// that a slower read can't overwrite a newer state. See checkState.
+	checkMu sync.Mutex
+
+	mu             sync.Mutex // guards all following fields
+	cbs            set.HandleSet[ChangeFunc]
+	ruleDelCB      set.HandleSet[RuleDeleteCallback]
+	ifState        *State
+	gwValid        bool       // whether gw and gwSelfIP are valid
+	gw             netip.Addr // our gateway's IP
+	gwSelfIP       netip.Addr // our own IP address (that corresponds to gw)
+	started        bool
+	closed         bool
+	goroutines     sync.WaitGroup
+	wallTimer      *time.Timer // nil until Started; re-armed AfterFunc per tick
+	lastWall       time.Time
+	timeJumped     bool          // whether we need to send a changed=true after a big time jump
+	debounceWindow time.Duration // if non-zero, window in which events are collapsed; see SetDebounce
 }
 
 // ChangeFunc is a callback function registered with Monitor that's called when the
@@ -160,6 +170,24 @@ func (m *Monitor) InterfaceState() *State {
 	return m.ifState
 }
 
+// DefaultRouteInterface returns the name and index of the interface that
+// owns the default route, not including any Tailscale interface, per the
+// monitor's latest snapshot of the network state. It reflects route changes
+// as the monitor observes them. If there's no default route, it returns
+// ErrNoDefaultRoute.
+func (m *Monitor) DefaultRouteInterface() (name string, idx int, err error) {
+	st := m.InterfaceState()
+	if st == nil || st.DefaultRouteInterface == "" {
+		return "", 0, ErrNoDefaultRoute
+	}
+	name = st.DefaultRouteInterface
+	iface, ok := st.Interface[name]
+	if !ok || iface.Interface == nil {
+		return "", 0, fmt.Errorf("default route interface %q not found", name)
+	}
+	return name, iface.Index, nil
+}
+
 func (m *Monitor) interfaceStateUncached() (*State, error) {
 	return GetState()
 }
@@ -218,6 +246,20 @@ func (m *Monitor) RegisterChangeCallback(callback ChangeFunc) (unregister func()
 	}
 }
 
+// SetDebounce sets how long the monitor waits after a network change event
+// for further events before re-checking the network state and calling the
+// ChangeFunc callbacks. Events in that window are collapsed into one check,
+// so a burst of events, as from a flaky Wi-Fi link, results in at most one
+// callback. If any of the events was forced, as by InjectEvent, the
+// callbacks are called even if the state didn't change.
+//
+// A zero d, the default, checks the state as soon as an event arrives.
+func (m *Monitor) SetDebounce(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.debounceWindow = d
+}
+
 // RuleDeleteCallback is a callback when a Linux IP policy routing
 // rule is deleted. The table is the table number (52, 253, 354) and
 // priority is the priority order number (for Tailscale rules
@@ -395,12 +437,29 @@ func (m *Monitor) debounce() {
 		case forceCallbacks = <-m.change:
 		}
 
-		if newState, err := m.interfaceStateUncached(); err != nil {
-			m.logf("interfaces.State: %v", err)
-		} else {
-			m.handlePotentialChange(newState, forceCallbacks)
+		m.mu.Lock()
+		window := m.debounceWindow
+		m.mu.Unlock()
+		if window > 0 {
+			// Collapse the events that arrive within the window into
+			// this one.
+			timer := time.NewTimer(window)
+		collapse:
+			for {
+				select {
+				case <-m.stop:
+					timer.Stop()
+					return
+				case force := <-m.change:
+					forceCallbacks = forceCallbacks || force
+				case <-timer.C:
+					break collapse
+				}
+			}
 		}
 
+		m.checkState(forceCallbacks)
+
 		select {
 		case <-m.stop:
 			return
@@ -409,6 +468,37 @@ func (m *Monitor) debounce() {
 	}
 }
 
+// PollNow synchronously re-reads the state of the network from the OS,
+// updating the monitor's state, and reports whether it changed. If so,
+// ChangeFunc callbacks are fired, as for a change the monitor observed
+// itself. Unlike Poll, it doesn't wait for the monitor's debounce pass,
+// which makes it useful right after a program changes the network
+// configuration.
+//
+// It's safe to call concurrently with the monitor's own checks. For a
+// static Monitor, it returns the snapshot's state and false.
+func (m *Monitor) PollNow() (*State, bool) {
+	if m.static {
+		return m.InterfaceState(), false
+	}
+	return m.checkState(false)
+}
+
+// checkState reads the state of the network and passes it to
+// handlePotentialChange. It returns the monitor's resulting state and
+// whether callbacks were fired.
+func (m *Monitor) checkState(forceCallbacks bool) (*State, bool) {
+	m.checkMu.Lock()
+	defer m.checkMu.Unlock()
+	newState, err := m.interfaceStateUncached()
+	if err != nil {
+		m.logf("interfaces.State: %v", err)
+		return m.InterfaceState(), false
+	}
+	changed := m.handlePotentialChange(newState, forceCallbacks)
+	return m.InterfaceState(), changed
+}
+
 var (
 	metricChangeEq       = clientmetric.NewCounter("netmon_link_change_eq")
 	metricChange         = clientmetric.NewCounter("netmon_link_change")
@@ -419,8 +509,9 @@ func (m *Monitor) debounce() {
 // handlePotentialChange considers whether newState is different enough to wake
 // up callers and updates the monitor's state if so.
 //
-// If forceCallbacks is true, they're always notified.
-func (m *Monitor) handlePotentialChange(newState *State, forceCallbacks bool) {
+// If forceCallbacks is true, they're always notified. It reports whether
+// they were.
+func (m *Monitor) handlePotentialChange(newState *State, forceCallbacks bool) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	oldState := m.ifState
@@ -428,7 +519,7 @@ func (m *Monitor) handlePotentialChange(newState *State, forceCallbacks bool) {
 	if !timeJumped && !forceCallbacks && oldState.Equal(newState) {
 		// Exactly equal. Nothing to do.
 		metricChangeEq.Add(1)
-		return
+		return false
 	}
 
 	delta := &ChangeDelta{
@@ -468,6 +559,7 @@ func (m *Monitor) handlePotentialChange(newState *State, forceCallbacks bool) {
 	for _, cb := range m.cbs {
 		go cb(delta)
 	}
+	return true
 }
 
 // IsMajorChangeFrom reports whether the transition from s1 to s2 is
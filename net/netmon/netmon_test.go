@@ -4,6 +4,7 @@
 package netmon
 
 import (
+	"errors"
 	"flag"
 	"net"
 	"net/netip"
@@ -58,6 +59,98 @@ func TestMonitorInjectEvent(t *testing.T) {
 	}
 }
 
+func TestMonitorDebounce(t *testing.T) {
+	mon, err := New(t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mon.Close()
+	const window = 300 * time.Millisecond
+	mon.SetDebounce(window)
+	var calls atomic.Int32
+	mon.RegisterChangeCallback(func(*ChangeDelta) {
+		calls.Add(1)
+	})
+	mon.Start()
+
+	// A burst of events within the window results in one callback,
+	// after the window.
+	start := time.Now()
+	for range 5 {
+		mon.InjectEvent()
+		time.Sleep(20 * time.Millisecond)
+	}
+	for calls.Load() == 0 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timeout waiting for callback")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d := time.Since(start); d < window {
+		t.Errorf("callback after %v; want after the %v window", d, window)
+	}
+	time.Sleep(2 * window)
+	if got := calls.Load(); got != 1 {
+		t.Errorf("got %d callbacks for the burst; want 1", got)
+	}
+}
+
+func TestMonitorPollNow(t *testing.T) {
+	mon, err := New(t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mon.Close()
+	got := make(chan *ChangeDelta, 1)
+	mon.RegisterChangeCallback(func(d *ChangeDelta) {
+		select {
+		case got <- d:
+		default:
+		}
+	})
+
+	// Make the monitor's state stale, so polling finds a major change.
+	mon.mu.Lock()
+	mon.ifState = &State{DefaultRouteInterface: "bogus0"}
+	mon.mu.Unlock()
+
+	st, changed := mon.PollNow()
+	if !changed {
+		t.Fatal("PollNow after state change reported no change")
+	}
+	if st == nil || mon.InterfaceState() != st {
+		t.Errorf("PollNow returned state %v; monitor has %v", st, mon.InterfaceState())
+	}
+	select {
+	case d := <-got:
+		if d.New != st {
+			t.Errorf("callback got state %v; want %v", d.New, st)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for callback")
+	}
+}
+
+func TestMonitorDefaultRouteInterface(t *testing.T) {
+	m := &Monitor{static: true}
+	if _, _, err := m.DefaultRouteInterface(); !errors.Is(err, ErrNoDefaultRoute) {
+		t.Errorf("with no state: err = %v; want ErrNoDefaultRoute", err)
+	}
+	m.ifState = &State{}
+	if _, _, err := m.DefaultRouteInterface(); !errors.Is(err, ErrNoDefaultRoute) {
+		t.Errorf("offline: err = %v; want ErrNoDefaultRoute", err)
+	}
+	m.ifState = &State{
+		DefaultRouteInterface: "eth0",
+		Interface: map[string]Interface{
+			"eth0": {Interface: &net.Interface{Name: "eth0", Index: 2}},
+		},
+	}
+	if name, idx, err := m.DefaultRouteInterface(); err != nil || name != "eth0" || idx != 2 {
+		t.Errorf("DefaultRouteInterface = %q, %d, %v; want eth0, 2, nil", name, idx, err)
+	}
+}
+
 var (
 	monitor         = flag.String("monitor", "", `go into monitor mode like 'route monitor'; test never terminates. Value can be either "raw" or "callback"`)
 	monitorDuration = flag.Duration("monitor-duration", 0, "if non-zero, how long to run TestMonitorMode. Zero means forever.")
@@ -15,6 +15,8 @@
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/netip"
 	"sync/atomic"
@@ -22,6 +24,7 @@
 	"tailscale.com/net/netknob"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
 )
 
 var disabled atomic.Bool
@@ -53,6 +56,43 @@ func SetDisableBindConnToInterface(v bool) {
 	disableBindConnToInterface.Store(v)
 }
 
+// interfaceAllowlist, if non-nil, is the set of names of the interfaces
+// that sockets may egress through. See SetInterfaceAllowlist.
+var interfaceAllowlist atomic.Pointer[set.Set[string]]
+
+// SetInterfaceAllowlist restricts the network interfaces that sockets
+// created with this package may egress through to those named in ifaces,
+// such as to keep tailscaled off a management interface on a multi-homed
+// host. Sockets that would use any other interface fail in their control
+// hook. On Linux, sockets are instead bound to an allowed interface with
+// SO_BINDTODEVICE, preferring the default route's, which requires root.
+// An empty list removes the restriction, which is the default.
+//
+// Currently, this only has an effect on Linux, macOS and Windows.
+func SetInterfaceAllowlist(ifaces []string) {
+	if len(ifaces) == 0 {
+		interfaceAllowlist.Store(nil)
+		return
+	}
+	s := set.SetOf(ifaces)
+	interfaceAllowlist.Store(&s)
+}
+
+// errInterfaceNotAllowed is returned by control hooks for sockets that
+// would egress through an interface not in the allowlist.
+var errInterfaceNotAllowed = errors.New("interface not in allowlist")
+
+// checkInterfaceAllowed returns an error, and logs, if the interface named
+// ifName may not be used to reach address per SetInterfaceAllowlist.
+func checkInterfaceAllowed(logf logger.Logf, ifName, address string) error {
+	allow := interfaceAllowlist.Load()
+	if allow == nil || allow.Contains(ifName) {
+		return nil
+	}
+	logf("netns: refusing to use interface %q for %q: not in allowlist", ifName, address)
+	return fmt.Errorf("netns: interface %q for %q: %w", ifName, address, errInterfaceNotAllowed)
+}
+
 // Listener returns a new net.Listener with its Control hook func
 // initialized as necessary to run in logical network namespace that
 // doesn't route back into Tailscale.
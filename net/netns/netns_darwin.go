@@ -51,8 +51,20 @@ func controlLogf(logf logger.Logf, netMon *netmon.Monitor, network, address stri
 	idx, err := getInterfaceIndex(logf, netMon, address)
 	if err != nil {
 		// callee logged
+		if interfaceAllowlist.Load() != nil {
+			return fmt.Errorf("netns: finding egress interface for %q: %w", address, err)
+		}
 		return nil
 	}
+	if interfaceAllowlist.Load() != nil {
+		iface, err := net.InterfaceByIndex(idx)
+		if err != nil {
+			return fmt.Errorf("netns: interface %d for %q: %w", idx, address, err)
+		}
+		if err := checkInterfaceAllowed(logf, iface.Name, address); err != nil {
+			return err
+		}
+	}
 
 	return bindConnToInterface(c, network, address, idx, logf)
 }
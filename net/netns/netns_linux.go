@@ -17,6 +17,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/linuxfw"
+	"tailscale.com/util/set"
 )
 
 // socketMarkWorksOnce is the sync.Once & cached value for useSocketMark.
@@ -78,8 +79,78 @@ func ignoreErrors() bool {
 	return false
 }
 
-func control(logger.Logf, *netmon.Monitor) func(network, address string, c syscall.RawConn) error {
-	return controlC
+func control(logf logger.Logf, _ *netmon.Monitor) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if allow := interfaceAllowlist.Load(); allow != nil && !isLocalhost(address) {
+			return controlAllowedInterface(logf, *allow, address, c)
+		}
+		return controlC(network, address, c)
+	}
+}
+
+// controlAllowedInterface is like controlC, but also binds c with
+// SO_BINDTODEVICE to an interface in allow, so
+// the kernel can't route it out of any other interface. Unlike controlC,
+// it doesn't ignore setsockopt errors, even when not root.
+func controlAllowedInterface(logf logger.Logf, allow set.Set[string], address string, c syscall.RawConn) error {
+	ifc, err := allowedEgressInterface(logf, allow, address)
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = c.Control(func(fd uintptr) {
+		if UseSocketMark() {
+			if sockErr = setBypassMark(fd); sockErr != nil {
+				return
+			}
+		}
+		if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifc); err != nil {
+			sockErr = fmt.Errorf("setting SO_BINDTODEVICE to %q: %w", ifc, err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("RawConn.Control on %T: %w", c, err)
+	}
+	return sockErr
+}
+
+// allowedEgressInterface returns the name of the interface to bind a
+// socket for address to. It's the default route's interface if that's
+// allowed, and otherwise the first allowed interface that's up.
+func allowedEgressInterface(logf logger.Logf, allow set.Set[string], address string) (string, error) {
+	def, err := netmon.DefaultRouteInterface()
+	if err != nil {
+		def = ""
+	}
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("netns: listing interfaces for %q: %w", address, err)
+	}
+	var up []string
+	for _, ifc := range ifs {
+		if ifc.Flags&net.FlagUp != 0 && ifc.Flags&net.FlagLoopback == 0 {
+			up = append(up, ifc.Name)
+		}
+	}
+	if ifc, ok := pickAllowedInterface(allow, def, up); ok {
+		return ifc, nil
+	}
+	logf("netns: refusing to use interface %q for %q: not in allowlist, and no allowed interface is up", def, address)
+	return "", fmt.Errorf("netns: no allowed interface for %q: %w", address, errInterfaceNotAllowed)
+}
+
+// pickAllowedInterface returns def if it's in allow, and otherwise the
+// first of up in allow. It reports false if none is.
+func pickAllowedInterface(allow set.Set[string], def string, up []string) (string, bool) {
+	if def != "" && allow.Contains(def) {
+		return def, true
+	}
+	for _, ifc := range up {
+		if allow.Contains(ifc) {
+			return ifc, true
+		}
+	}
+	return "", false
 }
 
 // controlC marks c as necessary to dial in a separate network namespace.
@@ -5,6 +5,8 @@
 
 import (
 	"testing"
+
+	"tailscale.com/util/set"
 )
 
 func TestSocketMarkWorks(t *testing.T) {
@@ -12,3 +14,27 @@ func TestSocketMarkWorks(t *testing.T) {
 	// we cannot actually assert whether the test runner has SO_MARK available
 	// or not, as we don't know. We're just checking that it doesn't panic.
 }
+
+func TestPickAllowedInterface(t *testing.T) {
+	allow := set.SetOf([]string{"eth1", "wlan0"})
+	tests := []struct {
+		name   string
+		def    string
+		up     []string
+		want   string
+		wantOK bool
+	}{
+		{"default-allowed", "wlan0", []string{"eth1", "wlan0"}, "wlan0", true},
+		{"default-not-allowed", "mgmt0", []string{"mgmt0", "eth1"}, "eth1", true},
+		{"no-default-route", "", []string{"wlan0"}, "wlan0", true},
+		{"none-allowed-up", "mgmt0", []string{"mgmt0", "eth0"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pickAllowedInterface(allow, tt.def, tt.up)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got (%q, %v); want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
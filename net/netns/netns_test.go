@@ -14,7 +14,10 @@
 package netns
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -76,3 +79,30 @@ func TestIsLocalhost(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckInterfaceAllowed(t *testing.T) {
+	defer SetInterfaceAllowlist(nil)
+
+	var logs []string
+	logf := func(format string, args ...any) { logs = append(logs, fmt.Sprintf(format, args...)) }
+
+	if err := checkInterfaceAllowed(logf, "mgmt0", "1.2.3.4:443"); err != nil {
+		t.Errorf("unrestricted: %v", err)
+	}
+
+	SetInterfaceAllowlist([]string{"eth0", "wlan0"})
+	if err := checkInterfaceAllowed(logf, "eth0", "1.2.3.4:443"); err != nil {
+		t.Errorf("allowed interface: %v", err)
+	}
+	if err := checkInterfaceAllowed(logf, "mgmt0", "1.2.3.4:443"); !errors.Is(err, errInterfaceNotAllowed) {
+		t.Errorf("disallowed interface: err = %v; want errInterfaceNotAllowed", err)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], `"mgmt0"`) {
+		t.Errorf("logs = %q; want one rejection of mgmt0", logs)
+	}
+
+	SetInterfaceAllowlist(nil)
+	if err := checkInterfaceAllowed(logf, "mgmt0", "1.2.3.4:443"); err != nil {
+		t.Errorf("after clearing allowlist: %v", err)
+	}
+}
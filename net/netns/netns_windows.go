@@ -6,6 +6,7 @@
 import (
 	"fmt"
 	"math/bits"
+	"net"
 	"net/netip"
 	"strings"
 	"syscall"
@@ -111,6 +112,17 @@ func controlC(logf logger.Logf, network, address string, c syscall.RawConn) (err
 		ifaceIdxV4, ifaceIdxV6 = defIfaceIdxV4, defIfaceIdxV6
 	}
 
+	if canV4 {
+		if err := checkInterfaceIndexAllowed(logf, ifaceIdxV4, address); err != nil {
+			return err
+		}
+	}
+	if canV6 {
+		if err := checkInterfaceIndexAllowed(logf, ifaceIdxV6, address); err != nil {
+			return err
+		}
+	}
+
 	if canV4 {
 		if err := bindSocket4(c, ifaceIdxV4); err != nil {
 			return fmt.Errorf("bindSocket4(%d): %w", ifaceIdxV4, err)
@@ -126,6 +138,24 @@ func controlC(logf logger.Logf, network, address string, c syscall.RawConn) (err
 	return nil
 }
 
+// checkInterfaceIndexAllowed returns an error if the interface with index
+// idx may not be used to reach address per SetInterfaceAllowlist. The zero
+// index, which leaves the choice of interface to the OS, is never allowed
+// when there is an allowlist.
+func checkInterfaceIndexAllowed(logf logger.Logf, idx uint32, address string) error {
+	if interfaceAllowlist.Load() == nil {
+		return nil
+	}
+	if idx == 0 {
+		return fmt.Errorf("netns: no egress interface for %q: %w", address, errInterfaceNotAllowed)
+	}
+	iface, err := net.InterfaceByIndex(int(idx))
+	if err != nil {
+		return fmt.Errorf("netns: interface %d for %q: %w", idx, address, err)
+	}
+	return checkInterfaceAllowed(logf, iface.Name, address)
+}
+
 func getInterfaceIndex(logf logger.Logf, addr netip.Addr, defaultIdx uint32) (idx uint32, err error) {
 	idx, err = interfaceIndexFor(addr)
 	if err != nil {
@@ -38,6 +38,12 @@ func init() {
 	expvar.Publish("stun", stats)
 }
 
+// Stats returns the number of STUN binding requests received, and of those
+// successfully answered, by all STUN servers in the process.
+func Stats() (requests, successes int64) {
+	return stunIPv4.Value() + stunIPv6.Value(), stunSuccess.Value()
+}
+
 type STUNServer struct {
 	ctx context.Context // ctx signals service shutdown
 	pc  *net.UDPConn    // pc is the UDP listener
@@ -19,6 +19,7 @@ func TestSTUNServer(t *testing.T) {
 	defer cancel()
 	s := New(ctx)
 	must.Do(s.Listen("localhost:0"))
+	reqs0, succ0 := Stats()
 	var w sync.WaitGroup
 	w.Add(1)
 	var serveErr error
@@ -50,6 +51,9 @@ func TestSTUNServer(t *testing.T) {
 	if tid != txid {
 		t.Fatalf("STUN response has wrong transaction ID; got %d, want %d", tid, txid)
 	}
+	if reqs, succ := Stats(); reqs != reqs0+1 || succ != succ0+1 {
+		t.Errorf("Stats = %d, %d; want %d, %d", reqs, succ, reqs0+1, succ0+1)
+	}
 
 	cancel()
 	w.Wait()
@@ -0,0 +1,191 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/net/packet"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/ipproto"
+)
+
+const (
+	// maxTrackedFlows is the most flows a flowTable tracks at once.
+	// Packets of new flows beyond this are not counted.
+	maxTrackedFlows = 4096
+
+	// flowShardBits is log2 of the number of shards a flowTable is split
+	// into, so that packets of different flows rarely contend for the
+	// same lock.
+	flowShardBits = 4
+	flowShards    = 1 << flowShardBits
+
+	// maxTrackedFlowsPerShard is the most flows a single shard tracks.
+	maxTrackedFlowsPerShard = maxTrackedFlows / flowShards
+
+	// flowIdleTimeout is how long a flow may go without traffic before
+	// it's no longer considered active.
+	flowIdleTimeout = 2 * time.Minute
+
+	// flowPruneInterval is the minimum time between prunes of a full
+	// shard, bounding how often the packet path scans it.
+	flowPruneInterval = flowIdleTimeout / 4
+)
+
+// Flow is a connection carried by a Wrapper, as seen from this node.
+type Flow struct {
+	Proto  ipproto.Proto
+	Local  netip.AddrPort // address on this side of the tunnel
+	Remote netip.AddrPort // address on the peer's side of the tunnel
+
+	TxPackets uint64 // packets sent from Local to Remote
+	TxBytes   uint64
+	RxPackets uint64 // packets received by Local from Remote
+	RxBytes   uint64
+
+	LastActive time.Time // when the flow last carried a packet
+}
+
+type flowCounts struct {
+	txPackets, txBytes uint64
+	rxPackets, rxBytes uint64
+	lastActive         mono.Time
+}
+
+// flowTable counts the packets and bytes of the flows passing through a
+// Wrapper. Its zero value is ready for use.
+type flowTable struct {
+	shards [flowShards]flowShard
+}
+
+// flowShard is the part of a flowTable holding the flows that hash to it.
+type flowShard struct {
+	mu        sync.Mutex
+	flows     map[flowtrack.Tuple]*flowCounts // keyed with Src as the local side
+	lastPrune mono.Time
+}
+
+// shardFor returns the shard of ft that tracks the flow between local and
+// remote.
+func (ft *flowTable) shardFor(local, remote netip.AddrPort) *flowShard {
+	l, r := local.Addr().As16(), remote.Addr().As16()
+	h := uint32(local.Port())<<16 | uint32(remote.Port())
+	h ^= uint32(l[15])<<8 | uint32(r[15])
+	h *= 0x9e3779b1 // Fibonacci hashing; the top bits are well mixed
+	return &ft.shards[h>>(32-flowShardBits)]
+}
+
+// update counts p, which has been accepted by the filter. If inbound, p was
+// received from a peer; otherwise it is being sent to one.
+func (ft *flowTable) update(p *packet.Parsed, inbound bool) {
+	switch p.IPProto {
+	case ipproto.TCP, ipproto.UDP, ipproto.ICMPv4, ipproto.ICMPv6, ipproto.SCTP:
+	default:
+		return
+	}
+	local, remote := p.Src, p.Dst
+	if inbound {
+		local, remote = remote, local
+	}
+	k := flowtrack.MakeTuple(p.IPProto, local, remote)
+	now := mono.Now()
+	n := uint64(len(p.Buffer()))
+
+	sh := ft.shardFor(local, remote)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	c, ok := sh.flows[k]
+	if !ok {
+		if len(sh.flows) >= maxTrackedFlowsPerShard {
+			// Pruning scans the whole shard, so don't do it for every
+			// packet of a new flow while the shard is full of active
+			// ones; drop new flows until the next prune instead.
+			if now.Sub(sh.lastPrune) < flowPruneInterval {
+				return
+			}
+			sh.pruneLocked(now)
+			if len(sh.flows) >= maxTrackedFlowsPerShard {
+				return
+			}
+		}
+		if sh.flows == nil {
+			sh.flows = make(map[flowtrack.Tuple]*flowCounts)
+		}
+		c = new(flowCounts)
+		sh.flows[k] = c
+	}
+	if inbound {
+		c.rxPackets++
+		c.rxBytes += n
+	} else {
+		c.txPackets++
+		c.txBytes += n
+	}
+	c.lastActive = now
+}
+
+// pruneLocked removes flows idle for longer than flowIdleTimeout.
+// sh.mu must be held.
+func (sh *flowShard) pruneLocked(now mono.Time) {
+	sh.lastPrune = now
+	for k, c := range sh.flows {
+		if now.Sub(c.lastActive) > flowIdleTimeout {
+			delete(sh.flows, k)
+		}
+	}
+}
+
+// active returns the flows that carried traffic within the last
+// flowIdleTimeout.
+func (ft *flowTable) active() []Flow {
+	now := mono.Now()
+	var flows []Flow
+	for i := range ft.shards {
+		sh := &ft.shards[i]
+		sh.mu.Lock()
+		sh.pruneLocked(now)
+		for k, c := range sh.flows {
+			flows = append(flows, Flow{
+				Proto:      k.Proto(),
+				Local:      netip.AddrPortFrom(k.SrcAddr(), k.SrcPort()),
+				Remote:     netip.AddrPortFrom(k.DstAddr(), k.DstPort()),
+				TxPackets:  c.txPackets,
+				TxBytes:    c.txBytes,
+				RxPackets:  c.rxPackets,
+				RxBytes:    c.rxBytes,
+				LastActive: c.lastActive.WallTime(),
+			})
+		}
+		sh.mu.Unlock()
+	}
+	return flows
+}
+
+// SetFlowTracking sets whether t tracks the flows it carries, for
+// ActiveFlows. Tracking is off by default, as it costs a map update per
+// packet. Turning it off discards the flows tracked so far; turning it on
+// tracks flows from then on, so ActiveFlows reports none until they carry
+// more traffic.
+func (t *Wrapper) SetFlowTracking(on bool) {
+	if !on {
+		t.flows.Store(nil)
+		return
+	}
+	t.flows.CompareAndSwap(nil, new(flowTable))
+}
+
+// ActiveFlows returns the connections that t carried traffic for within
+// the last two minutes, with their packet and byte counts. It returns nil
+// if flow tracking is off; see SetFlowTracking.
+func (t *Wrapper) ActiveFlows() []Flow {
+	ft := t.flows.Load()
+	if ft == nil {
+		return nil
+	}
+	return ft.active()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"sync/atomic"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+// PacketCounts are the packets and bytes carried in each direction.
+// Tx is from this node to peers; Rx is from peers to this node.
+type PacketCounts struct {
+	TxPackets uint64
+	TxBytes   uint64
+	RxPackets uint64
+	RxBytes   uint64
+}
+
+// ProtoStats are the packets carried by a Wrapper, broken down by IP
+// protocol.
+type ProtoStats struct {
+	TCP   PacketCounts
+	UDP   PacketCounts
+	ICMP  PacketCounts // ICMPv4 and ICMPv6
+	Other PacketCounts
+}
+
+// protoClass is a protocol counted separately by protoCounters.
+type protoClass int
+
+const (
+	protoTCP protoClass = iota
+	protoUDP
+	protoICMP
+	protoOther
+	numProtoClasses
+)
+
+func classifyProto(p ipproto.Proto) protoClass {
+	switch p {
+	case ipproto.TCP:
+		return protoTCP
+	case ipproto.UDP:
+		return protoUDP
+	case ipproto.ICMPv4, ipproto.ICMPv6:
+		return protoICMP
+	}
+	return protoOther
+}
+
+// protoCounters counts the packets passing through a Wrapper by protocol
+// class and direction. Its zero value is ready for use.
+//
+// Counting only uses atomic adds so as to stay cheap in the packet path.
+// As a result, a snapshot isn't taken at a single instant: a packet
+// counted concurrently may be reflected in its packet count but not yet
+// its byte count, or in one protocol's counts but not another's.
+type protoCounters struct {
+	// c is indexed by protocol class, then [txPackets, txBytes, rxPackets,
+	// rxBytes].
+	c [numProtoClasses][4]atomic.Uint64
+}
+
+// add counts p, which has been accepted by the filter. If inbound, p was
+// received from a peer; otherwise it is being sent to one.
+func (pc *protoCounters) add(p *packet.Parsed, inbound bool) {
+	c := &pc.c[classifyProto(p.IPProto)]
+	i := 0
+	if inbound {
+		i = 2
+	}
+	c[i].Add(1)
+	c[i+1].Add(uint64(len(p.Buffer())))
+}
+
+// snapshot returns the current counts. If reset, it also zeroes them, so
+// the next snapshot covers only the packets counted since.
+func (pc *protoCounters) snapshot(reset bool) ProtoStats {
+	load := func(class protoClass) PacketCounts {
+		var v [4]uint64
+		for i := range v {
+			if reset {
+				v[i] = pc.c[class][i].Swap(0)
+			} else {
+				v[i] = pc.c[class][i].Load()
+			}
+		}
+		return PacketCounts{TxPackets: v[0], TxBytes: v[1], RxPackets: v[2], RxBytes: v[3]}
+	}
+	return ProtoStats{
+		TCP:   load(protoTCP),
+		UDP:   load(protoUDP),
+		ICMP:  load(protoICMP),
+		Other: load(protoOther),
+	}
+}
+
+// ProtoStats returns the packets and bytes t has carried by IP protocol
+// since it was created or its stats were last reset. Only packets accepted
+// by the packet filter are counted.
+//
+// The result is an eventually-consistent snapshot: packets passing through
+// t while it is taken may be partially reflected.
+func (t *Wrapper) ProtoStats() ProtoStats {
+	return t.protoStats.snapshot(false)
+}
+
+// ResetProtoStats is like ProtoStats but also zeroes the counts, for
+// measuring the traffic over an interval. No packet is counted in both
+// the returned stats and a later call's.
+func (t *Wrapper) ResetProtoStats() ProtoStats {
+	return t.protoStats.snapshot(true)
+}
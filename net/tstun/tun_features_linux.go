@@ -10,10 +10,16 @@
 
 func setLinkFeatures(dev tun.Device) error {
 	if envknob.Bool("TS_TUN_DISABLE_UDP_GRO") {
-		linuxDev, ok := dev.(tun.LinuxDevice)
-		if ok {
-			linuxDev.DisableUDPGRO()
-		}
+		disableTUNOffload(dev)
 	}
 	return nil
 }
+
+// disableTUNOffload disables the offloads of dev that can be disabled after
+// creation. Currently that's only UDP GRO; TCP offloads stay enabled if the
+// kernel supports them.
+func disableTUNOffload(dev tun.Device) {
+	if linuxDev, ok := dev.(tun.LinuxDevice); ok {
+		linuxDev.DisableUDPGRO()
+	}
+}
@@ -24,6 +24,7 @@
 	"go4.org/mem"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"tailscale.com/disco"
+	"tailscale.com/envknob"
 	"tailscale.com/net/connstats"
 	"tailscale.com/net/packet"
 	"tailscale.com/net/packet/checksum"
@@ -53,6 +54,10 @@
 
 const tapDebug = false // for super verbose TAP debugging
 
+// tunDisableOffload reports whether TSO/GRO offloads should be disabled on
+// new Wrappers. See Wrapper.SetOffload.
+var tunDisableOffload = envknob.RegisterBool("TS_TUN_DISABLE_OFFLOAD")
+
 var (
 	// ErrClosed is returned when attempting an operation on a closed Wrapper.
 	ErrClosed = errors.New("device closed")
@@ -203,9 +208,24 @@ type Wrapper struct {
 	// disableTSMPRejected disables TSMP rejected responses. For tests.
 	disableTSMPRejected bool
 
+	// noOffload is whether segmentation and receive offloads are
+	// disabled. See SetOffload.
+	noOffload atomic.Bool
+
 	// stats maintains per-connection counters.
 	stats atomic.Pointer[connstats.Statistics]
 
+	// flows, if non-nil, tracks the active flows for ActiveFlows.
+	// See SetFlowTracking.
+	flows atomic.Pointer[flowTable]
+
+	// dnsServiceIP is an additional IP on which MagicDNS is served, if
+	// valid. See SetDNSServiceIP.
+	dnsServiceIP syncs.AtomicValue[netip.Addr]
+
+	// protoStats counts packets by protocol for ProtoStats.
+	protoStats protoCounters
+
 	captureHook syncs.AtomicValue[capture.Callback]
 }
 
@@ -289,9 +309,35 @@ func wrap(logf logger.Logf, tdev tun.Device, isTAP bool) *Wrapper {
 		sw.setWrapper(w)
 	}
 
+	if tunDisableOffload() {
+		w.SetOffload(false)
+	}
+
 	return w
 }
 
+// SetOffload sets whether t uses TCP segmentation and generic receive
+// offloads (TSO/GRO) where supported. Offloads are enabled by default, and
+// can also be disabled at startup with TS_TUN_DISABLE_OFFLOAD=true.
+//
+// Disabling offloads stops t from coalescing packets it delivers to
+// netstack, and on Linux also disables UDP GRO on the TUN device, which
+// can't be re-enabled without recreating the device. If the kernel lacks
+// offload support, the TUN device already reads and writes one packet at a
+// time (see OffloadSupported) and only the netstack path is affected.
+func (t *Wrapper) SetOffload(enable bool) {
+	t.noOffload.Store(!enable)
+	if !enable {
+		disableTUNOffload(t.tdev)
+	}
+}
+
+// OffloadSupported reports whether t's underlying TUN device supports
+// batched reads and writes with offloads.
+func (t *Wrapper) OffloadSupported() bool {
+	return t.tdev.BatchSize() > 1
+}
+
 // now returns the current time, either by calling t.timeNow if set or time.Now
 // if not.
 func (t *Wrapper) now() time.Time {
@@ -805,6 +851,19 @@ func (t *Wrapper) SetWGConfig(wcfg *wgcfg.Config) {
 	}
 }
 
+// SetDNSServiceIP sets an additional IP address, besides
+// 100.100.100.100 and its IPv6 equivalent, on which MagicDNS is
+// served. An invalid ip clears it.
+func (t *Wrapper) SetDNSServiceIP(ip netip.Addr) {
+	t.dnsServiceIP.Store(ip)
+}
+
+// DNSServiceIP returns the additional MagicDNS IP set by
+// SetDNSServiceIP, or the zero value if none.
+func (t *Wrapper) DNSServiceIP() netip.Addr {
+	return t.dnsServiceIP.Load()
+}
+
 var (
 	magicDNSIPPort   = netip.AddrPortFrom(tsaddr.TailscaleServiceIP(), 0) // 100.100.100.100:0
 	magicDNSIPPortv6 = netip.AddrPortFrom(tsaddr.TailscaleServiceIPv6(), 0)
@@ -827,6 +886,18 @@ func (t *Wrapper) filterPacketOutboundToWireGuard(p *packet.Parsed, pc *peerConf
 			t.InjectInboundCopy(outp)
 			return filter.DropSilently // don't pass on to OS; already handled
 		}
+		if ip := t.dnsServiceIP.Load(); ip.IsValid() && p.Dst.Addr() == ip {
+			if ip.Is4() {
+				header := p.ICMP4Header()
+				header.ToResponse()
+				t.InjectInboundCopy(packet.Generate(&header, p.Payload()))
+			} else {
+				header := p.ICMP6Header()
+				header.ToResponse()
+				t.InjectInboundCopy(packet.Generate(&header, p.Payload()))
+			}
+			return filter.DropSilently
+		}
 	}
 
 	// Issue 1526 workaround: if we sent disco packets over
@@ -935,6 +1006,11 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 			}
 		}
 
+		if ft := t.flows.Load(); ft != nil {
+			ft.update(p, false)
+		}
+		t.protoStats.add(p, false)
+
 		// Make sure to do SNAT after filtering, so that any flow tracking in
 		// the filter sees the original source address. See #12133.
 		pc.snat(p)
@@ -1182,6 +1258,7 @@ func (t *Wrapper) Write(buffs [][]byte, offset int) (int, error) {
 	captHook := t.captureHook.Load()
 	pc := t.peerConfig.Load()
 	var buffsGRO *gro.GRO
+	noOffload := t.noOffload.Load()
 	for _, buff := range buffs {
 		p.Decode(buff[offset:])
 		pc.dnat(p)
@@ -1191,9 +1268,18 @@ func (t *Wrapper) Write(buffs [][]byte, offset int) (int, error) {
 			//  appropriately. It is not only responsible for filtering, it
 			//  also routes packets towards gVisor/netstack.
 			res, buffsGRO = t.filterPacketInboundFromWireGuard(p, captHook, pc, buffsGRO)
+			if noOffload && buffsGRO != nil {
+				// Deliver each packet to netstack without coalescing.
+				buffsGRO.Flush()
+				buffsGRO = nil
+			}
 			if res != filter.Accept {
 				metricPacketInDrop.Add(1)
 			} else {
+				if ft := t.flows.Load(); ft != nil {
+					ft.update(p, true)
+				}
+				t.protoStats.add(p, true)
 				buffs[i] = buff
 				i++
 			}
@@ -40,6 +40,7 @@
 	"tailscale.com/util/must"
 	"tailscale.com/wgengine/capture"
 	"tailscale.com/wgengine/filter"
+	"tailscale.com/wgengine/netstack/gro"
 	"tailscale.com/wgengine/wgcfg"
 )
 
@@ -479,6 +480,81 @@ func BenchmarkWrite(b *testing.B) {
 	}
 }
 
+// BenchmarkWriteOffload compares writing vectors of packets destined for
+// netstack with offloads (GRO coalescing) enabled and disabled.
+func BenchmarkWriteOffload(b *testing.B) {
+	for _, enabled := range []bool{true, false} {
+		b.Run(fmt.Sprintf("offload=%v", enabled), func(b *testing.B) {
+			b.ReportAllocs()
+			_, tun := newFakeTUN(b.Logf, true)
+			defer tun.Close()
+			tun.SetOffload(enabled)
+			tun.PostFilterPacketInboundFromWireGuard = func(p *packet.Parsed, _ *Wrapper, g *gro.GRO) (filter.Response, *gro.GRO) {
+				if g == nil {
+					g = gro.NewGRO()
+				}
+				g.Enqueue(p)
+				return filter.DropSilently, g
+			}
+
+			const batch = 64
+			pkts := make([][]byte, batch)
+			for i := range pkts {
+				pkts[i] = udp4("5.6.7.8", "1.2.3.4", 89, 89)
+			}
+			vec := make([][]byte, batch)
+			b.SetBytes(int64(batch * len(pkts[0])))
+			b.ResetTimer()
+			for range b.N {
+				copy(vec, pkts)
+				if _, err := tun.Write(vec, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSetOffload(t *testing.T) {
+	_, tun := newFakeTUN(t.Logf, true)
+	defer tun.Close()
+
+	var vectors int
+	tun.PostFilterPacketInboundFromWireGuard = func(p *packet.Parsed, _ *Wrapper, g *gro.GRO) (filter.Response, *gro.GRO) {
+		if g == nil {
+			vectors++
+			g = gro.NewGRO()
+		}
+		return filter.DropSilently, g
+	}
+	write := func() {
+		t.Helper()
+		vec := [][]byte{
+			udp4("5.6.7.8", "1.2.3.4", 89, 89),
+			udp4("5.6.7.8", "1.2.3.4", 89, 89),
+			udp4("5.6.7.8", "1.2.3.4", 89, 89),
+		}
+		if _, err := tun.Write(vec, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write()
+	if vectors != 1 {
+		t.Errorf("with offload, got %d GROs for one vector; want 1", vectors)
+	}
+	if tun.OffloadSupported() {
+		t.Errorf("fake TUN reports offload support")
+	}
+
+	vectors = 0
+	tun.SetOffload(false)
+	write()
+	if vectors != 3 {
+		t.Errorf("without offload, got %d GROs for 3 packets; want 3", vectors)
+	}
+}
+
 func TestAtomic64Alignment(t *testing.T) {
 	off := unsafe.Offsetof(Wrapper{}.lastActivityAtomic)
 	if off%8 != 0 {
@@ -919,3 +995,173 @@ type captureRecord struct {
 			captured, want)
 	}
 }
+
+func TestFlowTable(t *testing.T) {
+	var ft flowTable
+	var p packet.Parsed
+
+	out := udp4("1.2.3.4", "5.6.7.8", 1000, 53)
+	p.Decode(out)
+	ft.update(&p, false)
+	ft.update(&p, false)
+
+	in := udp4("5.6.7.8", "1.2.3.4", 53, 1000)
+	p.Decode(in)
+	ft.update(&p, true)
+
+	p.Decode(tcp4syn("1.2.3.4", "5.6.7.8", 2000, 443))
+	ft.update(&p, false)
+
+	flows := ft.active()
+	if len(flows) != 2 {
+		t.Fatalf("got %d flows; want 2: %+v", len(flows), flows)
+	}
+	for _, f := range flows {
+		if f.Proto != ipproto.UDP {
+			continue
+		}
+		if want := netip.MustParseAddrPort("1.2.3.4:1000"); f.Local != want {
+			t.Errorf("Local = %v; want %v", f.Local, want)
+		}
+		if want := netip.MustParseAddrPort("5.6.7.8:53"); f.Remote != want {
+			t.Errorf("Remote = %v; want %v", f.Remote, want)
+		}
+		if f.TxPackets != 2 || f.TxBytes != uint64(2*len(out)) {
+			t.Errorf("tx = %d packets, %d bytes; want 2, %d", f.TxPackets, f.TxBytes, 2*len(out))
+		}
+		if f.RxPackets != 1 || f.RxBytes != uint64(len(in)) {
+			t.Errorf("rx = %d packets, %d bytes; want 1, %d", f.RxPackets, f.RxBytes, len(in))
+		}
+	}
+}
+
+func TestProtoStats(t *testing.T) {
+	var pc protoCounters
+	var p packet.Parsed
+
+	udp := udp4("1.2.3.4", "5.6.7.8", 1000, 53)
+	tcp := tcp4syn("5.6.7.8", "1.2.3.4", 2000, 443)
+	icmp := packet.Generate(packet.ICMP4Header{
+		IP4Header: packet.IP4Header{
+			IPProto: ipproto.ICMPv4,
+			Src:     netip.MustParseAddr("1.2.3.4"),
+			Dst:     netip.MustParseAddr("5.6.7.8"),
+		},
+		Type: packet.ICMP4EchoRequest,
+	}, []byte("ping"))
+	gre := packet.Generate(packet.IP4Header{
+		IPProto: ipproto.Proto(47),
+		Src:     netip.MustParseAddr("5.6.7.8"),
+		Dst:     netip.MustParseAddr("1.2.3.4"),
+	}, []byte("payload"))
+
+	p.Decode(udp)
+	pc.add(&p, false)
+	pc.add(&p, false)
+	p.Decode(tcp)
+	pc.add(&p, true)
+	p.Decode(icmp)
+	pc.add(&p, false)
+	p.Decode(gre)
+	pc.add(&p, true)
+
+	want := ProtoStats{
+		UDP:   PacketCounts{TxPackets: 2, TxBytes: uint64(2 * len(udp))},
+		TCP:   PacketCounts{RxPackets: 1, RxBytes: uint64(len(tcp))},
+		ICMP:  PacketCounts{TxPackets: 1, TxBytes: uint64(len(icmp))},
+		Other: PacketCounts{RxPackets: 1, RxBytes: uint64(len(gre))},
+	}
+	if got := pc.snapshot(false); got != want {
+		t.Errorf("snapshot = %+v; want %+v", got, want)
+	}
+	if got := pc.snapshot(true); got != want {
+		t.Errorf("snapshot with reset = %+v; want %+v", got, want)
+	}
+	if got := pc.snapshot(false); got != (ProtoStats{}) {
+		t.Errorf("after reset, snapshot = %+v; want zero", got)
+	}
+}
+
+func TestFlowTrackingOptIn(t *testing.T) {
+	chtun, tun := newChannelTUN(t.Logf, true)
+	defer tun.Close()
+
+	send := func() {
+		t.Helper()
+		chtun.Outbound <- udp4("1.2.3.4", "5.6.7.8", 1000, 53)
+		buf := make([]byte, 1500)
+		sizes := make([]int, 1)
+		if _, err := tun.Read([][]byte{buf}, sizes, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send()
+	if flows := tun.ActiveFlows(); flows != nil {
+		t.Errorf("flows tracked by default: %+v", flows)
+	}
+	tun.SetFlowTracking(true)
+	send()
+	if flows := tun.ActiveFlows(); len(flows) != 1 || flows[0].TxPackets != 1 {
+		t.Errorf("with tracking on, got flows %+v; want 1 flow of 1 packet", flows)
+	}
+	tun.SetFlowTracking(false)
+	if flows := tun.ActiveFlows(); flows != nil {
+		t.Errorf("flows after turning tracking off: %+v", flows)
+	}
+}
+
+func TestFlowTablePruneInterval(t *testing.T) {
+	var ft flowTable
+	var p packet.Parsed
+	local := netip.MustParseAddrPort("1.2.3.4:1000")
+	sh := ft.shardFor(local, netip.MustParseAddrPort("5.6.7.8:53"))
+
+	// Fill the shard that flows from local to 5.6.7.8:53 land in with
+	// flows to other ports of 5.6.7.8.
+	var extra netip.AddrPort
+	for port := uint16(1); !extra.IsValid(); port++ {
+		remote := netip.AddrPortFrom(netip.MustParseAddr("5.6.7.8"), port)
+		if ft.shardFor(local, remote) != sh {
+			continue
+		}
+		if len(sh.flows) < maxTrackedFlowsPerShard {
+			p.Decode(udp4("1.2.3.4", "5.6.7.8", local.Port(), port))
+			ft.update(&p, false)
+		} else {
+			extra = remote
+		}
+	}
+	// All flows are active, so a full shard drops new flows, and doesn't
+	// rescan itself for each of them.
+	p.Decode(udp4("1.2.3.4", "5.6.7.8", local.Port(), extra.Port()))
+	ft.update(&p, false)
+	pruned := sh.lastPrune
+	if pruned == 0 {
+		t.Fatal("full shard wasn't pruned")
+	}
+	ft.update(&p, false)
+	if sh.lastPrune != pruned {
+		t.Errorf("shard pruned again within flowPruneInterval")
+	}
+	if len(sh.flows) != maxTrackedFlowsPerShard {
+		t.Errorf("tracking %d flows; want %d", len(sh.flows), maxTrackedFlowsPerShard)
+	}
+}
+
+func TestFlowTableShards(t *testing.T) {
+	var ft flowTable
+	var p packet.Parsed
+	for i := range 1000 {
+		p.Decode(udp4("1.2.3.4", "5.6.7.8", uint16(10000+i), 53))
+		ft.update(&p, false)
+	}
+	if got := len(ft.active()); got != 1000 {
+		t.Errorf("tracking %d flows; want 1000", got)
+	}
+	for i := range ft.shards {
+		if len(ft.shards[i].flows) == 0 {
+			t.Errorf("shard %d is empty; flows aren't spread across shards", i)
+		}
+	}
+}
@@ -37,7 +37,7 @@ func main() {
 
 	if *addr == ":443" {
 		ln = tls.NewListener(ln, &tls.Config{
-			GetCertificate: lc.GetCertificate,
+			GetCertificate: s.GetCertificate,
 		})
 	}
 	log.Fatal(http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The tsnet-dial program demonstrates dialing out to other tailnet nodes
+// from a tsnet.Server. It fetches the page served by the tshello example.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"tailscale.com/tsnet"
+)
+
+var (
+	addr     = flag.String("addr", "tshello:80", "address of the tshello server, by MagicDNS name or Tailscale IP")
+	hostname = flag.String("hostname", "tsnet-dial", "hostname of this node on the tailnet")
+)
+
+func main() {
+	flag.Parse()
+
+	s := &tsnet.Server{Hostname: *hostname}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := s.Up(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := s.Dial(ctx, "tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	host, _, err := net.SplitHostPort(*addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(c, "GET / HTTP/1.0\r\nHost: %s\r\n\r\n", host)
+	if _, err := io.Copy(os.Stdout, c); err != nil {
+		log.Fatal(err)
+	}
+}
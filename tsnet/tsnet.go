@@ -100,12 +100,17 @@ type Server struct {
 	Logf logger.Logf
 
 	// Ephemeral, if true, specifies that the instance should register
-	// as an Ephemeral node (https://tailscale.com/s/ephemeral-nodes).
+	// as an Ephemeral node (https://tailscale.com/s/ephemeral-nodes),
+	// which control removes shortly after it goes offline. It's the only
+	// way to make the node ephemeral; there's no environment variable for
+	// it. Ephemeral nodes may use an in-memory Store.
 	Ephemeral bool
 
 	// AuthKey, if non-empty, is the auth key to create the node
 	// and will be preferred over the TS_AUTHKEY environment
-	// variable. If the node is already created (from state
+	// variable, which is in turn preferred over TS_AUTH_KEY. If
+	// none of them is set, the node logs an interactive login URL
+	// instead. If the node is already created (from state
 	// previously stored in Store), then this field is not
 	// used.
 	AuthKey string
@@ -149,6 +154,9 @@ type Server struct {
 	fallbackTCPHandlers set.HandleSet[FallbackTCPHandler]
 	dialer              *tsdial.Dialer
 	closed              bool
+	lbStarting          bool               // lb is set, so state changes can be watched
+	stateCallback       func(ipn.State)    // or nil
+	stopStateWatch      context.CancelFunc // stops watching for stateCallback, or nil
 }
 
 // FallbackTCPHandler describes the callback which
@@ -164,7 +172,9 @@ type Server struct {
 // over the TCP conn.
 type FallbackTCPHandler func(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool)
 
-// Dial connects to the address on the tailnet.
+// Dial connects to the address on the tailnet, using s's own netstack
+// rather than the host's network stack. Host names are resolved with
+// MagicDNS first, so peers can be dialed by name, as in "tshello:80".
 // It will start the server if it has not been started yet.
 func (s *Server) Dial(ctx context.Context, network, address string) (net.Conn, error) {
 	if err := s.Start(); err != nil {
@@ -187,6 +197,10 @@ func (s *Server) HTTPClient() *http.Client {
 
 // LocalClient returns a LocalClient that speaks to s.
 //
+// The client talks to s's LocalAPI in memory, not over tailscaled's socket,
+// so WhoIs, Status and other calls target s even when several Servers run in
+// one process or tailscaled runs on the same machine.
+//
 // It will start the server if it has not been started yet. If the server's
 // already been started successfully, it doesn't return an error.
 func (s *Server) LocalClient() (*tailscale.LocalClient, error) {
@@ -343,6 +357,51 @@ func (s *Server) Up(ctx context.Context) (*ipnstate.Status, error) {
 	}
 }
 
+// SetStateCallback sets cb to be called with the backend's state, such as
+// ipn.NeedsLogin, ipn.Running or ipn.Stopped, whenever it changes. It's
+// first called with the current state, so callers needn't also check the
+// state themselves. If the server hasn't been started yet, cb is first
+// called when it is, with ipn.NoState.
+//
+// Calls are made one at a time, from a goroutine owned by s. Setting a new
+// callback replaces the previous one, and a nil cb stops the calls; the
+// previous callback may still be running, or be called once more, when
+// SetStateCallback returns.
+func (s *Server) SetStateCallback(cb func(ipn.State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopStateWatch != nil {
+		s.stopStateWatch()
+		s.stopStateWatch = nil
+	}
+	s.stateCallback = cb
+	if s.lbStarting && cb != nil {
+		s.startStateWatchLocked()
+	}
+}
+
+// startStateWatchLocked starts calling s.stateCallback with the backend's
+// state changes. It returns once the watch is registered with the
+// backend, so no state change after it returns is missed.
+//
+// s.mu must be held and s.lb must be set.
+func (s *Server) startStateWatchLocked() {
+	ctx, cancel := context.WithCancel(s.shutdownCtx)
+	s.stopStateWatch = cancel
+	cb := s.stateCallback
+	watchAdded := make(chan struct{})
+	go s.lb.WatchNotifications(ctx, ipn.NotifyInitialState, func() { close(watchAdded) }, func(n *ipn.Notify) bool {
+		if n.State != nil && ctx.Err() == nil {
+			cb(*n.State)
+		}
+		return ctx.Err() == nil
+	})
+	select {
+	case <-watchAdded:
+	case <-ctx.Done():
+	}
+}
+
 // Close stops the server.
 //
 // It must not be called before or concurrently with Start.
@@ -413,12 +472,69 @@ func (s *Server) doInit() {
 	}
 }
 
+// MetricsHandler returns an HTTP handler that serves metrics about s in the
+// Prometheus text exposition format, suitable for mounting at /metrics.
+//
+// The tsnet_* metrics describe this Server's backend state, peers and
+// listeners. They're followed by the process-wide client metrics (such as
+// magicsock and DNS counters), which are shared by every Server in the
+// process.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.writeMetrics(w)
+		clientmetric.WritePrometheusExpositionFormat(w)
+	})
+}
+
+// writeMetrics writes s's metrics to w in the Prometheus text exposition
+// format.
+func (s *Server) writeMetrics(w io.Writer) {
+	state := ipn.NoState
+	var peers, online int
+	if s.lb != nil {
+		state = s.lb.State()
+		if nm := s.lb.NetMap(); nm != nil {
+			peers = len(nm.Peers)
+			for _, p := range nm.Peers {
+				if o := p.Online(); o != nil && *o {
+					online++
+				}
+			}
+		}
+	}
+	s.mu.Lock()
+	listeners := len(s.listeners)
+	s.mu.Unlock()
+
+	var up int
+	if state == ipn.Running {
+		up = 1
+	}
+	fmt.Fprintf(w, "# TYPE tsnet_up gauge\n")
+	fmt.Fprintf(w, "tsnet_up %d\n", up)
+	fmt.Fprintf(w, "# TYPE tsnet_backend_state gauge\n")
+	fmt.Fprintf(w, "tsnet_backend_state{state=%q} 1\n", state.String())
+	fmt.Fprintf(w, "# TYPE tsnet_peers gauge\n")
+	fmt.Fprintf(w, "tsnet_peers %d\n", peers)
+	fmt.Fprintf(w, "# TYPE tsnet_peers_online gauge\n")
+	fmt.Fprintf(w, "tsnet_peers_online %d\n", online)
+	fmt.Fprintf(w, "# TYPE tsnet_listeners gauge\n")
+	fmt.Fprintf(w, "tsnet_listeners %d\n", listeners)
+}
+
 // CertDomains returns the list of domains for which the server can
 // provide TLS certificates. These are also the DNS names for the
 // Server.
 // If the server is not running, it returns nil.
 func (s *Server) CertDomains() []string {
-	nm := s.lb.NetMap()
+	s.mu.Lock()
+	lb := s.lb
+	s.mu.Unlock()
+	if lb == nil {
+		return nil
+	}
+	nm := lb.NetMap()
 	if nm == nil {
 		return nil
 	}
@@ -603,7 +719,15 @@ func (s *Server) start() (reterr error) {
 	lb.SetTCPHandlerForFunnelFlow(s.getTCPHandlerForFunnelFlow)
 	lb.SetVarRoot(s.rootPath)
 	s.logf("tsnet starting with hostname %q, varRoot %q", s.hostname, s.rootPath)
+	s.mu.Lock()
 	s.lb = lb
+	s.lbStarting = true
+	if s.stateCallback != nil {
+		// Watch before starting the backend, so the callback sees every
+		// state from the initial one.
+		s.startStateWatchLocked()
+	}
+	s.mu.Unlock()
 	if err := ns.Start(lb); err != nil {
 		return fmt.Errorf("failed to start netstack: %w", err)
 	}
@@ -971,7 +1095,7 @@ func (s *Server) ListenTLS(network, addr string) (net.Listener, error) {
 		return nil, err
 	}
 	return tls.NewListener(ln, &tls.Config{
-		GetCertificate: s.getCert,
+		GetCertificate: s.GetCertificate,
 	}), nil
 }
 
@@ -993,11 +1117,17 @@ func (s *Server) RegisterFallbackTCPHandler(cb FallbackTCPHandler) func() {
 	}
 }
 
-// getCert is the GetCertificate function used by ListenTLS.
+// GetCertificate returns a TLS certificate for one of s's CertDomains, for
+// use as a tls.Config.GetCertificate function. It's the one used by
+// ListenTLS and ListenFunnel.
+//
+// Unlike tailscale.GetCertificate, it fetches the certificate through s's
+// own LocalAPI and certificate store, so each Server in a process
+// terminates TLS with its own identity.
 //
-// It calls GetCertificate on the localClient, passing in the ClientHelloInfo.
-// For testing, if s.getCertForTesting is set, it will call that instead.
-func (s *Server) getCert(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+// It will start the server if it has not been started yet.
+func (s *Server) GetCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// For testing, if s.getCertForTesting is set, call that instead.
 	if s.getCertForTesting != nil {
 		return s.getCertForTesting(hi)
 	}
@@ -1035,6 +1165,12 @@ func FunnelOnly() FunnelOption { return funnelOnly(1) }
 //
 // and the only other supported addrs currently are ":8443" and ":10000".
 //
+// ListenFunnel turns on Funnel for addr in the node's serve config and
+// serves TLS with a certificate for the node's domain, so no "tailscale
+// serve" or "tailscale funnel" configuration is needed. It returns an
+// error if the tailnet policy doesn't permit the node to use Funnel on
+// addr's port.
+//
 // It will start the server if it has not been started yet.
 func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.Listener, error) {
 	if network != "tcp" {
@@ -1062,7 +1198,7 @@ func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.L
 	// if not already on. Specifically when running from a terminal.
 	// See cli.serveEnv.verifyFunnelEnabled.
 	if err := ipn.CheckFunnelAccess(uint16(port), st.Self); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ListenFunnel(%q, %q): %w", network, addr, err)
 	}
 
 	lc := s.localClient
@@ -1100,7 +1236,7 @@ func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.L
 		return nil, err
 	}
 	return tls.NewListener(ln, &tls.Config{
-		GetCertificate: s.getCert,
+		GetCertificate: s.GetCertificate,
 	}), nil
 }
 
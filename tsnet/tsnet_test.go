@@ -25,6 +25,7 @@
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -42,6 +43,7 @@
 	"tailscale.com/tstest/integration/testcontrol"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/must"
 )
 
@@ -287,6 +289,27 @@ func TestConn(t *testing.T) {
 		t.Errorf("got %q, want %q", got, want)
 	}
 
+	// s1 can also be dialed by its MagicDNS name.
+	w, err = s2.Dial(ctx, "tcp", "s1:8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.WriteString(w, want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAtLeast(r, got, len(got)); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("dialed by name: got %q, want %q", got, want)
+	}
+
 	_, err = s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8082", s1ip)) // some random port
 	if err == nil {
 		t.Fatalf("unexpected success; should have seen a connection refused error")
@@ -302,6 +325,139 @@ func TestConn(t *testing.T) {
 	}
 }
 
+func TestLocalClient(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, s2ip, _ := startServer(t, ctx, controlURL, "s2")
+
+	// Each server's LocalClient talks to that server, not the other one
+	// in the same process.
+	for _, tt := range []struct {
+		s        *Server
+		hostname string
+		peerIP   netip.Addr
+		peerName string
+	}{
+		{s1, "s1", s2ip, "s2"},
+		{s2, "s2", s1ip, "s1"},
+	} {
+		lc := must.Get(tt.s.LocalClient())
+		st, err := lc.StatusWithoutPeers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Self.HostName != tt.hostname {
+			t.Errorf("%s: Status.Self.HostName = %q; want %q", tt.hostname, st.Self.HostName, tt.hostname)
+		}
+		who, err := lc.WhoIs(ctx, tt.peerIP.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if who.Node.Hostinfo.Hostname() != tt.peerName {
+			t.Errorf("%s: WhoIs(%v) = %q; want %q", tt.hostname, tt.peerIP, who.Node.Hostinfo.Hostname(), tt.peerName)
+		}
+	}
+}
+
+func TestGetAuthKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		authKey   string
+		tsAuthkey string
+		tsAuthKey string
+		want      string
+	}{
+		{name: "none"},
+		{name: "field", authKey: "field", tsAuthkey: "env1", tsAuthKey: "env2", want: "field"},
+		{name: "TS_AUTHKEY", tsAuthkey: "env1", tsAuthKey: "env2", want: "env1"},
+		{name: "TS_AUTH_KEY", tsAuthKey: "env2", want: "env2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TS_AUTHKEY", tt.tsAuthkey)
+			t.Setenv("TS_AUTH_KEY", tt.tsAuthKey)
+			s := &Server{AuthKey: tt.authKey}
+			if got := s.getAuthKey(); got != tt.want {
+				t.Errorf("getAuthKey = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateCallback(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	tmp := filepath.Join(t.TempDir(), "s1")
+	os.MkdirAll(tmp, 0755)
+	s := &Server{
+		Dir:        tmp,
+		ControlURL: controlURL,
+		Hostname:   "s1",
+		Store:      new(mem.Store),
+		Ephemeral:  true,
+	}
+	defer s.Close()
+
+	// Set before Start, the callback sees every state.
+	states := make(chan ipn.State, 10)
+	s.SetStateCallback(func(st ipn.State) { states <- st })
+	if _, err := s.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := <-states; st != ipn.NoState {
+		t.Errorf("first state = %v; want %v", st, ipn.NoState)
+	}
+	for st := range states {
+		if st == ipn.Running {
+			break
+		}
+	}
+
+	// Set later, it's called with the current state.
+	running := make(chan ipn.State, 10)
+	s.SetStateCallback(func(st ipn.State) { running <- st })
+	select {
+	case st := <-running:
+		if st != ipn.Running {
+			t.Errorf("initial state = %v; want %v", st, ipn.Running)
+		}
+	case <-ctx.Done():
+		t.Fatal("callback not called with current state")
+	}
+	s.SetStateCallback(nil)
+}
+
+func TestCertDomains(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if got := new(Server).CertDomains(); got != nil {
+		t.Errorf("CertDomains before Start = %q; want nil", got)
+	}
+
+	controlURL, _ := startControl(t)
+	s1, _, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _, _ := startServer(t, ctx, controlURL, "s2")
+	for _, tt := range []struct {
+		s    *Server
+		want string
+	}{
+		{s1, "s1.tail-scale.ts.net"},
+		{s2, "s2.tail-scale.ts.net"},
+	} {
+		if got := tt.s.CertDomains(); !slices.Equal(got, []string{tt.want}) {
+			t.Errorf("CertDomains = %q; want [%q]", got, tt.want)
+		}
+	}
+}
+
 func TestLoopbackLocalAPI(t *testing.T) {
 	flakytest.Mark(t, "https://github.com/tailscale/tailscale/issues/8557")
 	tstest.ResourceCheck(t)
@@ -468,6 +624,48 @@ func TestTailscaleIPs(t *testing.T) {
 	}
 }
 
+func TestMetricsHandler(t *testing.T) {
+	getMetrics := func(s *Server) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d; want 200", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	// A Server that hasn't started reports that it's not up.
+	if got := getMetrics(new(Server)); !strings.Contains(got, "tsnet_up 0\n") {
+		t.Errorf("unstarted server metrics missing tsnet_up 0:\n%s", got)
+	}
+
+	// Client metrics are included too.
+	clientmetric.NewCounter("tsnet_test_metrics_handler").Add(3)
+	if got := getMetrics(new(Server)); !strings.Contains(got, "tsnet_test_metrics_handler 3\n") {
+		t.Errorf("metrics missing client metric:\n%s", got)
+	}
+
+	controlURL, _ := startControl(t)
+	s1, _, _ := startServer(t, context.Background(), controlURL, "s1")
+	ln, err := s1.Listen("tcp", ":8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	got := getMetrics(s1)
+	for _, want := range []string{
+		"tsnet_up 1\n",
+		`tsnet_backend_state{state="Running"} 1` + "\n",
+		"tsnet_listeners 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics missing %q:\n%s", want, got)
+		}
+	}
+}
+
 // TestListenerCleanup is a regression test to verify that s.Close doesn't
 // deadlock if a listener is still open.
 func TestListenerCleanup(t *testing.T) {
@@ -550,6 +748,11 @@ func TestFunnel(t *testing.T) {
 	s1, _, _ := startServer(t, ctx, controlURL, "s1")
 	s2, _, _ := startServer(t, ctx, controlURL, "s2")
 
+	// Ports the tailnet policy doesn't permit Funnel on are refused.
+	if _, err := s1.ListenFunnel("tcp", ":8443"); err == nil || !strings.Contains(err.Error(), "not allowed for funnel") {
+		t.Errorf("ListenFunnel on :8443 = %v; want not allowed error", err)
+	}
+
 	ln := must.Get(s1.ListenFunnel("tcp", ":443"))
 	defer ln.Close()
 	wantSrcAddrPort := netip.MustParseAddrPort("127.0.0.1:1234")
@@ -23,6 +23,7 @@
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -44,6 +45,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/tstest/integration/testcontrol"
+	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/opt"
@@ -185,6 +187,56 @@ func TestControlKnobs(t *testing.T) {
 	}
 }
 
+// TestDNSConfigFromControl tests that a DNS configuration injected by control
+// makes its way through the netmap into the client's DNS manager.
+func TestDNSConfigFromControl(t *testing.T) {
+	tstest.Shard(t)
+	tstest.Parallel(t)
+	env := newTestEnv(t)
+	n1 := newTestNode(t, env)
+
+	d1 := n1.StartDaemon()
+	defer d1.MustCleanShutdown(t)
+	n1.AwaitResponding()
+	n1.MustUp()
+	n1.AwaitRunning()
+
+	env.Control.SetDNSConfig(&tailcfg.DNSConfig{
+		Resolvers: []*dnstype.Resolver{{Addr: "192.0.2.1"}},
+		Routes: map[string][]*dnstype.Resolver{
+			"corp.example.com": {{Addr: "192.0.2.53"}},
+		},
+		Domains: []string{"corp.example.com"},
+	})
+
+	lc := &tailscale.LocalClient{
+		Socket:        n1.sockFile,
+		UseSocketOnly: true,
+	}
+	if err := tstest.WaitFor(20*time.Second, func() error {
+		changes, err := lc.DNSHistory(context.Background(), 1)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			return errors.New("no DNS config applied yet")
+		}
+		c := changes[0]
+		if got := c.Routes["corp.example.com."]; !slices.Equal(got, []string{"192.0.2.53"}) {
+			return fmt.Errorf("route for corp.example.com. = %q; want [192.0.2.53]", got)
+		}
+		if !slices.Equal(c.Resolvers, []string{"192.0.2.1"}) {
+			return fmt.Errorf("resolvers = %q; want [192.0.2.1]", c.Resolvers)
+		}
+		if !slices.Contains(c.SearchDomains, "corp.example.com.") {
+			return fmt.Errorf("search domains = %q; want corp.example.com.", c.SearchDomains)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCollectPanic(t *testing.T) {
 	tstest.Shard(t)
 	tstest.Parallel(t)
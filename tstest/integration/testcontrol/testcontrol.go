@@ -21,6 +21,7 @@
 	"net/url"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +39,7 @@
 	"tailscale.com/util/rands"
 	"tailscale.com/util/set"
 	"tailscale.com/util/zstdframe"
+	"tailscale.com/wgengine/filter"
 )
 
 const msgLimit = 1 << 20 // encrypted message length limit
@@ -50,10 +52,21 @@ type Server struct {
 	RequireAuth    bool
 	RequireAuthKey string // required authkey for all nodes
 	Verbose        bool
-	DNSConfig      *tailcfg.DNSConfig // nil means no DNS config
+	DNSConfig      *tailcfg.DNSConfig // nil means no DNS config; guarded by mu once serving (see SetDNSConfig)
 	MagicDNSDomain string
 	HandleC2N      http.Handler // if non-nil, used for /some-c2n-path/ in tests
 
+	// CapabilityVersion, if non-zero, is the capability version that the
+	// server claims to speak. Responses omit features that are newer than
+	// the lower of this and the client's version. Zero means
+	// tailcfg.CurrentCapabilityVersion.
+	CapabilityVersion tailcfg.CapabilityVersion
+
+	// MinClientCapabilityVersion, if non-zero, is the oldest client
+	// capability version the server supports. Requests from older clients
+	// are rejected.
+	MinClientCapabilityVersion tailcfg.CapabilityVersion
+
 	// ExplicitBaseURL or HTTPTestServer must be set.
 	ExplicitBaseURL string           // e.g. "http://127.0.0.1:1234" with no trailing URL
 	HTTPTestServer  *httptest.Server // if non-nil, used to get BaseURL
@@ -83,6 +96,27 @@ type Server struct {
 	// nodeCapMaps overrides the capability map sent down to a client.
 	nodeCapMaps map[key.NodePublic]tailcfg.NodeCapMap
 
+	// forceDERP is whether peers' endpoints are stripped from
+	// MapResponses, so that nodes never learn a direct path from the
+	// control plane. See SetForceDERP.
+	forceDERP bool
+
+	// packetFilter, if non-nil, is the packet filter sent to all nodes in
+	// place of the default allow-all one. An empty non-nil value blocks
+	// everything. See SetPacketFilter.
+	packetFilter []tailcfg.FilterRule
+
+	// nodePacketFilters overrides packetFilter for specific nodes. See
+	// SetNodePacketFilter.
+	nodePacketFilters map[key.NodePublic][]tailcfg.FilterRule
+
+	// mapDelays is how long to wait before sending each MapResponse to a
+	// node, to simulate a slow control plane. See SetMapDelay.
+	mapDelays map[key.NodePublic]time.Duration
+
+	// fakeNodes is the set of nodes added by AddFakeNode.
+	fakeNodes set.Set[tailcfg.NodeID]
+
 	// suppressAutoMapResponses is the set of nodes that should not be sent
 	// automatic map responses from serveMap. (They should only get manually sent ones)
 	suppressAutoMapResponses set.Set[key.NodePublic]
@@ -224,6 +258,64 @@ func (s *Server) SetExpireAllNodes(expired bool) {
 	}
 }
 
+// DeleteNode removes the node with the given node key from the tailnet, as
+// if an admin had deleted it mid-session.
+//
+// If the node has an active streaming map poll, it is sent a final
+// MapResponse marking its node key as expired, which moves the client to
+// NeedsLogin, and the poll is then ended. Peers are notified that the node
+// is gone. All per-node server state is discarded, so the node must log in
+// again to rejoin.
+//
+// It reports whether the node was found.
+func (s *Server) DeleteNode(nodeKey key.NodePublic) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := s.nodeLocked(nodeKey)
+	if node == nil {
+		return false
+	}
+	if updatesCh := s.updates[node.ID]; updatesCh != nil {
+		self := node.Clone()
+		self.KeyExpiry = time.Now().Add(-1 * time.Minute)
+		if s.msgToSend == nil {
+			s.msgToSend = map[key.NodePublic]any{}
+		}
+		s.msgToSend[nodeKey] = &tailcfg.MapResponse{Node: self}
+		sendUpdate(updatesCh, updateDebugInjection)
+		delete(s.updates, node.ID)
+	} else {
+		delete(s.msgToSend, nodeKey)
+	}
+
+	delete(s.nodes, nodeKey)
+	delete(s.nodeKeyAuthed, nodeKey)
+	delete(s.nodeSubnetRoutes, nodeKey)
+	delete(s.nodeCapMaps, nodeKey)
+	delete(s.masquerades, nodeKey)
+	delete(s.peerIsJailed, nodeKey)
+	delete(s.nodePacketFilters, nodeKey)
+	delete(s.mapDelays, nodeKey)
+	s.fakeNodes.Delete(node.ID)
+	for _, m := range s.masquerades {
+		delete(m, nodeKey)
+	}
+	for _, m := range s.peerIsJailed {
+		delete(m, nodeKey)
+	}
+	s.suppressAutoMapResponses.Delete(nodeKey)
+	for path, ap := range s.authPath {
+		if ap.nodeKey == nodeKey {
+			delete(s.authPath, path)
+		}
+	}
+
+	s.updateLocked("DeleteNode", s.nodeIDsLocked(node.ID))
+	s.condLocked().Broadcast()
+	return true
+}
+
 type AuthPath struct {
 	nodeKey key.NodePublic
 
@@ -243,6 +335,30 @@ func (ap *AuthPath) CompleteSuccessfully() {
 	ap.closeOnce.Do(ap.completeSuccessfully)
 }
 
+// serverCapVer returns the capability version that s claims to speak.
+func (s *Server) serverCapVer() tailcfg.CapabilityVersion {
+	if s.CapabilityVersion != 0 {
+		return s.CapabilityVersion
+	}
+	return tailcfg.CurrentCapabilityVersion
+}
+
+// effectiveCapVer returns the capability version to use when generating
+// responses for a client speaking clientVer: the lower of the client's and
+// the server's versions.
+func (s *Server) effectiveCapVer(clientVer tailcfg.CapabilityVersion) tailcfg.CapabilityVersion {
+	return min(clientVer, s.serverCapVer())
+}
+
+// checkClientCapVer returns an error if s is configured to not support
+// clients speaking clientVer.
+func (s *Server) checkClientCapVer(clientVer tailcfg.CapabilityVersion) error {
+	if minVer := s.MinClientCapabilityVersion; minVer != 0 && clientVer < minVer {
+		return fmt.Errorf("client capability version %d unsupported; want >= %d", clientVer, minVer)
+	}
+	return nil
+}
+
 func (s *Server) logf(format string, a ...any) {
 	if s.Logf != nil {
 		s.Logf(format, a...)
@@ -325,11 +441,21 @@ func (s *Server) ensureKeyPairLocked() {
 
 func (s *Server) serveKey(w http.ResponseWriter, r *http.Request) {
 	noiseKey, legacyKey := s.publicKeys()
-	if r.FormValue("v") == "" {
+	v := r.FormValue("v")
+	if v == "" {
 		w.Header().Set("Content-Type", "text/plain")
 		io.WriteString(w, legacyKey.UntypedHexString())
 		return
 	}
+	clientVer, err := strconv.Atoi(v)
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkClientCapVer(tailcfg.CapabilityVersion(clientVer)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(&tailcfg.OverTLSPublicKeyResponse{
 		LegacyPublicKey: legacyKey,
@@ -420,6 +546,119 @@ func (s *Server) SetNodeCapMap(nodeKey key.NodePublic, capMap tailcfg.NodeCapMap
 	s.updateLocked("SetNodeCapMap", s.nodeIDsLocked(0))
 }
 
+// SetDNSConfig sets the DNS configuration, such as nameservers, split DNS
+// routes and whether MagicDNS is enabled, that's sent to all nodes in their
+// netmaps, and sends them updated netmaps. A nil cfg removes it.
+func (s *Server) SetDNSConfig(cfg *tailcfg.DNSConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DNSConfig = cfg.Clone()
+	s.updateLocked("SetDNSConfig", s.nodeIDsLocked(0))
+}
+
+// SetForceDERP sets whether peers' endpoints are withheld from netmaps, as
+// if no direct path were possible, and sends all nodes updated netmaps. It's
+// for tests of DERP relaying.
+//
+// Nodes can still discover each other's endpoints via disco over DERP. To
+// keep all traffic relayed, also run the nodes with
+// TS_DEBUG_ALWAYS_USE_DERP=true.
+func (s *Server) SetForceDERP(v bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forceDERP = v
+	s.updateLocked("SetForceDERP", s.nodeIDsLocked(0))
+}
+
+// SetPacketFilter sets the packet filter rules sent to all nodes without a
+// per-node override (see SetNodePacketFilter), and sends them updated
+// netmaps. A nil rules restores the default, which allows all traffic; an
+// empty non-nil rules blocks all traffic. It returns an error, and changes
+// nothing, if the rules are malformed.
+func (s *Server) SetPacketFilter(rules []tailcfg.FilterRule) error {
+	if err := validateFilterRules(rules); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packetFilter = slices.Clone(rules)
+	s.updateLocked("SetPacketFilter", s.nodeIDsLocked(0))
+	return nil
+}
+
+// SetNodePacketFilter is like SetPacketFilter but only for the node with
+// the given key, overriding any rules set by SetPacketFilter. A nil rules
+// removes the override.
+func (s *Server) SetNodePacketFilter(nodeKey key.NodePublic, rules []tailcfg.FilterRule) error {
+	if err := validateFilterRules(rules); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rules == nil {
+		delete(s.nodePacketFilters, nodeKey)
+	} else {
+		mak.Set(&s.nodePacketFilters, nodeKey, slices.Clone(rules))
+	}
+	if node := s.nodes[nodeKey]; node != nil {
+		s.updateLocked("SetNodePacketFilter", []tailcfg.NodeID{node.ID})
+	}
+	return nil
+}
+
+// validateFilterRules reports whether rules can be parsed by clients.
+func validateFilterRules(rules []tailcfg.FilterRule) error {
+	for i, r := range rules {
+		for _, p := range r.IPProto {
+			if p < 0 || p > 0xff {
+				return fmt.Errorf("rule %d: invalid IPProto %d", i, p)
+			}
+		}
+		for _, d := range r.DstPorts {
+			if d.Ports.First > d.Ports.Last {
+				return fmt.Errorf("rule %d: invalid port range %d-%d for %q", i, d.Ports.First, d.Ports.Last, d.IP)
+			}
+		}
+	}
+	if _, err := filter.MatchesFromFilterRules(rules); err != nil {
+		return fmt.Errorf("invalid packet filter: %w", err)
+	}
+	return nil
+}
+
+// SetMapDelay sets how long the server waits before sending each
+// MapResponse to the node with the given key, both in reply to its map
+// requests and when pushing updates to its streaming map poll. Keep-alives
+// aren't delayed. A zero d removes the delay.
+func (s *Server) SetMapDelay(nodeKey key.NodePublic, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d <= 0 {
+		delete(s.mapDelays, nodeKey)
+		return
+	}
+	mak.Set(&s.mapDelays, nodeKey, d)
+}
+
+// waitMapDelay waits for the delay set by SetMapDelay for nk, if any. It
+// reports false if ctx is done first.
+func (s *Server) waitMapDelay(ctx context.Context, nk key.NodePublic) bool {
+	s.mu.Lock()
+	d := s.mapDelays[nk]
+	s.mu.Unlock()
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 // nodeIDsLocked returns the node IDs of all nodes in the server, except
 // for the node with the given ID.
 func (s *Server) nodeIDsLocked(except tailcfg.NodeID) []tailcfg.NodeID {
@@ -447,8 +686,9 @@ func (s *Server) nodeLocked(nodeKey key.NodePublic) *tailcfg.Node {
 	return s.nodes[nodeKey].Clone()
 }
 
-// AddFakeNode injects a fake node into the server.
-func (s *Server) AddFakeNode() {
+// AddFakeNode injects a fake node into the server, notifies the other nodes
+// of it, and returns it.
+func (s *Server) AddFakeNode() *tailcfg.Node {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.nodes == nil {
@@ -461,7 +701,7 @@ func (s *Server) AddFakeNode() {
 	id := int64(binary.LittleEndian.Uint64(r[:]))
 	ip := netaddr.IPv4(r[0], r[1], r[2], r[3])
 	addr := netip.PrefixFrom(ip, 32)
-	s.nodes[nk] = &tailcfg.Node{
+	node := &tailcfg.Node{
 		ID:                tailcfg.NodeID(id),
 		StableID:          tailcfg.StableNodeID(fmt.Sprintf("TESTCTRL%08x", id)),
 		User:              tailcfg.UserID(id),
@@ -472,7 +712,31 @@ func (s *Server) AddFakeNode() {
 		Addresses:         []netip.Prefix{addr},
 		AllowedIPs:        []netip.Prefix{addr},
 	}
-	// TODO: send updates to other (non-fake?) nodes
+	s.nodes[nk] = node
+	s.fakeNodes.Make()
+	s.fakeNodes.Add(node.ID)
+	s.updateLocked("AddFakeNode", s.nodeIDsLocked(node.ID))
+	return node.Clone()
+}
+
+// RemoveFakeNode removes the fake node with the given ID, added by
+// AddFakeNode, as DeleteNode does. It reports whether such a node was found.
+func (s *Server) RemoveFakeNode(id tailcfg.NodeID) bool {
+	s.mu.Lock()
+	var nk key.NodePublic
+	if s.fakeNodes.Contains(id) {
+		for k, n := range s.nodes {
+			if n.ID == id {
+				nk = k
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	if nk.IsZero() {
+		return false
+	}
+	return s.DeleteNode(nk)
 }
 
 func (s *Server) AllUsers() (users []*tailcfg.User) {
@@ -603,6 +867,14 @@ func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request, mkey key.
 		j, _ := json.MarshalIndent(req, "", "\t")
 		log.Printf("Got %T: %s", req, j)
 	}
+	if err := s.checkClientCapVer(req.Version); err != nil {
+		res := must.Get(s.encode(false, tailcfg.RegisterResponse{
+			Error: err.Error(),
+		}))
+		w.WriteHeader(200)
+		w.Write(res)
+		return
+	}
 	if s.RequireAuthKey != "" && (req.Auth == nil || req.Auth.AuthKey != s.RequireAuthKey) {
 		res := must.Get(s.encode(false, tailcfg.RegisterResponse{
 			Error: "invalid authkey",
@@ -773,6 +1045,10 @@ func (s *Server) serveMap(w http.ResponseWriter, r *http.Request, mkey key.Machi
 	if err := s.decode(msg, req); err != nil {
 		go panic(fmt.Sprintf("bad map request: %v", err))
 	}
+	if err := s.checkClientCapVer(req.Version); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	jitter := rand.N(8 * time.Second)
 	keepAlive := 50*time.Second + jitter
@@ -830,6 +1106,9 @@ func (s *Server) serveMap(w http.ResponseWriter, r *http.Request, mkey key.Machi
 
 	w.WriteHeader(200)
 	for {
+		if !s.waitMapDelay(ctx, req.NodeKey) {
+			return
+		}
 		if resBytes, ok := s.takeRawMapMessage(req.NodeKey); ok {
 			if err := s.sendMapMsg(w, mkey, compress, resBytes); err != nil {
 				s.logf("sendMapMsg of raw message: %v", err)
@@ -933,16 +1212,28 @@ func (s *Server) MapResponse(req *tailcfg.MapRequest) (res *tailcfg.MapResponse,
 		return nil, nil
 	}
 
+	capVer := s.effectiveCapVer(req.Version)
+
 	s.mu.Lock()
 	nodeCapMap := maps.Clone(s.nodeCapMaps[nk])
+	dns := s.DNSConfig
+	forceDERP := s.forceDERP
+	packetFilter, ok := s.nodePacketFilters[nk]
+	if !ok {
+		packetFilter = s.packetFilter
+	}
 	s.mu.Unlock()
+	if packetFilter == nil {
+		packetFilter = packetFilterWithIngressCaps()
+	}
 
-	node.CapMap = nodeCapMap
+	if capVer >= 74 { // client understands NodeCapMap
+		node.CapMap = nodeCapMap
+	}
 	node.Capabilities = append(node.Capabilities, tailcfg.NodeAttrDisableUPnP)
 
 	user, _ := s.getUser(nk)
 	t := time.Date(2020, 8, 3, 0, 0, 0, 1, time.UTC)
-	dns := s.DNSConfig
 	if dns != nil && s.MagicDNSDomain != "" {
 		dns = dns.Clone()
 		dns.CertDomains = []string{
@@ -955,10 +1246,16 @@ func (s *Server) MapResponse(req *tailcfg.MapRequest) (res *tailcfg.MapResponse,
 		DERPMap:         s.DERPMap,
 		Domain:          domain,
 		CollectServices: "true",
-		PacketFilter:    packetFilterWithIngressCaps(),
+		PacketFilter:    packetFilter,
 		DNSConfig:       dns,
 		ControlTime:     &t,
 	}
+	if len(packetFilter) == 0 {
+		// An empty PacketFilter doesn't survive JSON encoding, so block
+		// everything by clearing all filters instead.
+		res.PacketFilter = nil
+		res.PacketFilters = map[string][]tailcfg.FilterRule{"*": nil}
+	}
 
 	s.mu.Lock()
 	nodeMasqs := s.masquerades[node.Key]
@@ -975,7 +1272,12 @@ func (s *Server) MapResponse(req *tailcfg.MapRequest) (res *tailcfg.MapResponse,
 				p.SelfNodeV4MasqAddrForThisPeer = ptr.To(masqIP)
 			}
 		}
-		p.IsJailed = jailed[p.Key]
+		if capVer >= 94 { // client understands Node.IsJailed
+			p.IsJailed = jailed[p.Key]
+		}
+		if forceDERP {
+			p.Endpoints = nil
+		}
 
 		s.mu.Lock()
 		peerAddress := s.masquerades[p.Key][node.Key]
@@ -0,0 +1,305 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package testcontrol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestMapResponseCapabilityVersion(t *testing.T) {
+	nk := key.NewNode().Public()
+	capMap := tailcfg.NodeCapMap{"foo": nil}
+
+	tests := []struct {
+		name       string
+		serverVer  tailcfg.CapabilityVersion
+		clientVer  tailcfg.CapabilityVersion
+		wantCapMap bool
+	}{
+		{"current", 0, tailcfg.CurrentCapabilityVersion, true},
+		{"old-client", 0, 73, false},
+		{"old-server", 73, tailcfg.CurrentCapabilityVersion, false},
+		{"both-new-enough", 74, 74, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{CapabilityVersion: tt.serverVer}
+			s.nodes = map[key.NodePublic]*tailcfg.Node{
+				nk: {ID: 1, Key: nk},
+			}
+			s.SetNodeCapMap(nk, capMap)
+			res, err := s.MapResponse(&tailcfg.MapRequest{
+				Version: tt.clientVer,
+				NodeKey: nk,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res.Node.CapMap != nil; got != tt.wantCapMap {
+				t.Errorf("got CapMap=%v; want present=%v", res.Node.CapMap, tt.wantCapMap)
+			}
+		})
+	}
+}
+
+func TestCheckClientCapVer(t *testing.T) {
+	s := &Server{MinClientCapabilityVersion: 90}
+	if err := s.checkClientCapVer(89); err == nil {
+		t.Error("version 89 accepted; want rejection")
+	}
+	if err := s.checkClientCapVer(90); err != nil {
+		t.Errorf("version 90 rejected: %v", err)
+	}
+	if err := new(Server).checkClientCapVer(1); err != nil {
+		t.Errorf("zero MinClientCapabilityVersion rejected version 1: %v", err)
+	}
+}
+
+func TestDeleteNode(t *testing.T) {
+	s := new(Server)
+	s.AddFakeNode()
+	s.AddFakeNode()
+	nodes := s.AllNodes()
+	victim, peer := nodes[0], nodes[1]
+
+	victimCh := make(chan updateType, 1)
+	peerCh := make(chan updateType, 1)
+	s.updates = map[tailcfg.NodeID]chan updateType{
+		victim.ID: victimCh,
+		peer.ID:   peerCh,
+	}
+	s.SetNodeCapMap(victim.Key, tailcfg.NodeCapMap{"foo": nil})
+	<-victimCh
+	<-peerCh
+
+	if !s.DeleteNode(victim.Key) {
+		t.Fatal("DeleteNode = false; want true")
+	}
+	if got := s.NumNodes(); got != 1 {
+		t.Errorf("NumNodes = %d; want 1", got)
+	}
+	if s.Node(victim.Key) != nil {
+		t.Error("deleted node still present")
+	}
+	if _, ok := s.nodeCapMaps[victim.Key]; ok {
+		t.Error("deleted node's cap map not cleaned up")
+	}
+	if _, ok := s.updates[victim.ID]; ok {
+		t.Error("deleted node's map session not cleaned up")
+	}
+
+	select {
+	case <-victimCh:
+	default:
+		t.Error("deleted node's map poll not woken")
+	}
+	select {
+	case <-peerCh:
+	default:
+		t.Error("peer not notified of deletion")
+	}
+
+	raw, ok := s.takeRawMapMessage(victim.Key)
+	if !ok {
+		t.Fatal("no final MapResponse queued for deleted node")
+	}
+	var mr tailcfg.MapResponse
+	if err := json.Unmarshal(raw, &mr); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Node == nil || !mr.Node.KeyExpiry.Before(time.Now()) {
+		t.Errorf("final MapResponse self node not expired: %+v", mr.Node)
+	}
+
+	if s.DeleteNode(victim.Key) {
+		t.Error("second DeleteNode = true; want false")
+	}
+}
+
+func TestSetForceDERP(t *testing.T) {
+	s := new(Server)
+	s.AddFakeNode()
+	s.AddFakeNode()
+	nodes := s.AllNodes()
+	self, peer := nodes[0], nodes[1]
+	peer.Endpoints = []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:41641")}
+	s.UpdateNode(peer)
+
+	check := func(wantForced bool) {
+		t.Helper()
+		res, err := s.MapResponse(&tailcfg.MapRequest{
+			Version: tailcfg.CurrentCapabilityVersion,
+			NodeKey: self.Key,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Peers) != 1 {
+			t.Fatalf("got %d peers; want 1", len(res.Peers))
+		}
+		if got := len(res.Peers[0].Endpoints) == 0; got != wantForced {
+			t.Errorf("peer endpoints = %v; want stripped = %v", res.Peers[0].Endpoints, wantForced)
+		}
+	}
+	check(false)
+	s.SetForceDERP(true)
+	check(true)
+	s.SetForceDERP(false)
+	check(false)
+}
+
+func TestSetMapDelay(t *testing.T) {
+	s := new(Server)
+	s.AddFakeNode()
+	node := s.AllNodes()[0]
+	body, err := json.Marshal(&tailcfg.MapRequest{
+		Version: tailcfg.CurrentCapabilityVersion,
+		NodeKey: node.Key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveMap := func(ctx context.Context) (*httptest.ResponseRecorder, time.Duration) {
+		r := httptest.NewRequestWithContext(ctx, "POST", "/machine/map", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		start := time.Now()
+		s.serveMap(w, r, node.Machine)
+		return w, time.Since(start)
+	}
+
+	const delay = 200 * time.Millisecond
+	s.SetMapDelay(node.Key, delay)
+	w, took := serveMap(context.Background())
+	if took < delay {
+		t.Errorf("map request took %v; want at least %v", took, delay)
+	}
+	if w.Body.Len() == 0 {
+		t.Error("no MapResponse sent")
+	}
+
+	// A canceled request returns promptly without a MapResponse.
+	s.SetMapDelay(node.Key, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w, took = serveMap(ctx)
+	if took >= time.Minute {
+		t.Errorf("canceled map request took %v", took)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("canceled map request got %d bytes; want none", w.Body.Len())
+	}
+
+	s.SetMapDelay(node.Key, 0)
+	if _, took := serveMap(context.Background()); took >= delay {
+		t.Errorf("map request took %v after removing delay", took)
+	}
+}
+
+func TestSetPacketFilter(t *testing.T) {
+	s := new(Server)
+	s.AddFakeNode()
+	s.AddFakeNode()
+	nodes := s.AllNodes()
+	a, b := nodes[0], nodes[1]
+	mapResponse := func(n *tailcfg.Node) *tailcfg.MapResponse {
+		t.Helper()
+		res, err := s.MapResponse(&tailcfg.MapRequest{
+			Version: tailcfg.CurrentCapabilityVersion,
+			NodeKey: n.Key,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	sshOnly := []tailcfg.FilterRule{{
+		SrcIPs:   []string{"100.64.0.0/10"},
+		DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRange{First: 22, Last: 22}}},
+	}}
+	if err := s.SetPacketFilter(sshOnly); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		if got := mapResponse(n).PacketFilter; !reflect.DeepEqual(got, sshOnly) {
+			t.Errorf("PacketFilter = %+v; want %+v", got, sshOnly)
+		}
+	}
+
+	// An empty per-node filter blocks everything for just that node.
+	if err := s.SetNodePacketFilter(a.Key, []tailcfg.FilterRule{}); err != nil {
+		t.Fatal(err)
+	}
+	res := mapResponse(a)
+	if res.PacketFilter != nil || res.PacketFilters == nil || res.PacketFilters["*"] != nil {
+		t.Errorf("got PacketFilter %+v, PacketFilters %+v; want everything cleared", res.PacketFilter, res.PacketFilters)
+	}
+	if got := mapResponse(b).PacketFilter; !reflect.DeepEqual(got, sshOnly) {
+		t.Errorf("other node's PacketFilter = %+v; want %+v", got, sshOnly)
+	}
+
+	// Removing the override and the filter restores the default.
+	if err := s.SetNodePacketFilter(a.Key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPacketFilter(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mapResponse(a).PacketFilter, packetFilterWithIngressCaps(); !reflect.DeepEqual(got, want) {
+		t.Errorf("PacketFilter = %+v; want default %+v", got, want)
+	}
+
+	for _, bad := range [][]tailcfg.FilterRule{
+		{{SrcIPs: []string{"not-an-ip"}}},
+		{{SrcIPs: []string{"*"}, IPProto: []int{256}}},
+		{{SrcIPs: []string{"*"}, DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRange{First: 80, Last: 22}}}}},
+	} {
+		if err := s.SetPacketFilter(bad); err == nil {
+			t.Errorf("SetPacketFilter(%+v) succeeded; want error", bad)
+		}
+	}
+	if s.packetFilter != nil {
+		t.Errorf("rejected filter was stored: %+v", s.packetFilter)
+	}
+}
+
+func TestAddRemoveFakeNode(t *testing.T) {
+	s := new(Server)
+	peer := s.AddFakeNode()
+	peerCh := make(chan updateType, 1)
+	s.updates = map[tailcfg.NodeID]chan updateType{peer.ID: peerCh}
+
+	n := s.AddFakeNode()
+	if got := s.NumNodes(); got != 2 {
+		t.Fatalf("NumNodes = %d; want 2", got)
+	}
+	select {
+	case <-peerCh:
+	default:
+		t.Error("peer not notified of added node")
+	}
+
+	if s.RemoveFakeNode(n.ID + 1) {
+		t.Error("RemoveFakeNode of unknown ID = true; want false")
+	}
+	if !s.RemoveFakeNode(n.ID) {
+		t.Fatal("RemoveFakeNode = false; want true")
+	}
+	if s.Node(n.Key) != nil {
+		t.Error("removed node still present")
+	}
+	if s.RemoveFakeNode(n.ID) {
+		t.Error("second RemoveFakeNode = true; want false")
+	}
+}
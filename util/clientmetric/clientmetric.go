@@ -44,14 +44,29 @@ type scanEntry struct {
 	lastLogged int64        // last logged value
 }
 
-// Type is a metric type: counter or gauge.
+// Type is a metric type: counter, gauge or histogram.
 type Type uint8
 
 const (
 	TypeGauge Type = iota
 	TypeCounter
+	TypeHistogram // only used by Histogram
 )
 
+// String returns the name of t in the Prometheus and OpenMetrics formats:
+// "gauge", "counter" or "histogram".
+func (t Type) String() string {
+	switch t {
+	case TypeGauge:
+		return "gauge"
+	case TypeCounter:
+		return "counter"
+	case TypeHistogram:
+		return "histogram"
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
 // Metric is an integer metric value that's tracked over time.
 //
 // It's safe for concurrent use.
@@ -121,7 +136,7 @@ func (m *Metric) Publish() {
 	if m.name == "" {
 		panic("unnamed Metric")
 	}
-	if _, dup := metrics[m.name]; dup {
+	if nameTakenLocked(m.name) {
 		panic("duplicate metric " + m.name)
 	}
 	metrics[m.name] = m
@@ -161,13 +176,33 @@ func Metrics() []*Metric {
 	return sorted
 }
 
-// HasPublished reports whether a metric with the given name has already been
-// published.
+// HasPublished reports whether a metric, histogram or labeled metric with
+// the given name has already been published, or whether name is that of a
+// sample of a published histogram, such as "foo_count" for histogram "foo".
 func HasPublished(name string) bool {
 	mu.Lock()
 	defer mu.Unlock()
-	_, ok := metrics[name]
-	return ok
+	return nameTakenLocked(name)
+}
+
+// nameTakenLocked reports whether name is the name of any published metric,
+// histogram or labeled metric, or of a sample of a published histogram.
+// mu must be held.
+func nameTakenLocked(name string) bool {
+	_, ok1 := metrics[name]
+	_, ok2 := histograms[name]
+	_, ok3 := labeled[name]
+	if ok1 || ok2 || ok3 {
+		return true
+	}
+	for _, suffix := range histogramSampleSuffixes {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			if _, ok := histograms[base]; ok {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // NewUnpublished initializes a new Metric without calling Publish on
@@ -228,15 +263,92 @@ func NewGaugeFunc(name string, f func() int64) *Metric {
 //
 // See https://github.com/prometheus/docs/blob/main/content/docs/instrumenting/exposition_formats.md
 func WritePrometheusExpositionFormat(w io.Writer) {
+	for _, f := range sortedFamilies() {
+		switch f := f.(type) {
+		case *Metric:
+			fmt.Fprintf(w, "# TYPE %s %s\n", f.Name(), f.Type())
+			fmt.Fprintf(w, "%s %v\n", f.Name(), f.Value())
+		case *Histogram:
+			fmt.Fprintf(w, "# TYPE %s histogram\n", f.Name())
+			f.writeSamples(w)
+		case *LabeledMetric:
+			fmt.Fprintf(w, "# TYPE %s %s\n", f.Name(), f.Type())
+			f.writeSamples(w, f.Name())
+		}
+	}
+}
+
+// sortedFamilies returns all published metrics, histograms and labeled
+// metrics, sorted by name.
+func sortedFamilies() []any {
+	var ret []any
 	for _, m := range Metrics() {
-		switch m.Type() {
-		case TypeGauge:
-			fmt.Fprintf(w, "# TYPE %s gauge\n", m.Name())
-		case TypeCounter:
-			fmt.Fprintf(w, "# TYPE %s counter\n", m.Name())
+		ret = append(ret, m)
+	}
+	for _, h := range Histograms() {
+		ret = append(ret, h)
+	}
+	for _, lm := range LabeledMetrics() {
+		ret = append(ret, lm)
+	}
+	name := func(f any) string { return f.(interface{ Name() string }).Name() }
+	sort.Slice(ret, func(i, j int) bool {
+		return name(ret[i]) < name(ret[j])
+	})
+	return ret
+}
+
+// OpenMetricsContentType is the Content-Type of the output of
+// WriteOpenMetricsFormat.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// openMetricsUnits are the metric name suffixes recognized as units by
+// WriteOpenMetricsFormat.
+var openMetricsUnits = []string{"bytes", "seconds"}
+
+// WriteOpenMetricsFormat writes all client metrics to w in the OpenMetrics
+// text format. Unlike WritePrometheusExpositionFormat, counter samples have
+// a "_total" suffix (and their metric family names lack one), families
+// whose names end in a unit get a "# UNIT" line, and the output ends with
+// "# EOF".
+//
+// See https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md
+func WriteOpenMetricsFormat(w io.Writer) {
+	for _, f := range sortedFamilies() {
+		h, ok := f.(*Histogram)
+		if ok {
+			fmt.Fprintf(w, "# TYPE %s histogram\n", h.Name())
+			h.writeSamples(w)
+			continue
+		}
+		var family string
+		var typ Type
+		switch f := f.(type) {
+		case *Metric:
+			family, typ = f.Name(), f.Type()
+		case *LabeledMetric:
+			family, typ = f.Name(), f.Type()
+		}
+		sample := family
+		if typ == TypeCounter {
+			family = strings.TrimSuffix(family, "_total")
+			sample = family + "_total"
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", family, typ)
+		for _, u := range openMetricsUnits {
+			if strings.HasSuffix(family, "_"+u) {
+				fmt.Fprintf(w, "# UNIT %s %s\n", family, u)
+				break
+			}
+		}
+		switch f := f.(type) {
+		case *Metric:
+			fmt.Fprintf(w, "%s %v\n", sample, f.Value())
+		case *LabeledMetric:
+			f.writeSamples(w, sample)
 		}
-		fmt.Fprintf(w, "%s %v\n", m.Name(), m.Value())
 	}
+	io.WriteString(w, "# EOF\n")
 }
 
 const (
@@ -4,6 +4,8 @@
 package clientmetric
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 )
@@ -31,6 +33,8 @@ func clearMetrics() {
 	mu.Lock()
 	defer mu.Unlock()
 	metrics = map[string]*Metric{}
+	histograms = map[string]*Histogram{}
+	labeled = map[string]*LabeledMetric{}
 	numWireID = 0
 	lastDelta = time.Time{}
 	sorted = nil
@@ -107,3 +111,171 @@ func TestWithFunc(t *testing.T) {
 		t.Errorf("second = %q; want %q", got, want)
 	}
 }
+
+func TestWriteOpenMetricsFormat(t *testing.T) {
+	clearMetrics()
+
+	NewCounter("foo").Add(3)
+	NewCounter("bar_total").Add(4)
+	NewGauge("baz_bytes").Set(5)
+
+	var buf strings.Builder
+	WriteOpenMetricsFormat(&buf)
+	const want = `# TYPE bar counter
+bar_total 4
+# TYPE baz_bytes gauge
+# UNIT baz_bytes bytes
+baz_bytes 5
+# TYPE foo counter
+foo_total 3
+# EOF
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	clearMetrics()
+
+	NewCounter("a_counter").Add(1)
+	h := NewHistogram("b_latency_ms", []int64{10, 100, 1000})
+	NewGauge("c_gauge").Set(2)
+	for _, v := range []int64{5, 10, 11, 100, 5000} {
+		h.Observe(v)
+	}
+	if got, want := h.Count(), int64(5); got != want {
+		t.Errorf("Count = %v; want %v", got, want)
+	}
+	if got, want := h.Sum(), int64(5126); got != want {
+		t.Errorf("Sum = %v; want %v", got, want)
+	}
+	if !HasPublished("b_latency_ms") {
+		t.Error("HasPublished = false for histogram")
+	}
+
+	var buf strings.Builder
+	WritePrometheusExpositionFormat(&buf)
+	const want = `# TYPE a_counter counter
+a_counter 1
+# TYPE b_latency_ms histogram
+b_latency_ms_bucket{le="10"} 2
+b_latency_ms_bucket{le="100"} 4
+b_latency_ms_bucket{le="1000"} 4
+b_latency_ms_bucket{le="+Inf"} 5
+b_latency_ms_sum 5126
+b_latency_ms_count 5
+# TYPE c_gauge gauge
+c_gauge 2
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Histograms share a namespace with other metrics, including the
+	// names of their samples.
+	NewCounter("e_count")
+	if !HistogramNameTaken("e") || !HasPublished("b_latency_ms_count") {
+		t.Error("sample names not taken")
+	}
+	for _, f := range []func(){
+		func() { NewCounter("b_latency_ms") },
+		func() { NewCounter("b_latency_ms_sum") },
+		func() { NewHistogram("a_counter", []int64{1}) },
+		func() { NewHistogram("e", []int64{1}) },
+		func() { NewHistogram("d", []int64{2, 1}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestLabeledMetric(t *testing.T) {
+	clearMetrics()
+
+	packets := NewCounterWithLabels("derp_packets", []string{"region", "dir"})
+	packets.With("nyc", "rx").Add(3)
+	packets.With("fra", "tx").Add(1)
+	packets.With("nyc", "rx").Add(2)
+	NewGaugeWithLabels("home_bytes", []string{"name"}).With(`a "quoted"\name`).Set(7)
+	NewCounter("flat").Add(1)
+
+	if got := packets.With("nyc", "rx").Value(); got != 5 {
+		t.Errorf("nyc rx = %v; want 5", got)
+	}
+	for _, name := range []string{"derp_packets", "home_bytes", "flat"} {
+		if !HasPublished(name) {
+			t.Errorf("HasPublished(%q) = false", name)
+		}
+	}
+
+	var buf strings.Builder
+	WritePrometheusExpositionFormat(&buf)
+	const want = `# TYPE derp_packets counter
+derp_packets{region="fra",dir="tx"} 1
+derp_packets{region="nyc",dir="rx"} 5
+# TYPE flat counter
+flat 1
+# TYPE home_bytes gauge
+home_bytes{name="a \"quoted\"\\name"} 7
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	WriteOpenMetricsFormat(&buf)
+	if got, want := buf.String(), "# TYPE derp_packets counter\nderp_packets_total{region=\"fra\",dir=\"tx\"} 1\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("OpenMetrics got:\n%s\nwant prefix:\n%s", got, want)
+	}
+
+	// Labeled metrics share a namespace with other metrics.
+	for _, f := range []func(){
+		func() { NewCounter("derp_packets") },
+		func() { NewHistogram("derp_packets", []int64{1}) },
+		func() { NewGaugeWithLabels("flat", []string{"a"}) },
+		func() { NewCounterWithLabels("x", []string{"a", "a"}) },
+		func() { packets.With("nyc") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	clearMetrics()
+
+	NewGauge("b_gauge").Set(-2)
+	NewCounter("a_counter").Add(3)
+	NewHistogram("c_hist", []int64{10}).Observe(12)
+	lm := NewCounterWithLabels("d_labeled", []string{"region"})
+	lm.With("nyc").Add(4)
+	lm.With("fra").Add(5)
+
+	j, err := json.Marshal(Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `[` +
+		`{"Name":"a_counter","Type":"counter","Value":3},` +
+		`{"Name":"b_gauge","Type":"gauge","Value":-2},` +
+		`{"Name":"c_hist","Type":"histogram","Value":1,"Histogram":{"Buckets":[10],"Counts":[0,1],"Sum":12,"Count":1}},` +
+		`{"Name":"d_labeled","Type":"counter","Labels":{"region":"fra"},"Value":5},` +
+		`{"Name":"d_labeled","Type":"counter","Labels":{"region":"nyc"},"Value":4}` +
+		`]`
+	if string(j) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", j, want)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package clientmetric
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// histograms are the published histograms, by name. They share a namespace
+// with metrics and are guarded by mu.
+var histograms = map[string]*Histogram{}
+
+// histogramSampleSuffixes are the suffixes of the names of the samples a
+// histogram is exported as, which no other metric may use.
+var histogramSampleSuffixes = []string{"_bucket", "_sum", "_count"}
+
+// Histogram is a distribution of integer observations, such as latencies in
+// milliseconds, counted in fixed buckets.
+//
+// Unlike a Metric, a Histogram isn't included in the logtail metrics deltas;
+// it's only exported by WritePrometheusExpositionFormat and
+// WriteOpenMetricsFormat.
+//
+// It's safe for concurrent use.
+type Histogram struct {
+	name   string
+	bounds []int64        // inclusive upper bounds of the buckets, ascending
+	counts []atomic.Int64 // per bucket, non-cumulative; the last is for values above all bounds
+	sum    atomic.Int64
+	count  atomic.Int64
+}
+
+// NewHistogram returns a new published histogram counting observations in
+// buckets with the given inclusive upper bounds, which must be ascending.
+// Observations greater than all bounds are counted in an implicit +Inf
+// bucket.
+//
+// It panics if the name is illegal or a duplicate of any metric or
+// histogram in the process, or if the name of one of its samples, such as
+// name+"_count", is taken; see HistogramNameTaken.
+func NewHistogram(name string, buckets []int64) *Histogram {
+	if i := strings.IndexFunc(name, isIllegalMetricRune); name == "" || i != -1 {
+		panic(fmt.Sprintf("illegal metric name %q (index %v)", name, i))
+	}
+	if len(buckets) == 0 {
+		panic("histogram " + name + " has no buckets")
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			panic(fmt.Sprintf("histogram %s buckets not ascending: %v", name, buckets))
+		}
+	}
+	h := &Histogram{
+		name:   name,
+		bounds: slices.Clone(buckets),
+		counts: make([]atomic.Int64, len(buckets)+1),
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if histogramNameTakenLocked(name) {
+		panic("duplicate metric " + name)
+	}
+	histograms[name] = h
+	return h
+}
+
+// HistogramNameTaken reports whether NewHistogram would panic for name
+// because it, or the name of one of the histogram's "_bucket", "_sum" or
+// "_count" samples, is already in use.
+func HistogramNameTaken(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return histogramNameTakenLocked(name)
+}
+
+// histogramNameTakenLocked reports whether name, or the name of one of
+// the samples of a histogram called name, is taken. mu must be held.
+func histogramNameTakenLocked(name string) bool {
+	if nameTakenLocked(name) {
+		return true
+	}
+	for _, suffix := range histogramSampleSuffixes {
+		if nameTakenLocked(name + suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Histogram) Name() string { return h.name }
+
+func (h *Histogram) Type() Type { return TypeHistogram }
+
+// Buckets returns the inclusive upper bounds of h's buckets, not including
+// the implicit +Inf bucket.
+func (h *Histogram) Buckets() []int64 { return slices.Clone(h.bounds) }
+
+// Observe records the observation v.
+func (h *Histogram) Observe(v int64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i].Add(1)
+	h.sum.Add(v)
+	h.count.Add(1)
+}
+
+// Sum returns the sum of all observations.
+func (h *Histogram) Sum() int64 { return h.sum.Load() }
+
+// Count returns the number of observations.
+func (h *Histogram) Count() int64 { return h.count.Load() }
+
+// BucketCounts returns the cumulative number of observations less than or
+// equal to each of h's bucket bounds, followed by the total for the +Inf
+// bucket.
+//
+// As observations aren't recorded atomically across buckets, the result
+// may not reflect an observation made concurrently, or reflect it in
+// BucketCounts but not Sum or Count.
+func (h *Histogram) BucketCounts() []int64 {
+	ret := make([]int64, len(h.counts))
+	var cum int64
+	for i := range h.counts {
+		cum += h.counts[i].Load()
+		ret[i] = cum
+	}
+	return ret
+}
+
+// writeSamples writes h's "_bucket", "_sum" and "_count" samples to w, in
+// the format shared by Prometheus and OpenMetrics.
+func (h *Histogram) writeSamples(w io.Writer) {
+	counts := h.BucketCounts()
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%d\"} %d\n", h.name, b, counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, counts[len(counts)-1])
+	fmt.Fprintf(w, "%s_sum %d\n", h.name, h.Sum())
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.Count())
+}
+
+// Histograms returns the published histograms, sorted by name.
+func Histograms() []*Histogram {
+	mu.Lock()
+	defer mu.Unlock()
+	ret := make([]*Histogram, 0, len(histograms))
+	for _, h := range histograms {
+		ret = append(ret, h)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].name < ret[j].name
+	})
+	return ret
+}
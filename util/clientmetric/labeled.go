@@ -0,0 +1,175 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package clientmetric
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// labeled are the published labeled metrics, by name. They share a
+// namespace with metrics and histograms and are guarded by mu.
+var labeled = map[string]*LabeledMetric{}
+
+// LabeledMetric is a family of counters or gauges with the same name,
+// distinguished by the values of a fixed set of labels, as in
+// derp_packets{region="nyc"}.
+//
+// Like a Histogram, a LabeledMetric isn't included in the logtail metrics
+// deltas; it's only exported by WritePrometheusExpositionFormat and
+// WriteOpenMetricsFormat.
+//
+// It's safe for concurrent use.
+type LabeledMetric struct {
+	name string
+	typ  Type
+	keys []string
+
+	mu       sync.Mutex
+	children map[string]*labeledChild // by labelsKey of the values
+}
+
+type labeledChild struct {
+	values []string
+	m      *Metric
+}
+
+// NewCounterWithLabels returns a new published family of counters with the
+// given name and label keys. Use With to get the counter for some label
+// values.
+//
+// It panics if the name or a label key is illegal, or if the name is a
+// duplicate of any metric in the process.
+func NewCounterWithLabels(name string, labelKeys []string) *LabeledMetric {
+	return newLabeled(name, TypeCounter, labelKeys)
+}
+
+// NewGaugeWithLabels is like NewCounterWithLabels, but for gauges.
+func NewGaugeWithLabels(name string, labelKeys []string) *LabeledMetric {
+	return newLabeled(name, TypeGauge, labelKeys)
+}
+
+func newLabeled(name string, typ Type, labelKeys []string) *LabeledMetric {
+	if i := strings.IndexFunc(name, isIllegalMetricRune); name == "" || i != -1 {
+		panic(fmt.Sprintf("illegal metric name %q (index %v)", name, i))
+	}
+	if len(labelKeys) == 0 {
+		panic("labeled metric " + name + " has no label keys")
+	}
+	for i, k := range labelKeys {
+		if j := strings.IndexFunc(k, isIllegalMetricRune); k == "" || j != -1 {
+			panic(fmt.Sprintf("illegal label key %q of metric %s", k, name))
+		}
+		if slices.Contains(labelKeys[:i], k) {
+			panic(fmt.Sprintf("duplicate label key %q of metric %s", k, name))
+		}
+	}
+	lm := &LabeledMetric{
+		name:     name,
+		typ:      typ,
+		keys:     slices.Clone(labelKeys),
+		children: map[string]*labeledChild{},
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if nameTakenLocked(name) {
+		panic("duplicate metric " + name)
+	}
+	labeled[name] = lm
+	return lm
+}
+
+func (lm *LabeledMetric) Name() string { return lm.name }
+
+func (lm *LabeledMetric) Type() Type { return lm.typ }
+
+// LabelKeys returns lm's label keys.
+func (lm *LabeledMetric) LabelKeys() []string { return slices.Clone(lm.keys) }
+
+// With returns the metric for the given label values, one per label key,
+// creating it with a zero value if needed. The returned metric's Name is
+// lm's.
+//
+// It panics if the number of values doesn't match the number of keys.
+func (lm *LabeledMetric) With(labelValues ...string) *Metric {
+	if len(labelValues) != len(lm.keys) {
+		panic(fmt.Sprintf("metric %s has %d label keys; got %d values", lm.name, len(lm.keys), len(labelValues)))
+	}
+	k := labelsKey(labelValues)
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if c, ok := lm.children[k]; ok {
+		return c.m
+	}
+	m := &Metric{
+		v:    new(int64),
+		name: lm.name,
+		typ:  lm.typ,
+	}
+	lm.children[k] = &labeledChild{values: slices.Clone(labelValues), m: m}
+	return m
+}
+
+// labelsKey returns a map key for the label values vs.
+func labelsKey(vs []string) string {
+	var sb strings.Builder
+	for _, v := range vs {
+		sb.WriteString(strconv.Quote(v))
+	}
+	return sb.String()
+}
+
+// sortedChildren returns lm's metrics, sorted by their label values.
+func (lm *LabeledMetric) sortedChildren() []*labeledChild {
+	lm.mu.Lock()
+	ret := make([]*labeledChild, 0, len(lm.children))
+	for _, c := range lm.children {
+		ret = append(ret, c)
+	}
+	lm.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		return slices.Compare(ret[i].values, ret[j].values) < 0
+	})
+	return ret
+}
+
+// writeSamples writes a sample for each of lm's metrics to w, using sample
+// as the name of the samples.
+func (lm *LabeledMetric) writeSamples(w io.Writer, sample string) {
+	for _, c := range lm.sortedChildren() {
+		io.WriteString(w, sample)
+		io.WriteString(w, "{")
+		for i, k := range lm.keys {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", k, labelValueEscaper.Replace(c.values[i]))
+		}
+		fmt.Fprintf(w, "} %v\n", c.m.Value())
+	}
+}
+
+// labelValueEscaper escapes label values in the Prometheus and OpenMetrics
+// text formats.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// LabeledMetrics returns the published labeled metrics, sorted by name.
+func LabeledMetrics() []*LabeledMetric {
+	mu.Lock()
+	defer mu.Unlock()
+	ret := make([]*LabeledMetric, 0, len(labeled))
+	for _, lm := range labeled {
+		ret = append(ret, lm)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].name < ret[j].name
+	})
+	return ret
+}
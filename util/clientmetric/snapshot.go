@@ -0,0 +1,84 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package clientmetric
+
+// MetricSnapshot is the state of a metric at the time of a Snapshot.
+type MetricSnapshot struct {
+	Name string
+	Type string // "counter", "gauge" or "histogram"
+
+	// Labels are the label keys and values of a metric of a
+	// LabeledMetric. Each labeled metric is its own MetricSnapshot.
+	Labels map[string]string `json:",omitempty"`
+
+	// Value is the metric's value. For histograms, it's the number of
+	// observations.
+	Value int64
+
+	// Histogram is the distribution of a histogram's observations. It's
+	// nil for other types.
+	Histogram *HistogramSnapshot `json:",omitempty"`
+}
+
+// HistogramSnapshot is the state of a Histogram at the time of a Snapshot.
+type HistogramSnapshot struct {
+	// Buckets are the inclusive upper bounds of the histogram's buckets,
+	// not including the implicit +Inf bucket.
+	Buckets []int64
+
+	// Counts are the cumulative number of observations less than or
+	// equal to each of Buckets, followed by the total for the +Inf
+	// bucket.
+	Counts []int64
+
+	Sum   int64 // sum of all observations
+	Count int64 // number of observations
+}
+
+// Snapshot returns the current state of all published metrics, histograms
+// and labeled metrics, sorted by name and then by label values.
+//
+// Like WritePrometheusExpositionFormat, the snapshot isn't taken at a single
+// instant; metrics changing while it's taken may be reflected in some
+// entries but not others.
+func Snapshot() []MetricSnapshot {
+	var ret []MetricSnapshot
+	for _, f := range sortedFamilies() {
+		switch f := f.(type) {
+		case *Metric:
+			ret = append(ret, MetricSnapshot{
+				Name:  f.Name(),
+				Type:  f.Type().String(),
+				Value: f.Value(),
+			})
+		case *Histogram:
+			hs := &HistogramSnapshot{
+				Buckets: f.Buckets(),
+				Counts:  f.BucketCounts(),
+				Sum:     f.Sum(),
+				Count:   f.Count(),
+			}
+			ret = append(ret, MetricSnapshot{
+				Name:      f.Name(),
+				Type:      f.Type().String(),
+				Value:     hs.Count,
+				Histogram: hs,
+			})
+		case *LabeledMetric:
+			for _, c := range f.sortedChildren() {
+				labels := make(map[string]string, len(f.keys))
+				for i, k := range f.keys {
+					labels[k] = c.values[i]
+				}
+				ret = append(ret, MetricSnapshot{
+					Name:   f.Name(),
+					Type:   f.Type().String(),
+					Labels: labels,
+					Value:  c.m.Value(),
+				})
+			}
+		}
+	}
+	return ret
+}
@@ -6,11 +6,13 @@
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -98,7 +100,7 @@ func (c *Conn) pickDERPFallback() int {
 	if !c.wantDerpLocked() {
 		return 0
 	}
-	ids := c.derpMap.RegionIDs()
+	ids := slices.DeleteFunc(c.derpMap.RegionIDs(), c.failedDERP.Contains)
 	if len(ids) == 0 {
 		// No DERP regions in non-nil map.
 		return 0
@@ -115,7 +117,7 @@ func (c *Conn) pickDERPFallback() int {
 	// We used to do the above for legacy clients, but never updated
 	// it for disco.
 
-	if c.myDerp != 0 {
+	if c.myDerp != 0 && !c.failedDERP.Contains(c.myDerp) {
 		return c.myDerp
 	}
 
@@ -172,7 +174,10 @@ func (c *Conn) maybeSetNearestDERP(report *netcheck.Report) (preferredDERP int)
 	}
 
 	preferredDERP = report.PreferredDERP
-	if preferredDERP == 0 {
+	c.mu.Lock()
+	failed := c.failedDERP.Contains(preferredDERP)
+	c.mu.Unlock()
+	if preferredDERP == 0 || failed {
 		// Perhaps UDP is blocked. Pick a deterministic but arbitrary
 		// one.
 		preferredDERP = c.pickDERPFallback()
@@ -331,6 +336,9 @@ func (c *Conn) derpWriteChanForRegion(regionID int, peer key.NodePublic) chan<-
 	if c.derpMap == nil || c.derpMap.Regions[regionID] == nil {
 		return nil
 	}
+	if c.failedDERP.Contains(regionID) {
+		return nil
+	}
 	if c.privateKey.IsZero() {
 		c.logf("magicsock: DERP lookup of region %v with no private key; ignoring", regionID)
 		return nil
@@ -800,7 +808,7 @@ func (c *Conn) SetDERPMap(dm *tailcfg.DERPMap) {
 
 	go c.ReSTUN("derp-map-update")
 }
-func (c *Conn) wantDerpLocked() bool { return c.derpMap != nil }
+func (c *Conn) wantDerpLocked() bool { return c.derpMap != nil && !c.derpDisabled }
 
 // c.mu must be held.
 func (c *Conn) closeAllDerpLocked(why string) {
@@ -813,17 +821,148 @@ func (c *Conn) closeAllDerpLocked(why string) {
 	c.logActiveDerpLocked()
 }
 
-// DebugBreakDERPConns breaks all DERP connections for debug/testing reasons.
-func (c *Conn) DebugBreakDERPConns() error {
+// DebugBreakDERPConns breaks all DERP connections for debug/testing reasons
+// and reports how many were closed. The home DERP connection is then
+// re-established.
+func (c *Conn) DebugBreakDERPConns() (closed int, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if len(c.activeDerp) == 0 {
 		c.logf("magicsock: DebugBreakDERPConns: no active DERP connections")
-		return nil
+		return 0, nil
 	}
+	closed = len(c.activeDerp)
 	c.closeAllDerpLocked("debug-break-derp")
 	c.startDerpHomeConnectLocked()
-	return nil
+	return closed, nil
+}
+
+// DebugSetDERPDisabled sets whether DERP relaying is disabled, leaving only
+// direct paths to peers. It exists to surface NAT traversal failures that DERP
+// would otherwise mask, and serves no useful user purpose.
+//
+// When disabling DERP, it closes all active DERP connections and returns the
+// IDs of the regions that were connected, along with the peers that had no
+// direct path and were thus only reachable via DERP.
+func (c *Conn) DebugSetDERPDisabled(v bool) (closedRegions []int, relayOnlyPeers []key.NodePublic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.derpDisabled == v {
+		return nil, nil
+	}
+	c.derpDisabled = v
+	if !v {
+		c.logf("magicsock: [debug] DERP re-enabled")
+		go c.ReSTUN("debug-derp-enabled")
+		return nil, nil
+	}
+
+	c.logf("magicsock: [debug] DERP disabled; using direct paths only")
+	c.foreachActiveDerpSortedLocked(func(regionID int, _ activeDerp) {
+		closedRegions = append(closedRegions, regionID)
+	})
+	c.peerMap.forEachEndpoint(func(ep *endpoint) {
+		ep.mu.Lock()
+		defer ep.mu.Unlock()
+		if !ep.bestAddr.AddrPort.IsValid() {
+			relayOnlyPeers = append(relayOnlyPeers, ep.publicKey)
+		}
+	})
+	c.myDerp = 0
+	c.health.SetMagicSockDERPHome(0, c.homeless)
+	c.closeAllDerpLocked("debug-disable-derp")
+	return closedRegions, relayOnlyPeers
+}
+
+// DebugSetDERPRegionFailed sets whether DERP region regionID is treated as
+// unreachable, as if it were suffering an outage. It exists to test home
+// region failover without breaking real infrastructure, and serves no useful
+// user purpose.
+//
+// Failing the home region closes its connection and moves home to the
+// nearest remaining region. It returns the home region after the change.
+func (c *Conn) DebugSetDERPRegionFailed(regionID int, failed bool) (home int, err error) {
+	c.mu.Lock()
+	if c.derpMap == nil {
+		c.mu.Unlock()
+		return 0, errors.New("no derpmap")
+	}
+	if c.derpMap.Regions[regionID] == nil {
+		c.mu.Unlock()
+		return 0, fmt.Errorf("unknown DERP region %d", regionID)
+	}
+	if !failed {
+		if c.failedDERP.Contains(regionID) {
+			c.logf("magicsock: [debug] derp-%d no longer failed", regionID)
+			c.failedDERP.Delete(regionID)
+		}
+		home = c.myDerp
+		c.mu.Unlock()
+		return home, nil
+	}
+	c.logf("magicsock: [debug] treating derp-%d as failed", regionID)
+	mak.Set(&c.failedDERP, regionID, struct{}{})
+	c.closeDerpLocked(regionID, "debug-fail-region")
+	if c.myDerp != regionID {
+		home = c.myDerp
+		c.mu.Unlock()
+		return home, nil
+	}
+	home = c.nearestDERPLocked()
+	c.mu.Unlock()
+
+	if home == 0 {
+		home = c.pickDERPFallback()
+	}
+	if !c.setNearestDERP(home) {
+		return 0, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.netInfoLast != nil {
+		ni := c.netInfoLast.Clone()
+		ni.PreferredDERP = home
+		c.callNetInfoCallbackLocked(ni)
+	}
+	return home, nil
+}
+
+// nearestDERPLocked returns the region with the lowest latency in the last
+// netcheck report that isn't treated as failed, or zero if there's none.
+//
+// c.mu must be held.
+func (c *Conn) nearestDERPLocked() int {
+	report := c.lastNetCheckReport.Load()
+	if report == nil {
+		return 0
+	}
+	var best int
+	var bestLatency time.Duration
+	for rid, d := range report.RegionLatency {
+		if c.failedDERP.Contains(rid) || c.derpMap.Regions[rid] == nil {
+			continue
+		}
+		if best == 0 || d < bestLatency || (d == bestLatency && rid < best) {
+			best, bestLatency = rid, d
+		}
+	}
+	return best
+}
+
+// derpMapWithoutFailedLocked returns c.derpMap without the regions treated as
+// failed by DebugSetDERPRegionFailed, so that netcheck never picks them.
+//
+// c.mu must be held.
+func (c *Conn) derpMapWithoutFailedLocked() *tailcfg.DERPMap {
+	dm := c.derpMap
+	if dm == nil || len(c.failedDERP) == 0 {
+		return dm
+	}
+	dm = dm.Clone()
+	for rid := range c.failedDERP {
+		delete(dm.Regions, rid)
+	}
+	return dm
 }
 
 // maybeCloseDERPsOnRebind, in response to a rebind, closes all
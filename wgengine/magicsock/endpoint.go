@@ -59,6 +59,7 @@ type endpoint struct {
 	lastRecvUDPAny        mono.Time // last time there were incoming UDP packets from this peer of any kind
 	numStopAndResetAtomic int64
 	debugUpdates          *ringbuffer.RingBuffer[EndpointChange]
+	latencyHistory        *ringbuffer.RingBuffer[LatencySample]
 
 	// These fields are initialized once and never modified.
 	c            *Conn
@@ -399,6 +400,18 @@ type EndpointChange struct {
 	To   any       `json:",omitempty"` // information about the new state
 }
 
+// latencyHistoryCount is how many LatencySample values we keep per endpoint.
+const latencyHistoryCount = 64
+
+// LatencySample is a round-trip time to a peer, measured by a disco ping
+// that tailscaled sent on its own or on behalf of a user.
+type LatencySample struct {
+	When    time.Time      // when the pong was received
+	Latency time.Duration  // round-trip time
+	Addr    netip.AddrPort // the pinged endpoint; for DERP, the magic DERP IP with the region ID as port
+	DERP    bool           // whether the ping went via a DERP relay
+}
+
 // shouldDeleteLocked reports whether we should delete this endpoint.
 func (st *endpointState) shouldDeleteLocked() bool {
 	switch {
@@ -481,7 +494,7 @@ func (de *endpoint) noteRecvActivity(ipp netip.AddrPort, now mono.Time) {
 		// to DERP.
 		de.mu.Lock()
 		if de.heartbeatDisabled && de.bestAddr.AddrPort == ipp {
-			de.trustBestAddrUntil = now.Add(trustUDPAddrDuration)
+			de.trustBestAddrUntil = now.Add(de.c.trustUDPAddrDuration())
 		}
 		de.mu.Unlock()
 	}
@@ -826,7 +839,7 @@ func (de *endpoint) heartbeat() {
 		de.sendDiscoPingsLocked(now, true)
 	}
 
-	de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+	de.heartBeatTimer = time.AfterFunc(de.c.heartbeatInterval(), de.heartbeat)
 }
 
 // setHeartbeatDisabled sets heartbeatDisabled to the provided value.
@@ -862,7 +875,7 @@ func (de *endpoint) wantFullPingLocked(now mono.Time) bool {
 func (de *endpoint) noteTxActivityExtTriggerLocked(now mono.Time) {
 	de.lastSendExt = now
 	if de.heartBeatTimer == nil && !de.heartbeatDisabled {
-		de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+		de.heartBeatTimer = time.AfterFunc(de.c.heartbeatInterval(), de.heartbeat)
 	}
 }
 
@@ -1558,6 +1571,12 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 
 	now := mono.Now()
 	latency := now.Sub(sp.at)
+	de.latencyHistory.Add(LatencySample{
+		When:    now.WallTime(),
+		Latency: latency,
+		Addr:    sp.to,
+		DERP:    isDerp,
+	})
 
 	if !isDerp {
 		st, ok := de.endpointState[sp.to]
@@ -1615,7 +1634,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 			})
 			de.bestAddr.latency = latency
 			de.bestAddrAt = now
-			de.trustBestAddrUntil = now.Add(trustUDPAddrDuration)
+			de.trustBestAddrUntil = now.Add(de.c.trustUDPAddrDuration())
 		}
 	}
 	return
@@ -1798,6 +1817,60 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 	}
 }
 
+// EndpointCandidate is a UDP address magicsock may reach a peer at
+// directly. It's for debugging and has no stability guarantees.
+type EndpointCandidate struct {
+	Addr netip.AddrPort
+	// Source is where the address was learned: "netmap" from the control
+	// plane, "call-me-maybe" from the peer over DERP, or "ping" from a
+	// disco ping the peer sent us from it.
+	Source   string
+	LastPing time.Time     // when we last pinged it; zero if never
+	Latency  time.Duration // round-trip time of its most recent pong; zero if none
+}
+
+// PeerEndpoints are the paths magicsock is considering to a peer. It's for
+// debugging and has no stability guarantees.
+type PeerEndpoints struct {
+	Candidates []EndpointCandidate // sorted by Addr
+	BestAddr   netip.AddrPort      // selected direct path; zero if none
+	// BestAddrTrusted is whether BestAddr is recently confirmed, so that
+	// packets are sent only to it and not also via DERP.
+	BestAddrTrusted bool
+	DERPRegion      int // the peer's home DERP region; zero if unknown
+}
+
+func (de *endpoint) peerEndpoints() PeerEndpoints {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	res := PeerEndpoints{
+		BestAddr:        de.bestAddr.AddrPort,
+		BestAddrTrusted: de.bestAddr.IsValid() && !mono.Now().After(de.trustBestAddrUntil),
+		DERPRegion:      int(de.derpAddr.Port()),
+	}
+	for ap, st := range de.endpointState {
+		c := EndpointCandidate{Addr: ap, Source: "netmap"}
+		switch {
+		case de.isCallMeMaybeEP[ap]:
+			c.Source = "call-me-maybe"
+		case !st.lastGotPing.IsZero():
+			c.Source = "ping"
+		}
+		if !st.lastPing.IsZero() {
+			c.LastPing = st.lastPing.WallTime()
+		}
+		if lat, ok := st.latencyLocked(); ok {
+			c.Latency = lat
+		}
+		res.Candidates = append(res.Candidates, c)
+	}
+	slices.SortFunc(res.Candidates, func(a, b EndpointCandidate) int {
+		return a.Addr.Compare(b.Addr)
+	})
+	return res
+}
+
 // stopAndReset stops timers associated with de and resets its state back to zero.
 // It's called when a discovery endpoint is no longer present in the
 // NetworkMap, or when magicsock is transitioning from running to
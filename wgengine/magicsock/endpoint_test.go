@@ -9,6 +9,10 @@
 	"time"
 
 	"github.com/dsnet/try"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 )
 
@@ -324,3 +328,43 @@ func(lifetime *probeUDPLifetime) time.Duration {
 		})
 	}
 }
+
+func Test_endpoint_peerEndpoints(t *testing.T) {
+	ap := netip.MustParseAddrPort
+	netmapEP, cmmEP, pingEP := ap("192.168.1.2:41641"), ap("203.0.113.5:41641"), ap("198.51.100.7:1234")
+	now := mono.Now()
+
+	pinged := &endpointState{lastPing: now}
+	pinged.addPongReplyLocked(pongReply{latency: 5 * time.Millisecond, pongAt: now})
+	de := &endpoint{
+		derpAddr:           netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 7),
+		bestAddr:           addrQuality{AddrPort: netmapEP},
+		trustBestAddrUntil: now.Add(time.Minute),
+		endpointState: map[netip.AddrPort]*endpointState{
+			netmapEP: pinged,
+			cmmEP:    {callMeMaybeTime: time.Now()},
+			pingEP:   {lastGotPing: time.Now()},
+		},
+		isCallMeMaybeEP: map[netip.AddrPort]bool{cmmEP: true},
+	}
+
+	got := de.peerEndpoints()
+	want := PeerEndpoints{
+		Candidates: []EndpointCandidate{
+			{Addr: netmapEP, Source: "netmap", LastPing: now.WallTime(), Latency: 5 * time.Millisecond},
+			{Addr: pingEP, Source: "ping"},
+			{Addr: cmmEP, Source: "call-me-maybe"},
+		},
+		BestAddr:        netmapEP,
+		BestAddrTrusted: true,
+		DERPRegion:      7,
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateComparable(netip.AddrPort{}), cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("peerEndpoints mismatch (-want +got):\n%s", diff)
+	}
+
+	de.trustBestAddrUntil = 0
+	if de.peerEndpoints().BestAddrTrusted {
+		t.Error("BestAddrTrusted = true after trust expired")
+	}
+}
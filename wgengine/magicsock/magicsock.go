@@ -58,6 +58,7 @@
 	"tailscale.com/util/mak"
 	"tailscale.com/util/ringbuffer"
 	"tailscale.com/util/set"
+	"tailscale.com/util/singleflight"
 	"tailscale.com/util/testenv"
 	"tailscale.com/util/uniq"
 	"tailscale.com/wgengine/capture"
@@ -173,6 +174,22 @@ type Conn struct {
 
 	lastNetCheckReport atomic.Pointer[netcheck.Report]
 
+	// netcheckFlight shares a netcheck that's already running (such as
+	// the periodic ReSTUN one) with other callers of updateNetInfo. It's
+	// keyed by whether the netcheck is full, so a caller asking for a full
+	// report never gets a partial one.
+	netcheckFlight singleflight.Group[bool, *netcheck.Report]
+
+	// netcheckMu serializes netcheck runs, as netcheck.Client doesn't
+	// permit concurrent GetReport calls.
+	netcheckMu sync.Mutex
+
+	// heartbeatIntervalOverride and trustUDPAddrDurationOverride, if
+	// positive, override the heartbeatInterval and trustUDPAddrDuration
+	// defaults. See SetKeepaliveTuning.
+	heartbeatIntervalOverride    atomic.Int64 // time.Duration
+	trustUDPAddrDurationOverride atomic.Int64 // time.Duration
+
 	// port is the preferred port from opts.Port; 0 means auto.
 	port atomic.Uint32
 
@@ -283,6 +300,8 @@ type Conn struct {
 	everHadKey       bool                          // whether we ever had a non-zero private key
 	myDerp           int                           // nearest DERP region ID; 0 means none/unknown
 	homeless         bool                          // if true, don't try to find & stay conneted to a DERP home (myDerp will stay 0)
+	derpDisabled     bool                          // if true, DERP relaying is disabled for debugging; only direct paths are used
+	failedDERP       set.Set[int]                  // DERP regions treated as unreachable for debugging failover
 	derpStarted      chan struct{}                 // closed on first connection to DERP; for tests & cleaner Close
 	activeDerp       map[int]activeDerp            // DERP regionID -> connection to a node in that region
 	prevDerp         map[int]*syncs.WaitGroupChan
@@ -679,18 +698,56 @@ func (c *Conn) setNetInfoHavePortMap() {
 	c.callNetInfoCallbackLocked(ni)
 }
 
-func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
+const (
+	// netcheckTimeout bounds a netcheck run by updateNetInfo.
+	netcheckTimeout = 2 * time.Second
+
+	// fullNetcheckTimeout bounds a full netcheck run, which probes every
+	// DERP region.
+	fullNetcheckTimeout = 5 * time.Second
+)
+
+// updateNetInfo runs a netcheck and updates the NetInfo from its results. If
+// full, all DERP regions are probed rather than only the quickest few from
+// the last report.
+//
+// If a netcheck of the same kind is already in flight, updateNetInfo waits
+// for it and returns its report instead of starting another. The netcheck
+// itself isn't bound to ctx, which only bounds how long updateNetInfo waits
+// for it, so one caller giving up doesn't cancel it for the others.
+func (c *Conn) updateNetInfo(ctx context.Context, full bool) (*netcheck.Report, error) {
+	ch := c.netcheckFlight.DoChan(full, func() (*netcheck.Report, error) {
+		timeout := netcheckTimeout
+		if full {
+			timeout = fullNetcheckTimeout
+		}
+		ctx, cancel := context.WithTimeout(c.connCtx, timeout)
+		defer cancel()
+		return c.updateNetInfoNow(ctx, full)
+	})
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Conn) updateNetInfoNow(ctx context.Context, full bool) (*netcheck.Report, error) {
+	c.netcheckMu.Lock()
+	defer c.netcheckMu.Unlock()
+
 	c.mu.Lock()
-	dm := c.derpMap
+	dm := c.derpMapWithoutFailedLocked()
 	c.mu.Unlock()
 
 	if dm == nil || c.networkDown() {
 		return new(netcheck.Report), nil
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
-	defer cancel()
-
+	if full {
+		c.netChecker.MakeNextReportFull()
+	}
 	report, err := c.netChecker.GetReport(ctx, dm, &netcheck.GetReportOpts{
 		// Pass information about the last time that we received a
 		// frame from a DERP server to our netchecker to help avoid
@@ -866,6 +923,42 @@ func (c *Conn) GetEndpointChanges(peer tailcfg.NodeView) ([]EndpointChange, erro
 	return ep.debugUpdates.GetAll(), nil
 }
 
+// GetLatencyHistory returns the most recent round-trip times measured to
+// peer, both direct and via DERP, oldest first.
+func (c *Conn) GetLatencyHistory(peer tailcfg.NodeView) ([]LatencySample, error) {
+	c.mu.Lock()
+	if c.privateKey.IsZero() {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("tailscaled stopped")
+	}
+	ep, ok := c.peerMap.endpointForNodeKey(peer.Key())
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown peer")
+	}
+
+	return ep.latencyHistory.GetAll(), nil
+}
+
+// GetPeerEndpoints returns the candidate endpoints magicsock knows for
+// peer and which of them, if any, it currently sends to.
+func (c *Conn) GetPeerEndpoints(peer tailcfg.NodeView) (PeerEndpoints, error) {
+	c.mu.Lock()
+	if c.privateKey.IsZero() {
+		c.mu.Unlock()
+		return PeerEndpoints{}, fmt.Errorf("tailscaled stopped")
+	}
+	ep, ok := c.peerMap.endpointForNodeKey(peer.Key())
+	c.mu.Unlock()
+
+	if !ok {
+		return PeerEndpoints{}, fmt.Errorf("unknown peer")
+	}
+
+	return ep.peerEndpoints(), nil
+}
+
 // DiscoPublicKey returns the discovery public key.
 func (c *Conn) DiscoPublicKey() key.DiscoPublic {
 	return c.discoPublic
@@ -884,7 +977,7 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 		portmapExt, havePortmap = c.portMapper.GetCachedMappingOrStartCreatingOne()
 	}
 
-	nr, err := c.updateNetInfo(ctx)
+	nr, err := c.updateNetInfo(ctx, false)
 	if err != nil {
 		c.logf("magicsock.Conn.determineEndpoints: updateNetInfo: %v", err)
 		return nil, err
@@ -2113,6 +2206,7 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 			endpointState:     map[netip.AddrPort]*endpointState{},
 			heartbeatDisabled: flags.heartbeatDisabled,
 			isWireguardOnly:   n.IsWireGuardOnly(),
+			latencyHistory:    ringbuffer.New[LatencySample](latencyHistoryCount),
 		}
 		switch runtime.GOOS {
 		case "ios", "android":
@@ -2783,6 +2877,32 @@ func (c *Conn) SetHomeless(v bool) {
 	endpointsFreshEnoughDuration = 27 * time.Second
 )
 
+// SetKeepaliveTuning overrides how often the best UDP path to an active
+// peer is pinged to keep it alive (heartbeat) and how long that path is
+// trusted without a pong before packets are also sent via DERP (trustUDP).
+// A zero value restores the corresponding default.
+func (c *Conn) SetKeepaliveTuning(heartbeat, trustUDP time.Duration) {
+	c.heartbeatIntervalOverride.Store(int64(heartbeat))
+	c.trustUDPAddrDurationOverride.Store(int64(trustUDP))
+}
+
+// heartbeatInterval returns how often pings to the best UDP address are sent.
+func (c *Conn) heartbeatInterval() time.Duration {
+	if d := time.Duration(c.heartbeatIntervalOverride.Load()); d > 0 {
+		return d
+	}
+	return heartbeatInterval
+}
+
+// trustUDPAddrDuration returns how long a UDP address is trusted as the
+// exclusive path without having heard a pong reply.
+func (c *Conn) trustUDPAddrDuration() time.Duration {
+	if d := time.Duration(c.trustUDPAddrDurationOverride.Load()); d > 0 {
+		return d
+	}
+	return trustUDPAddrDuration
+}
+
 // Constants that are variable for testing.
 var (
 	// pingTimeoutDuration is how long we wait for a pong reply before
@@ -3011,7 +3131,7 @@ func getPeerMTUsProbedMetric(mtu tstun.WireMTU) *clientmetric.Metric {
 func (c *Conn) GetLastNetcheckReport(ctx context.Context) *netcheck.Report {
 	lastReport := c.lastNetCheckReport.Load()
 	if lastReport == nil {
-		nr, err := c.updateNetInfo(ctx)
+		nr, err := c.updateNetInfo(ctx, false)
 		if err != nil {
 			c.logf("magicsock.Conn.GetLastNetcheckReport: updateNetInfo: %v", err)
 			return nil
@@ -3021,6 +3141,23 @@ func (c *Conn) GetLastNetcheckReport(ctx context.Context) *netcheck.Report {
 	return lastReport
 }
 
+// MeasureDERPLatency runs a full netcheck now, rather than waiting for the
+// next periodic one, and updates the DERP home and NetInfo from its results.
+// ctx bounds how long MeasureDERPLatency waits for the measurement.
+func (c *Conn) MeasureDERPLatency(ctx context.Context) (*netcheck.Report, error) {
+	return c.Netcheck(ctx, true)
+}
+
+// Netcheck runs a netcheck now, rather than waiting for the next periodic
+// one, and updates the DERP home and NetInfo from its results. If full, all
+// DERP regions are probed rather than only the quickest few from the last
+// report. ctx bounds how long Netcheck waits for the report. If a netcheck
+// of the same kind is already running, Netcheck returns its report rather
+// than starting another.
+func (c *Conn) Netcheck(ctx context.Context, full bool) (*netcheck.Report, error) {
+	return c.updateNetInfo(ctx, full)
+}
+
 // SetLastNetcheckReportForTest sets the magicsock conn's last netcheck report.
 // Used for testing purposes.
 func (c *Conn) SetLastNetcheckReportForTest(ctx context.Context, report *netcheck.Report) {
@@ -19,6 +19,7 @@
 	"net/netip"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -49,6 +50,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/packet"
 	"tailscale.com/net/ping"
+	"tailscale.com/net/stun"
 	"tailscale.com/net/stun/stuntest"
 	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
@@ -62,6 +64,7 @@
 	"tailscale.com/types/nettype"
 	"tailscale.com/types/ptr"
 	"tailscale.com/util/cibuild"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/racebuild"
 	"tailscale.com/util/set"
 	"tailscale.com/wgengine/filter"
@@ -2953,6 +2956,59 @@ func TestMaybeSetNearestDERP(t *testing.T) {
 	}
 }
 
+func TestDebugSetDERPRegionFailed(t *testing.T) {
+	c := newConn(t.Logf)
+	c.health = new(health.Tracker)
+	c.derpMap = &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, RegionCode: "one"},
+			2: {RegionID: 2, RegionCode: "two"},
+			3: {RegionID: 3, RegionCode: "three"},
+		},
+	}
+	c.myDerp = 1
+	c.lastNetCheckReport.Store(&netcheck.Report{
+		RegionLatency: map[int]time.Duration{
+			1: 10 * time.Millisecond,
+			2: 30 * time.Millisecond,
+			3: 20 * time.Millisecond,
+		},
+	})
+
+	if _, err := c.DebugSetDERPRegionFailed(99, true); err == nil {
+		t.Error("failing unknown region succeeded")
+	}
+
+	home, err := c.DebugSetDERPRegionFailed(1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if home != 3 {
+		t.Errorf("home after failing region 1 = %d; want 3", home)
+	}
+	if dm := c.derpMapWithoutFailedLocked(); dm.Regions[1] != nil || len(dm.Regions) != 2 {
+		t.Errorf("netcheck DERP map still has failed region: %v", dm.Regions)
+	}
+	if c.derpMap.Regions[1] == nil {
+		t.Error("failing region 1 removed it from the real DERP map")
+	}
+	if got := c.maybeSetNearestDERP(&netcheck.Report{PreferredDERP: 1}); got != 3 {
+		t.Errorf("maybeSetNearestDERP picked %d; want 3 while region 1 is failed", got)
+	}
+
+	// Failing a region that isn't home leaves home alone.
+	if home, err := c.DebugSetDERPRegionFailed(2, true); err != nil || home != 3 {
+		t.Errorf("failing region 2 = %d, %v; want 3, nil", home, err)
+	}
+
+	if _, err := c.DebugSetDERPRegionFailed(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.maybeSetNearestDERP(&netcheck.Report{PreferredDERP: 1}); got != 1 {
+		t.Errorf("maybeSetNearestDERP picked %d; want 1 after region 1 restored", got)
+	}
+}
+
 func TestMaybeRebindOnError(t *testing.T) {
 	tstest.PanicOnLog()
 	tstest.ResourceCheck(t)
@@ -2982,3 +3038,127 @@ func TestMaybeRebindOnError(t *testing.T) {
 		}
 	})
 }
+
+func TestDebugSetDERPDisabled(t *testing.T) {
+	conn := newTestConn(t)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetPrivateKey(key.NewNode())
+
+	direct, relayOnly := key.NewNode().Public(), key.NewNode().Public()
+	conn.SetNetworkMap(&netmap.NetworkMap{
+		Peers: nodeViews([]*tailcfg.Node{
+			{ID: 1, Key: direct, DiscoKey: key.NewDisco().Public(), Endpoints: eps("192.168.1.2:345")},
+			{ID: 2, Key: relayOnly, DiscoKey: key.NewDisco().Public(), Endpoints: eps("192.168.1.3:345")},
+		}),
+	})
+	de, ok := conn.peerMap.endpointForNodeKey(direct)
+	if !ok {
+		t.Fatal("no endpoint for direct peer")
+	}
+	de.mu.Lock()
+	de.bestAddr = addrQuality{AddrPort: netip.MustParseAddrPort("192.168.1.2:345")}
+	de.mu.Unlock()
+
+	conn.mu.Lock()
+	conn.derpMap = &tailcfg.DERPMap{}
+	conn.myDerp = 2
+	close(conn.derpStarted) // as if the first DERP connection had started
+	for _, id := range []int{2, 1} {
+		mak.Set(&conn.activeDerp, id, activeDerp{
+			c:          new(derphttp.Client),
+			cancel:     func() {},
+			lastWrite:  new(time.Time),
+			createTime: time.Now(),
+		})
+	}
+	conn.mu.Unlock()
+
+	regions, peers := conn.DebugSetDERPDisabled(true)
+	if want := []int{1, 2}; !slices.Equal(regions, want) {
+		t.Errorf("closed regions = %v; want %v", regions, want)
+	}
+	if want := []key.NodePublic{relayOnly}; !slices.Equal(peers, want) {
+		t.Errorf("relay-only peers = %v; want %v", peers, want)
+	}
+	conn.mu.Lock()
+	if conn.wantDerpLocked() || len(conn.activeDerp) != 0 || conn.myDerp != 0 {
+		t.Errorf("after disable: wantDerp=%v, activeDerp=%v, myDerp=%v; want false, 0, 0",
+			conn.wantDerpLocked(), len(conn.activeDerp), conn.myDerp)
+	}
+	conn.mu.Unlock()
+
+	if regions, peers := conn.DebugSetDERPDisabled(true); regions != nil || peers != nil {
+		t.Errorf("disabling again = %v, %v; want nil, nil", regions, peers)
+	}
+
+	conn.DebugSetDERPDisabled(false)
+	conn.mu.Lock()
+	if !conn.wantDerpLocked() {
+		t.Error("after enable: wantDerp = false; want true")
+	}
+	conn.mu.Unlock()
+}
+
+func TestLatencyHistory(t *testing.T) {
+	conn := newTestConn(t)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetPrivateKey(key.NewNode())
+
+	peer := (&tailcfg.Node{
+		ID:        1,
+		Key:       key.NewNode().Public(),
+		DiscoKey:  key.NewDisco().Public(),
+		Endpoints: eps("192.168.1.2:345"),
+	}).View()
+	conn.SetNetworkMap(&netmap.NetworkMap{Peers: []tailcfg.NodeView{peer}})
+	de, ok := conn.peerMap.endpointForNodeKey(peer.Key())
+	if !ok {
+		t.Fatal("no endpoint for peer")
+	}
+
+	derpAddr := netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 7)
+	directAddr := netip.MustParseAddrPort("192.168.1.2:345")
+	pong := func(to netip.AddrPort, latency time.Duration) {
+		t.Helper()
+		txid := stun.NewTxID()
+		de.mu.Lock()
+		de.sentPing[txid] = sentPing{
+			to:      to,
+			at:      mono.Now().Add(-latency),
+			timer:   time.NewTimer(time.Hour),
+			purpose: pingDiscovery,
+		}
+		de.mu.Unlock()
+		if !de.handlePongConnLocked(&disco.Pong{TxID: txid, Src: to}, nil, to) {
+			t.Fatalf("pong from %v: unknown txid", to)
+		}
+	}
+	pong(derpAddr, 80*time.Millisecond)
+	pong(directAddr, 10*time.Millisecond)
+
+	got, err := conn.GetLatencyHistory(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d samples; want 2: %+v", len(got), got)
+	}
+	for i, want := range []struct {
+		addr    netip.AddrPort
+		derp    bool
+		latency time.Duration
+	}{
+		{derpAddr, true, 80 * time.Millisecond},
+		{directAddr, false, 10 * time.Millisecond},
+	} {
+		s := got[i]
+		if s.Addr != want.addr || s.DERP != want.derp || s.Latency < want.latency || s.When.IsZero() {
+			t.Errorf("sample %d = %+v; want addr %v, DERP %v, latency >= %v", i, s, want.addr, want.derp, want.latency)
+		}
+	}
+
+	unknown := (&tailcfg.Node{Key: key.NewNode().Public()}).View()
+	if _, err := conn.GetLatencyHistory(unknown); err == nil {
+		t.Error("unknown peer: got nil error")
+	}
+}
@@ -732,6 +732,17 @@ func (ns *Impl) handleLocalPackets(p *packet.Parsed, t *tstun.Wrapper) filter.Re
 				return filter.Accept
 			}
 		}
+	case ns.isDNSServiceIP(dst):
+		// An additional MagicDNS IP configured via dns.Config.ServiceIP.
+		// Unlike the service IP above, only DNS is served on it.
+		switch p.IPProto {
+		case ipproto.TCP, ipproto.UDP:
+			if p.Dst.Port() != 53 {
+				return filter.Accept
+			}
+		default:
+			return filter.Accept
+		}
 	case viaRange.Contains(dst):
 		// We need to handle 4via6 packets leaving the host if the via
 		// route is for this host; otherwise the packet will be dropped
@@ -861,6 +872,16 @@ func (ns *Impl) inject() {
 	}
 }
 
+// isDNSServiceIP reports whether ip is the additional MagicDNS IP
+// configured via dns.Config.ServiceIP, if any.
+func (ns *Impl) isDNSServiceIP(ip netip.Addr) bool {
+	if ns.tundev == nil {
+		return false
+	}
+	sip := ns.tundev.DNSServiceIP()
+	return sip.IsValid() && ip == sip
+}
+
 // shouldSendToHost determines if the provided packet should be sent to the
 // host (i.e the current machine running Tailscale), in which case it will
 // return true. It will return false if the packet should be sent outbound, for
@@ -873,13 +894,13 @@ func (ns *Impl) shouldSendToHost(pkt *stack.PacketBuffer) bool {
 	switch v := hdr.(type) {
 	case header.IPv4:
 		srcIP := netip.AddrFrom4(v.SourceAddress().As4())
-		if serviceIP == srcIP {
+		if serviceIP == srcIP || ns.isDNSServiceIP(srcIP) {
 			return true
 		}
 
 	case header.IPv6:
 		srcIP := netip.AddrFrom16(v.SourceAddress().As16())
-		if srcIP == serviceIPv6 {
+		if srcIP == serviceIPv6 || ns.isDNSServiceIP(srcIP) {
 			return true
 		}
 
@@ -1244,7 +1265,7 @@ func (ns *Impl) acceptTCP(r *tcp.ForwarderRequest) {
 
 	// Local Services (DNS and WebDAV)
 	hittingServiceIP := dialIP == serviceIP || dialIP == serviceIPv6
-	hittingDNS := hittingServiceIP && reqDetails.LocalPort == 53
+	hittingDNS := (hittingServiceIP || ns.isDNSServiceIP(dialIP)) && reqDetails.LocalPort == 53
 	if hittingDNS {
 		c := getConnOrReset()
 		if c == nil {
@@ -1435,7 +1456,7 @@ func (ns *Impl) acceptUDP(r *udp.ForwarderRequest) {
 	}
 
 	// Handle magicDNS traffic (via UDP) here.
-	if dst := dstAddr.Addr(); dst == serviceIP || dst == serviceIPv6 {
+	if dst := dstAddr.Addr(); dst == serviceIP || dst == serviceIPv6 || ns.isDNSServiceIP(dst) {
 		if dstAddr.Port() != 53 {
 			ep.Close()
 			return // Only MagicDNS traffic runs on the service IPs for now.
@@ -13,6 +13,7 @@
 	"testing"
 	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -22,6 +23,7 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/metrics"
+	"tailscale.com/net/dns"
 	"tailscale.com/net/packet"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
@@ -30,7 +32,9 @@
 	"tailscale.com/tstest"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/logid"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/capture"
 	"tailscale.com/wgengine/filter"
 )
 
@@ -800,6 +804,117 @@ func TestHandleLocalPackets(t *testing.T) {
 	})
 }
 
+// TestDNSServiceIP tests that MagicDNS is served on the additional IP set
+// by dns.Config.ServiceIP, and that only DNS is intercepted on it.
+func TestDNSServiceIP(t *testing.T) {
+	var (
+		svcIP  = netip.MustParseAddr("100.90.90.90")
+		client = netip.MustParseAddrPort("100.64.1.2:5353")
+		hostIP = netip.MustParseAddr("100.64.1.3")
+	)
+
+	impl := makeNetstack(t, func(impl *Impl) {
+		impl.atomicIsLocalIPFunc.Store(func(addr netip.Addr) bool {
+			return addr == client.Addr()
+		})
+	})
+	err := impl.dns.Set(dns.Config{
+		Hosts: map[dnsname.FQDN][]netip.Addr{
+			"foo.test.": {hostIP},
+		},
+		ServiceIP: svcIP,
+	})
+	if err != nil {
+		t.Fatalf("dns.Set: %v", err)
+	}
+	impl.tundev.SetDNSServiceIP(svcIP)
+
+	t.Run("NonDNSPassedThrough", func(t *testing.T) {
+		pkt := &packet.Parsed{
+			IPVersion: 4,
+			IPProto:   ipproto.TCP,
+			Src:       client,
+			Dst:       netip.AddrPortFrom(svcIP, 80),
+			TCPFlags:  packet.TCPSyn,
+		}
+		if resp := impl.handleLocalPackets(pkt, impl.tundev); resp != filter.Accept {
+			t.Errorf("got filter outcome %v, want filter.Accept", resp)
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		answers := make(chan []byte, 1)
+		impl.tundev.InstallCaptureHook(func(path capture.Path, _ time.Time, b []byte, _ packet.CaptureMeta) {
+			if path != capture.SynthesizedToLocal {
+				return
+			}
+			var p packet.Parsed
+			p.Decode(b)
+			if p.IPProto != ipproto.UDP || p.Src != netip.AddrPortFrom(svcIP, 53) || p.Dst != client {
+				return
+			}
+			select {
+			case answers <- append([]byte(nil), p.Payload()...):
+			default:
+			}
+		})
+
+		qb := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+		qb.StartQuestions()
+		qb.Question(dnsmessage.Question{
+			Name:  dnsmessage.MustNewName("foo.test."),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		})
+		query, err := qb.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := packet.Generate(packet.UDP4Header{
+			IP4Header: packet.IP4Header{
+				Src: client.Addr(),
+				Dst: svcIP,
+			},
+			SrcPort: client.Port(),
+			DstPort: 53,
+		}, query)
+		var pkt packet.Parsed
+		pkt.Decode(raw)
+		if resp := impl.handleLocalPackets(&pkt, impl.tundev); resp != filter.DropSilently {
+			t.Fatalf("got filter outcome %v, want filter.DropSilently", resp)
+		}
+
+		var answer []byte
+		select {
+		case answer = <-answers:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for DNS response")
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(answer); err != nil {
+			t.Fatalf("unpacking response: %v", err)
+		}
+		if len(msg.Answers) != 1 {
+			t.Fatalf("got %d answers, want 1", len(msg.Answers))
+		}
+		a, ok := msg.Answers[0].Body.(*dnsmessage.AResource)
+		if !ok {
+			t.Fatalf("got answer %T, want A record", msg.Answers[0].Body)
+		}
+		if got := netip.AddrFrom4(a.A); got != hostIP {
+			t.Errorf("got A %v, want %v", got, hostIP)
+		}
+	})
+
+	t.Run("ResponseSentToHost", func(t *testing.T) {
+		pkt := makeUDP4PacketBuffer(netip.AddrPortFrom(svcIP, 53), client)
+		defer pkt.DecRef()
+		if !impl.shouldSendToHost(pkt) {
+			t.Error("shouldSendToHost = false, want true")
+		}
+	})
+}
+
 func TestShouldSendToHost(t *testing.T) {
 	var (
 		selfIP4 = netip.MustParseAddr("100.64.1.2")
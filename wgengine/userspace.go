@@ -861,6 +861,7 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	e.wgLock.Lock()
 	defer e.wgLock.Unlock()
 	e.tundev.SetWGConfig(cfg)
+	e.tundev.SetDNSServiceIP(dnsCfg.ServiceIP)
 	e.lastDNSConfig = dnsCfg
 
 	peerSet := make(set.Set[key.NodePublic], len(cfg.Peers))
@@ -1228,7 +1229,7 @@ func (e *userspaceEngine) linkChange(delta *netmon.ChangeDelta) {
 			dnsCfg := e.lastDNSConfig
 			e.wgLock.Unlock()
 			if dnsCfg != nil {
-				if err := e.dns.Set(*dnsCfg); err != nil {
+				if err := e.dns.Reapply(*dnsCfg); err != nil {
 					e.logf("wgengine: error setting DNS config after major link change: %v", err)
 				} else if err := e.reconfigureVPNIfNecessary(); err != nil {
 					e.logf("wgengine: error reconfiguring VPN after major link change: %v", err)
@@ -1582,6 +1583,10 @@ func (ls fwdDNSLinkSelector) PickLink(ip netip.Addr) (linkName string) {
 	metricNumMinorChanges = clientmetric.NewCounter("wgengine_minor_changes")
 )
 
+func (e *userspaceEngine) SetDebugLoggingEnabled(v bool) {
+	e.wgLogger.SetVerbose(v)
+}
+
 func (e *userspaceEngine) InstallCaptureHook(cb capture.Callback) {
 	e.tundev.InstallCaptureHook(cb)
 	e.magicConn.InstallCaptureHook(cb)
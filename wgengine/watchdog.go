@@ -162,6 +162,10 @@ func (e *watchdogEngine) Done() <-chan struct{} {
 	return e.wrap.Done()
 }
 
+func (e *watchdogEngine) SetDebugLoggingEnabled(v bool) {
+	e.watchdog("SetDebugLoggingEnabled", func() { e.wrap.SetDebugLoggingEnabled(v) })
+}
+
 func (e *watchdogEngine) InstallCaptureHook(cb capture.Callback) {
 	e.wrap.InstallCaptureHook(cb)
 }
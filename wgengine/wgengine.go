@@ -130,4 +130,8 @@ type Engine interface {
 	// packets traversing the data path. The hook can be uninstalled by
 	// calling this function with a nil value.
 	InstallCaptureHook(capture.Callback)
+
+	// SetDebugLoggingEnabled sets whether the engine logs wireguard-go's
+	// verbose messages, which are otherwise dropped.
+	SetDebugLoggingEnabled(bool)
 }
@@ -8,6 +8,7 @@
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/tailscale/wireguard-go/device"
 	"tailscale.com/envknob"
@@ -22,6 +23,7 @@
 type Logger struct {
 	DeviceLogger *device.Logger
 	replace      syncs.AtomicValue[map[string]string]
+	verbose      atomic.Bool                  // log wireguard-go's verbose lines unprefixed by "[v2]"
 	mu           sync.Mutex                   // protects strs
 	strs         map[key.NodePublic]*strCache // cached strs used to populate replace
 }
@@ -91,14 +93,28 @@ func NewLogger(logf logger.Logf) *Logger {
 	if envknob.Bool("TS_DEBUG_RAW_WGLOG") {
 		wrapper = logf
 	}
+	verbosef := logger.WithPrefix(wrapper, prefix+"[v2] ")
+	elevatedf := logger.WithPrefix(wrapper, prefix)
 	ret.DeviceLogger = &device.Logger{
-		Verbosef: logger.WithPrefix(wrapper, prefix+"[v2] "),
-		Errorf:   logger.WithPrefix(wrapper, prefix),
+		Verbosef: func(format string, args ...any) {
+			if ret.verbose.Load() {
+				elevatedf(format, args...)
+			} else {
+				verbosef(format, args...)
+			}
+		},
+		Errorf: logger.WithPrefix(wrapper, prefix),
 	}
 	ret.strs = make(map[key.NodePublic]*strCache)
 	return ret
 }
 
+// SetVerbose sets whether wireguard-go's verbose log lines are logged at the
+// normal level rather than as "[v2]" lines, which are usually dropped.
+func (x *Logger) SetVerbose(v bool) {
+	x.verbose.Store(v)
+}
+
 // SetPeers adjusts x to rewrite the peer public keys found in peers.
 // SetPeers is safe for concurrent use.
 func (x *Logger) SetPeers(peers []wgcfg.Peer) {
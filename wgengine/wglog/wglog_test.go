@@ -5,6 +5,7 @@
 
 import (
 	"fmt"
+	"slices"
 	"testing"
 
 	"go4.org/mem"
@@ -81,6 +82,24 @@ func TestSuppressLogs(t *testing.T) {
 	}
 }
 
+func TestSetVerbose(t *testing.T) {
+	var logs []string
+	logf := func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	x := wglog.NewLogger(logf)
+	x.DeviceLogger.Verbosef("one")
+	x.SetVerbose(true)
+	x.DeviceLogger.Verbosef("two")
+	x.SetVerbose(false)
+	x.DeviceLogger.Verbosef("three")
+
+	want := []string{"wg: [v2] one", "wg: two", "wg: [v2] three"}
+	if !slices.Equal(logs, want) {
+		t.Errorf("got %q, want %q", logs, want)
+	}
+}
+
 func stringer(s string) stringerString {
 	return stringerString(s)
 }